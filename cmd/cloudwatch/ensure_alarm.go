@@ -0,0 +1,59 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["cloudwatch-ensure-alarm"] = cloudwatchEnsureAlarm
+	lib.Args["cloudwatch-ensure-alarm"] = cloudwatchEnsureAlarmArgs{}
+}
+
+type cloudwatchEnsureAlarmArgs struct {
+	Name    string   `arg:"positional,required"`
+	Attr    []string `arg:"positional"`
+	Preview bool     `arg:"-p,--preview"`
+}
+
+func (cloudwatchEnsureAlarmArgs) Description() string {
+	return `
+ensure a cloudwatch metric alarm
+
+example:
+ - libaws cloudwatch-ensure-alarm my-lambda-errors namespace=AWS/Lambda metric=Errors dimension=FunctionName=my-lambda statistic=sum threshold=1 action=my-topic
+
+required attrs:
+ - namespace=VALUE
+ - metric=VALUE
+ - threshold=VALUE
+
+optional attrs:
+ - dimension=NAME=VALUE (repeatable)
+ - statistic=VALUE      (values = average | sum | minimum | maximum | samplecount, default = average)
+ - comparison=VALUE     (values = gt | gte | lt | lte,                                 default = gte)
+ - period=SECONDS       (default = 60)
+ - periods=VALUE        (evaluation periods, default = 1)
+ - datapoints=VALUE     (datapoints to alarm, default = periods)
+ - missing=VALUE        (values = missing | notBreaching | breaching | ignore,       default = missing)
+ - action=TOPIC         (sns topic name or arn notified on alarm, repeatable)
+ - ok-action=TOPIC      (sns topic name or arn notified on ok, repeatable)
+
+`
+}
+
+func cloudwatchEnsureAlarm() {
+	var args cloudwatchEnsureAlarmArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	input, err := lib.CloudwatchEnsureAlarmInput("", args.Name, args.Attr)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	err = lib.CloudwatchEnsureAlarm(ctx, input, args.Preview)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
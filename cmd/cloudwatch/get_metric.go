@@ -19,7 +19,7 @@ func init() {
 type cloudwatchGetMetricArgs struct {
 	Namespace string `arg:"positional,required"`
 	Metric    string `arg:"positional,required" help:"comma separated list of metrics"`
-	Dimension string `arg:"positional,required"`
+	Dimension string `arg:"positional,required" help:"NAME=VALUE, comma separated list for multiple dimensions"`
 	FromHours int    `arg:"-f,--from-hours" default:"72" help:"get data no older than this"`
 	ToHours   int    `arg:"-t,--to-hours" default:"0" help:"get data no younger than this"`
 	Period    int    `arg:"-p,--period" default:"60" help:"granularity of data in seconds"`
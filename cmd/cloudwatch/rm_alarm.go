@@ -0,0 +1,32 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["cloudwatch-rm-alarm"] = cloudwatchRmAlarm
+	lib.Args["cloudwatch-rm-alarm"] = cloudwatchRmAlarmArgs{}
+}
+
+type cloudwatchRmAlarmArgs struct {
+	Name    string `arg:"positional,required"`
+	Preview bool   `arg:"-p,--preview"`
+}
+
+func (cloudwatchRmAlarmArgs) Description() string {
+	return "\ndelete a cloudwatch alarm\n"
+}
+
+func cloudwatchRmAlarm() {
+	var args cloudwatchRmAlarmArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	err := lib.CloudwatchDeleteAlarm(ctx, args.Name, args.Preview)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
@@ -25,6 +25,8 @@ type ec2GosshArgs struct {
 	MaxConcurrency     int      `arg:"-m,--max-concurrency" default:"32" help:"max concurrent gossh connections"`
 	Ed25519PrivKeyFile string   `arg:"-e,--ed25519" help:"private key"`
 	RsaPrivKeyFile     string   `arg:"-r,--rsa" help:"private key"`
+	Jump               string   `arg:"-j,--jump" help:"proxy through this instance selector, defaults to the instance tag 'bastion' if present"`
+	Insecure           bool     `arg:"--insecure" help:"skip host key checking, instead of pinning host keys per instance-id under ~/.libaws/known_hosts"`
 }
 
 func (ec2GosshArgs) Description() string {
@@ -77,11 +79,28 @@ func ec2Gossh() {
 		rsaPrivKey := string(rsaBytes)
 		edBytes, _ := os.ReadFile(args.Ed25519PrivKeyFile)
 		ed25519PrivKey := string(edBytes)
+		var jump *lib.EC2JumpHost
+		if args.Jump != "" {
+			jump, err = lib.EC2Jump(ctx, args.Jump, "", "")
+			if err != nil {
+				lib.Logger.Fatal("error: ", err)
+			}
+		}
+		jumps, err := lib.EC2JumpHosts(ctx, instances, jump, "")
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
 		var targetAddrs []string
+		var instanceIDs []string
 		for _, instance := range instances {
-			targetAddrs = append(targetAddrs, *instance.PublicDnsName)
+			addr := *instance.PublicDnsName
+			if jumps[*instance.InstanceId] != nil {
+				addr = *instance.PrivateIpAddress
+			}
+			targetAddrs = append(targetAddrs, addr)
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
 		}
-		_, err := lib.EC2GoSsh(context.Background(), &lib.EC2GoSshInput{
+		_, err = lib.EC2GoSsh(context.Background(), &lib.EC2GoSshInput{
 			NoTTY:          true,
 			User:           args.User,
 			TimeoutSeconds: args.Timeout,
@@ -91,6 +110,9 @@ func ec2Gossh() {
 			MaxConcurrency: args.MaxConcurrency,
 			RsaPrivKey:     rsaPrivKey,
 			Ed25519PrivKey: ed25519PrivKey,
+			InstanceIDs:    instanceIDs,
+			Jumps:          jumps,
+			Insecure:       args.Insecure,
 			Stdout:         os.Stdout,
 			Stderr:         os.Stderr,
 		})
@@ -3,7 +3,6 @@ package cliaws
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"os"
 	"strings"
 
@@ -19,25 +18,28 @@ func init() {
 }
 
 type ec2NewArgs struct {
-	Name           string `arg:"positional,required"`
-	Num            int    `arg:"-n,--num" default:"1"`
-	Type           string `arg:"-t,--type,required"`
-	Ami            string `arg:"-a,--ami,required" help:"ami-ID | amzn2 | amzn2023 | deeplearning | bionic | xenial | trusty | focal | jammy | bookworm | bullseye | buster | stretch | alpine-xx.yy.zz"`
-	UserName       string `arg:"-u,--user" help:"ssh user name, otherwise look for 'user' tag on instance or find via ami name lookup"`
-	Key            string `arg:"-k,--key,required"`
-	EphemeralKey   bool   `arg:"-e,--ephemeral-key" help:"add an additional ssh keypair to this instance.\n                         the private key will be written to /tmp/libaws/SSH_ID/id_ed25519.\n                         the SSH_ID will be tagged on the instance."`
-	SpotStrategy   string `arg:"-s,--spot" help:"leave unspecified to create on-demand instances.\n                         otherwise choose spotStrategy from: lowestPrice | diversified | capacityOptimized | capacityOptimizedPrioritized | priceCapacityOptimized"`
-	Sg             string `arg:"--sg,required" help:"security group name or id"`
-	SubnetIds      string `arg:"--subnets" help:"subnet-ids as space separated values"`
-	Vpc            string `arg:"-v,--vpc" help:"vpc name or id"`
-	Gigs           int    `arg:"-g,--gigs" help:"ebs gigabytes\n                        " default:"16"`
-	Iops           int    `arg:"--iops" help:"gp3 iops\n                        " default:"3000"`
-	Throughput     int    `arg:"--throughput" help:"gp3 throughput mb/s\n                        " default:"125"`
-	Init           string `arg:"-i,--init" help:"cloud init bash script"`
-	Tags           string `arg:"--tags" help:"space separated values like: key=value"`
-	Profile        string `arg:"-p,--profile" help:"iam instance profile name"`
-	SecondsTimeout int    `arg:"--seconds-timeout" default:"3600" help:"will $(sudo poweroff) after this many seconds.\n                         calls $(bash /etc/timeout.sh) and waits 60 seconds for it to exit before calling $(sudo poweroff).\n                         set to 0 to disable.\n                         $(sudo journalctl -f -u timeout.service) to follow logs.\n                        "`
-	Wait           bool   `arg:"-w,--wait" default:"false" help:"wait for ssh"`
+	Name                string `arg:"positional,required"`
+	Num                 int    `arg:"-n,--num" default:"1"`
+	Type                string `arg:"-t,--type" help:"instance type"`
+	Types               string `arg:"--types" help:"instance types as comma separated values, in order of preference. all must share an arch"`
+	Ami                 string `arg:"-a,--ami,required" help:"ami-ID | amzn2 | amzn2023 | deeplearning | bionic | xenial | trusty | focal | jammy | bookworm | bullseye | buster | stretch | alpine-xx.yy.zz"`
+	UserName            string `arg:"-u,--user" help:"ssh user name, otherwise look for 'user' tag on instance or find via ami name lookup"`
+	Key                 string `arg:"-k,--key,required"`
+	EphemeralKey        bool   `arg:"-e,--ephemeral-key" help:"add an additional ssh keypair to this instance.\n                         the private key will be written to /tmp/libaws/SSH_ID/id_ed25519.\n                         the SSH_ID will be tagged on the instance."`
+	SpotStrategy        string `arg:"-s,--spot" help:"leave unspecified to create on-demand instances.\n                         otherwise choose spotStrategy from: lowestPrice | diversified | capacityOptimized | capacityOptimizedPrioritized | priceCapacityOptimized"`
+	SpotFallback        string `arg:"--spot-fallback" help:"set to ondemand to launch instances on-demand when spot is not fulfilled in time"`
+	SpotFallbackSeconds int    `arg:"--spot-fallback-seconds" default:"300" help:"seconds to wait for spot before fallback"`
+	Sg                  string `arg:"--sg,required" help:"security group name or id"`
+	SubnetIds           string `arg:"--subnets" help:"subnet-ids as space separated values"`
+	Vpc                 string `arg:"-v,--vpc" help:"vpc name or id"`
+	Gigs                int    `arg:"-g,--gigs" help:"ebs gigabytes\n                        " default:"16"`
+	Iops                int    `arg:"--iops" help:"gp3 iops\n                        " default:"3000"`
+	Throughput          int    `arg:"--throughput" help:"gp3 throughput mb/s\n                        " default:"125"`
+	Init                string `arg:"-i,--init" help:"cloud init bash script"`
+	Tags                string `arg:"--tags" help:"space separated values like: key=value"`
+	Profile             string `arg:"-p,--profile" help:"iam instance profile name"`
+	SecondsTimeout      int    `arg:"--seconds-timeout" default:"3600" help:"will $(sudo poweroff) after this many seconds.\n                         calls $(bash /etc/timeout.sh) and waits 60 seconds for it to exit before calling $(sudo poweroff).\n                         set to 0 to disable.\n                         $(sudo journalctl -f -u timeout.service) to follow logs.\n                        "`
+	Wait                bool   `arg:"-w,--wait" default:"false" help:"wait for ssh"`
 }
 
 func (ec2NewArgs) Description() string {
@@ -46,43 +48,24 @@ func (ec2NewArgs) Description() string {
 
 func useSubnetsFromVpc(ctx context.Context, args *ec2NewArgs) {
 	if args.Vpc != "" {
-		zones, err := lib.EC2ZonesWithInstance(ctx, args.Type)
+		vpcID, err := lib.VpcID(ctx, args.Vpc)
 		if err != nil {
 			lib.Logger.Fatal("error: ", err)
 		}
-		if len(zones) == 0 {
-			lib.Logger.Fatal("error: no zones contain instance type " + args.Type)
-		}
-		vpcID := args.Vpc
-		if !strings.HasPrefix("vpc-", args.Vpc) {
-			vpcID, err = lib.VpcID(ctx, args.Vpc)
-			if err != nil {
-				lib.Logger.Fatal("error: ", err)
-			}
-		}
 		subnets, err := lib.VpcSubnets(ctx, vpcID)
 		if err != nil {
 			lib.Logger.Fatal("error: ", err)
 		}
-		if args.SpotStrategy == "" {
-			zone := zones[rand.Intn(len(zones))]
-			for _, subnet := range subnets {
-				if *subnet.AvailabilityZone == zone {
-					args.SubnetIds = *subnet.SubnetId
-					break
-				}
-			}
-			if len(lib.SplitWhiteSpace(args.SubnetIds)) != 1 {
-				lib.Logger.Fatalf("no subnet in zone %s for vpc %s", zone, vpcID)
-			}
-		} else {
-			for _, subnet := range subnets {
-				args.SubnetIds = " " + *subnet.SubnetId
-			}
-			if len(lib.SplitWhiteSpace(args.SubnetIds)) == 0 {
-				lib.Logger.Fatalf("no subnets for vpc %s", vpcID)
+		var subnetIDs []string
+		for _, subnet := range subnets {
+			if !lib.VpcSubnetIsPrivate(subnet) {
+				subnetIDs = append(subnetIDs, *subnet.SubnetId)
 			}
 		}
+		if len(subnetIDs) == 0 {
+			lib.Logger.Fatalf("no public subnets for vpc %s", vpcID)
+		}
+		args.SubnetIds = strings.Join(subnetIDs, " ")
 	}
 }
 
@@ -101,6 +84,29 @@ func ec2New() {
 	if args.Vpc == "" && len(lib.SplitWhiteSpace(args.SubnetIds)) == 0 {
 		p.Fail("you must specify one of --vpc | --subnets")
 	}
+	var types []string
+	for _, instanceType := range strings.Split(args.Types, ",") {
+		if instanceType != "" {
+			types = append(types, instanceType)
+		}
+	}
+	if args.Type == "" && len(types) == 0 {
+		p.Fail("you must specify one of --type | --types")
+	}
+	if args.Type == "" {
+		args.Type = types[0]
+	}
+	for _, instanceType := range types {
+		if lib.EC2Arch(instanceType) != lib.EC2Arch(args.Type) {
+			p.Fail(fmt.Sprintf("all instance types must share an arch, got: %s %s", args.Type, instanceType))
+		}
+	}
+	if args.SpotFallback != "" && args.SpotFallback != lib.EC2SpotFallbackOnDemand {
+		p.Fail("--spot-fallback must be: " + lib.EC2SpotFallbackOnDemand)
+	}
+	if args.SpotFallback != "" && args.SpotStrategy == "" {
+		p.Fail("--spot-fallback needs --spot")
+	}
 	if len(lib.SplitWhiteSpace(args.SubnetIds)) == 0 {
 		useSubnetsFromVpc(ctx, &args)
 	}
@@ -123,11 +129,7 @@ func ec2New() {
 			args.UserName = lib.EC2GetTag(images.Images[0].Tags, "user", "")
 		}
 	} else {
-		arch := lib.EC2ArchAmd64
-		if strings.Contains(strings.Split(args.Type, ".")[0][1:], "g") { // slice first char, since arm64 g is never first char
-			arch = lib.EC2ArchArm64
-		}
-		ami, sshUser, err := lib.EC2AmiBase(ctx, args.Ami, arch)
+		ami, sshUser, err := lib.EC2AmiBase(ctx, args.Ami, lib.EC2Arch(args.Type))
 		if err != nil {
 			lib.Logger.Fatal("error: ", err)
 		}
@@ -155,6 +157,7 @@ func ec2New() {
 		AmiID:          args.Ami,
 		UserName:       args.UserName,
 		InstanceType:   args.Type,
+		InstanceTypes:  types,
 		Name:           args.Name,
 		Key:            args.Key,
 		TempKey:        args.EphemeralKey,
@@ -167,6 +170,9 @@ func ec2New() {
 		Tags:           tags,
 		Profile:        args.Profile,
 		SecondsTimeout: args.SecondsTimeout,
+
+		SpotFallback:        args.SpotFallback,
+		SpotFallbackSeconds: args.SpotFallbackSeconds,
 	}
 	var err error
 	if args.SpotStrategy != "" {
@@ -177,6 +183,9 @@ func ec2New() {
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
+	for _, line := range lib.EC2LaunchSummary(instances) {
+		lib.Logger.Println("launched:", line)
+	}
 	var ids []string
 	for _, instance := range instances {
 		ids = append(ids, *instance.InstanceId)
@@ -24,6 +24,8 @@ type ec2RsyncArgs struct {
 	PrivateIP      bool     `arg:"-p,--private-ip" help:"use ec2 private-ip instead of public-dns for host address"`
 	MaxConcurrency int      `arg:"-m,--max-concurrency" default:"32" help:"max concurrent rsync connections"`
 	Key            string   `arg:"-k,--key" help:"rsync private key"`
+	Jump           string   `arg:"-j,--jump" help:"proxy through this instance selector, defaults to the instance tag 'bastion' if present"`
+	Insecure       bool     `arg:"--insecure" help:"skip host key checking, instead of pinning host keys per instance-id under ~/.libaws/known_hosts"`
 	Preview        bool     `arg:"-p,--preview"`
 }
 
@@ -55,6 +57,13 @@ func ec2Rsync() {
 	if args.Preview {
 		os.Exit(0)
 	}
+	var jump *lib.EC2JumpHost
+	if args.Jump != "" {
+		jump, err = lib.EC2Jump(ctx, args.Jump, "", args.Key)
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+	}
 	if len(instances) == 0 {
 		err = fmt.Errorf("no instances found for those selectors")
 		if err != nil {
@@ -69,6 +78,8 @@ func ec2Rsync() {
 		PrivateIP:      args.PrivateIP,
 		MaxConcurrency: args.MaxConcurrency,
 		Key:            args.Key,
+		Jump:           jump,
+		Insecure:       args.Insecure,
 		PrintLock:      sync.RWMutex{},
 	})
 	var lastErr error
@@ -56,11 +56,7 @@ func ec2Speedtest() {
 	for _, subnet := range subnets {
 		subnetIDs = append(subnetIDs, *subnet.SubnetId)
 	}
-	arch := lib.EC2ArchAmd64
-	if strings.Contains(strings.Split(args.Type, ".")[0][1:], "g") { // slice first char, since arm64 g is never first char
-		arch = lib.EC2ArchArm64
-	}
-	amiID, sshUser, err := lib.EC2AmiBase(ctx, lib.EC2AmiAlpine3160, arch)
+	amiID, sshUser, err := lib.EC2AmiBase(ctx, lib.EC2AmiAlpine3160, lib.EC2Arch(args.Type))
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
@@ -26,6 +26,8 @@ type ec2SshArgs struct {
 	PrivateIP      bool     `arg:"-p,--private-ip" help:"use ec2 private-ip instead of public-dns for host address"`
 	MaxConcurrency int      `arg:"-m,--max-concurrency" default:"32" help:"max concurrent ssh connections"`
 	Key            string   `arg:"-k,--key" help:"ssh private key"`
+	Jump           string   `arg:"-j,--jump" help:"proxy through this instance selector, defaults to the instance tag 'bastion' if present"`
+	Insecure       bool     `arg:"--insecure" help:"skip host key checking, instead of pinning host keys per instance-id under ~/.libaws/known_hosts"`
 	Preview        bool     `arg:"-p,--preview" default:"false"`
 	NoPrint        bool     `arg:"--no-print" default:"false" help:"do not print live output to stdout/stderr"`
 	IPNotID        bool     `arg:"-i,--ip" default:"false" help:"when targeting multiple instances, prefix output lines with ipv4 not instance-id"`
@@ -59,6 +61,13 @@ func ec2Ssh() {
 	if args.Preview {
 		os.Exit(0)
 	}
+	var jump *lib.EC2JumpHost
+	if args.Jump != "" {
+		jump, err = lib.EC2Jump(ctx, args.Jump, "", args.Key)
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+	}
 	if args.Cmd != "" && lib.Exists(args.Cmd) {
 		bytes, err := os.ReadFile(args.Cmd)
 		if err != nil {
@@ -80,7 +89,7 @@ func ec2Ssh() {
 			lib.Logger.Fatal("error: ", err)
 		}
 	} else if len(instances) == 1 && args.Cmd == "" {
-		err = lib.EC2SshLogin(instances[0], args.User, args.Key)
+		err = lib.EC2SshLogin(instances[0], args.User, args.Key, jump, args.Insecure)
 		if err != nil {
 			lib.Logger.Fatal("error: ", err)
 		}
@@ -94,6 +103,8 @@ func ec2Ssh() {
 			PrivateIP:      args.PrivateIP,
 			MaxConcurrency: args.MaxConcurrency,
 			Key:            args.Key,
+			Jump:           jump,
+			Insecure:       args.Insecure,
 			PrintLock:      sync.RWMutex{},
 			IPNotID:        args.IPNotID,
 		})
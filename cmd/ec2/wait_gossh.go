@@ -23,6 +23,8 @@ type ec2WaitGoSshArgs struct {
 	MaxWait            int      `arg:"-w,--max-wait" help:"after this many seconds, terminate any instances not ready and return instance-id of all ready instances"`
 	Ed25519PrivKeyFile string   `arg:"-e,--ed25519" help:"private key"`
 	RsaPrivKeyFile     string   `arg:"-r,--rsa" help:"private key"`
+	Jump               string   `arg:"-j,--jump" help:"proxy through this instance selector, defaults to the instance tag 'bastion' if present"`
+	Insecure           bool     `arg:"--insecure" help:"skip host key checking, instead of pinning host keys per instance-id under ~/.libaws/known_hosts"`
 }
 
 func (ec2WaitGoSshArgs) Description() string {
@@ -66,6 +68,13 @@ func ec2WaitGoSsh() {
 			lib.Logger.Println(lib.EC2Name(instance.Tags), *instance.InstanceId)
 		}
 	}
+	var jump *lib.EC2JumpHost
+	if args.Jump != "" {
+		jump, err = lib.EC2Jump(ctx, args.Jump, "", "")
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+	}
 	rsaBytes, _ := os.ReadFile(args.RsaPrivKeyFile)
 	edBytes, _ := os.ReadFile(args.Ed25519PrivKeyFile)
 	readyIDs, err := lib.EC2WaitGoSsh(ctx, &lib.EC2WaitGoSshInput{
@@ -75,6 +84,8 @@ func ec2WaitGoSsh() {
 		MaxConcurrency: args.MaxConcurrency,
 		RsaPrivKey:     string(rsaBytes),
 		Ed25519PrivKey: string(edBytes),
+		Jump:           jump,
+		Insecure:       args.Insecure,
 	})
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
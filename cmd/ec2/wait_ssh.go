@@ -22,6 +22,8 @@ type ec2WaitSshArgs struct {
 	PrivateIP      bool     `arg:"-p,--private-ip" help:"use ec2 private-ip instead of public-dns for host address"`
 	MaxConcurrency int      `arg:"-m,--max-concurrency" default:"32" help:"max concurrent waitssh connections"`
 	Key            string   `arg:"-k,--key" help:"waitssh private key"`
+	Jump           string   `arg:"-j,--jump" help:"proxy through this instance selector, defaults to the instance tag 'bastion' if present"`
+	Insecure       bool     `arg:"--insecure" help:"skip host key checking, instead of pinning host keys per instance-id under ~/.libaws/known_hosts"`
 	MaxWait        int      `arg:"-w,--max-wait" help:"after this many seconds, terminate any instances not ready and return instance-id of all ready instances"`
 	Preview        bool     `arg:"-p,--preview"`
 }
@@ -70,12 +72,21 @@ func ec2WaitSsh() {
 	if args.Preview {
 		os.Exit(0)
 	}
+	var jump *lib.EC2JumpHost
+	if args.Jump != "" {
+		jump, err = lib.EC2Jump(ctx, args.Jump, "", args.Key)
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+	}
 	readyIDs, err := lib.EC2WaitSsh(ctx, &lib.EC2WaitSshInput{
 		Selectors:      args.Selectors,
 		MaxWaitSeconds: args.MaxWait,
 		PrivateIP:      args.PrivateIP,
 		User:           args.User,
 		Key:            args.Key,
+		Jump:           jump,
+		Insecure:       args.Insecure,
 		MaxConcurrency: args.MaxConcurrency,
 	})
 	if err != nil {
@@ -1,14 +1,9 @@
 package cliaws
 
 import (
-	"encoding/base64"
-	"fmt"
-	"os"
-	"os/exec"
-	"strings"
+	"context"
 
 	"github.com/alexflint/go-arg"
-	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/nathants/libaws/lib"
 )
 
@@ -21,31 +16,14 @@ type ecrLoginArgs struct {
 }
 
 func (ecrLoginArgs) Description() string {
-	return "\nlogin to docker\n"
+	return "\nlogin to docker, or the cli named by LIBAWS_CONTAINER_BUILDER\n"
 }
 
 func ecrLogin() {
 	var args ecrLoginArgs
 	arg.MustParse(&args)
-	token, err := lib.EcrClient().GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
-	if err != nil {
-		lib.Logger.Fatal("error: ", err)
-	}
-	bytes, err := base64.StdEncoding.DecodeString(*token.AuthorizationData[0].AuthorizationToken)
-	if err != nil {
-		lib.Logger.Fatal("error: ", err)
-	}
-	parts := strings.Split(string(bytes), ":")
-	if len(parts) != 2 {
-		err := fmt.Errorf("expected two parts")
-		lib.Logger.Fatal("error: ", err)
-	}
-	password := parts[1]
-	endpoint := *token.AuthorizationData[0].ProxyEndpoint
-	cmd := exec.Command("docker", "login", "--username", "AWS", "--password", password, endpoint)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	ctx := context.Background()
+	err := lib.EcrLogin(ctx)
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
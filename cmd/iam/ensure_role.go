@@ -21,7 +21,12 @@ type iamEnsureRoleArgs struct {
 }
 
 func (iamEnsureRoleArgs) Description() string {
-	return "\nensure an iam role\n"
+	return `
+ensure an iam role
+
+principal is a service name like ec2 or ecs-tasks, an account id, or an iam arn
+
+`
 }
 
 func iamEnsureRole() {
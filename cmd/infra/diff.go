@@ -0,0 +1,61 @@
+package cliaws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["infra-diff"] = infraDiff
+	lib.Args["infra-diff"] = infraDiffArgs{}
+}
+
+type infraDiffArgs struct {
+	YamlPath string `arg:"positional,required"`
+	Json     bool   `arg:"-j,--json" help:"print the plan as json"`
+}
+
+func (infraDiffArgs) Description() string {
+	return `
+diff infra.yaml against the live account
+
+prints one line per add, change, or remove. changes show the field
+and the values to add (+) or remove (-) to match the yaml.
+
+exits 1 when there is drift, 0 when the account matches the yaml.
+
+`
+}
+
+func infraDiff() {
+	var args infraDiffArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	infraSet, err := lib.InfraParse(args.YamlPath)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	diff, err := lib.InfraDiff(ctx, infraSet)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	if args.Json {
+		bytes, err := json.MarshalIndent(diff, "", "    ")
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+		fmt.Println(string(bytes))
+	} else {
+		for _, change := range diff.Changes {
+			fmt.Println(change)
+		}
+	}
+	if len(diff.Changes) != 0 {
+		os.Exit(1)
+	}
+}
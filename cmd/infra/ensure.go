@@ -17,6 +17,7 @@ type infraEnsureArgs struct {
 	Preview          bool   `arg:"-p,--preview"`
 	Quick            string `arg:"-q,--quick" help:"patch this lambda's code without updating infrastructure"`
 	ShowEnvVarValues bool   `arg:"-v,--env-values" help:"show environment variable values instead of their hash"`
+	MaxConcurrency   int    `arg:"-m,--max-concurrency" default:"8" help:"resources to ensure in parallel, preview always runs serially"`
 }
 
 func (infraEnsureArgs) Description() string {
@@ -31,7 +32,7 @@ func infraEnsure() {
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
-	err = lib.InfraEnsure(ctx, infraSet, args.Quick, args.Preview, args.ShowEnvVarValues)
+	err = lib.InfraEnsure(ctx, infraSet, args.Quick, args.Preview, args.ShowEnvVarValues, args.MaxConcurrency)
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
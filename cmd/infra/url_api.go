@@ -20,7 +20,7 @@ type infraUrlApiArgs struct {
 }
 
 func (infraUrlApiArgs) Description() string {
-	return "\nget infra api url\n"
+	return "\nget infra api url, or the function url for a lambda with a url trigger\n"
 }
 
 func infraUrlApi() {
@@ -31,9 +31,9 @@ func infraUrlApi() {
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
-	for name := range infraSet.Lambda {
+	for name, infraLambda := range infraSet.Lambda {
 		if name == args.LambdaName {
-			url, err := lib.ApiUrl(ctx, name)
+			url, err := lib.InfraLambdaUrl(ctx, name, infraLambda)
 			if err != nil {
 				lib.Logger.Fatal("error: ", err)
 			}
@@ -0,0 +1,33 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["lambda-rollback"] = lambdaRollback
+	lib.Args["lambda-rollback"] = lambdaRollbackArgs{}
+}
+
+type lambdaRollbackArgs struct {
+	Name    string `arg:"positional,required"`
+	Alias   string `arg:"-a,--alias" help:"alias to roll back, defaults to the only alias managed by infra-ensure"`
+	Preview bool   `arg:"-p,--preview"`
+}
+
+func (lambdaRollbackArgs) Description() string {
+	return "\nmove a lambda alias back to the version it pointed at before the last deploy\n"
+}
+
+func lambdaRollback() {
+	var args lambdaRollbackArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	err := lib.LambdaRollback(ctx, args.Name, args.Alias, args.Preview)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
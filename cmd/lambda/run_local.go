@@ -0,0 +1,59 @@
+package cliaws
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["lambda-run-local"] = lambdaRunLocal
+	lib.Args["lambda-run-local"] = lambdaRunLocalArgs{}
+}
+
+type lambdaRunLocalArgs struct {
+	YamlPath string   `arg:"positional,required"`
+	Name     string   `arg:"positional,required"`
+	Event    string   `arg:"-e,--event" help:"json file to invoke with instead of synthetic trigger events, - for stdin"`
+	Trigger  []string `arg:"-t,--trigger,separate" help:"only invoke with events for this trigger type, can be repeated"`
+}
+
+func (lambdaRunLocalArgs) Description() string {
+	return `
+build a lambda from infra.yaml and invoke it locally with an event per trigger
+
+responses are printed to stdout one per line, function output goes to stderr
+
+supported triggers: sqs, s3, dynamodb, sns, schedule, api, websocket
+`
+}
+
+func lambdaRunLocal() {
+	var args lambdaRunLocalArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	infraSet, err := lib.InfraParse(args.YamlPath)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	var events []*lib.LambdaLocalEvent
+	if args.Event != "" {
+		var data []byte
+		if args.Event == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args.Event)
+		}
+		if err != nil {
+			lib.Logger.Fatal("error: ", err)
+		}
+		events = append(events, &lib.LambdaLocalEvent{Trigger: "event", Payload: data})
+	}
+	err = lib.LambdaRunLocal(ctx, infraSet, args.Name, events, args.Trigger)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
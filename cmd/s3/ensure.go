@@ -36,6 +36,13 @@ setting 'cors=true' uses '*' for allowed origins. to specify one or more explici
  - corsorigin=http://localhost:8080
  - corsorigin=https://example.com
 
+lifecycle attrs, days are positive integers and prefixes are case sensitive:
+ - ttldays=DAYS                    expire all objects
+ - expire=DAYS:PREFIX              expire objects under prefix
+ - transition=CLASS:DAYS[:PREFIX]  (class = standard_ia | glacier_ir | deep_archive)
+ - noncurrent-expire=DAYS          expire noncurrent versions, requires versioning=true
+ - abort-multipart=DAYS            abort incomplete multipart uploads
+
 `
 }
 
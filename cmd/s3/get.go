@@ -1,14 +1,11 @@
 package cliaws
 
 import (
+	"bufio"
 	"context"
-	"io"
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/libaws/lib"
 )
@@ -19,11 +16,19 @@ func init() {
 }
 
 type s3GetArgs struct {
-	Path string `arg:"positional"`
+	Path        string `arg:"positional"`
+	PartSize    int64  `arg:"--part-size" default:"64" help:"ranged get size in MB, minimum 5"`
+	Parallelism int    `arg:"-P,--parallelism" default:"8" help:"max concurrent ranged gets"`
 }
 
 func (s3GetArgs) Description() string {
-	return "\nget an object and write it to stdout\n"
+	return `
+get an object and write it to stdout
+
+large objects are fetched with concurrent ranged gets and written in
+order, memory use is bounded by part-size * parallelism.
+
+`
 }
 
 func s3Get() {
@@ -42,16 +47,17 @@ func s3Get() {
 		lib.Logger.Fatal("error: ", err)
 	}
 
-	out, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	stdout := bufio.NewWriter(os.Stdout)
+	err = lib.S3GetStream(ctx, s3Client, &lib.S3StreamInput{
+		Bucket:      bucket,
+		Key:         key,
+		PartSize:    args.PartSize * 1024 * 1024,
+		Parallelism: args.Parallelism,
+	}, stdout)
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
-	defer func() { _ = out.Body.Close() }()
-
-	_, err = io.Copy(os.Stdout, out.Body)
+	err = stdout.Flush()
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
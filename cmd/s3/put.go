@@ -1,17 +1,10 @@
 package cliaws
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
-	"io"
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/libaws/lib"
 )
@@ -22,12 +15,24 @@ func init() {
 }
 
 type s3PutArgs struct {
-	Path   string `arg:"positional"`
-	Sha256 bool   `arg:"-s,--sha256" help:"add sha256 checksum"`
+	Path        string `arg:"positional"`
+	Sha256      bool   `arg:"-s,--sha256" help:"add sha256 checksum"`
+	PartSize    int64  `arg:"--part-size" default:"64" help:"multipart part size in MB, minimum 5"`
+	Parallelism int    `arg:"-P,--parallelism" default:"8" help:"max concurrent part uploads"`
 }
 
 func (s3PutArgs) Description() string {
-	return "\nput an object from stdin\n"
+	return `
+put an object from stdin
+
+stdin is streamed as a multipart upload, memory use is bounded by
+part-size * parallelism. objects smaller than one part use a single
+put. on failure the multipart upload is aborted.
+
+objects are limited to 10000 parts, increase --part-size for very
+large uploads.
+
+`
 }
 
 func s3Put() {
@@ -46,24 +51,13 @@ func s3Put() {
 		lib.Logger.Fatal("error: ", err)
 	}
 
-	val, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		lib.Logger.Fatal("error: ", err)
-	}
-
-	input := &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(val),
-	}
-
-	if args.Sha256 {
-		hash := sha256.Sum256(val)
-		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
-		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(hash[:]))
-	}
-
-	_, err = s3Client.PutObjectWithContext(ctx, input)
+	err = lib.S3PutStream(ctx, s3Client, &lib.S3StreamInput{
+		Bucket:      bucket,
+		Key:         key,
+		PartSize:    args.PartSize * 1024 * 1024,
+		Parallelism: args.Parallelism,
+		Sha256:      args.Sha256,
+	}, os.Stdin)
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
@@ -0,0 +1,56 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["s3-sync"] = s3Sync
+	lib.Args["s3-sync"] = s3SyncArgs{}
+}
+
+type s3SyncArgs struct {
+	Src            string   `arg:"positional,required" help:"local directory or s3://bucket/prefix"`
+	Dst            string   `arg:"positional,required" help:"local directory or s3://bucket/prefix"`
+	Delete         bool     `arg:"-d,--delete" help:"delete files in dst that are not in src"`
+	DryRun         bool     `arg:"-n,--dry-run" help:"print changes without making them"`
+	Include        []string `arg:"-i,--include,separate" help:"only sync paths matching this glob, can be repeated"`
+	Exclude        []string `arg:"-e,--exclude,separate" help:"skip paths matching this glob, can be repeated"`
+	MaxConcurrency int      `arg:"-m,--max-concurrency" default:"16" help:"max concurrent transfers"`
+	PartSize       int64    `arg:"--part-size" default:"64" help:"multipart part size in MB, minimum 5"`
+}
+
+func (s3SyncArgs) Description() string {
+	return `
+sync a local directory and an s3 prefix
+
+example:
+ - libaws s3-sync ./site s3://bucket/ --delete
+ - libaws s3-sync s3://bucket/prefix ./local --exclude '*.tmp'
+
+files are compared by size, then by sha256 checksum when the object
+has one, else by etag. globs match the relative path or the basename.
+
+`
+}
+
+func s3Sync() {
+	var args s3SyncArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	err := lib.S3Sync(ctx, &lib.S3SyncInput{
+		Src:            args.Src,
+		Dst:            args.Dst,
+		Delete:         args.Delete,
+		Include:        args.Include,
+		Exclude:        args.Exclude,
+		MaxConcurrency: args.MaxConcurrency,
+		PartSize:       args.PartSize * 1024 * 1024,
+	}, args.DryRun)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
@@ -0,0 +1,49 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["sns-ensure"] = snsEnsure
+	lib.Args["sns-ensure"] = snsEnsureArgs{}
+}
+
+type snsEnsureArgs struct {
+	Name    string   `arg:"positional,required"`
+	Attr    []string `arg:"positional"`
+	Preview bool     `arg:"-p,--preview"`
+}
+
+func (snsEnsureArgs) Description() string {
+	return `
+ensure a sns topic
+
+example:
+ - libaws sns-ensure test-topic subscribe=sqs:test-queue subscribe=email:ops@example.com
+
+optional attrs:
+ - fifo=BOOL,                 default: false, name must end with .fifo
+ - kms=KEY,                   default: alias/aws/sns, kms=false disables encryption
+ - subscribe=sqs:QUEUE_NAME,  can be specified multiple times
+ - subscribe=email:ADDRESS,   can be specified multiple times
+
+`
+}
+
+func snsEnsure() {
+	var args snsEnsureArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	input, err := lib.SNSEnsureInput("", args.Name, args.Attr)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	err = lib.SNSEnsure(ctx, input, args.Preview)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
@@ -0,0 +1,34 @@
+package cliaws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["sns-ls"] = snsLs
+	lib.Args["sns-ls"] = snsLsArgs{}
+}
+
+type snsLsArgs struct {
+}
+
+func (snsLsArgs) Description() string {
+	return "\nlist sns topics\n"
+}
+
+func snsLs() {
+	var args snsLsArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	topics, err := lib.SNSListTopics(ctx)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	for _, topic := range topics {
+		fmt.Println(topic)
+	}
+}
@@ -0,0 +1,32 @@
+package cliaws
+
+import (
+	"context"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/libaws/lib"
+)
+
+func init() {
+	lib.Commands["sns-rm"] = snsRm
+	lib.Args["sns-rm"] = snsRmArgs{}
+}
+
+type snsRmArgs struct {
+	TopicName string `arg:"positional,required"`
+	Preview   bool   `arg:"-p,--preview"`
+}
+
+func (snsRmArgs) Description() string {
+	return "\ndelete an sns topic\n"
+}
+
+func snsRm() {
+	var args snsRmArgs
+	arg.MustParse(&args)
+	ctx := context.Background()
+	err := lib.SNSDeleteTopic(ctx, args.TopicName, args.Preview)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+}
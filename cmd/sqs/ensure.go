@@ -24,6 +24,8 @@ ensure a sqs queue
 
 example:
  - libaws sqs-ensure test-queue delay=30 timeout=60
+ - libaws sqs-ensure test-queue dlq=test-queue-dlq maxreceive=3
+ - libaws sqs-ensure test-queue.fifo fifo=true
 
 optional attrs:
  - DelaySeconds=VALUE,                  shortcut: delay=VALUE,     default: 0
@@ -31,6 +33,11 @@ optional attrs:
  - MessageRetentionPeriod=VALUE,        shortcut: retention=VALUE  default: 345600
  - ReceiveMessageWaitTimeSeconds=VALUE, shortcut: wait=VALUE       default: 0
  - VisibilityTimeout=VALUE,             shortcut: timeout=VALUE    default: 30
+ - fifo=BOOL,                                                       default: false, name must end with .fifo
+ - ContentBasedDeduplication=BOOL,      shortcut: dedup=BOOL       default: true for fifo queues
+ - dlq=QUEUE_NAME,                                                  default: none, created if it does not exist
+ - maxReceiveCount=VALUE,               shortcut: maxreceive=VALUE default: 5, requires dlq
+ - redrive-allow=allowAll|denyAll|QUEUE_NAME[,QUEUE_NAME],          default: allowAll
 
 `
 }
@@ -14,19 +14,35 @@ func init() {
 }
 
 type vpcEnsureArgs struct {
-	Name    string `arg:"positional,required"`
-	Preview bool   `arg:"-p,--preview"`
+	Name    string   `arg:"positional,required"`
+	Attrs   []string `arg:"positional"`
+	Preview bool     `arg:"-p,--preview"`
 }
 
 func (vpcEnsureArgs) Description() string {
-	return "\nensure a default-like vpc with an internet gateway and public access\n"
+	return `
+ensure a default-like vpc with an internet gateway and public access
+
+example:
+ - libaws vpc-ensure test-vpc cidr=10.1.0.0/16 private-subnets=true nat=single
+
+optional attrs:
+ - cidr=CIDR,                 default: 10.0.0.0/16, from /16 to /24, cannot change after create
+ - private-subnets=BOOL,      default: false, adds a private subnet per zone
+ - nat=single|per-zone,       default: none, nat gateways for private subnets
+
+`
 }
 
 func vpcEnsure() {
 	var args vpcEnsureArgs
 	arg.MustParse(&args)
 	ctx := context.Background()
-	vpc, err := lib.VpcEnsure(ctx, "", args.Name, args.Preview)
+	input, err := lib.VpcEnsureInput("", args.Name, args.Attrs)
+	if err != nil {
+		lib.Logger.Fatal("error: ", err)
+	}
+	vpc, err := lib.VpcEnsure(ctx, input, args.Preview)
 	if err != nil {
 		lib.Logger.Fatal("error: ", err)
 	}
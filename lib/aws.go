@@ -3,6 +3,7 @@ package lib
 import (
 	"context"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,13 +18,56 @@ var sess *session.Session
 var sessLock sync.RWMutex
 var sessRegional = make(map[string]*session.Session)
 
+const (
+	endpointEnvVar            = "LIBAWS_ENDPOINT_URL"
+	endpointS3PathStyleEnvVar = "LIBAWS_S3_FORCE_PATH_STYLE"
+)
+
+// EndpointUrl returns the endpoint override for a service, or "" when the
+// real aws endpoint should be used. service is the sdk endpoint id, ie "s3",
+// "sqs", "dynamodb". LIBAWS_ENDPOINT_URL_$SERVICE takes precedence over
+// LIBAWS_ENDPOINT_URL, which applies to every service.
+func EndpointUrl(service string) string {
+	name := endpointEnvVar + "_" + strings.ToUpper(strings.ReplaceAll(service, "-", "_"))
+	url := os.Getenv(name)
+	if url == "" {
+		url = os.Getenv(endpointEnvVar)
+	}
+	return strings.TrimRight(url, "/")
+}
+
+func endpointResolver(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	url := EndpointUrl(service)
+	if url == "" {
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	}
+	return endpoints.ResolvedEndpoint{
+		URL:           url,
+		SigningRegion: region,
+	}, nil
+}
+
+// sessionConfig applies endpoint overrides, used to point libaws at localstack
+// or other local emulators.
+func sessionConfig(config *aws.Config) *aws.Config {
+	config.EndpointResolver = endpoints.ResolverFunc(endpointResolver)
+	pathStyle := os.Getenv(endpointS3PathStyleEnvVar)
+	if pathStyle == "" && EndpointUrl("s3") != "" {
+		pathStyle = "true"
+	}
+	if pathStyle == "true" {
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	return config
+}
+
 func SessionExplicit(accessKeyID, accessKeySecret, region string) *session.Session {
-	sess, err := session.NewSession(&aws.Config{
+	sess, err := session.NewSession(sessionConfig(&aws.Config{
 		Region:              aws.String(region),
 		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
 		MaxRetries:          aws.Int(5),
 		Credentials:         credentials.NewStaticCredentials(accessKeyID, accessKeySecret, ""),
-	})
+	}))
 	if err != nil {
 		panic(err)
 	}
@@ -38,10 +82,10 @@ func Session() *session.Session {
 		if err != nil {
 			panic(err)
 		}
-		sess = session.Must(session.NewSession(&aws.Config{
+		sess = session.Must(session.NewSession(sessionConfig(&aws.Config{
 			STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
 			MaxRetries:          aws.Int(5),
-		}))
+		})))
 	}
 	return sess
 }
@@ -55,11 +99,11 @@ func SessionRegion(region string) (*session.Session, error) {
 		if err != nil {
 			return nil, err
 		}
-		sess, err = session.NewSession(&aws.Config{
+		sess, err = session.NewSession(sessionConfig(&aws.Config{
 			Region:              aws.String(region),
 			STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
 			MaxRetries:          aws.Int(5),
-		})
+		}))
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestEndpointUrl(t *testing.T) {
+	type test struct {
+		service  string
+		all      string
+		s3       string
+		expected string
+	}
+	tests := []test{
+		{"s3", "", "", ""},
+		{"s3", "http://localhost:4566", "", "http://localhost:4566"},
+		{"s3", "http://localhost:4566/", "", "http://localhost:4566"},
+		{"s3", "http://localhost:4566", "http://localhost:9000", "http://localhost:9000"},
+		{"sqs", "http://localhost:4566", "http://localhost:9000", "http://localhost:4566"},
+		{"sqs", "", "http://localhost:9000", ""},
+	}
+	for _, test := range tests {
+		t.Setenv("LIBAWS_ENDPOINT_URL", test.all)
+		t.Setenv("LIBAWS_ENDPOINT_URL_S3", test.s3)
+		output := EndpointUrl(test.service)
+		if output != test.expected {
+			t.Errorf("\ngot:\n%s\nwant:\n%s\n", output, test.expected)
+		}
+	}
+}
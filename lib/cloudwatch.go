@@ -2,6 +2,9 @@ package lib
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -114,22 +117,451 @@ func CloudwatchListAlarms(ctx context.Context) ([]*CloudwatchAlarm, error) {
 	return result, nil
 }
 
-func CloudwatchEnsureAlarm(ctx context.Context, name string) error {
+const (
+	cloudwatchAlarmAttrNamespace  = "namespace"
+	cloudwatchAlarmAttrMetric     = "metric"
+	cloudwatchAlarmAttrDimension  = "dimension"
+	cloudwatchAlarmAttrStatistic  = "statistic"
+	cloudwatchAlarmAttrThreshold  = "threshold"
+	cloudwatchAlarmAttrComparison = "comparison"
+	cloudwatchAlarmAttrPeriod     = "period"
+	cloudwatchAlarmAttrPeriods    = "periods"
+	cloudwatchAlarmAttrDatapoints = "datapoints"
+	cloudwatchAlarmAttrMissing    = "missing"
+	cloudwatchAlarmAttrAction     = "action"
+	cloudwatchAlarmAttrOkAction   = "ok-action"
+
+	cloudwatchAlarmStatisticDefault  = cloudwatch.StatisticAverage
+	cloudwatchAlarmPeriodDefault     = 60
+	cloudwatchAlarmPeriodsDefault    = 1
+	cloudwatchAlarmMissingDefault    = "missing"
+	cloudwatchAlarmComparisonDefault = cloudwatch.ComparisonOperatorGreaterThanOrEqualToThreshold
+)
+
+var cloudwatchAlarmComparisons = map[string]string{
+	"gt":  cloudwatch.ComparisonOperatorGreaterThanThreshold,
+	"gte": cloudwatch.ComparisonOperatorGreaterThanOrEqualToThreshold,
+	"lt":  cloudwatch.ComparisonOperatorLessThanThreshold,
+	"lte": cloudwatch.ComparisonOperatorLessThanOrEqualToThreshold,
+}
+
+type cloudwatchEnsureAlarmInput struct {
+	infraSetName string
+	name         string
+	namespace    string
+	metric       string
+	dimensions   []string
+	statistic    string
+	threshold    *float64
+	comparison   string
+	period       int
+	periods      int
+	datapoints   int
+	missing      string
+	actions      []string
+	okActions    []string
+}
+
+func CloudwatchEnsureAlarmInput(infraSetName, alarmName string, attrs []string) (*cloudwatchEnsureAlarmInput, error) {
+	input := &cloudwatchEnsureAlarmInput{
+		infraSetName: infraSetName,
+		name:         alarmName,
+		statistic:    cloudwatchAlarmStatisticDefault,
+		comparison:   cloudwatchAlarmComparisonDefault,
+		period:       cloudwatchAlarmPeriodDefault,
+		periods:      cloudwatchAlarmPeriodsDefault,
+		missing:      cloudwatchAlarmMissingDefault,
+	}
+	for _, line := range attrs {
+		attr, value, err := SplitOnce(line, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch strings.ToLower(attr) {
+		case cloudwatchAlarmAttrNamespace:
+			input.namespace = value
+		case cloudwatchAlarmAttrMetric:
+			input.metric = value
+		case cloudwatchAlarmAttrDimension:
+			_, _, err := SplitOnce(value, "=")
+			if err != nil {
+				err := fmt.Errorf("alarm dimension should be NAME=VALUE, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.dimensions = append(input.dimensions, value)
+		case cloudwatchAlarmAttrStatistic:
+			found := false
+			for _, stat := range cloudwatch.Statistic_Values() {
+				if strings.EqualFold(stat, value) {
+					input.statistic = stat
+					found = true
+					break
+				}
+			}
+			if !found {
+				err := fmt.Errorf("unknown alarm statistic, should be one of %v, got: %s", cloudwatch.Statistic_Values(), line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		case cloudwatchAlarmAttrThreshold:
+			threshold, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.threshold = aws.Float64(threshold)
+		case cloudwatchAlarmAttrComparison:
+			comparison, ok := cloudwatchAlarmComparisons[strings.ToLower(value)]
+			if !ok {
+				err := fmt.Errorf("unknown alarm comparison, should be one of gt|gte|lt|lte, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.comparison = comparison
+		case cloudwatchAlarmAttrPeriod:
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.period = num
+		case cloudwatchAlarmAttrPeriods:
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.periods = num
+		case cloudwatchAlarmAttrDatapoints:
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.datapoints = num
+		case cloudwatchAlarmAttrMissing:
+			found := false
+			for _, missing := range []string{"missing", "notBreaching", "breaching", "ignore"} {
+				if strings.EqualFold(missing, value) {
+					input.missing = missing
+					found = true
+					break
+				}
+			}
+			if !found {
+				err := fmt.Errorf("unknown alarm missing data treatment, should be one of missing|notBreaching|breaching|ignore, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		case cloudwatchAlarmAttrAction:
+			input.actions = append(input.actions, value)
+		case cloudwatchAlarmAttrOkAction:
+			input.okActions = append(input.okActions, value)
+		default:
+			err := fmt.Errorf("unknown alarm attr: %s", line)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	if input.namespace == "" || input.metric == "" || input.threshold == nil {
+		err := fmt.Errorf("alarm %s requires attrs: namespace, metric, threshold", alarmName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if input.datapoints == 0 {
+		input.datapoints = input.periods
+	}
+	if input.datapoints > input.periods {
+		err := fmt.Errorf("alarm %s datapoints cannot exceed periods: %d > %d", alarmName, input.datapoints, input.periods)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return input, nil
+}
+
+// required attrs, then optional attrs not at their default, with statistic and
+// comparison spelled the way InfraListAlarm reports them
+func (input *cloudwatchEnsureAlarmInput) canonicalAttrs() []string {
+	attrs := []string{
+		cloudwatchAlarmAttrNamespace + "=" + input.namespace,
+		cloudwatchAlarmAttrMetric + "=" + input.metric,
+	}
+	for _, dimension := range input.dimensions {
+		attrs = append(attrs, cloudwatchAlarmAttrDimension+"="+dimension)
+	}
+	if input.statistic != cloudwatchAlarmStatisticDefault {
+		attrs = append(attrs, cloudwatchAlarmAttrStatistic+"="+strings.ToLower(input.statistic))
+	}
+	attrs = append(attrs, fmt.Sprintf("%s=%v", cloudwatchAlarmAttrThreshold, *input.threshold))
+	if input.comparison != cloudwatchAlarmComparisonDefault {
+		for k, v := range cloudwatchAlarmComparisons {
+			if v == input.comparison {
+				attrs = append(attrs, cloudwatchAlarmAttrComparison+"="+k)
+				break
+			}
+		}
+	}
+	if input.period != cloudwatchAlarmPeriodDefault {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", cloudwatchAlarmAttrPeriod, input.period))
+	}
+	if input.periods != cloudwatchAlarmPeriodsDefault {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", cloudwatchAlarmAttrPeriods, input.periods))
+	}
+	if input.datapoints != input.periods {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", cloudwatchAlarmAttrDatapoints, input.datapoints))
+	}
+	if input.missing != cloudwatchAlarmMissingDefault {
+		attrs = append(attrs, cloudwatchAlarmAttrMissing+"="+input.missing)
+	}
+	for _, action := range input.actions {
+		attrs = append(attrs, cloudwatchAlarmAttrAction+"="+action)
+	}
+	for _, action := range input.okActions {
+		attrs = append(attrs, cloudwatchAlarmAttrOkAction+"="+action)
+	}
+	return attrs
+}
+
+// actions are sns topic names or arns
+func cloudwatchAlarmActionArns(ctx context.Context, actions []string) ([]*string, error) {
+	var arns []*string
+	for _, action := range actions {
+		if strings.HasPrefix(action, "arn:") {
+			arns = append(arns, aws.String(action))
+			continue
+		}
+		arn, err := SNSArn(ctx, action)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		arns = append(arns, aws.String(arn))
+	}
+	return arns, nil
+}
+
+func (input *cloudwatchEnsureAlarmInput) putMetricAlarmInput(ctx context.Context) (*cloudwatch.PutMetricAlarmInput, error) {
+	alarmActions, err := cloudwatchAlarmActionArns(ctx, input.actions)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	okActions, err := cloudwatchAlarmActionArns(ctx, input.okActions)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	putInput := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:          aws.String(input.name),
+		ActionsEnabled:     aws.Bool(true),
+		AlarmActions:       alarmActions,
+		OKActions:          okActions,
+		Namespace:          aws.String(input.namespace),
+		MetricName:         aws.String(input.metric),
+		Statistic:          aws.String(input.statistic),
+		Threshold:          input.threshold,
+		ComparisonOperator: aws.String(input.comparison),
+		Period:             aws.Int64(int64(input.period)),
+		EvaluationPeriods:  aws.Int64(int64(input.periods)),
+		DatapointsToAlarm:  aws.Int64(int64(input.datapoints)),
+		TreatMissingData:   aws.String(input.missing),
+		Tags: []*cloudwatch.Tag{{
+			Key:   aws.String(infraSetTagName),
+			Value: aws.String(input.infraSetName),
+		}},
+	}
+	for _, dimension := range input.dimensions {
+		k, v, err := SplitOnce(dimension, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		putInput.Dimensions = append(putInput.Dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+	return putInput, nil
+}
+
+func cloudwatchAlarmDimensionsString(dimensions []*cloudwatch.Dimension) string {
+	var xs []string
+	for _, dimension := range dimensions {
+		xs = append(xs, *dimension.Name+"="+*dimension.Value)
+	}
+	sort.Strings(xs)
+	return strings.Join(xs, ",")
+}
+
+func cloudwatchAlarmActionsString(actions []*string) string {
+	xs := StringSlice(actions)
+	sort.Strings(xs)
+	return strings.Join(xs, ",")
+}
+
+func CloudwatchDescribeAlarm(ctx context.Context, name string) (*cloudwatch.MetricAlarm, error) {
 	if doDebug {
-		d := &Debug{start: time.Now(), name: "CloudwatchEnsureAlarm"}
+		d := &Debug{start: time.Now(), name: "CloudwatchDescribeAlarm"}
 		defer d.Log()
 	}
 	out, err := CloudwatchClient().DescribeAlarmsWithContext(ctx, &cloudwatch.DescribeAlarmsInput{
 		AlarmNames: []*string{
 			aws.String(name),
 		},
+		AlarmTypes: []*string{
+			aws.String(cloudwatch.AlarmTypeMetricAlarm),
+		},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	switch len(out.MetricAlarms) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out.MetricAlarms[0], nil
+	default:
+		err := fmt.Errorf("%s alarm: %s", ErrPrefixDidntFindExactlyOne, name)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+}
+
+func CloudwatchEnsureAlarm(ctx context.Context, input *cloudwatchEnsureAlarmInput, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "CloudwatchEnsureAlarm"}
+		defer d.Log()
+	}
+	putInput, err := input.putMetricAlarmInput(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	alarm, err := CloudwatchDescribeAlarm(ctx, input.name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if alarm == nil {
+		if !preview {
+			_, err := CloudwatchClient().PutMetricAlarmWithContext(ctx, putInput)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"created alarm:", input.name)
+		return nil
+	}
+	existing := map[string]*string{
+		"namespace":  alarm.Namespace,
+		"metric":     alarm.MetricName,
+		"dimension":  aws.String(cloudwatchAlarmDimensionsString(alarm.Dimensions)),
+		"statistic":  alarm.Statistic,
+		"threshold":  aws.String(fmt.Sprint(aws.Float64Value(alarm.Threshold))),
+		"comparison": alarm.ComparisonOperator,
+		"period":     aws.String(fmt.Sprint(aws.Int64Value(alarm.Period))),
+		"periods":    aws.String(fmt.Sprint(aws.Int64Value(alarm.EvaluationPeriods))),
+		"datapoints": aws.String(fmt.Sprint(aws.Int64Value(alarm.DatapointsToAlarm))),
+		"missing":    alarm.TreatMissingData,
+		"action":     aws.String(cloudwatchAlarmActionsString(alarm.AlarmActions)),
+		"ok-action":  aws.String(cloudwatchAlarmActionsString(alarm.OKActions)),
+	}
+	desired := map[string]*string{
+		"namespace":  putInput.Namespace,
+		"metric":     putInput.MetricName,
+		"dimension":  aws.String(cloudwatchAlarmDimensionsString(putInput.Dimensions)),
+		"statistic":  putInput.Statistic,
+		"threshold":  aws.String(fmt.Sprint(*putInput.Threshold)),
+		"comparison": putInput.ComparisonOperator,
+		"period":     aws.String(fmt.Sprint(*putInput.Period)),
+		"periods":    aws.String(fmt.Sprint(*putInput.EvaluationPeriods)),
+		"datapoints": aws.String(fmt.Sprint(*putInput.DatapointsToAlarm)),
+		"missing":    putInput.TreatMissingData,
+		"action":     aws.String(cloudwatchAlarmActionsString(putInput.AlarmActions)),
+		"ok-action":  aws.String(cloudwatchAlarmActionsString(putInput.OKActions)),
+	}
+	for k, v := range existing {
+		if v != nil && *v == "" {
+			delete(existing, k)
+		}
+	}
+	for k, v := range desired {
+		if v != nil && *v == "" {
+			delete(desired, k)
+		}
+	}
+	needsUpdate, err := diffMapStringStringPointers(desired, existing, PreviewString(preview)+"alarm "+input.name+":", true)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if needsUpdate {
+		if !preview {
+			_, err := CloudwatchClient().PutMetricAlarmWithContext(ctx, putInput)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"updated alarm:", input.name)
+	}
+	tagsOut, err := CloudwatchClient().ListTagsForResourceWithContext(ctx, &cloudwatch.ListTagsForResourceInput{
+		ResourceARN: alarm.AlarmArn,
 	})
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	_ = out
-	_, _ = CloudwatchClient().PutMetricAlarmWithContext(ctx, &cloudwatch.PutMetricAlarmInput{})
+	tagged := false
+	for _, tag := range tagsOut.Tags {
+		if *tag.Key == infraSetTagName && *tag.Value == input.infraSetName {
+			tagged = true
+			break
+		}
+	}
+	if !tagged {
+		if !preview {
+			_, err := CloudwatchClient().TagResourceWithContext(ctx, &cloudwatch.TagResourceInput{
+				ResourceARN: alarm.AlarmArn,
+				Tags:        putInput.Tags,
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"updated alarm tags:", input.name, infraSetTagName+"="+input.infraSetName)
+	}
+	return nil
+}
+
+func CloudwatchDeleteAlarm(ctx context.Context, name string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "CloudwatchDeleteAlarm"}
+		defer d.Log()
+	}
+	alarm, err := CloudwatchDescribeAlarm(ctx, name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if alarm == nil {
+		return nil
+	}
+	if !preview {
+		_, err := CloudwatchClient().DeleteAlarmsWithContext(ctx, &cloudwatch.DeleteAlarmsInput{
+			AlarmNames: []*string{aws.String(name)},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"deleted alarm:", name)
 	return nil
 }
 
@@ -173,6 +605,13 @@ func CloudwatchGetMetricData(ctx context.Context, period int, stat string, fromT
 			NextToken:         token,
 			MetricDataQueries: []*cloudwatch.MetricDataQuery{},
 		}
+		var dimensions []*cloudwatch.Dimension
+		for _, dim := range strings.Split(dimension, ",") {
+			dimensions = append(dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(strings.Split(dim, "=")[0]),
+				Value: aws.String(strings.Split(dim, "=")[1]),
+			})
+		}
 		for _, metric := range metrics {
 			input.MetricDataQueries = append(input.MetricDataQueries, &cloudwatch.MetricDataQuery{
 				Id: aws.String("a" + strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")),
@@ -182,10 +621,7 @@ func CloudwatchGetMetricData(ctx context.Context, period int, stat string, fromT
 					Metric: &cloudwatch.Metric{
 						Namespace:  aws.String(namespace),
 						MetricName: aws.String(metric),
-						Dimensions: []*cloudwatch.Dimension{{
-							Name:  aws.String(strings.Split(dimension, "=")[0]),
-							Value: aws.String(strings.Split(dimension, "=")[1]),
-						}},
+						Dimensions: dimensions,
 					},
 				},
 			})
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestCloudwatchEnsureAlarmInput(t *testing.T) {
+	type test struct {
+		attrs []string
+		input *cloudwatchEnsureAlarmInput
+		err   bool
+	}
+	tests := []test{
+		{
+			[]string{"namespace=AWS/Lambda", "metric=Errors", "threshold=1"},
+			&cloudwatchEnsureAlarmInput{
+				name:       "alarm",
+				namespace:  "AWS/Lambda",
+				metric:     "Errors",
+				threshold:  aws.Float64(1),
+				statistic:  "Average",
+				comparison: "GreaterThanOrEqualToThreshold",
+				period:     60,
+				periods:    1,
+				datapoints: 1,
+				missing:    "missing",
+			},
+			false,
+		},
+		{
+			[]string{
+				"namespace=AWS/SQS",
+				"metric=ApproximateAgeOfOldestMessage",
+				"dimension=QueueName=jobs",
+				"statistic=maximum",
+				"threshold=900.5",
+				"comparison=gt",
+				"period=300",
+				"periods=3",
+				"datapoints=2",
+				"missing=notbreaching",
+				"action=oncall",
+				"ok-action=oncall",
+			},
+			&cloudwatchEnsureAlarmInput{
+				name:       "alarm",
+				namespace:  "AWS/SQS",
+				metric:     "ApproximateAgeOfOldestMessage",
+				dimensions: []string{"QueueName=jobs"},
+				threshold:  aws.Float64(900.5),
+				statistic:  "Maximum",
+				comparison: "GreaterThanThreshold",
+				period:     300,
+				periods:    3,
+				datapoints: 2,
+				missing:    "notBreaching",
+				actions:    []string{"oncall"},
+				okActions:  []string{"oncall"},
+			},
+			false,
+		},
+		{[]string{"namespace=AWS/Lambda", "metric=Errors"}, nil, true},
+		{[]string{"namespace=AWS/Lambda", "metric=Errors", "threshold=1", "comparison=eq"}, nil, true},
+		{[]string{"namespace=AWS/Lambda", "metric=Errors", "threshold=1", "datapoints=2"}, nil, true},
+		{[]string{"namespace=AWS/Lambda", "metric=Errors", "threshold=1", "dimension=FunctionName"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := CloudwatchEnsureAlarmInput("", "alarm", test.attrs)
+		if err != nil {
+			if !test.err {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("expected error: %v", test.attrs)
+			continue
+		}
+		if !reflect.DeepEqual(input, test.input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", input, test.input)
+		}
+	}
+}
@@ -457,6 +457,59 @@ func DynamoDBEnsureInput(infraSetName, tableName string, keys []string, attrs []
 	return input, nil
 }
 
+// table attrs as InfraListDynamoDB reports them, with shortcuts for throughput
+// and stream. sse is not reported by infra list, so it is omitted.
+func dynamoDBCanonicalAttrs(input *dynamodb.CreateTableInput) []string {
+	var attrs []string
+	attrTypes := make(map[string]string)
+	for _, attr := range input.AttributeDefinitions {
+		attrTypes[*attr.AttributeName] = *attr.AttributeType
+	}
+	if input.ProvisionedThroughput != nil {
+		if aws.Int64Value(input.ProvisionedThroughput.ReadCapacityUnits) != 0 {
+			attrs = append(attrs, fmt.Sprintf("read=%d", *input.ProvisionedThroughput.ReadCapacityUnits))
+		}
+		if aws.Int64Value(input.ProvisionedThroughput.WriteCapacityUnits) != 0 {
+			attrs = append(attrs, fmt.Sprintf("write=%d", *input.ProvisionedThroughput.WriteCapacityUnits))
+		}
+	}
+	if input.StreamSpecification != nil && aws.BoolValue(input.StreamSpecification.StreamEnabled) {
+		attrs = append(attrs, fmt.Sprintf("stream=%s", strings.ToLower(aws.StringValue(input.StreamSpecification.StreamViewType))))
+	}
+	projection := func(prefix string, projection *dynamodb.Projection) {
+		if projection == nil {
+			return
+		}
+		attrs = append(attrs, fmt.Sprintf("%s.Projection.ProjectionType=%s", prefix, aws.StringValue(projection.ProjectionType)))
+		for j, attr := range projection.NonKeyAttributes {
+			attrs = append(attrs, fmt.Sprintf("%s.Projection.NonKeyAttributes.%d=%s", prefix, j, *attr))
+		}
+	}
+	for i, index := range input.LocalSecondaryIndexes {
+		prefix := fmt.Sprintf("LocalSecondaryIndexes.%d", i)
+		attrs = append(attrs, fmt.Sprintf("%s.IndexName=%s", prefix, aws.StringValue(index.IndexName)))
+		for j, key := range index.KeySchema {
+			attrs = append(attrs, fmt.Sprintf("%s.Key.%d=%s:%s:%s", prefix, j, *key.AttributeName, attrTypes[*key.AttributeName], *key.KeyType))
+		}
+		projection(prefix, index.Projection)
+	}
+	for i, index := range input.GlobalSecondaryIndexes {
+		prefix := fmt.Sprintf("GlobalSecondaryIndexes.%d", i)
+		attrs = append(attrs, fmt.Sprintf("%s.IndexName=%s", prefix, aws.StringValue(index.IndexName)))
+		for j, key := range index.KeySchema {
+			attrs = append(attrs, fmt.Sprintf("%s.Key.%d=%s:%s:%s", prefix, j, *key.AttributeName, attrTypes[*key.AttributeName], *key.KeyType))
+		}
+		projection(prefix, index.Projection)
+		throughput := index.ProvisionedThroughput
+		if throughput == nil {
+			throughput = &dynamodb.ProvisionedThroughput{}
+		}
+		attrs = append(attrs, fmt.Sprintf("%s.ProvisionedThroughput.ReadCapacityUnits=%d", prefix, aws.Int64Value(throughput.ReadCapacityUnits)))
+		attrs = append(attrs, fmt.Sprintf("%s.ProvisionedThroughput.WriteCapacityUnits=%d", prefix, aws.Int64Value(throughput.WriteCapacityUnits)))
+	}
+	return attrs
+}
+
 func DynamoDBEnsure(ctx context.Context, input *dynamodb.CreateTableInput, preview bool) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "DynamoDBEnsure"}
@@ -5,11 +5,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -18,6 +21,7 @@ import (
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/sync/semaphore"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -83,6 +87,7 @@ type EC2Config struct {
 	Key            string
 	TempKey        bool
 	InstanceType   string
+	InstanceTypes  []string // alternates to InstanceType, all must share its arch
 	SubnetIds      []string
 	Gigs           int
 	Throughput     int
@@ -91,6 +96,29 @@ type EC2Config struct {
 	Tags           []EC2Tag
 	Profile        string
 	SecondsTimeout int
+
+	// when set to EC2SpotFallbackOnDemand, a spot fleet that reports capacity
+	// errors, or is not fulfilled within SpotFallbackSeconds, launches the
+	// missing instances on-demand
+	SpotFallback        string
+	SpotFallbackSeconds int
+}
+
+const (
+	EC2SpotFallbackOnDemand = "ondemand"
+
+	ec2SpotFallbackSecondsDefault = 300
+)
+
+// InstanceType followed by any distinct InstanceTypes
+func (config *EC2Config) instanceTypes() []string {
+	types := []string{config.InstanceType}
+	for _, instanceType := range config.InstanceTypes {
+		if !Contains(types, instanceType) {
+			types = append(types, instanceType)
+		}
+	}
+	return types
 }
 
 func EC2DescribeSpotFleet(ctx context.Context, spotFleetRequestId *string) (*ec2.SpotFleetRequestConfig, error) {
@@ -446,14 +474,41 @@ func ec2SpotFleetHistoryErrors(ctx context.Context, spotFleetRequestId *string)
 		}
 	}
 	if len(errors) != 0 {
-		err = fmt.Errorf(strings.Join(errors, "\n"))
+		err := &ec2SpotFleetHistoryError{events: errors}
 		Logger.Println("error: spot fleet history error:", err)
 		return err
 	}
 	return nil
 }
 
-func ec2WaitSpotFleet(ctx context.Context, spotFleetRequestId *string, num int) error {
+// events like insufficient capacity recorded in a spot fleet's history
+type ec2SpotFleetHistoryError struct {
+	events []string
+}
+
+func (e *ec2SpotFleetHistoryError) Error() string {
+	return strings.Join(e.events, "\n")
+}
+
+// returned by ec2WaitSpotFleet when the fleet is not fulfilled before its deadline
+var ec2ErrSpotFleetDeadline = fmt.Errorf("spot fleet not fulfilled before deadline")
+
+// whether a spot fleet wait error means the fleet was not fulfilled, and the
+// missing instances should be launched on-demand. history errors are usually
+// capacity shortfalls, so they fall back right away instead of at the deadline.
+func ec2SpotFleetShouldFallback(spotFallback string, err error) bool {
+	if spotFallback != EC2SpotFallbackOnDemand {
+		return false
+	}
+	if err == ec2ErrSpotFleetDeadline {
+		return true
+	}
+	_, ok := err.(*ec2SpotFleetHistoryError)
+	return ok
+}
+
+// wait for a spot fleet to be fulfilled, failing early after deadline unless it is zero
+func ec2WaitSpotFleet(ctx context.Context, spotFleetRequestId *string, num int, deadline time.Time) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "ec2WaitSpotFleet"}
 		defer d.Log()
@@ -487,6 +542,10 @@ func ec2WaitSpotFleet(ctx context.Context, spotFleetRequestId *string, num int)
 			num_ready++
 		}
 		if num_ready < num {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				Logger.Printf("error: %s: %d/%d\n", ec2ErrSpotFleetDeadline, num_ready, num)
+				return ec2ErrSpotFleetDeadline
+			}
 			Logger.Printf("waiting for instances: %d/%d\n", num_ready, num)
 			select {
 			case <-time.After(5 * time.Second):
@@ -685,6 +744,9 @@ func EC2RequestSpotFleet(ctx context.Context, spotStrategy string, config *EC2Co
 	if !Contains(ec2.AllocationStrategy_Values(), spotStrategy) {
 		return nil, fmt.Errorf("invalid spot allocation strategy: %s", spotStrategy)
 	}
+	if !Contains([]string{"", EC2SpotFallbackOnDemand}, config.SpotFallback) {
+		return nil, fmt.Errorf("invalid spot fallback: %s", config.SpotFallback)
+	}
 	role, err := IamClient().GetRoleWithContext(ctx, &iam.GetRoleInput{
 		RoleName: aws.String(EC2SpotFleetTaggingRole),
 	})
@@ -692,19 +754,24 @@ func EC2RequestSpotFleet(ctx context.Context, spotStrategy string, config *EC2Co
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	init, err := makeInit(config)
+	inits, err := makeInits(config, config.instanceTypes())
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	pairs, err := ec2LaunchPairs(ctx, config)
 	if err != nil {
 		Logger.Println("error:", err)
 		return nil, err
 	}
 	launchSpecs := []*ec2.SpotFleetLaunchSpecification{}
-	for _, subnetId := range config.SubnetIds {
+	for _, pair := range pairs {
 		launchSpec := &ec2.SpotFleetLaunchSpecification{
 			ImageId:             aws.String(config.AmiID),
 			KeyName:             aws.String(config.Key),
-			SubnetId:            aws.String(subnetId),
-			InstanceType:        aws.String(config.InstanceType),
-			UserData:            aws.String(init),
+			SubnetId:            aws.String(pair.subnetID),
+			InstanceType:        aws.String(pair.instanceType),
+			UserData:            aws.String(inits[pair.instanceType]),
 			EbsOptimized:        aws.Bool(true),
 			SecurityGroups:      []*ec2.GroupIdentifier{{GroupId: aws.String(config.SgID)}},
 			BlockDeviceMappings: makeBlockDeviceMapping(config),
@@ -728,7 +795,7 @@ func EC2RequestSpotFleet(ctx context.Context, spotStrategy string, config *EC2Co
 		ReplaceUnhealthyInstances:        aws.Bool(false),
 		TerminateInstancesWithExpiration: aws.Bool(false),
 	}})
-	Logger.Println("type:", config.InstanceType)
+	Logger.Println("types:", config.instanceTypes())
 	Logger.Println("subnets:", config.SubnetIds)
 	launchSpecs[0].UserData = nil
 	launchSpecs[0].SubnetId = nil
@@ -737,7 +804,23 @@ func EC2RequestSpotFleet(ctx context.Context, spotStrategy string, config *EC2Co
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	err = ec2WaitSpotFleet(ctx, spotFleet.SpotFleetRequestId, config.NumInstances)
+	var deadline time.Time
+	if config.SpotFallback != "" {
+		seconds := config.SpotFallbackSeconds
+		if seconds == 0 {
+			seconds = ec2SpotFallbackSecondsDefault
+		}
+		deadline = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	err = ec2WaitSpotFleet(ctx, spotFleet.SpotFleetRequestId, config.NumInstances, deadline)
+	if ec2SpotFleetShouldFallback(config.SpotFallback, err) {
+		instances, err := ec2SpotFleetFallback(ctx, config, spotFleet.SpotFleetRequestId, pairs, inits)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		return instances, nil
+	}
 	if err != nil {
 		Logger.Println("error:", err)
 		err2 := EC2TeardownSpotFleet(context.Background(), spotFleet.SpotFleetRequestId)
@@ -768,34 +851,172 @@ func EC2RequestSpotFleet(ctx context.Context, spotStrategy string, config *EC2Co
 	return instances, nil
 }
 
-func makeInit(config *EC2Config) (string, error) {
-	if config.UserName == "" {
-		err := fmt.Errorf("makeInit needs a username")
+// keep the spot instances a fleet has launched and launch the rest on-demand.
+// if on-demand launches fail too, the spot instances are terminated.
+func ec2SpotFleetFallback(ctx context.Context, config *EC2Config, spotFleetRequestId *string, pairs []ec2LaunchPair, inits map[string]string) ([]*ec2.Instance, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "ec2SpotFleetFallback"}
+		defer d.Log()
+	}
+	err := ec2FinalizeSpotFleet(ctx, spotFleetRequestId)
+	if err != nil {
 		Logger.Println("error:", err)
-		return "", err
+		return nil, err
+	}
+	// a fleet can still launch instances until its cancellation completes
+	err = ec2WaitSpotFleetCancelled(ctx, spotFleetRequestId)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	fleetInstances, err := EC2DescribeSpotFleetActiveInstances(ctx, spotFleetRequestId)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
 	}
-	init := config.Init
-	for _, instanceType := range []string{"i3", "i3en", "i4i", "c5d", "m5d", "r5d", "z1d", "c6gd", "c6id", "m6gd", "r6gd", "c5ad", "is4gen", "im4gn"} {
-		if instanceType == strings.Split(config.InstanceType, ".")[0] {
-			init = nvmeInit + init
+	var instanceIDs []string
+	for _, instance := range fleetInstances {
+		instanceIDs = append(instanceIDs, *instance.InstanceId)
+	}
+	missing := config.NumInstances - len(instanceIDs)
+	Logger.Printf("spot fleet fallback to on-demand for instances: %d/%d\n", missing, config.NumInstances)
+	if missing > 0 {
+		instances, err := ec2RunInstancesAny(ctx, config, pairs, inits, missing)
+		if err != nil {
+			Logger.Println("error:", err)
+			if len(instanceIDs) > 0 {
+				_, err2 := EC2Client().TerminateInstancesWithContext(context.Background(), &ec2.TerminateInstancesInput{
+					InstanceIds: aws.StringSlice(instanceIDs),
+				})
+				if err2 != nil {
+					Logger.Println("error:", err2)
+					return nil, err2
+				}
+			}
+			return nil, err
+		}
+		for _, instance := range instances {
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
+		}
+	}
+	instances, err := EC2DescribeInstances(ctx, instanceIDs)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return instances, nil
+}
+
+func ec2WaitSpotFleetCancelled(ctx context.Context, spotFleetRequestId *string) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "ec2WaitSpotFleetCancelled"}
+		defer d.Log()
+	}
+	for i := 0; i < 60; i++ {
+		config, err := EC2DescribeSpotFleet(ctx, spotFleetRequestId)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if strings.HasPrefix(*config.SpotFleetRequestState, ec2.BatchStateCancelled) {
+			return nil
+		}
+		Logger.Println("waiting for spot fleet cancellation:", *spotFleetRequestId, *config.SpotFleetRequestState)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := fmt.Errorf("failed to wait for spot fleet cancellation: %s", *spotFleetRequestId)
+	Logger.Println("error:", err)
+	return err
+}
+
+// an instance type and a subnet in a zone that offers it
+type ec2LaunchPair struct {
+	instanceType string
+	subnetID     string
+	zone         string
+}
+
+// every pair of config instance type and subnet whose zone offers that type,
+// ordered by instance type preference
+func ec2LaunchPairs(ctx context.Context, config *EC2Config) ([]ec2LaunchPair, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "ec2LaunchPairs"}
+		defer d.Log()
+	}
+	subnets, err := EC2Client().DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(config.SubnetIds),
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	types := config.instanceTypes()
+	offered := map[string]bool{}
+	var token *string
+	for {
+		out, err := EC2Client().DescribeInstanceTypeOfferingsWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+			Filters:      []*ec2.Filter{{Name: aws.String("instance-type"), Values: aws.StringSlice(types)}},
+			NextToken:    token,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, offer := range out.InstanceTypeOfferings {
+			offered[*offer.InstanceType+"::"+*offer.Location] = true
+		}
+		if out.NextToken == nil {
 			break
 		}
+		token = out.NextToken
+	}
+	var pairs []ec2LaunchPair
+	for _, instanceType := range types {
+		for _, subnetID := range config.SubnetIds {
+			for _, subnet := range subnets.Subnets {
+				if *subnet.SubnetId == subnetID && offered[instanceType+"::"+*subnet.AvailabilityZone] {
+					pairs = append(pairs, ec2LaunchPair{instanceType: instanceType, subnetID: subnetID, zone: *subnet.AvailabilityZone})
+				}
+			}
+		}
 	}
+	if len(pairs) == 0 {
+		err := fmt.Errorf("no subnets in zones offering instance types %v: %v", types, config.SubnetIds)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// user data for each instance type, which differ only in nvme setup. any temp
+// key is generated once and shared by all of them.
+func makeInits(config *EC2Config, instanceTypes []string) (map[string]string, error) {
+	if config.UserName == "" {
+		err := fmt.Errorf("makeInits needs a username")
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	prefix := ""
 	if config.SecondsTimeout != 0 {
-		init = strings.Replace(timeoutInit, "TIMEOUT_SECONDS", fmt.Sprint(config.SecondsTimeout), 1) + init
+		prefix = strings.Replace(timeoutInit, "TIMEOUT_SECONDS", fmt.Sprint(config.SecondsTimeout), 1) + prefix
 	}
 	if config.TempKey {
 		pubKey, privKey, err := SshKeygenEd25519()
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return nil, err
 		}
 		uid := uuid.Must(uuid.NewV4()).String()
 		path := fmt.Sprintf("/tmp/libaws/%s", uid)
 		err = os.MkdirAll(path, os.ModePerm)
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return nil, err
 		}
 		config.Tags = append(config.Tags, EC2Tag{
 			Name:  "ssh-id",
@@ -804,14 +1025,25 @@ func makeInit(config *EC2Config) (string, error) {
 		err = os.WriteFile(path+"/id_ed25519", []byte(privKey), 0600)
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return nil, err
+		}
+		prefix = strings.ReplaceAll(tempkeyInit, "PUBKEY", pubKey) + prefix
+	}
+	inits := map[string]string{}
+	for _, instanceType := range instanceTypes {
+		init := config.Init
+		for _, nvmeType := range []string{"i3", "i3en", "i4i", "c5d", "m5d", "r5d", "z1d", "c6gd", "c6id", "m6gd", "r6gd", "c5ad", "is4gen", "im4gn"} {
+			if nvmeType == strings.Split(instanceType, ".")[0] {
+				init = nvmeInit + init
+				break
+			}
 		}
-		init = strings.ReplaceAll(tempkeyInit, "PUBKEY", pubKey) + init
+		init = prefix + init
+		init = base64.StdEncoding.EncodeToString([]byte(init))
+		init = fmt.Sprintf("#!/bin/sh\nset -x; path=/tmp/$(cat /proc/sys/kernel/random/uuid); if which apk >/dev/null; then apk update && apk add curl git procps ncurses-terminfo coreutils sed grep less vim sudo bash && echo -e '%s ALL=(ALL) NOPASSWD:ALL\nroot ALL=(ALL) NOPASSWD:ALL' > /etc/sudoers; fi; echo %s | base64 -d > $path; cd /home/%s; sudo -u %s bash -e $path 2>&1", config.UserName, init, config.UserName, config.UserName)
+		inits[instanceType] = base64.StdEncoding.EncodeToString([]byte(init))
 	}
-	init = base64.StdEncoding.EncodeToString([]byte(init))
-	init = fmt.Sprintf("#!/bin/sh\nset -x; path=/tmp/$(cat /proc/sys/kernel/random/uuid); if which apk >/dev/null; then apk update && apk add curl git procps ncurses-terminfo coreutils sed grep less vim sudo bash && echo -e '%s ALL=(ALL) NOPASSWD:ALL\nroot ALL=(ALL) NOPASSWD:ALL' > /etc/sudoers; fi; echo %s | base64 -d > $path; cd /home/%s; sudo -u %s bash -e $path 2>&1", config.UserName, init, config.UserName, config.UserName)
-	init = base64.StdEncoding.EncodeToString([]byte(init))
-	return init, nil
+	return inits, nil
 }
 
 func makeTags(config *EC2Config) []*ec2.Tag {
@@ -842,6 +1074,9 @@ func ec2ConfigDefaults(config *EC2Config) *EC2Config {
 	return config
 }
 
+// launch on-demand instances. with one instance type and one subnet they launch
+// there, otherwise each instance type is tried in order across the subnets whose
+// zones offer it, moving on when a zone is out of capacity.
 func EC2NewInstances(ctx context.Context, config *EC2Config) ([]*ec2.Instance, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "EC2NewInstances"}
@@ -858,27 +1093,85 @@ func EC2NewInstances(ctx context.Context, config *EC2Config) ([]*ec2.Instance, e
 		return nil, err
 	}
 	config = ec2ConfigDefaults(config)
-	if len(config.SubnetIds) != 1 {
-		err := fmt.Errorf("must specify exactly one subnet, got: %v", config.SubnetIds)
+	if len(config.SubnetIds) == 0 {
+		err := fmt.Errorf("must specify at least one subnet")
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	types := config.instanceTypes()
+	inits, err := makeInits(config, types)
+	if err != nil {
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	init, err := makeInit(config)
+	if len(types) == 1 && len(config.SubnetIds) == 1 {
+		pair := ec2LaunchPair{instanceType: types[0], subnetID: config.SubnetIds[0]}
+		return ec2RunInstances(ctx, config, pair, inits[pair.instanceType], config.NumInstances)
+	}
+	pairs, err := ec2LaunchPairs(ctx, config)
 	if err != nil {
 		Logger.Println("error:", err)
 		return nil, err
 	}
+	return ec2RunInstancesAny(ctx, config, pairs, inits, config.NumInstances)
+}
+
+// errors that mean another instance type or zone may succeed
+var ec2CapacityErrors = []string{
+	"InsufficientInstanceCapacity",
+	"InsufficientCapacity",
+	"Unsupported",
+	"VcpuLimitExceeded",
+}
+
+// try each instance type in preference order, across its subnets in random order
+func ec2RunInstancesAny(ctx context.Context, config *EC2Config, pairs []ec2LaunchPair, inits map[string]string, num int) ([]*ec2.Instance, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "ec2RunInstancesAny"}
+		defer d.Log()
+	}
+	var errLast error
+	for _, instanceType := range config.instanceTypes() {
+		var typePairs []ec2LaunchPair
+		for _, pair := range pairs {
+			if pair.instanceType == instanceType {
+				typePairs = append(typePairs, pair)
+			}
+		}
+		rand.Shuffle(len(typePairs), func(i, j int) { typePairs[i], typePairs[j] = typePairs[j], typePairs[i] })
+		for _, pair := range typePairs {
+			instances, err := ec2RunInstances(ctx, config, pair, inits[instanceType], num)
+			if err == nil {
+				return instances, nil
+			}
+			aerr, ok := err.(awserr.Error)
+			if !ok || !Contains(ec2CapacityErrors, aerr.Code()) {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			Logger.Println("no capacity for:", instanceType, pair.zone)
+			errLast = err
+		}
+	}
+	if errLast == nil {
+		errLast = fmt.Errorf("no subnets to launch instances in")
+	}
+	Logger.Println("error:", errLast)
+	return nil, errLast
+}
+
+func ec2RunInstances(ctx context.Context, config *EC2Config, pair ec2LaunchPair, init string, num int) ([]*ec2.Instance, error) {
 	runInstancesInput := &ec2.RunInstancesInput{
 		ImageId:             aws.String(config.AmiID),
 		KeyName:             aws.String(config.Key),
-		SubnetId:            aws.String(config.SubnetIds[0]),
-		InstanceType:        aws.String(config.InstanceType),
+		SubnetId:            aws.String(pair.subnetID),
+		InstanceType:        aws.String(pair.instanceType),
 		UserData:            aws.String(init),
 		EbsOptimized:        aws.Bool(true),
 		SecurityGroupIds:    []*string{&config.SgID},
 		BlockDeviceMappings: makeBlockDeviceMapping(config),
-		MinCount:            aws.Int64(int64(config.NumInstances)),
-		MaxCount:            aws.Int64(int64(config.NumInstances)),
+		MinCount:            aws.Int64(int64(num)),
+		MaxCount:            aws.Int64(int64(num)),
 		TagSpecifications: []*ec2.TagSpecification{{
 			ResourceType: aws.String(ec2.ResourceTypeInstance),
 			Tags:         makeTags(config),
@@ -891,13 +1184,33 @@ func EC2NewInstances(ctx context.Context, config *EC2Config) ([]*ec2.Instance, e
 	runInstancesInput.UserData = nil
 	Logger.Println("run instances", Pformat(runInstancesInput))
 	if err != nil {
-		Logger.Println("error:", err)
 		return nil, err
 	}
-
 	return reservation.Instances, nil
 }
 
+// counts of instances by type, zone and pricing model, like: 3 c6i.xlarge us-west-2a spot
+func EC2LaunchSummary(instances []*ec2.Instance) []string {
+	counts := map[string]int{}
+	for _, instance := range instances {
+		zone := ""
+		if instance.Placement != nil {
+			zone = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		counts[fmt.Sprintf("%s %s %s", aws.StringValue(instance.InstanceType), zone, EC2Kind(instance))]++
+	}
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%d %s", counts[key], key))
+	}
+	return lines
+}
+
 type EC2RsyncInput struct {
 	Source           string
 	Destination      string
@@ -907,8 +1220,12 @@ type EC2RsyncInput struct {
 	User             string
 	PrivateIP        bool
 	Key              string
+	Jump             *EC2JumpHost
+	Insecure         bool
 	PrintLock        sync.RWMutex
 	AccumulateResult bool
+	jumps            map[string]*EC2JumpHost
+	knownHostsDir    string
 }
 
 func EC2Rsync(ctx context.Context, input *EC2RsyncInput) ([]*ec2SshResult, error) {
@@ -946,6 +1263,17 @@ func EC2Rsync(ctx context.Context, input *EC2RsyncInput) ([]*ec2SshResult, error
 		defer timeoutCancel()
 		ctx = timeoutCtx
 	}
+	jumps, err := EC2JumpHosts(ctx, input.Instances, input.Jump, input.Key)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	input.jumps = jumps
+	input.knownHostsDir, err = ec2HostKeySetup(ctx, input.Insecure, input.Instances, jumps)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
 	resultChan := make(chan *ec2SshResult, len(input.Instances))
 	concurrency := semaphore.NewWeighted(int64(input.MaxConcurrency))
 	cancelCtx, cancel := context.WithCancel(ctx)
@@ -991,23 +1319,22 @@ func ec2Rsync(ctx context.Context, instance *ec2.Instance, input *EC2RsyncInput)
 		"-avh",
 		"--delete",
 	}
+	jump := input.jumps[*instance.InstanceId]
+	rsh := "ssh " + strings.Join(ec2HostKeyArgs(input.knownHostsDir, *instance.InstanceId), " ")
 	tempKey := ec2EphemeralKey(instance.Tags)
 	if tempKey != "" {
-		rsyncCmd = append(rsyncCmd, []string{"-e", fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no", tempKey)}...)
+		rsh += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes", tempKey)
 	} else if input.Key != "" {
-		rsyncCmd = append(rsyncCmd, []string{"-e", fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no", input.Key)}...)
-	} else {
-		rsyncCmd = append(rsyncCmd, []string{"-e", "ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"}...)
+		rsh += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes", input.Key)
+	}
+	if jump != nil {
+		rsh += fmt.Sprintf(" -o 'ProxyCommand=%s'", jump.proxyCommand(input.knownHostsDir))
 	}
+	rsyncCmd = append(rsyncCmd, []string{"-e", rsh}...)
 	if os.Getenv("RSYNC_OPTIONS") != "" {
 		rsyncCmd = append(rsyncCmd, SplitWhiteSpace(os.Getenv("RSYNC_OPTIONS"))...)
 	}
-	target := input.User + "@"
-	if input.PrivateIP {
-		target += *instance.PrivateIpAddress
-	} else {
-		target += *instance.PublicDnsName
-	}
+	target := input.User + "@" + ec2SshAddr(instance, input.PrivateIP, jump)
 	source := input.Source
 	destination := input.Destination
 	if strings.HasPrefix(source, ":") {
@@ -1116,8 +1443,12 @@ type EC2ScpInput struct {
 	User             string
 	PrivateIP        bool
 	Key              string
+	Jump             *EC2JumpHost
+	Insecure         bool
 	PrintLock        sync.RWMutex
 	AccumulateResult bool
+	jumps            map[string]*EC2JumpHost
+	knownHostsDir    string
 }
 
 func EC2Scp(ctx context.Context, input *EC2ScpInput) ([]*ec2SshResult, error) {
@@ -1155,6 +1486,17 @@ func EC2Scp(ctx context.Context, input *EC2ScpInput) ([]*ec2SshResult, error) {
 		defer timeoutCancel()
 		ctx = timeoutCtx
 	}
+	jumps, err := EC2JumpHosts(ctx, input.Instances, input.Jump, input.Key)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	input.jumps = jumps
+	input.knownHostsDir, err = ec2HostKeySetup(ctx, input.Insecure, input.Instances, jumps)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
 	resultChan := make(chan *ec2SshResult, len(input.Instances))
 	concurrency := semaphore.NewWeighted(int64(input.MaxConcurrency))
 	cancelCtx, cancel := context.WithCancel(ctx)
@@ -1196,23 +1538,19 @@ func ec2Scp(ctx context.Context, instance *ec2.Instance, input *EC2ScpInput) *ec
 	result := &ec2SshResult{
 		InstanceID: *instance.InstanceId,
 	}
-	scpCmd := []string{
-		"scp",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "StrictHostKeyChecking=no",
-	}
+	scpCmd := []string{"scp"}
+	scpCmd = append(scpCmd, ec2HostKeyArgs(input.knownHostsDir, *instance.InstanceId)...)
 	tempKey := ec2EphemeralKey(instance.Tags)
 	if tempKey != "" {
 		scpCmd = append(scpCmd, []string{"-o", "IdentitiesOnly=yes", "-i", tempKey}...)
 	} else if input.Key != "" {
 		scpCmd = append(scpCmd, []string{"-o", "IdentitiesOnly=yes", "-i", input.Key}...)
 	}
-	target := input.User + "@"
-	if input.PrivateIP {
-		target += *instance.PrivateIpAddress
-	} else {
-		target += *instance.PublicDnsName
+	jump := input.jumps[*instance.InstanceId]
+	if jump != nil {
+		scpCmd = append(scpCmd, jump.sshArgs(input.knownHostsDir)...)
 	}
+	target := input.User + "@" + ec2SshAddr(instance, input.PrivateIP, jump)
 	source := input.Source
 	destination := input.Destination
 	if strings.HasPrefix(source, ":") {
@@ -1310,10 +1648,14 @@ type EC2SshInput struct {
 	Stdin            string
 	PrivateIP        bool
 	Key              string
+	Jump             *EC2JumpHost
+	Insecure         bool
 	AccumulateResult bool
 	PrintLock        sync.RWMutex
 	NoPrint          bool
 	IPNotID          bool
+	jumps            map[string]*EC2JumpHost
+	knownHostsDir    string
 }
 
 const remoteCmdTemplateFailureMessage = `
@@ -1399,6 +1741,17 @@ func EC2Ssh(ctx context.Context, input *EC2SshInput) ([]*ec2SshResult, error) {
 		defer timeoutCancel()
 		ctx = timeoutCtx
 	}
+	jumps, err := EC2JumpHosts(ctx, input.Instances, input.Jump, input.Key)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	input.jumps = jumps
+	input.knownHostsDir, err = ec2HostKeySetup(ctx, input.Insecure, input.Instances, jumps)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
 	resultChan := make(chan *ec2SshResult, len(input.Instances))
 	concurrency := semaphore.NewWeighted(int64(input.MaxConcurrency))
 	cancelCtx, cancel := context.WithCancel(ctx)
@@ -1427,27 +1780,374 @@ func EC2Ssh(ctx context.Context, input *EC2SshInput) ([]*ec2SshResult, error) {
 			Logger.Println("error:", sshResult.Err)
 			errLast = sshResult.Err
 		}
-		result = append(result, sshResult)
+		result = append(result, sshResult)
+	}
+	return result, errLast
+}
+
+type ec2SshResult struct {
+	Err        error
+	InstanceID string
+	Stdout     []string
+}
+
+func ec2EphemeralKey(tags []*ec2.Tag) string {
+	for _, tag := range tags {
+		if *tag.Key == "ssh-id" {
+			path := fmt.Sprintf("/tmp/libaws/%s/id_ed25519", *tag.Value)
+			if Exists(path) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// instances tagged bastion=SELECTOR are reached through that jump host unless another is given
+const ec2BastionTagName = "bastion"
+
+// a host that ssh connections are proxied through, for instances without a public address
+type EC2JumpHost struct {
+	User       string
+	Addr       string
+	Key        string // private key path, empty for ssh defaults
+	InstanceID string // host key is pinned by instance id, or by addr when empty
+}
+
+// resolve a selector to the newest running instance to jump through. like any
+// ssh target, its ephemeral key is preferred over key, and user defaults to its
+// user tag.
+func EC2Jump(ctx context.Context, selector, user, key string) (*EC2JumpHost, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "EC2Jump"}
+		defer d.Log()
+	}
+	instances, err := EC2ListInstances(ctx, []string{selector}, ec2.InstanceStateNameRunning)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if len(instances) == 0 {
+		err := fmt.Errorf("no running jump instance for: %s", selector)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	instance := instances[0]
+	for _, candidate := range instances[1:] {
+		if aws.TimeValue(candidate.LaunchTime).After(aws.TimeValue(instance.LaunchTime)) {
+			instance = candidate
+		}
+	}
+	if user == "" {
+		user = EC2GetTag(instance.Tags, "user", "")
+	}
+	if user == "" {
+		err := fmt.Errorf("no user provided and no user tag available on jump instance: %s", *instance.InstanceId)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if aws.StringValue(instance.PublicDnsName) == "" {
+		err := fmt.Errorf("jump instance has no public dns name: %s", *instance.InstanceId)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	tempKey := ec2EphemeralKey(instance.Tags)
+	if tempKey != "" {
+		key = tempKey
+	}
+	return &EC2JumpHost{User: user, Addr: *instance.PublicDnsName, Key: key, InstanceID: *instance.InstanceId}, nil
+}
+
+// the jump host for each instance id, from jump when set, otherwise from the bastion tag
+func EC2JumpHosts(ctx context.Context, instances []*ec2.Instance, jump *EC2JumpHost, key string) (map[string]*EC2JumpHost, error) {
+	jumps := map[string]*EC2JumpHost{}
+	bySelector := map[string]*EC2JumpHost{}
+	for _, instance := range instances {
+		if jump != nil {
+			jumps[*instance.InstanceId] = jump
+			continue
+		}
+		selector := EC2GetTag(instance.Tags, ec2BastionTagName, "")
+		if selector == "" {
+			continue
+		}
+		host, ok := bySelector[selector]
+		if !ok {
+			var err error
+			host, err = EC2Jump(ctx, selector, "", key)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			bySelector[selector] = host
+		}
+		jumps[*instance.InstanceId] = host
+	}
+	return jumps, nil
+}
+
+func (j *EC2JumpHost) alias() string {
+	if j.InstanceID != "" {
+		return j.InstanceID
+	}
+	return j.Addr
+}
+
+func (j *EC2JumpHost) proxyCommand(knownHostsDir string) string {
+	cmd := "ssh " + strings.Join(ec2HostKeyArgs(knownHostsDir, j.alias()), " ")
+	if j.Key != "" {
+		cmd += " -i " + j.Key + " -o IdentitiesOnly=yes"
+	}
+	return cmd + " -W %h:%p " + j.User + "@" + j.Addr
+}
+
+// ssh and scp options that proxy through the jump host. ProxyCommand is used
+// instead of ProxyJump so that the jump host can have its own key.
+func (j *EC2JumpHost) sshArgs(knownHostsDir string) []string {
+	return []string{"-o", "ProxyCommand=" + j.proxyCommand(knownHostsDir)}
+}
+
+// behind a jump host instances are reached by private ip
+func ec2SshAddr(instance *ec2.Instance, privateIP bool, jump *EC2JumpHost) string {
+	if privateIP || jump != nil {
+		return *instance.PrivateIpAddress
+	}
+	return *instance.PublicDnsName
+}
+
+// host keys are pinned on first use, in one known_hosts file per instance id so
+// that a key follows its instance across public, private and jump addresses.
+// the state dir defaults to ~/.libaws and can be set with LIBAWS_STATE_DIR.
+func ec2KnownHostsDir() (string, error) {
+	dir := os.Getenv("LIBAWS_STATE_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		dir = filepath.Join(home, ".libaws")
+	}
+	dir = filepath.Join(dir, "known_hosts")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	return dir, nil
+}
+
+// the known_hosts dir, or empty when insecure, with the host keys of the instances and their jump hosts seeded
+func ec2HostKeySetup(ctx context.Context, insecure bool, instances []*ec2.Instance, jumps map[string]*EC2JumpHost) (string, error) {
+	if insecure {
+		return "", nil
+	}
+	knownHostsDir, err := ec2KnownHostsDir()
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	var aliases []string
+	for _, instance := range instances {
+		aliases = append(aliases, *instance.InstanceId)
+	}
+	for _, jump := range jumps {
+		aliases = append(aliases, jump.alias())
+	}
+	ec2SeedHostKeysAll(ctx, knownHostsDir, aliases)
+	return knownHostsDir, nil
+}
+
+// ssh options that check the host key pinned for alias, or skip checking when knownHostsDir is empty
+func ec2HostKeyArgs(knownHostsDir, alias string) []string {
+	if knownHostsDir == "" {
+		return []string{"-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no"}
+	}
+	return []string{
+		"-o", "UserKnownHostsFile=" + filepath.Join(knownHostsDir, alias),
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "HostKeyAlias=" + alias,
+	}
+}
+
+// the host keys cloud-init printed to the console during the most recent boot
+func ec2ConsoleHostKeys(console string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	inside := false
+	for _, line := range strings.Split(console, "\n") {
+		switch {
+		case strings.Contains(line, "-----BEGIN SSH HOST KEY KEYS-----"):
+			inside = true
+			keys = nil
+		case strings.Contains(line, "-----END SSH HOST KEY KEYS-----"):
+			inside = false
+		case inside:
+			fields := strings.Fields(line)
+			for i := range fields {
+				key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+				if err == nil {
+					keys = append(keys, key)
+					break
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// seed the host keys of each alias once, concurrently
+func ec2SeedHostKeysAll(ctx context.Context, knownHostsDir string, aliases []string) {
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for _, alias := range aliases {
+		if seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		wg.Add(1)
+		go func(alias string) {
+			defer wg.Done()
+			ec2SeedHostKeys(ctx, knownHostsDir, alias)
+		}(alias)
+	}
+	wg.Wait()
+}
+
+// console output is not looked up again for an instance until this long after
+// a lookup found no host keys, so that retry loops like EC2WaitSsh do not call
+// GetConsoleOutput on every attempt
+const ec2SeedHostKeysRetry = time.Minute
+
+var (
+	ec2SeedHostKeysFailed     = map[string]time.Time{}
+	ec2SeedHostKeysFailedLock sync.Mutex
+)
+
+// when nothing is pinned for an instance yet, pin the host keys from its
+// console output so that even the first connection is verified. this is best
+// effort, console output lags boot by minutes, and without it the first
+// connection is trusted instead.
+func ec2SeedHostKeys(ctx context.Context, knownHostsDir, instanceID string) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "ec2SeedHostKeys"}
+		defer d.Log()
+	}
+	if knownHostsDir == "" || !strings.HasPrefix(instanceID, "i-") {
+		return
+	}
+	file := filepath.Join(knownHostsDir, instanceID)
+	if Exists(file) {
+		return
+	}
+	ec2SeedHostKeysFailedLock.Lock()
+	failed, ok := ec2SeedHostKeysFailed[instanceID]
+	ec2SeedHostKeysFailedLock.Unlock()
+	if ok && time.Since(failed) < ec2SeedHostKeysRetry {
+		return
+	}
+	keys := ec2ConsoleHostKeysFor(ctx, instanceID)
+	if len(keys) == 0 {
+		ec2SeedHostKeysFailedLock.Lock()
+		ec2SeedHostKeysFailed[instanceID] = time.Now()
+		ec2SeedHostKeysFailedLock.Unlock()
+		return
+	}
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{instanceID}, key))
+	}
+	tmp, err := os.CreateTemp(knownHostsDir, instanceID+".tmp.")
+	if err != nil {
+		return
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		_ = tmp.Close()
+		return
+	}
+	err = tmp.Close()
+	if err != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), file)
+}
+
+func ec2ConsoleHostKeysFor(ctx context.Context, instanceID string) []ssh.PublicKey {
+	out, err := EC2Client().GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil || out.Output == nil {
+		return nil
+	}
+	console, err := base64.StdEncoding.DecodeString(*out.Output)
+	if err != nil {
+		return nil
+	}
+	return ec2ConsoleHostKeys(string(console))
+}
+
+// a copy of config that checks the host key pinned for alias, pinning it on first use,
+// or skips checking when knownHostsDir is empty
+func ec2HostKeyConfig(config *ssh.ClientConfig, knownHostsDir, alias string) *ssh.ClientConfig {
+	hostConfig := *config
+	if knownHostsDir == "" {
+		hostConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return &hostConfig
+	}
+	file := filepath.Join(knownHostsDir, alias)
+	hostConfig.HostKeyAlgorithms = ec2HostKeyAlgorithms(file)
+	hostConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if Exists(file) {
+			callback, err := knownhosts.New(file)
+			if err != nil {
+				return err
+			}
+			err = callback(alias+":22", remote, key)
+			if err == nil {
+				return nil
+			}
+			keyErr, ok := err.(*knownhosts.KeyError)
+			if !ok {
+				return err
+			}
+			if len(keyErr.Want) > 0 {
+				return fmt.Errorf("host key mismatch for %s, got %s %s which differs from the key pinned in %s. if the host was legitimately replaced, delete that file, or use --insecure", alias, key.Type(), ssh.FingerprintSHA256(key), file)
+			}
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{alias}, key))
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
 	}
-	return result, errLast
-}
-
-type ec2SshResult struct {
-	Err        error
-	InstanceID string
-	Stdout     []string
+	return &hostConfig
 }
 
-func ec2EphemeralKey(tags []*ec2.Tag) string {
-	for _, tag := range tags {
-		if *tag.Key == "ssh-id" {
-			path := fmt.Sprintf("/tmp/libaws/%s/id_ed25519", *tag.Value)
-			if Exists(path) {
-				return path
-			}
+// the algorithms of the pinned keys, so that the server presents a key that can be checked
+func ec2HostKeyAlgorithms(file string) []string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var algorithms []string
+	for len(data) > 0 {
+		_, _, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			break
 		}
+		if key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, "rsa-sha2-512", "rsa-sha2-256")
+		}
+		algorithms = append(algorithms, key.Type())
+		data = rest
 	}
-	return ""
+	return algorithms
 }
 
 func ec2Ssh(ctx context.Context, instance *ec2.Instance, input *EC2SshInput) *ec2SshResult {
@@ -1458,24 +2158,19 @@ func ec2Ssh(ctx context.Context, instance *ec2.Instance, input *EC2SshInput) *ec
 	result := &ec2SshResult{
 		InstanceID: *instance.InstanceId,
 	}
-	sshCmd := []string{
-		"ssh",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "StrictHostKeyChecking=no",
-	}
+	sshCmd := []string{"ssh"}
+	sshCmd = append(sshCmd, ec2HostKeyArgs(input.knownHostsDir, *instance.InstanceId)...)
 	tempKey := ec2EphemeralKey(instance.Tags)
 	if tempKey != "" {
 		sshCmd = append(sshCmd, []string{"-i", tempKey, "-o", "IdentitiesOnly=yes"}...)
 	} else if input.Key != "" {
 		sshCmd = append(sshCmd, []string{"-i", input.Key, "-o", "IdentitiesOnly=yes"}...)
 	}
-	target := input.User + "@"
-	if input.PrivateIP {
-		target += *instance.PrivateIpAddress
-	} else {
-		target += *instance.PublicDnsName
+	jump := input.jumps[*instance.InstanceId]
+	if jump != nil {
+		sshCmd = append(sshCmd, jump.sshArgs(input.knownHostsDir)...)
 	}
-	sshCmd = append(sshCmd, target)
+	sshCmd = append(sshCmd, input.User+"@"+ec2SshAddr(instance, input.PrivateIP, jump))
 	failureMessage := "failure"
 	if len(input.Instances) == 1 {
 		failureMessage = fmt.Sprintf("failure on %s", *instance.InstanceId)
@@ -1557,7 +2252,7 @@ func ec2Ssh(ctx context.Context, instance *ec2.Instance, input *EC2SshInput) *ec
 	return result
 }
 
-func EC2SshLogin(instance *ec2.Instance, user, key string) error {
+func EC2SshLogin(instance *ec2.Instance, user, key string, jump *EC2JumpHost, insecure bool) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "EC2SshLogin"}
 		defer d.Log()
@@ -1570,23 +2265,37 @@ func EC2SshLogin(instance *ec2.Instance, user, key string) error {
 			return err
 		}
 	}
+	ctx := context.Background()
+	jumps, err := EC2JumpHosts(ctx, []*ec2.Instance{instance}, jump, key)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	jump = jumps[*instance.InstanceId]
+	knownHostsDir, err := ec2HostKeySetup(ctx, insecure, []*ec2.Instance{instance}, jumps)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
 	sshCmd := []string{
 		"ssh",
-		user + "@" + *instance.PublicDnsName,
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "StrictHostKeyChecking=no",
+		user + "@" + ec2SshAddr(instance, false, jump),
 	}
+	sshCmd = append(sshCmd, ec2HostKeyArgs(knownHostsDir, *instance.InstanceId)...)
 	tempKey := ec2EphemeralKey(instance.Tags)
 	if tempKey != "" {
 		sshCmd = append(sshCmd, []string{"-i", tempKey, "-o", "IdentitiesOnly=yes"}...)
 	} else if key != "" {
 		sshCmd = append(sshCmd, []string{"-i", key, "-o", "IdentitiesOnly=yes"}...)
 	}
+	if jump != nil {
+		sshCmd = append(sshCmd, jump.sshArgs(knownHostsDir)...)
+	}
 	cmd := exec.Command(sshCmd[0], sshCmd[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
@@ -1773,6 +2482,14 @@ func ec2AmiAlpine(ctx context.Context, name, arch string) (string, error) {
 	return *images[0].ImageId, nil
 }
 
+// the ami arch for an instance type, arm64 for graviton types like c7g.large
+func EC2Arch(instanceType string) string {
+	if strings.Contains(strings.Split(instanceType, ".")[0][1:], "g") { // slice first char, since arm64 g is never first char
+		return EC2ArchArm64
+	}
+	return EC2ArchAmd64
+}
+
 func EC2AmiBase(ctx context.Context, name, arch string) (amiID string, sshUser string, err error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "EC2AmiBase"}
@@ -1946,6 +2663,8 @@ type EC2WaitSshInput struct {
 	User           string
 	Key            string
 	MaxConcurrency int
+	Jump           *EC2JumpHost
+	Insecure       bool
 }
 
 func EC2WaitSsh(ctx context.Context, input *EC2WaitSshInput) ([]string, error) {
@@ -1971,13 +2690,14 @@ func EC2WaitSsh(ctx context.Context, input *EC2WaitSshInput) ([]string, error) {
 			default:
 			}
 		}
+		jumps, err := EC2JumpHosts(ctx, instances, input.Jump, input.Key)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
 		var ips []string
 		for _, instance := range instances {
-			if input.PrivateIP {
-				ips = append(ips, *instance.PrivateIpAddress)
-			} else {
-				ips = append(ips, *instance.PublicDnsName)
-			}
+			ips = append(ips, ec2SshAddr(instance, input.PrivateIP, jumps[*instance.InstanceId]))
 		}
 		// add an executable on PATH named `aws-ec2-ip-callback` which
 		// will be invoked with the ipv4 of all instances to be waited
@@ -1991,6 +2711,8 @@ func EC2WaitSsh(ctx context.Context, input *EC2WaitSshInput) ([]string, error) {
 			PrivateIP:      input.PrivateIP,
 			MaxConcurrency: input.MaxConcurrency,
 			Key:            input.Key,
+			Jump:           input.Jump,
+			Insecure:       input.Insecure,
 			PrintLock:      sync.RWMutex{},
 			NoPrint:        true,
 		})
@@ -2051,6 +2773,8 @@ type EC2WaitGoSshInput struct {
 	Ed25519PrivKey string
 	Stdout         io.Writer
 	Stderr         io.Writer
+	Jump           *EC2JumpHost
+	Insecure       bool
 }
 
 func EC2WaitGoSsh(ctx context.Context, input *EC2WaitGoSshInput) ([]string, error) {
@@ -2082,9 +2806,16 @@ func EC2WaitGoSsh(ctx context.Context, input *EC2WaitGoSshInput) ([]string, erro
 			default:
 			}
 		}
+		jumps, err := EC2JumpHosts(ctx, instances, input.Jump, "")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
 		var targetAddrs []string
+		var instanceIDs []string
 		for _, instance := range instances {
-			targetAddrs = append(targetAddrs, *instance.PublicDnsName)
+			targetAddrs = append(targetAddrs, ec2SshAddr(instance, false, jumps[*instance.InstanceId]))
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
 		}
 		// add an executable on PATH named `aws-ec2-ip-callback` which
 		// will be invoked with the ipv4 of all instances to be waited
@@ -2101,6 +2832,9 @@ func EC2WaitGoSsh(ctx context.Context, input *EC2WaitGoSshInput) ([]string, erro
 			Stderr:         input.Stderr,
 			RsaPrivKey:     input.RsaPrivKey,
 			Ed25519PrivKey: input.Ed25519PrivKey,
+			Jumps:          jumps,
+			Insecure:       input.Insecure,
+			InstanceIDs:    instanceIDs,
 		})
 		for _, result := range results {
 			if result.Err == nil {
@@ -2121,7 +2855,7 @@ func EC2WaitGoSsh(ctx context.Context, input *EC2WaitGoSshInput) ([]string, erro
 			for _, result := range results {
 				if result.Err != nil {
 					Logger.Printf("terminating unready instance:", result.TargetAddr)
-					terminate = append(terminate, result.TargetAddr)
+					terminate = append(terminate, result.InstanceID)
 				} else {
 					ready = append(ready, result.TargetAddr)
 				}
@@ -2628,11 +3362,15 @@ type EC2GoSshInput struct {
 	Stdin          string
 	RsaPrivKey     string
 	Ed25519PrivKey string
+	InstanceIDs    []string                // parallel to TargetAddrs, since private ips behind different jump hosts can collide
+	Jumps          map[string]*EC2JumpHost // instance id to jump host, targets without one are dialed directly
+	Insecure       bool
 }
 
 type ec2GoSshResult struct {
 	Err        error
 	TargetAddr string
+	InstanceID string
 }
 
 func pubKey(privKey string) (ssh.AuthMethod, error) {
@@ -2679,11 +3417,11 @@ func EC2GoSsh(ctx context.Context, input *EC2GoSshInput) ([]*ec2GoSshResult, err
 		Logger.Println("error:", err)
 		return nil, err
 	}
+	// host key checking is set per target by ec2HostKeyConfig
 	config := &ssh.ClientConfig{
-		User:            input.User,
-		Auth:            auth,
-		Timeout:         5 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:    input.User,
+		Auth:    auth,
+		Timeout: 5 * time.Second,
 	}
 	if input.MaxConcurrency == 0 {
 		input.MaxConcurrency = 32
@@ -2693,12 +3431,53 @@ func EC2GoSsh(ctx context.Context, input *EC2GoSshInput) ([]*ec2GoSshResult, err
 		defer timeoutCancel()
 		ctx = timeoutCtx
 	}
+	knownHostsDir := ""
+	if !input.Insecure {
+		var err error
+		knownHostsDir, err = ec2KnownHostsDir()
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	if len(input.InstanceIDs) != 0 && len(input.InstanceIDs) != len(input.TargetAddrs) {
+		err := fmt.Errorf("InstanceIDs must be parallel to TargetAddrs: %d != %d", len(input.InstanceIDs), len(input.TargetAddrs))
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	var aliases []string
+	for i := range input.TargetAddrs {
+		aliases = append(aliases, ec2GoSshAlias(input, i))
+		if jump := ec2GoSshJump(input, i); jump != nil {
+			aliases = append(aliases, jump.alias())
+		}
+	}
+	ec2SeedHostKeysAll(ctx, knownHostsDir, aliases)
+	// one connection per jump host, shared by the targets behind it
+	jumpClients := map[string]*ssh.Client{}
+	for i := range input.TargetAddrs {
+		jump := ec2GoSshJump(input, i)
+		if jump == nil {
+			continue
+		}
+		if _, ok := jumpClients[jump.alias()]; ok {
+			continue
+		}
+		jumpClient, err := sshDialJump(ctx, jump, ec2HostKeyConfig(config, knownHostsDir, jump.alias()))
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		defer func() { _ = jumpClient.Close() }()
+		jumpClients[jump.alias()] = jumpClient
+	}
 	// done := make(chan error, len(input.Instances))
 	resultChan := make(chan *ec2GoSshResult, len(input.TargetAddrs))
 	concurrency := semaphore.NewWeighted(int64(input.MaxConcurrency))
 	cancelCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	for _, addr := range input.TargetAddrs {
+	for i, addr := range input.TargetAddrs {
+		i := i
 		addr := addr
 		go func() {
 			defer func() {
@@ -2706,14 +3485,23 @@ func EC2GoSsh(ctx context.Context, input *EC2GoSshInput) ([]*ec2GoSshResult, err
 					logRecover(r)
 				}
 			}()
+			result := &ec2GoSshResult{TargetAddr: addr}
+			if len(input.InstanceIDs) != 0 {
+				result.InstanceID = input.InstanceIDs[i]
+			}
 			err := concurrency.Acquire(cancelCtx, 1)
 			if err != nil {
-				resultChan <- &ec2GoSshResult{Err: err, TargetAddr: addr}
+				result.Err = err
+				resultChan <- result
 				return
 			}
 			defer concurrency.Release(1)
-			err = ec2GoSsh(cancelCtx, config, addr, input)
-			resultChan <- &ec2GoSshResult{Err: err, TargetAddr: addr}
+			var jumpClient *ssh.Client
+			if jump := ec2GoSshJump(input, i); jump != nil {
+				jumpClient = jumpClients[jump.alias()]
+			}
+			result.Err = ec2GoSsh(cancelCtx, ec2HostKeyConfig(config, knownHostsDir, ec2GoSshAlias(input, i)), jumpClient, addr, input)
+			resultChan <- result
 		}()
 	}
 	var errLast error
@@ -2728,6 +3516,22 @@ func EC2GoSsh(ctx context.Context, input *EC2GoSshInput) ([]*ec2GoSshResult, err
 	return result, errLast
 }
 
+// the jump host of the i-th target, if any
+func ec2GoSshJump(input *EC2GoSshInput, i int) *EC2JumpHost {
+	if len(input.InstanceIDs) == 0 {
+		return nil
+	}
+	return input.Jumps[input.InstanceIDs[i]]
+}
+
+// host keys are pinned by instance id when known, otherwise by addr
+func ec2GoSshAlias(input *EC2GoSshInput, i int) string {
+	if len(input.InstanceIDs) != 0 {
+		return input.InstanceIDs[i]
+	}
+	return input.TargetAddrs[i]
+}
+
 func sshDialContext(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "sshDialContext"}
@@ -2747,12 +3551,82 @@ func sshDialContext(ctx context.Context, network, addr string, config *ssh.Clien
 	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func ec2GoSsh(ctx context.Context, config *ssh.ClientConfig, targetAddr string, input *EC2GoSshInput) error {
+// dial the jump host, with its own user and key if it has one, otherwise the same auth as the targets
+func sshDialJump(ctx context.Context, jump *EC2JumpHost, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "sshDialJump"}
+		defer d.Log()
+	}
+	jumpConfig := *config
+	jumpConfig.User = jump.User
+	if jump.Key != "" {
+		data, err := os.ReadFile(jump.Key)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		auth, err := pubKey(string(data))
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		jumpConfig.Auth = []ssh.AuthMethod{auth}
+	}
+	return sshDialContext(ctx, "tcp", fmt.Sprintf("%s:22", jump.Addr), &jumpConfig)
+}
+
+// dial addr through an established connection to a jump host
+func sshDialThrough(ctx context.Context, jumpClient *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "sshDialThrough"}
+		defer d.Log()
+	}
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	dialChan := make(chan dialResult, 1)
+	go func() {
+		conn, err := jumpClient.Dial("tcp", addr)
+		dialChan <- dialResult{conn, err}
+	}()
+	dialContext, dialCancel := context.WithTimeout(ctx, config.Timeout)
+	defer dialCancel()
+	var conn net.Conn
+	select {
+	case <-dialContext.Done():
+		go func() {
+			result := <-dialChan
+			if result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, dialContext.Err()
+	case result := <-dialChan:
+		if result.err != nil {
+			return nil, result.err
+		}
+		conn = result.conn
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func ec2GoSsh(ctx context.Context, config *ssh.ClientConfig, jumpClient *ssh.Client, targetAddr string, input *EC2GoSshInput) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "ec2GoSsh"}
 		defer d.Log()
 	}
-	sshConn, err := sshDialContext(ctx, "tcp", fmt.Sprintf("%s:22", targetAddr), config)
+	var sshConn *ssh.Client
+	var err error
+	if jumpClient != nil {
+		sshConn, err = sshDialThrough(ctx, jumpClient, fmt.Sprintf("%s:22", targetAddr), config)
+	} else {
+		sshConn, err = sshDialContext(ctx, "tcp", fmt.Sprintf("%s:22", targetAddr), config)
+	}
 	if err != nil {
 		return err
 	}
@@ -2946,3 +3820,332 @@ func EC2EnsureKeypair(ctx context.Context, infraSetName, keyName, pubkeyContent
 		return err
 	}
 }
+
+const (
+	ec2AttrType    = "type"
+	ec2AttrAmi     = "ami"
+	ec2AttrKey     = "key"
+	ec2AttrSg      = "sg"
+	ec2AttrProfile = "profile"
+	ec2AttrGigs    = "gigs"
+	ec2AttrInit    = "init"
+	ec2AttrSpot    = "spot"
+)
+
+// tag recording the ensure attrs of an instance, since the ami alias, spot
+// strategy, gigs and init script cannot be read back from the instance. init
+// is recorded relative to infra.yaml as written, so the tag is the same no
+// matter where the repo is checked out.
+const ec2AttrsTagName = "libaws.ec2.attrs"
+
+type ec2EnsureInput struct {
+	infraSetName string
+	vpcName      string
+	name         string
+	count        int
+	instanceType string
+	ami          string
+	key          string
+	sg           string
+	profile      string
+	gigs         int
+	init         string // path to a cloud init bash script, relative to initDir as written in infra.yaml
+	initDir      string
+	spot         string // spot allocation strategy, on-demand when empty
+}
+
+func EC2EnsureInput(infraSetName, vpcName, name string, count int, attrs []string) (*ec2EnsureInput, error) {
+	input := &ec2EnsureInput{
+		infraSetName: infraSetName,
+		vpcName:      vpcName,
+		name:         name,
+		count:        count,
+		sg:           "default",
+		gigs:         16,
+	}
+	if strings.Contains(name, "::") {
+		err := fmt.Errorf("ec2 name cannot contain '::', got: %s", name)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if count < 0 {
+		err := fmt.Errorf("ec2 %s count cannot be negative, got: %d", name, count)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	for _, attr := range attrs {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case ec2AttrType:
+			input.instanceType = v
+		case ec2AttrAmi:
+			input.ami = v
+		case ec2AttrKey:
+			input.key = v
+		case ec2AttrSg:
+			input.sg = v
+		case ec2AttrProfile:
+			input.profile = v
+		case ec2AttrGigs:
+			gigs, err := strconv.Atoi(v)
+			if err != nil || gigs <= 0 {
+				err := fmt.Errorf("ec2 %s attr %s should be a positive integer, got: %s", name, k, v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.gigs = gigs
+		case ec2AttrInit:
+			input.init = v
+		case ec2AttrSpot:
+			if !Contains(ec2.AllocationStrategy_Values(), v) {
+				err := fmt.Errorf("ec2 %s attr %s should be one of %v, got: %s", name, k, ec2.AllocationStrategy_Values(), v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.spot = v
+		default:
+			err := fmt.Errorf("unknown ec2 attr: %s", attr)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	for _, required := range [][2]string{{ec2AttrType, input.instanceType}, {ec2AttrAmi, input.ami}, {ec2AttrKey, input.key}} {
+		if required[1] == "" {
+			err := fmt.Errorf("ec2 %s is missing attr: %s", name, required[0])
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	if len(input.attrsTag()) > 256 {
+		err := fmt.Errorf("ec2 %s attrs are too long to record in tag %s: %s", name, ec2AttrsTagName, input.attrsTag())
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return input, nil
+}
+
+// required attrs first, then optional attrs not at their default. this is
+// what ec2AttrsTagName records.
+func (input *ec2EnsureInput) canonicalAttrs() []string {
+	attrs := []string{
+		ec2AttrType + "=" + input.instanceType,
+		ec2AttrAmi + "=" + input.ami,
+		ec2AttrKey + "=" + input.key,
+	}
+	if input.sg != "default" {
+		attrs = append(attrs, ec2AttrSg+"="+input.sg)
+	}
+	if input.profile != "" {
+		attrs = append(attrs, ec2AttrProfile+"="+input.profile)
+	}
+	if input.gigs != 16 {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", ec2AttrGigs, input.gigs))
+	}
+	if input.init != "" {
+		attrs = append(attrs, ec2AttrInit+"="+input.init)
+	}
+	if input.spot != "" {
+		attrs = append(attrs, ec2AttrSpot+"="+input.spot)
+	}
+	return attrs
+}
+
+func (input *ec2EnsureInput) attrsTag() string {
+	data, err := json.Marshal(input.canonicalAttrs())
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// the ensure attrs of an instance launched by EC2Ensure. instances launched
+// before the attrs tag existed report what can be read back from the instance.
+func ec2ListAttrs(instance *ec2.Instance) []string {
+	for _, tag := range instance.Tags {
+		if *tag.Key == ec2AttrsTagName {
+			var attrs []string
+			err := json.Unmarshal([]byte(*tag.Value), &attrs)
+			if err == nil {
+				return attrs
+			}
+		}
+	}
+	attrs := []string{
+		ec2AttrType + "=" + aws.StringValue(instance.InstanceType),
+		ec2AttrAmi + "=" + aws.StringValue(instance.ImageId),
+		ec2AttrKey + "=" + aws.StringValue(instance.KeyName),
+	}
+	for _, sg := range instance.SecurityGroups {
+		if aws.StringValue(sg.GroupName) != "default" {
+			attrs = append(attrs, ec2AttrSg+"="+aws.StringValue(sg.GroupName))
+		}
+	}
+	if instance.IamInstanceProfile != nil {
+		parts := strings.Split(aws.StringValue(instance.IamInstanceProfile.Arn), "/")
+		attrs = append(attrs, ec2AttrProfile+"="+parts[len(parts)-1])
+	}
+	return attrs
+}
+
+// instances matching the Name and infraset tags in the vpc, newest first.
+// stopped and pending instances count, only terminated and shutting-down ones
+// do not, so a stopped instance is kept rather than replaced.
+func ec2EnsureInstances(ctx context.Context, input *ec2EnsureInput, vpcID string) ([]*ec2.Instance, error) {
+	instances, err := EC2ListInstances(ctx, []string{"Name=" + input.name, infraSetTagName + "=" + input.infraSetName}, "")
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	var res []*ec2.Instance
+	for _, instance := range instances {
+		if aws.StringValue(instance.VpcId) != vpcID {
+			continue
+		}
+		if Contains([]string{ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated}, *instance.State.Name) {
+			continue
+		}
+		res = append(res, instance)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return aws.TimeValue(res[i].LaunchTime).After(aws.TimeValue(res[j].LaunchTime))
+	})
+	return res, nil
+}
+
+// reconcile the number of instances with a name in a vpc, terminating the
+// newest extras and launching missing ones. existing instances are never
+// modified, terminate them to pick up changed attrs.
+func EC2Ensure(ctx context.Context, input *ec2EnsureInput, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "EC2Ensure"}
+		defer d.Log()
+	}
+	vpcID, err := VpcID(ctx, input.vpcName)
+	if err != nil {
+		// a missing vpc has no instances, which is fine when previewing or removing
+		if !strings.HasPrefix(err.Error(), ErrPrefixDidntFindExactlyOne) || (!preview && input.count != 0) {
+			Logger.Println("error:", err)
+			return err
+		}
+		vpcID = ""
+	}
+	var instances []*ec2.Instance
+	if vpcID != "" {
+		instances, err = ec2EnsureInstances(ctx, input, vpcID)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	if len(instances) > input.count {
+		var ids []*string
+		for _, instance := range instances[:len(instances)-input.count] {
+			ids = append(ids, instance.InstanceId)
+		}
+		if !preview {
+			_, err := EC2Client().TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+				InstanceIds: ids,
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			// wait so that security groups and the vpc can be deleted right after
+			err = EC2WaitState(ctx, aws.StringValueSlice(ids), ec2.InstanceStateNameTerminated)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		for _, id := range ids {
+			Logger.Println(PreviewString(preview)+"terminated instance:", input.name, *id)
+		}
+		return nil
+	}
+	missing := input.count - len(instances)
+	if missing == 0 {
+		return nil
+	}
+	if preview {
+		Logger.Println(PreviewString(preview)+"created instances:", input.name, missing)
+		return nil
+	}
+	arch := EC2Arch(input.instanceType)
+	amiID := input.ami
+	var sshUser string
+	if strings.HasPrefix(amiID, "ami-") {
+		sshUser, err = EC2AmiUser(ctx, amiID)
+	} else {
+		amiID, sshUser, err = EC2AmiBase(ctx, input.ami, arch)
+	}
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	sgID, err := EC2SgID(ctx, input.vpcName, input.sg)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	var init string
+	if input.init != "" {
+		initPath := input.init
+		if !filepath.IsAbs(initPath) {
+			initPath = filepath.Join(input.initDir, initPath)
+		}
+		data, err := os.ReadFile(initPath)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		init = string(data)
+	}
+	subnets, err := VpcSubnets(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	var subnetIDs []string
+	for _, subnet := range subnets {
+		if vpcSubnetTier(subnet.Tags) == vpcSubnetPublic {
+			subnetIDs = append(subnetIDs, *subnet.SubnetId)
+		}
+	}
+	if len(subnetIDs) == 0 {
+		err := fmt.Errorf("no public subnet in vpc: %s", input.vpcName)
+		Logger.Println("error:", err)
+		return err
+	}
+	config := &EC2Config{
+		NumInstances: missing,
+		Name:         input.name,
+		SgID:         sgID,
+		AmiID:        amiID,
+		UserName:     sshUser,
+		Key:          input.key,
+		InstanceType: input.instanceType,
+		SubnetIds:    subnetIDs,
+		Gigs:         input.gigs,
+		Init:         init,
+		Tags:         []EC2Tag{{Name: infraSetTagName, Value: input.infraSetName}, {Name: ec2AttrsTagName, Value: input.attrsTag()}},
+		Profile:      input.profile,
+	}
+	var created []*ec2.Instance
+	if input.spot != "" {
+		created, err = EC2RequestSpotFleet(ctx, input.spot, config)
+	} else {
+		created, err = EC2NewInstances(ctx, config)
+	}
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	for _, instance := range created {
+		Logger.Println("created instance:", input.name, *instance.InstanceId)
+	}
+	return nil
+}
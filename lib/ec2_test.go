@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestEC2EnsureInput(t *testing.T) {
+	type test struct {
+		count int
+		attrs []string
+		input *ec2EnsureInput
+		err   bool
+	}
+	tests := []test{
+		{
+			2,
+			[]string{"type=t3.small", "ami=jammy", "key=dev"},
+			&ec2EnsureInput{vpcName: "vpc", name: "box", count: 2, instanceType: "t3.small", ami: "jammy", key: "dev", sg: "default", gigs: 16},
+			false,
+		},
+		{
+			1,
+			[]string{"type=c7g.large", "ami=ami-123", "key=dev", "sg=ssh", "profile=builder", "gigs=64", "init=/tmp/init.sh", "spot=lowestPrice"},
+			&ec2EnsureInput{vpcName: "vpc", name: "box", count: 1, instanceType: "c7g.large", ami: "ami-123", key: "dev", sg: "ssh", profile: "builder", gigs: 64, init: "/tmp/init.sh", spot: "lowestPrice"},
+			false,
+		},
+		{1, []string{"ami=jammy", "key=dev"}, nil, true},
+		{1, []string{"type=t3.small", "key=dev"}, nil, true},
+		{1, []string{"type=t3.small", "ami=jammy"}, nil, true},
+		{1, []string{"type=t3.small", "ami=jammy", "key=dev", "gigs=0"}, nil, true},
+		{1, []string{"type=t3.small", "ami=jammy", "key=dev", "spot=cheap"}, nil, true},
+		{1, []string{"type=t3.small", "ami=jammy", "key=dev", "unknown=value"}, nil, true},
+		{-1, []string{"type=t3.small", "ami=jammy", "key=dev"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := EC2EnsureInput("", "vpc", "box", test.count, test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(input, test.input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", input, test.input)
+		}
+	}
+}
+
+func TestEC2Arch(t *testing.T) {
+	tests := map[string]string{
+		"t3.small":    EC2ArchAmd64,
+		"c7g.large":   EC2ArchArm64,
+		"m6gd.xlarge": EC2ArchArm64,
+		"g5.xlarge":   EC2ArchAmd64,
+	}
+	for instanceType, want := range tests {
+		got := EC2Arch(instanceType)
+		if got != want {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n%s\n", got, want, instanceType)
+		}
+	}
+}
+
+func TestEC2ListAttrs(t *testing.T) {
+	type test struct {
+		attrs []string
+	}
+	tests := []test{
+		{[]string{"type=t3.small", "ami=jammy", "key=dev"}},
+		{[]string{"type=t3.small", "ami=jammy", "key=dev", "sg=default", "gigs=16"}},
+		{[]string{"type=c7g.large", "ami=ami-123", "key=dev", "sg=ssh", "profile=builder", "gigs=64", "init=/tmp/init.sh", "spot=lowestPrice"}},
+	}
+	for _, test := range tests {
+		input, err := EC2EnsureInput("", "vpc", "box", 1, test.attrs)
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", test.attrs, err)
+			continue
+		}
+		instance := &ec2.Instance{
+			InstanceType: aws.String("t3.small"),
+			ImageId:      aws.String("ami-456"),
+			KeyName:      aws.String("dev"),
+			Tags:         []*ec2.Tag{{Key: aws.String(ec2AttrsTagName), Value: aws.String(input.attrsTag())}},
+		}
+		listed, err := EC2EnsureInput("", "vpc", "box", 1, ec2ListAttrs(instance))
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", ec2ListAttrs(instance), err)
+			continue
+		}
+		if !reflect.DeepEqual(listed, input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", listed, input)
+		}
+	}
+	instance := &ec2.Instance{
+		InstanceType:       aws.String("t3.small"),
+		ImageId:            aws.String("ami-456"),
+		KeyName:            aws.String("dev"),
+		SecurityGroups:     []*ec2.GroupIdentifier{{GroupName: aws.String("ssh")}},
+		IamInstanceProfile: &ec2.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/builder")},
+	}
+	want := []string{"type=t3.small", "ami=ami-456", "key=dev", "sg=ssh", "profile=builder"}
+	got := ec2ListAttrs(instance)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", got, want)
+	}
+}
+
+func TestEC2ConfigInstanceTypes(t *testing.T) {
+	config := &EC2Config{InstanceType: "c6i.xlarge", InstanceTypes: []string{"c6i.xlarge", "c6a.xlarge", "m6i.xlarge", "c6a.xlarge"}}
+	want := []string{"c6i.xlarge", "c6a.xlarge", "m6i.xlarge"}
+	got := config.instanceTypes()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", got, want)
+	}
+}
+
+func TestEC2LaunchSummary(t *testing.T) {
+	instance := func(instanceType, zone string, spot bool) *ec2.Instance {
+		instance := &ec2.Instance{
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(zone)},
+		}
+		if spot {
+			instance.SpotInstanceRequestId = aws.String("sir-123")
+		}
+		return instance
+	}
+	instances := []*ec2.Instance{
+		instance("c6i.xlarge", "us-west-2a", true),
+		instance("c6a.xlarge", "us-west-2b", true),
+		instance("c6i.xlarge", "us-west-2a", true),
+		instance("c6i.xlarge", "us-west-2a", false),
+	}
+	want := []string{
+		"1 c6a.xlarge us-west-2b spot",
+		"1 c6i.xlarge us-west-2a ondemand",
+		"2 c6i.xlarge us-west-2a spot",
+	}
+	got := EC2LaunchSummary(instances)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", got, want)
+	}
+}
+
+func TestEC2SpotFleetShouldFallback(t *testing.T) {
+	type test struct {
+		spotFallback string
+		err          error
+		fallback     bool
+	}
+	capacity := &ec2SpotFleetHistoryError{events: []string{"c6i.xlarge, ami-123, Linux/UNIX, us-west-2a, Spot: There is no Spot capacity available that matches your request."}}
+	tests := []test{
+		{EC2SpotFallbackOnDemand, ec2ErrSpotFleetDeadline, true},
+		{EC2SpotFallbackOnDemand, capacity, true},
+		{EC2SpotFallbackOnDemand, fmt.Errorf("spot fleet request failed with state: failed"), false},
+		{EC2SpotFallbackOnDemand, nil, false},
+		{"", ec2ErrSpotFleetDeadline, false},
+		{"", capacity, false},
+	}
+	for _, test := range tests {
+		fallback := ec2SpotFleetShouldFallback(test.spotFallback, test.err)
+		if fallback != test.fallback {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n%q %v\n", fallback, test.fallback, test.spotFallback, test.err)
+		}
+	}
+}
+
+func TestEC2JumpHostProxyCommand(t *testing.T) {
+	type test struct {
+		jump          *EC2JumpHost
+		knownHostsDir string
+		cmd           string
+	}
+	tests := []test{
+		{
+			&EC2JumpHost{User: "ubuntu", Addr: "ec2-1-2-3-4.compute-1.amazonaws.com"},
+			"",
+			"ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -W %h:%p ubuntu@ec2-1-2-3-4.compute-1.amazonaws.com",
+		},
+		{
+			&EC2JumpHost{User: "alpine", Addr: "ec2-1-2-3-4.compute-1.amazonaws.com", Key: "/tmp/bastion.key"},
+			"",
+			"ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -i /tmp/bastion.key -o IdentitiesOnly=yes -W %h:%p alpine@ec2-1-2-3-4.compute-1.amazonaws.com",
+		},
+		{
+			&EC2JumpHost{User: "ubuntu", Addr: "ec2-1-2-3-4.compute-1.amazonaws.com", InstanceID: "i-123"},
+			"/state/known_hosts",
+			"ssh -o UserKnownHostsFile=/state/known_hosts/i-123 -o StrictHostKeyChecking=accept-new -o HostKeyAlias=i-123 -W %h:%p ubuntu@ec2-1-2-3-4.compute-1.amazonaws.com",
+		},
+		{
+			&EC2JumpHost{User: "ubuntu", Addr: "ec2-1-2-3-4.compute-1.amazonaws.com"},
+			"/state/known_hosts",
+			"ssh -o UserKnownHostsFile=/state/known_hosts/ec2-1-2-3-4.compute-1.amazonaws.com -o StrictHostKeyChecking=accept-new -o HostKeyAlias=ec2-1-2-3-4.compute-1.amazonaws.com -W %h:%p ubuntu@ec2-1-2-3-4.compute-1.amazonaws.com",
+		},
+	}
+	for _, test := range tests {
+		cmd := test.jump.proxyCommand(test.knownHostsDir)
+		if cmd != test.cmd {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", cmd, test.cmd)
+		}
+	}
+	instance := &ec2.Instance{PublicDnsName: aws.String("public"), PrivateIpAddress: aws.String("10.0.0.1")}
+	if addr := ec2SshAddr(instance, false, nil); addr != "public" {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", addr, "public")
+	}
+	if addr := ec2SshAddr(instance, false, tests[0].jump); addr != "10.0.0.1" {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", addr, "10.0.0.1")
+	}
+}
+
+func TestEC2ConsoleHostKeys(t *testing.T) {
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMSf6mMjkz5lvsozVVxmrPf5wD1O0wY1H166vxJ7lH7u"
+	console := strings.Join([]string{
+		"[   10.1] cloud-init[512]: Cloud-init v. 23.1 finished",
+		"-----BEGIN SSH HOST KEY KEYS-----",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIYYGZ7lMX8ES1U37fg7FOYMmlhHa6RjQcWX2uxIf8/4 root@ip-10-0-0-1",
+		"-----END SSH HOST KEY KEYS-----",
+		"[  512.3] reboot: Restarting system",
+		"-----BEGIN SSH HOST KEY KEYS-----",
+		"[   11.2] " + key + " root@ip-10-0-0-1",
+		"not a key",
+		"-----END SSH HOST KEY KEYS-----",
+		key,
+	}, "\n")
+	keys := ec2ConsoleHostKeys(console)
+	if len(keys) != 1 {
+		t.Fatalf("\ngot:\n%d keys\nwant:\n1 key\n", len(keys))
+	}
+	got := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(keys[0])))
+	if got != key {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", got, key)
+	}
+	if keys := ec2ConsoleHostKeys("no keys printed yet"); len(keys) != 0 {
+		t.Errorf("\ngot:\n%d keys\nwant:\nnone\n", len(keys))
+	}
+}
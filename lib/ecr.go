@@ -2,8 +2,12 @@ package lib
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"os/exec"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -100,3 +104,90 @@ func EcrUrl(ctx context.Context) (string, error) {
 	}
 	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", account, Region()), nil
 }
+
+// the username, password and registry endpoint that docker login needs
+func EcrAuth(ctx context.Context) (string, string, string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "EcrAuth"}
+		defer d.Log()
+	}
+	token, err := EcrClient().GetAuthorizationTokenWithContext(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", "", "", err
+	}
+	if len(token.AuthorizationData) == 0 {
+		err := fmt.Errorf("ecr returned no authorization data")
+		Logger.Println("error:", err)
+		return "", "", "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(*token.AuthorizationData[0].AuthorizationToken)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", "", "", err
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 2 {
+		err := fmt.Errorf("expected two parts")
+		Logger.Println("error:", err)
+		return "", "", "", err
+	}
+	return parts[0], parts[1], *token.AuthorizationData[0].ProxyEndpoint, nil
+}
+
+// the container cli used to build and push images, docker by default
+func EcrBuilder() string {
+	builder := os.Getenv("LIBAWS_CONTAINER_BUILDER")
+	if builder == "" {
+		builder = "docker"
+	}
+	return builder
+}
+
+func EcrLogin(ctx context.Context) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "EcrLogin"}
+		defer d.Log()
+	}
+	username, password, endpoint, err := EcrAuth(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	cmd := exec.CommandContext(ctx, EcrBuilder(), "login", "--username", username, "--password-stdin", endpoint)
+	cmd.Stdin = strings.NewReader(password)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+// the digest of an image tag, or "" if the tag does not exist
+func EcrImageDigest(ctx context.Context, repo, tag string) (string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "EcrImageDigest"}
+		defer d.Log()
+	}
+	out, err := EcrClient().DescribeImagesWithContext(ctx, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repo),
+		ImageIds:       []*ecr.ImageIdentifier{{ImageTag: aws.String(tag)}},
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok && (aerr.Code() == ecr.ErrCodeImageNotFoundException || aerr.Code() == ecr.ErrCodeRepositoryNotFoundException) {
+			return "", nil
+		}
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if len(out.ImageDetails) != 1 {
+		err := fmt.Errorf("expected 1 image for %s:%s, got: %d", repo, tag, len(out.ImageDetails))
+		Logger.Println("error:", err)
+		return "", err
+	}
+	return *out.ImageDetails[0].ImageDigest, nil
+}
@@ -739,7 +739,58 @@ outer:
 	return nil
 }
 
+// principals are either a service name like 'ec2', a 12 digit account id, or an iam arn
+func iamPrincipalIsAccount(principalName string) bool {
+	return strings.HasPrefix(principalName, "arn:") || (len(principalName) == 12 && IsDigit(principalName))
+}
+
+func iamPrincipalAccountArn(principalName string) string {
+	if strings.HasPrefix(principalName, "arn:") {
+		return principalName
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:root", principalName)
+}
+
+// roles live under a path named for their principal, account principals use the account id
+func iamRolePath(principalName, roleName string) string {
+	if iamPrincipalIsAccount(principalName) {
+		parts := strings.Split(iamPrincipalAccountArn(principalName), ":")
+		if len(parts) > 4 {
+			principalName = "account-" + parts[4]
+		}
+	}
+	return fmt.Sprintf("/%s/%s-path/", principalName, roleName)
+}
+
+// returns the principal name for an assume role policy document created by iamAssumePolicyDocument
+func IamRolePrincipal(document *IamPolicyDocument) string {
+	if document == nil || len(document.Statement) != 1 {
+		return ""
+	}
+	principal, ok := document.Statement[0].Principal.(map[string]interface{})
+	if !ok || len(principal) != 1 {
+		return ""
+	}
+	if service, ok := principal["Service"].(string); ok {
+		return strings.TrimSuffix(service, ".amazonaws.com")
+	}
+	if arn, ok := principal["AWS"].(string); ok {
+		parts := strings.Split(arn, ":")
+		if len(parts) == 6 && parts[5] == "root" {
+			return parts[4]
+		}
+		return arn
+	}
+	return ""
+}
+
 func iamAssumePolicyDocument(principalName string) (*string, error) {
+	if iamPrincipalIsAccount(principalName) {
+		return aws.String(`{"Version": "2012-10-17",
+                        "Statement": [{"Effect": "Allow",
+                                       "Principal": {"AWS": "` + iamPrincipalAccountArn(principalName) + `"},
+                                       "Action": "sts:AssumeRole"}]}`), nil
+	}
 	if strings.Contains(principalName, ".") {
 		err := fmt.Errorf("principal should be '$name', not '$name.amazonaws.com', got: %s", principalName)
 		Logger.Println("error:", err)
@@ -756,7 +807,7 @@ func IamEnsureRole(ctx context.Context, infrasetName, roleName, principalName st
 		d := &Debug{start: time.Now(), name: "IamEnsureRole"}
 		defer d.Log()
 	}
-	rolePath := fmt.Sprintf("/%s/%s-path/", principalName, roleName)
+	rolePath := iamRolePath(principalName, roleName)
 	roles, err := IamListRoles(ctx, aws.String(rolePath))
 	if err != nil {
 		Logger.Println("error:", err)
@@ -1014,7 +1065,7 @@ func IamRoleArn(ctx context.Context, principalName, roleName string) (string, er
 		Logger.Println("error:", err)
 		return "", err
 	}
-	return fmt.Sprintf("arn:aws:iam::%s:role/%s/%s-path/%s", account, principalName, roleName, roleName), nil
+	return fmt.Sprintf("arn:aws:iam::%s:role%s%s", account, iamRolePath(principalName, roleName), roleName), nil
 }
 
 func IamInstanceProfileArn(ctx context.Context, profileName string) (string, error) {
@@ -1258,6 +1309,39 @@ func IamResetUserLoginTempPassword(ctx context.Context, username, password strin
 	return nil
 }
 
+func IamEnsureUser(ctx context.Context, infrasetName, username string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "IamEnsureUser"}
+		defer d.Log()
+	}
+	_, err := IamClient().GetUserWithContext(ctx, &iam.GetUserInput{
+		UserName: aws.String(username),
+	})
+	if err == nil {
+		return nil
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != iam.ErrCodeNoSuchEntityException {
+		Logger.Println("error:", err)
+		return err
+	}
+	if !preview {
+		_, err := IamClient().CreateUserWithContext(ctx, &iam.CreateUserInput{
+			UserName: aws.String(username),
+			Tags: []*iam.Tag{{
+				Key:   aws.String(infraSetTagName),
+				Value: aws.String(infrasetName),
+			}},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"iam created user:", username)
+	return nil
+}
+
 func IamEnsureUserApi(ctx context.Context, username string, preview bool) (*iam.AccessKey, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "IamEnsureUserApi"}
@@ -1535,8 +1619,14 @@ func IamListUsers(ctx context.Context) ([]*IamUser, error) {
 			return nil, err
 		}
 		for _, user := range out.Users {
+			tags, err := iamListUserTags(ctx, *user.UserName)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			user.Tags = tags
 			iamUser := &IamUser{}
-			err := iamUser.FromUser(ctx, user)
+			err = iamUser.FromUser(ctx, user)
 			if err != nil {
 				Logger.Println("error:", err)
 				return nil, err
@@ -1551,6 +1641,27 @@ func IamListUsers(ctx context.Context) ([]*IamUser, error) {
 	return result, nil
 }
 
+func iamListUserTags(ctx context.Context, username string) ([]*iam.Tag, error) {
+	var marker *string
+	var tags []*iam.Tag
+	for {
+		out, err := IamClient().ListUserTagsWithContext(ctx, &iam.ListUserTagsInput{
+			UserName: aws.String(username),
+			Marker:   marker,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		tags = append(tags, out.Tags...)
+		if out.Marker == nil {
+			break
+		}
+		marker = out.Marker
+	}
+	return tags, nil
+}
+
 func IamEnsureEC2SpotRoles(ctx context.Context, preview bool) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "IamEnsureEC2SpotRoles"}
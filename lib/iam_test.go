@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIamRolePrincipal(t *testing.T) {
+	type test struct {
+		principal string
+		path      string
+	}
+	tests := []test{
+		{"ec2", "/ec2/role-path/"},
+		{"ecs-tasks", "/ecs-tasks/role-path/"},
+		{"123456789012", "/account-123456789012/role-path/"},
+		{"arn:aws:iam::123456789012:role/deploy", "/account-123456789012/role-path/"},
+	}
+	for _, test := range tests {
+		path := iamRolePath(test.principal, "role")
+		if path != test.path {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", path, test.path)
+		}
+		document, err := iamAssumePolicyDocument(test.principal)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		policy := &IamPolicyDocument{}
+		err = json.Unmarshal([]byte(*document), policy)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		principal := IamRolePrincipal(policy)
+		if principal != test.principal {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", principal, test.principal)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -17,13 +18,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"golang.org/x/sync/semaphore"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,9 +48,13 @@ const (
 	infraKeyS3              = "s3"
 	infraKeyDynamoDB        = "dynamodb"
 	infraKeySqs             = "sqs"
+	infraKeySNS             = "sns"
 	infraKeyKeypair         = "keypair"
 	infraKeyVpc             = "vpc"
 	infraKeyInstanceProfile = "instance-profile"
+	infraKeyAlarm           = "alarm"
+	infraKeyUser            = "user"
+	infraKeyRole            = "role"
 )
 
 type InfraSet struct {
@@ -60,6 +68,7 @@ type InfraSet struct {
 	// stateful infra
 	DynamoDB map[string]*InfraDynamoDB `yaml:"dynamodb,omitempty"`
 	SQS      map[string]*InfraSQS      `yaml:"sqs,omitempty"`
+	SNS      map[string]*InfraSNS      `yaml:"sns,omitempty"`
 	S3       map[string]*InfraS3       `yaml:"s3,omitempty"`
 
 	// ec2 infra
@@ -67,9 +76,14 @@ type InfraSet struct {
 	Vpc             map[string]*InfraVpc             `yaml:"vpc,omitempty"`
 	InstanceProfile map[string]*InfraInstanceProfile `yaml:"instance-profile,omitempty"`
 
+	// monitoring
+	Alarm map[string]*InfraAlarm `yaml:"alarm,omitempty"`
+
+	// iam
+	User map[string]*InfraUser `yaml:"user,omitempty"`
+	Role map[string]*InfraRole `yaml:"role,omitempty"`
+
 	// "none" infraset gets a few extra slots for resources not associated with any infraset
-	User  map[string]*InfraUser  `yaml:"user,omitempty"`
-	Role  map[string]*InfraRole  `yaml:"role,omitempty"`  // any role  not associated with an infraset shows up here
 	Api   map[string]*InfraApi   `yaml:"api,omitempty"`   // any api   not associated with an infraset shows up here
 	Event map[string]*InfraEvent `yaml:"event,omitempty"` // any event not associated with an infraset shows up here
 }
@@ -82,15 +96,28 @@ type InfraApi struct {
 	ReadOnlyUrl  string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
+const (
+	infraKeyUserAllow  = "allow"
+	infraKeyUserPolicy = "policy"
+)
+
 type InfraUser struct {
-	Allow  []string `json:"allow,omitempty"  yaml:"allow,omitempty"`
-	Policy []string `json:"policy,omitempty" yaml:"policy,omitempty"`
+	infraSetName string
+	Allow        []string `json:"allow,omitempty"  yaml:"allow,omitempty"`
+	Policy       []string `json:"policy,omitempty" yaml:"policy,omitempty"`
 }
 
+const (
+	infraKeyRolePrincipal = "principal"
+	infraKeyRoleAllow     = "allow"
+	infraKeyRolePolicy    = "policy"
+)
+
 type InfraRole struct {
 	infraSetName string
-	Allow        []string `json:"allow,omitempty"  yaml:"allow,omitempty"`
-	Policy       []string `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Principal    string   `json:"principal,omitempty" yaml:"principal,omitempty"` // ec2 | ecs-tasks | ACCOUNT_ID | ARN
+	Allow        []string `json:"allow,omitempty"     yaml:"allow,omitempty"`
+	Policy       []string `json:"policy,omitempty"    yaml:"policy,omitempty"`
 }
 
 const (
@@ -130,14 +157,16 @@ type InfraKeypair struct {
 }
 
 const (
+	infraKeyVpcAttr          = "attr"
 	infraKeyVpcSecurityGroup = "security-group"
 	infraKeyVpcEC2           = "ec2"
 )
 
 type InfraVpc struct {
 	infraSetName  string
+	Attr          []string                       `json:"attr,omitempty" yaml:"attr,omitempty"`
 	SecurityGroup map[string]*InfraSecurityGroup `json:"security-group" yaml:"security-group"`
-	ReadOnlyEC2   map[string]*InfraEC2           `json:"ec2,omitempty"  yaml:"ec2,omitempty"`
+	EC2           map[string]*InfraEC2           `json:"ec2,omitempty"  yaml:"ec2,omitempty"`
 }
 
 const (
@@ -159,12 +188,19 @@ type InfraInstanceProfile struct {
 	Allow        []string `json:"allow,omitempty"  yaml:"allow,omitempty"`
 }
 
+const (
+	infraKeyEC2Attr  = "attr"
+	infraKeyEC2Count = "count"
+)
+
 type InfraEC2 struct {
-	vpcID      string
-	instanceID string
-	name       string
-	Attr       []string `json:"attr,omitempty"  yaml:"attr,omitempty"`
-	Count      int      `json:"count,omitempty" yaml:"count,omitempty"`
+	vpcID        string
+	instanceID   string
+	name         string
+	infraSetName string
+	dir          string
+	Attr         []string `json:"attr,omitempty"  yaml:"attr,omitempty"`
+	Count        int      `json:"count,omitempty" yaml:"count,omitempty"`
 }
 
 const (
@@ -181,8 +217,12 @@ const (
 
 type InfraLambda struct {
 	dir          string // parent dir of infra.yaml file
-	runtime      string // provided (container) or python (zip) or go (zip)
-	handler      string // "main" (go), "filename.main" (python), or "" (container)
+	runtime      string // provided (container) or python (zip) or node (zip) or go (zip)
+	handler      string // "main" (go), "filename.main" (python and node), or "" (container)
+	arch         string // amd64 or arm64
+	alias        string // when set, triggers invoke this alias instead of $LATEST
+	image        string // pushed image digest uri for dockerfile entrypoints
+	zipFile      string // when set, built instead of LambdaZipFile, so that local builds never touch the deploy zip
 	infraSetName string
 
 	Name       string          `json:"name,omitempty"       yaml:"name,omitempty"`
@@ -206,6 +246,15 @@ type InfraSQS struct {
 	Attr         []string `json:"attr,omitempty" yaml:"attr,omitempty"`
 }
 
+const (
+	infraKeySNSAttr = "attr"
+)
+
+type InfraSNS struct {
+	infraSetName string
+	Attr         []string `json:"attr,omitempty" yaml:"attr,omitempty"`
+}
+
 const (
 	infraKeyS3Attr = "attr"
 )
@@ -215,6 +264,15 @@ type InfraS3 struct {
 	Attr         []string `json:"attr,omitempty" yaml:"attr,omitempty"`
 }
 
+const (
+	infraKeyAlarmAttr = "attr"
+)
+
+type InfraAlarm struct {
+	infraSetName string
+	Attr         []string `json:"attr,omitempty" yaml:"attr,omitempty"`
+}
+
 type InfraEvent struct {
 	infraSetName string
 	Target       string   `json:"target,omitempty" yaml:"target,omitempty"`
@@ -422,6 +480,40 @@ func InfraList(ctx context.Context, filter string, showEnvVarValues bool) (*Infr
 		errs <- nil
 	}()
 
+	// list sns
+	count++
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logRecover(r)
+			}
+		}()
+		topics, err := InfraListSNS(ctx, triggersChan)
+		if err != nil {
+			errs <- err
+			return
+		}
+		for name, topic := range topics {
+			infraSetName := topic.infraSetName
+			if infraSetName == "" {
+				infraSetName = infraSetNameNone
+			}
+			if filter != "" && !(strings.Contains(infraSetName, filter) || strings.Contains(name, filter)) {
+				continue
+			}
+			lock.Lock()
+			if infra.InfraSet[infraSetName] == nil {
+				infra.InfraSet[infraSetName] = &InfraSet{}
+			}
+			if infra.InfraSet[infraSetName].SNS == nil {
+				infra.InfraSet[infraSetName].SNS = map[string]*InfraSNS{}
+			}
+			infra.InfraSet[infraSetName].SNS[name] = topic
+			lock.Unlock()
+		}
+		errs <- nil
+	}()
+
 	// list s3
 	count++
 	go func() {
@@ -503,17 +595,24 @@ func InfraList(ctx context.Context, filter string, showEnvVarValues bool) (*Infr
 			errs <- err
 			return
 		}
-		lock.Lock()
-		if infra.InfraSet[infraSetNameNone] == nil {
-			infra.InfraSet[infraSetNameNone] = &InfraSet{}
-		}
-		if infra.InfraSet[infraSetNameNone].User == nil {
-			infra.InfraSet[infraSetNameNone].User = map[string]*InfraUser{}
-		}
 		for name, user := range users {
-			infra.InfraSet[infraSetNameNone].User[name] = user
+			infraSetName := user.infraSetName
+			if infraSetName == "" {
+				infraSetName = infraSetNameNone
+			}
+			if filter != "" && !(strings.Contains(infraSetName, filter) || strings.Contains(name, filter)) {
+				continue
+			}
+			lock.Lock()
+			if infra.InfraSet[infraSetName] == nil {
+				infra.InfraSet[infraSetName] = &InfraSet{}
+			}
+			if infra.InfraSet[infraSetName].User == nil {
+				infra.InfraSet[infraSetName].User = map[string]*InfraUser{}
+			}
+			infra.InfraSet[infraSetName].User[name] = user
+			lock.Unlock()
 		}
-		lock.Unlock()
 		errs <- nil
 	}()
 
@@ -585,6 +684,40 @@ func InfraList(ctx context.Context, filter string, showEnvVarValues bool) (*Infr
 		errs <- nil
 	}()
 
+	// list alarm
+	count++
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logRecover(r)
+			}
+		}()
+		alarms, err := InfraListAlarm(ctx)
+		if err != nil {
+			errs <- err
+			return
+		}
+		for name, alarm := range alarms {
+			infraSetName := alarm.infraSetName
+			if infraSetName == "" {
+				infraSetName = infraSetNameNone
+			}
+			if filter != "" && !(strings.Contains(infraSetName, filter) || strings.Contains(name, filter)) {
+				continue
+			}
+			lock.Lock()
+			if infra.InfraSet[infraSetName] == nil {
+				infra.InfraSet[infraSetName] = &InfraSet{}
+			}
+			if infra.InfraSet[infraSetName].Alarm == nil {
+				infra.InfraSet[infraSetName].Alarm = map[string]*InfraAlarm{}
+			}
+			infra.InfraSet[infraSetName].Alarm[name] = alarm
+			lock.Unlock()
+		}
+		errs <- nil
+	}()
+
 	// list lambda
 	lambdaErr := make(chan error)
 	go func() {
@@ -657,7 +790,7 @@ func InfraList(ctx context.Context, filter string, showEnvVarValues bool) (*Infr
 					delete(vpc.SecurityGroup, sgName) // do not show empty default sg
 				}
 			}
-			for _, ec2 := range vpc.ReadOnlyEC2 {
+			for _, ec2 := range vpc.EC2 {
 				var attrs []string
 				for _, attr := range ec2.Attr {
 					if !strings.HasPrefix(attr, "vpc=") && !strings.HasPrefix(attr, "tag.user=") {
@@ -863,6 +996,34 @@ func InfraListLambda(ctx context.Context, triggersChan <-chan *InfraTrigger, fil
 			if *fn.Timeout != lambdaAttrTimeoutDefault {
 				infraLambda.Attr = append(infraLambda.Attr, fmt.Sprintf("timeout=%d", *fn.Timeout))
 			}
+			if arch := lambdaArch(fn.Architectures); arch != lambdaAttrArchDefault {
+				infraLambda.Attr = append(infraLambda.Attr, lambdaAttrArch+"="+arch)
+			}
+			vpcAttrs, err := lambdaVpcAttrs(ctx, fn.VpcConfig)
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			infraLambda.Attr = append(infraLambda.Attr, vpcAttrs...)
+			aliases, err := LambdaListManagedAliases(ctx, *fn.FunctionName)
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			qualifier := ""
+			for _, alias := range aliases {
+				qualifier = *alias.Name
+				infraLambda.Attr = append(infraLambda.Attr, lambdaAttrAlias+"="+*alias.Name)
+				_, canary, bake, _ := lambdaParseAliasDescription(alias.Description)
+				if canary != lambdaAttrCanaryDefault {
+					infraLambda.Attr = append(infraLambda.Attr, fmt.Sprintf("%s=%d", lambdaAttrCanary, canary))
+				}
+				if bake != lambdaAttrBakeDefault {
+					infraLambda.Attr = append(infraLambda.Attr, fmt.Sprintf("%s=%d", lambdaAttrBake, bake))
+				}
+			}
 			out, err := LambdaClient().GetFunctionConcurrencyWithContext(ctx, &lambda.GetFunctionConcurrencyInput{
 				FunctionName: aws.String(*fn.FunctionName),
 			})
@@ -874,6 +1035,24 @@ func InfraListLambda(ctx context.Context, triggersChan <-chan *InfraTrigger, fil
 			if out.ReservedConcurrentExecutions != nil {
 				infraLambda.Attr = append(infraLambda.Attr, fmt.Sprintf("concurrency=%d", *out.ReservedConcurrentExecutions))
 			}
+			eventInvokeConfig, err := LambdaGetEventInvokeConfig(ctx, *fn.FunctionName, qualifier)
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			var destinationAllows []string
+			for _, attr := range lambdaEventInvokeConfigAttrs(eventInvokeConfig) {
+				infraLambda.Attr = append(infraLambda.Attr, attr)
+				k, v, _ := SplitOnce(attr, "=")
+				if k == lambdaAttrOnFailure || k == lambdaAttrOnSuccess {
+					account := strings.Split(*fn.FunctionArn, ":")[4]
+					_, allow, err := lambdaDestinationArn(account, Region(), v)
+					if err == nil {
+						destinationAllows = append(destinationAllows, allow)
+					}
+				}
+			}
 			logGroupName := "/aws/lambda/" + *fn.FunctionName
 			outGroups, err := LogsClient().DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
 				LogGroupNamePrefix: aws.String(logGroupName),
@@ -904,6 +1083,9 @@ func InfraListLambda(ctx context.Context, triggersChan <-chan *InfraTrigger, fil
 				return
 			}
 			for _, policy := range policies {
+				if *policy.PolicyName == lambdaVpcPolicy && len(vpcAttrs) > 0 {
+					continue // implied by the vpc attr
+				}
 				infraLambda.Policy = append(infraLambda.Policy, *policy.PolicyName)
 			}
 			allows, err := IamListRoleAllows(ctx, roleName)
@@ -912,8 +1094,27 @@ func InfraListLambda(ctx context.Context, triggersChan <-chan *InfraTrigger, fil
 				return
 			}
 			for _, allow := range allows {
+				if Contains(destinationAllows, allow.String()) {
+					continue // implied by the on-failure and on-success attrs
+				}
 				infraLambda.Allow = append(infraLambda.Allow, allow.String())
 			}
+			urlOut, err := LambdaClient().ListFunctionUrlConfigsWithContext(ctx, &lambda.ListFunctionUrlConfigsInput{
+				FunctionName: fn.FunctionName,
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			for _, config := range urlOut.FunctionUrlConfigs {
+				urlTrigger := lambdaTriggerUrlConfFromConfig(config.AuthType, config.InvokeMode, config.Cors)
+				triggers[*fn.FunctionName] = append(triggers[*fn.FunctionName], &InfraTrigger{
+					lambdaName: *fn.FunctionName,
+					Type:       lambdaTriggerUrl,
+					Attr:       append(urlTrigger.Attrs(), lambdaTriggerUrlAttrUrl+"="+*config.FunctionUrl),
+				})
+			}
 			var marker *string
 			for {
 				out, err := LambdaClient().ListEventSourceMappingsWithContext(ctx, &lambda.ListEventSourceMappingsInput{
@@ -1313,7 +1514,7 @@ func InfraListVpc(ctx context.Context) (map[string]*InfraVpc, error) {
 	}
 	for _, vpc := range vpcs {
 		infraVpc := &InfraVpc{
-			ReadOnlyEC2:   map[string]*InfraEC2{},
+			EC2:           map[string]*InfraEC2{},
 			SecurityGroup: map[string]*InfraSecurityGroup{},
 		}
 		for _, tag := range vpc.Tags {
@@ -1322,11 +1523,17 @@ func InfraListVpc(ctx context.Context) (map[string]*InfraVpc, error) {
 				break
 			}
 		}
+		attrs, err := vpcListAttrs(ctx, vpc)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		infraVpc.Attr = attrs
 		for name, ec2 := range ec2s {
 			if ec2.vpcID != *vpc.VpcId {
 				continue
 			}
-			infraVpc.ReadOnlyEC2[name] = ec2
+			infraVpc.EC2[name] = ec2
 		}
 		for _, sg := range sgs {
 			if *sg.VpcId != *vpc.VpcId {
@@ -1381,19 +1588,30 @@ func InfraListEC2(ctx context.Context) (map[string]*InfraEC2, error) {
 		infraEC2.instanceID = *instance.InstanceId
 		infraEC2.vpcID = orDash(instance.VpcId)
 		infraEC2.Count = 1
-		infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("type=%s", *instance.InstanceType))
-		infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("ami=%s", *instance.ImageId))
-		infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("kind=%s", EC2Kind(instance)))
-		infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("vpc=%s", orDash(instance.VpcId)))
-		for _, sg := range instance.SecurityGroups {
-			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("sg=%s", orDash(sg.GroupName)))
-		}
-		if *instance.State.Name != ec2.InstanceStateNameRunning {
-			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("state=%s", *instance.State.Name))
-		}
 		for _, tag := range instance.Tags {
-			if *tag.Key != "creation-date" && *tag.Key != "Name" && *tag.Key != "aws:ec2spot:fleet-request-id" {
-				infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("tag.%s=%s", *tag.Key, *tag.Value))
+			if *tag.Key == infraSetTagName {
+				infraEC2.infraSetName = *tag.Value
+				break
+			}
+		}
+		if infraEC2.infraSetName != "" {
+			// managed by an infra set, so list attrs that EC2EnsureInput accepts
+			infraEC2.Attr = ec2ListAttrs(instance)
+		} else {
+			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("type=%s", *instance.InstanceType))
+			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("ami=%s", *instance.ImageId))
+			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("kind=%s", EC2Kind(instance)))
+			infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("vpc=%s", orDash(instance.VpcId)))
+			for _, sg := range instance.SecurityGroups {
+				infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("sg=%s", orDash(sg.GroupName)))
+			}
+			if *instance.State.Name != ec2.InstanceStateNameRunning {
+				infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("state=%s", *instance.State.Name))
+			}
+			for _, tag := range instance.Tags {
+				if *tag.Key != "creation-date" && *tag.Key != "Name" && *tag.Key != "aws:ec2spot:fleet-request-id" {
+					infraEC2.Attr = append(infraEC2.Attr, fmt.Sprintf("tag.%s=%s", *tag.Key, *tag.Value))
+				}
 			}
 		}
 		key := infraEC2.name + "::" + strings.Join(infraEC2.Attr, "::")
@@ -1436,14 +1654,25 @@ func InfraListUser(ctx context.Context) (map[string]*InfraUser, error) {
 	}
 	result := make(map[string]*InfraUser)
 	for _, user := range out {
-		result[*user.UserName] = &InfraUser{
-			Allow:  user.Allows,
-			Policy: user.Policies,
-		}
+		result[*user.UserName] = infraUserFromIamUser(user)
 	}
 	return result, nil
 }
 
+func infraUserFromIamUser(user *IamUser) *InfraUser {
+	infraUser := &InfraUser{
+		Allow:  user.Allows,
+		Policy: user.Policies,
+	}
+	for _, tag := range user.tags {
+		if *tag.Key == infraSetTagName {
+			infraUser.infraSetName = *tag.Value
+			break
+		}
+	}
+	return infraUser
+}
+
 func InfraListRole(ctx context.Context) (map[string]*InfraRole, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "InfraListRole"}
@@ -1460,8 +1689,9 @@ func InfraListRole(ctx context.Context) (map[string]*InfraRole, error) {
 			continue
 		}
 		infraRole := &InfraRole{
-			Allow:  role.Allow,
-			Policy: role.Policy,
+			Principal: IamRolePrincipal(role.AssumeRolePolicyDocument),
+			Allow:     role.Allow,
+			Policy:    role.Policy,
 		}
 		for _, tag := range role.Tags {
 			if *tag.Key == infraSetTagName {
@@ -1593,16 +1823,18 @@ func InfraListS3(ctx context.Context, triggersChan chan<- *InfraTrigger) (map[st
 			if s3Default.metrics != metrics {
 				infraS3.Attr = append(infraS3.Attr, fmt.Sprintf("metrics=%t", metrics))
 			}
-			ttl := descr.Lifecycle
-			if len(ttl) == 1 && int64(s3Default.ttlDays) != *ttl[0].Expiration.Days {
-				infraS3.Attr = append(infraS3.Attr, fmt.Sprintf("ttldays=%d", *ttl[0].Expiration.Days))
+			lifecycle, supported := s3LifecycleFromRules(descr.Lifecycle)
+			if !supported {
+				Logger.Println("ignoring unsupported lifecycle rules for:", *bucket.Name)
+			} else {
+				infraS3.Attr = append(infraS3.Attr, lifecycle.Attrs()...)
 			}
 			if descr.Notifications != nil {
 				for _, conf := range descr.Notifications.LambdaFunctionConfigurations {
 					triggersChan <- &InfraTrigger{
 						lambdaName: LambdaArnToLambdaName(*conf.LambdaFunctionArn),
 						Type:       lambdaTrigerS3,
-						Attr:       []string{*bucket.Name},
+						Attr:       lambdaTriggerS3FromConf(*bucket.Name, conf).Attrs(),
 					}
 				}
 			}
@@ -1652,6 +1884,10 @@ func InfraListSQS(ctx context.Context) (map[string]*InfraSQS, error) {
 					aws.String(sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds),
 					aws.String(sqs.QueueAttributeNameVisibilityTimeout),
 					aws.String(sqs.QueueAttributeNameKmsDataKeyReusePeriodSeconds),
+					aws.String(sqs.QueueAttributeNameFifoQueue),
+					aws.String(sqs.QueueAttributeNameContentBasedDeduplication),
+					aws.String(sqs.QueueAttributeNameRedrivePolicy),
+					aws.String(sqs.QueueAttributeNameRedriveAllowPolicy),
 				},
 			})
 			if err != nil {
@@ -1697,6 +1933,35 @@ func InfraListSQS(ctx context.Context) (map[string]*InfraSQS, error) {
 			if out.Attributes["KmsDataKeyReusePeriodSeconds"] != nil && *out.Attributes["KmsDataKeyReusePeriodSeconds"] != "300" { // default
 				infraSQS.Attr = append(infraSQS.Attr, "KmsDataKeyReusePeriodSeconds="+*out.Attributes["KmsDataKeyReusePeriodSeconds"])
 			}
+			if out.Attributes["FifoQueue"] != nil && *out.Attributes["FifoQueue"] == "true" {
+				infraSQS.Attr = append(infraSQS.Attr, "fifo=true")
+				if out.Attributes["ContentBasedDeduplication"] != nil && *out.Attributes["ContentBasedDeduplication"] != "true" { // default
+					infraSQS.Attr = append(infraSQS.Attr, "dedup="+*out.Attributes["ContentBasedDeduplication"])
+				}
+			}
+			if out.Attributes["RedrivePolicy"] != nil {
+				dlq, maxReceiveCount, err := sqsParseRedrivePolicy(*out.Attributes["RedrivePolicy"])
+				if err != nil {
+					Logger.Println("error:", err)
+					errChan <- err
+					return
+				}
+				infraSQS.Attr = append(infraSQS.Attr, "dlq="+dlq)
+				if maxReceiveCount != sqsMaxReceiveDefault {
+					infraSQS.Attr = append(infraSQS.Attr, fmt.Sprintf("maxreceive=%d", maxReceiveCount))
+				}
+			}
+			if out.Attributes["RedriveAllowPolicy"] != nil {
+				redriveAllow, err := sqsParseRedriveAllowPolicy(*out.Attributes["RedriveAllowPolicy"])
+				if err != nil {
+					Logger.Println("error:", err)
+					errChan <- err
+					return
+				}
+				if strings.Join(redriveAllow, ",") != sqsRedriveAllowAll { // default
+					infraSQS.Attr = append(infraSQS.Attr, "redrive-allow="+strings.Join(redriveAllow, ","))
+				}
+			}
 			lock.Lock()
 			res[SQSUrlToName(url)] = infraSQS
 			lock.Unlock()
@@ -1713,67 +1978,325 @@ func InfraListSQS(ctx context.Context) (map[string]*InfraSQS, error) {
 	return res, nil
 }
 
-func InfraEnsureKeypair(ctx context.Context, infraSet *InfraSet, preview bool) error {
-	if doDebug {
-		d := &Debug{start: time.Now(), name: "InfraEnsureKeypair"}
-		defer d.Log()
-	}
-	for keypairName, infraKeypair := range infraSet.Keypair {
-		err := EC2EnsureKeypair(ctx, infraSet.Name, keypairName, infraKeypair.PubkeyContent, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
+func infraAlarmAction(arn string) string {
+	if strings.HasPrefix(arn, "arn:aws:sns:") {
+		return Last(strings.Split(arn, ":"))
 	}
-	return nil
+	return arn
 }
 
-func InfraEnsureInstanceProfile(ctx context.Context, infraSet *InfraSet, preview bool) error {
-	if doDebug {
-		d := &Debug{start: time.Now(), name: "InfraEnsureInstanceProfile"}
-		defer d.Log()
-	}
-	for profileName, infraProfile := range infraSet.InstanceProfile {
-		err := IamEnsureInstanceProfile(ctx, infraSet.Name, profileName, infraProfile.Policy, infraProfile.Allow, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
-	}
-	return nil
-}
+const infraListAlarmConcurrency = 16
 
-func InfraEnsureVpc(ctx context.Context, infraSet *InfraSet, preview bool) error {
+func InfraListAlarm(ctx context.Context) (map[string]*InfraAlarm, error) {
 	if doDebug {
-		d := &Debug{start: time.Now(), name: "InfraEnsureVpc"}
+		d := &Debug{start: time.Now(), name: "InfraListAlarm"}
 		defer d.Log()
 	}
-	hasVpc := false
-	for vpcName, infraVpc := range infraSet.Vpc {
-		hasVpc = true
-		vpcID, err := VpcEnsure(ctx, infraSet.Name, vpcName, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
-		var sgNames []string
-		for sgName, infraSg := range infraVpc.SecurityGroup {
-			input, err := EC2EnsureSgInput(infraSet.Name, vpcName, sgName, infraSg.Rule)
+	alarms, err := CloudwatchListAlarms(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	errChan := make(chan error)
+	lock := &sync.Mutex{}
+	res := make(map[string]*InfraAlarm)
+	// an account can have thousands of alarms, and tags are listed per alarm
+	concurrency := semaphore.NewWeighted(infraListAlarmConcurrency)
+	for _, alarm := range alarms {
+		alarm := alarm
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logRecover(r)
+				}
+			}()
+			err := concurrency.Acquire(ctx, 1)
 			if err != nil {
-				Logger.Println("error:", err)
-				return err
+				errChan <- err
+				return
 			}
-			err = EC2EnsureSg(ctx, input, preview)
+			defer concurrency.Release(1)
+			infraAlarm := &InfraAlarm{}
+			tagsOut, err := CloudwatchClient().ListTagsForResourceWithContext(ctx, &cloudwatch.ListTagsForResourceInput{
+				ResourceARN: alarm.alarmArn,
+			})
 			if err != nil {
 				Logger.Println("error:", err)
-				return err
+				errChan <- err
+				return
 			}
-			sgNames = append(sgNames, sgName)
-		}
-		sgs, err := EC2ListSgs(ctx)
+			for _, tag := range tagsOut.Tags {
+				if *tag.Key == infraSetTagName {
+					infraAlarm.infraSetName = *tag.Value
+					break
+				}
+			}
+			if alarm.MetricName == nil { // metric math alarms are not declarable
+				errChan <- nil
+				return
+			}
+			infraAlarm.Attr = append(infraAlarm.Attr, "namespace="+*alarm.Namespace)
+			infraAlarm.Attr = append(infraAlarm.Attr, "metric="+*alarm.MetricName)
+			for _, dimension := range alarm.Dimensions {
+				infraAlarm.Attr = append(infraAlarm.Attr, "dimension="+*dimension.Name+"="+*dimension.Value)
+			}
+			if alarm.Statistic != nil && *alarm.Statistic != cloudwatchAlarmStatisticDefault {
+				infraAlarm.Attr = append(infraAlarm.Attr, "statistic="+strings.ToLower(*alarm.Statistic))
+			}
+			infraAlarm.Attr = append(infraAlarm.Attr, fmt.Sprintf("threshold=%v", aws.Float64Value(alarm.Threshold)))
+			if alarm.ComparisonOperator != nil && *alarm.ComparisonOperator != cloudwatchAlarmComparisonDefault {
+				for k, v := range cloudwatchAlarmComparisons {
+					if v == *alarm.ComparisonOperator {
+						infraAlarm.Attr = append(infraAlarm.Attr, "comparison="+k)
+						break
+					}
+				}
+			}
+			if aws.Int64Value(alarm.Period) != cloudwatchAlarmPeriodDefault {
+				infraAlarm.Attr = append(infraAlarm.Attr, fmt.Sprintf("period=%d", aws.Int64Value(alarm.Period)))
+			}
+			if aws.Int64Value(alarm.EvaluationPeriods) != cloudwatchAlarmPeriodsDefault {
+				infraAlarm.Attr = append(infraAlarm.Attr, fmt.Sprintf("periods=%d", aws.Int64Value(alarm.EvaluationPeriods)))
+			}
+			if alarm.DatapointsToAlarm != nil && *alarm.DatapointsToAlarm != aws.Int64Value(alarm.EvaluationPeriods) {
+				infraAlarm.Attr = append(infraAlarm.Attr, fmt.Sprintf("datapoints=%d", *alarm.DatapointsToAlarm))
+			}
+			if alarm.TreatMissingData != nil && *alarm.TreatMissingData != cloudwatchAlarmMissingDefault {
+				infraAlarm.Attr = append(infraAlarm.Attr, "missing="+*alarm.TreatMissingData)
+			}
+			for _, action := range alarm.AlarmActions {
+				infraAlarm.Attr = append(infraAlarm.Attr, "action="+infraAlarmAction(*action))
+			}
+			for _, action := range alarm.OKActions {
+				infraAlarm.Attr = append(infraAlarm.Attr, "ok-action="+infraAlarmAction(*action))
+			}
+			lock.Lock()
+			res[*alarm.AlarmName] = infraAlarm
+			lock.Unlock()
+			errChan <- nil
+		}()
+	}
+	for range alarms {
+		err := <-errChan
 		if err != nil {
 			Logger.Println("error:", err)
-			return err
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func InfraListSNS(ctx context.Context, triggersChan chan<- *InfraTrigger) (map[string]*InfraSNS, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraListSNS"}
+		defer d.Log()
+	}
+	topicArns, err := SNSListTopics(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	errChan := make(chan error)
+	lock := &sync.Mutex{}
+	res := make(map[string]*InfraSNS)
+	for _, topicArn := range topicArns {
+		topicArn := topicArn
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logRecover(r)
+				}
+			}()
+			infraSNS := &InfraSNS{}
+			tagsOut, err := SNSClient().ListTagsForResourceWithContext(ctx, &sns.ListTagsForResourceInput{
+				ResourceArn: aws.String(topicArn),
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			for _, tag := range tagsOut.Tags {
+				if *tag.Key == infraSetTagName {
+					infraSNS.infraSetName = *tag.Value
+					break
+				}
+			}
+			out, err := SNSClient().GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
+				TopicArn: aws.String(topicArn),
+			})
+			if err != nil {
+				aerr, ok := err.(awserr.Error)
+				if ok && aerr.Code() == sns.ErrCodeNotFoundException {
+					errChan <- nil
+					return
+				}
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			if out.Attributes["FifoTopic"] != nil && *out.Attributes["FifoTopic"] == "true" {
+				infraSNS.Attr = append(infraSNS.Attr, "fifo=true")
+			}
+			if out.Attributes["KmsMasterKeyId"] == nil {
+				infraSNS.Attr = append(infraSNS.Attr, "kms=false")
+			} else if *out.Attributes["KmsMasterKeyId"] != snsKmsDefault {
+				infraSNS.Attr = append(infraSNS.Attr, "kms="+*out.Attributes["KmsMasterKeyId"])
+			}
+			subscriptions, err := SNSListSubscriptions(ctx, topicArn)
+			if err != nil {
+				Logger.Println("error:", err)
+				errChan <- err
+				return
+			}
+			for _, subscription := range subscriptions {
+				switch *subscription.Protocol {
+				case "lambda":
+					triggersChan <- &InfraTrigger{
+						lambdaName: LambdaArnToLambdaName(*subscription.Endpoint),
+						Type:       lambdaTriggerSNS,
+						Attr:       []string{SNSArnToName(topicArn)},
+					}
+				case snsSubscribeSQS:
+					infraSNS.Attr = append(infraSNS.Attr, "subscribe=sqs:"+SQSArnToName(*subscription.Endpoint))
+				case snsSubscribeEmail:
+					infraSNS.Attr = append(infraSNS.Attr, "subscribe=email:"+*subscription.Endpoint)
+				default:
+					Logger.Println("ignoring sns subscription:", topicArn, *subscription.Protocol, *subscription.Endpoint)
+				}
+			}
+			lock.Lock()
+			res[SNSArnToName(topicArn)] = infraSNS
+			lock.Unlock()
+			errChan <- nil
+		}()
+	}
+	for range topicArns {
+		err := <-errChan
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func InfraEnsureKeypair(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureKeypair"}
+		defer d.Log()
+	}
+	for keypairName, infraKeypair := range infraSet.Keypair {
+		err := EC2EnsureKeypair(ctx, infraSet.Name, keypairName, infraKeypair.PubkeyContent, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func InfraEnsureInstanceProfile(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureInstanceProfile"}
+		defer d.Log()
+	}
+	for profileName, infraProfile := range infraSet.InstanceProfile {
+		err := IamEnsureInstanceProfile(ctx, infraSet.Name, profileName, infraProfile.Policy, infraProfile.Allow, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func InfraEnsureUser(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureUser"}
+		defer d.Log()
+	}
+	for username, infraUser := range infraSet.User {
+		err := IamEnsureUser(ctx, infraSet.Name, username, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = IamEnsureUserAllows(ctx, username, infraUser.Allow, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = IamEnsureUserPolicies(ctx, username, infraUser.Policy, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func InfraEnsureRole(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureRole"}
+		defer d.Log()
+	}
+	for roleName, infraRole := range infraSet.Role {
+		err := IamEnsureRole(ctx, infraSet.Name, roleName, infraRole.Principal, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = IamEnsureRolePolicies(ctx, roleName, infraRole.Policy, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = IamEnsureRoleAllows(ctx, roleName, infraRole.Allow, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func InfraEnsureVpc(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureVpc"}
+		defer d.Log()
+	}
+	hasVpc := false
+	for vpcName, infraVpc := range infraSet.Vpc {
+		hasVpc = true
+		input, err := VpcEnsureInput(infraSet.Name, vpcName, infraVpc.Attr)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		vpcID, err := VpcEnsure(ctx, input, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		var sgNames []string
+		for sgName, infraSg := range infraVpc.SecurityGroup {
+			input, err := EC2EnsureSgInput(infraSet.Name, vpcName, sgName, infraSg.Rule)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			err = EC2EnsureSg(ctx, input, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			sgNames = append(sgNames, sgName)
+		}
+		sgs, err := EC2ListSgs(ctx)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
 		}
 		for _, sg := range sgs {
 			if *sg.VpcId == vpcID && *sg.GroupName != "default" && !Contains(sgNames, *sg.GroupName) {
@@ -1815,6 +2338,26 @@ func InfraEnsureS3(ctx context.Context, infraSet *InfraSet, preview bool) error
 	return nil
 }
 
+func InfraEnsureAlarm(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureAlarm"}
+		defer d.Log()
+	}
+	for alarmName, infraAlarm := range infraSet.Alarm {
+		input, err := CloudwatchEnsureAlarmInput(infraSet.Name, alarmName, infraAlarm.Attr)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = CloudwatchEnsureAlarm(ctx, input, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
 func infraEnsureDynamoDBGlobalIndexToAttrs(infraDynamoDB *InfraDynamoDB) error {
 	count := 0
 	for name, index := range infraDynamoDB.GlobalIndex {
@@ -1934,13 +2477,53 @@ func InfraEnsureSQS(ctx context.Context, infraSet *InfraSet, preview bool) error
 		d := &Debug{start: time.Now(), name: "InfraEnsureSQS"}
 		defer d.Log()
 	}
+	inputs := map[string]*sqsEnsureInput{}
 	for queueName, infraSQS := range infraSet.SQS {
 		input, err := SQSEnsureInput(infraSet.Name, queueName, infraSQS.Attr)
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
-		err = SQSEnsure(ctx, input, preview)
+		inputs[queueName] = input
+	}
+	// ensure dlqs before the queues that redrive to them
+	done := map[string]bool{}
+	for len(done) < len(inputs) {
+		progress := false
+		for queueName, input := range inputs {
+			if done[queueName] || (inputs[input.dlq] != nil && !done[input.dlq]) {
+				continue
+			}
+			input.dlqEnsured = inputs[input.dlq] != nil
+			err := SQSEnsure(ctx, input, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			done[queueName] = true
+			progress = true
+		}
+		if !progress {
+			err := fmt.Errorf("sqs dlq cycle in infra set: %s", infraSet.Name)
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func InfraEnsureSNS(ctx context.Context, infraSet *InfraSet, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsureSNS"}
+		defer d.Log()
+	}
+	for topicName, infraSNS := range infraSet.SNS {
+		input, err := SNSEnsureInput(infraSet.Name, topicName, infraSNS.Attr)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = SNSEnsure(ctx, input, preview)
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
@@ -1972,32 +2555,13 @@ func InfraEnsureLambda(ctx context.Context, infraSet *InfraSet, quick string, pr
 			continue
 		}
 		infraLambda.Name = lambdaName
-		if strings.HasSuffix(infraLambda.Entrypoint, ".py") {
-			infraLambda.runtime = lambdaRuntimePython
-			infraLambda.handler = strings.TrimSuffix(path.Base(infraLambda.Entrypoint), ".py") + ".main"
-			err := lambdaEnsure(ctx, infraLambda, quick != "", preview, showEnvVarValues, lambdaUpdateZipPy, lambdaCreateZipPy)
-			if err != nil {
-				Logger.Println("error:", err)
-				return err
-			}
-		} else if strings.HasSuffix(infraLambda.Entrypoint, ".go") {
-			infraLambda.runtime = lambdaRuntimeGo
-			infraLambda.handler = "main"
-			err := lambdaEnsure(ctx, infraLambda, quick != "", preview, showEnvVarValues, lambdaUpdateZipGo, lambdaCreateZipGo)
-			if err != nil {
-				Logger.Println("error:", err)
-				return err
-			}
-		} else if strings.Contains(infraLambda.Entrypoint, ".dkr.ecr.") {
-			infraLambda.runtime = lambdaRuntimeContainer
-			infraLambda.handler = "main"
-			err := lambdaEnsure(ctx, infraLambda, quick != "", preview, showEnvVarValues, lambdaUpdateZipFake, lambdaCreateZipFake)
-			if err != nil {
-				Logger.Println("error:", err)
-				return err
-			}
-		} else {
-			err := fmt.Errorf("unknown entrypoint type: %s", infraLambda.Entrypoint)
+		updateZipFn, createZipFn, err := lambdaSetRuntime(infraLambda)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = lambdaEnsure(ctx, infraLambda, quick != "", preview, showEnvVarValues, updateZipFn, createZipFn)
+		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
@@ -2022,48 +2586,376 @@ func resolveEnvVars(s string, ignore []string) (string, error) {
 	return s, nil
 }
 
+// set runtime and handler from the entrypoint, returning the functions that build its zip
+func lambdaSetRuntime(infraLambda *InfraLambda) (LambdaUpdateZipFn, LambdaCreateZipFn, error) {
+	if strings.HasSuffix(infraLambda.Entrypoint, ".py") {
+		infraLambda.runtime = lambdaRuntimePython
+		infraLambda.handler = strings.TrimSuffix(path.Base(infraLambda.Entrypoint), ".py") + ".main"
+		return lambdaUpdateZipPy, lambdaCreateZipPy, nil
+	} else if Contains([]string{".js", ".mjs", ".ts"}, path.Ext(infraLambda.Entrypoint)) {
+		infraLambda.runtime = lambdaRuntimeNode
+		infraLambda.handler = strings.TrimSuffix(path.Base(infraLambda.Entrypoint), path.Ext(infraLambda.Entrypoint)) + ".main"
+		return lambdaUpdateZipNode, lambdaCreateZipNode, nil
+	} else if strings.HasSuffix(infraLambda.Entrypoint, ".go") {
+		infraLambda.runtime = lambdaRuntimeGo
+		infraLambda.handler = "main"
+		return lambdaUpdateZipGo, lambdaCreateZipGo, nil
+	} else if strings.Contains(infraLambda.Entrypoint, ".dkr.ecr.") {
+		infraLambda.runtime = lambdaRuntimeContainer
+		infraLambda.handler = "main"
+		return lambdaUpdateZipFake, lambdaCreateZipFake, nil
+	} else if _, _, ok := lambdaDockerfile(infraLambda.Entrypoint); ok {
+		infraLambda.runtime = lambdaRuntimeContainer
+		infraLambda.handler = "main"
+		return lambdaUpdateZipFake, lambdaCreateZipFake, nil
+	}
+	err := fmt.Errorf("unknown entrypoint type: %s", infraLambda.Entrypoint)
+	Logger.Println("error:", err)
+	return nil, nil, err
+}
+
+// the function url of a lambda with a url trigger, otherwise the url of its api
+func InfraLambdaUrl(ctx context.Context, name string, infraLambda *InfraLambda) (string, error) {
+	for _, trigger := range infraLambda.Trigger {
+		if trigger.Type == lambdaTriggerUrl {
+			alias := ""
+			for _, attr := range infraLambda.Attr {
+				k, v, err := SplitOnce(attr, "=")
+				if err == nil && k == lambdaAttrAlias {
+					alias = v
+				}
+			}
+			return LambdaUrl(ctx, name, alias)
+		}
+	}
+	return ApiUrl(ctx, name)
+}
+
 func lambdaUpdateZipFake(_ *InfraLambda) error { return nil }
 
 func lambdaCreateZipFake(_ *InfraLambda) error { return nil }
 
-func InfraEnsure(ctx context.Context, infraSet *InfraSet, quick string, preview, showEnvVarValues bool) error {
-	if doDebug {
-		d := &Debug{start: time.Now(), name: "InfraEnsure"}
-		defer d.Log()
-	}
-	if quick == "" {
-		err := InfraEnsureKeypair(ctx, infraSet, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
+const infraEnsureMaxConcurrencyDefault = 8
+
+type infraTask struct {
+	kind string
+	name string
+	deps []string // ids of tasks that must finish first
+	fn   func() error
+}
+
+func (t *infraTask) id() string {
+	return t.kind + "/" + t.name
+}
+
+// the order resources are started in when they are ready, same as the
+// historical serial order of InfraEnsure
+var infraTaskKindOrder = []string{
+	infraKeyKeypair,
+	infraKeyVpc,
+	infraKeyInstanceProfile,
+	infraKeyUser,
+	infraKeyRole,
+	infraKeyS3,
+	infraKeyDynamoDB,
+	infraKeySqs,
+	infraKeySNS,
+	infraKeyLambda,
+	infraKeyAlarm,
+}
+
+func infraTaskIndex(kind string) int {
+	for i, k := range infraTaskKindOrder {
+		if k == kind {
+			return i
 		}
-		err = InfraEnsureVpc(ctx, infraSet, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
+	}
+	return len(infraTaskKindOrder)
+}
+
+// run tasks with at most maxConcurrency in flight, starting each task once
+// its deps are done. ready tasks start in kind order then name order, so
+// with maxConcurrency=1 execution and output are deterministic.
+func infraRunTasks(tasks []*infraTask, maxConcurrency int) error {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if tasks[i].kind != tasks[j].kind {
+			return infraTaskIndex(tasks[i].kind) < infraTaskIndex(tasks[j].kind)
 		}
-		err = InfraEnsureInstanceProfile(ctx, infraSet, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
+		return tasks[i].name < tasks[j].name
+	})
+	ids := map[string]bool{}
+	for _, task := range tasks {
+		ids[task.id()] = true
+	}
+	done := map[string]bool{}
+	started := map[string]bool{}
+	type result struct {
+		id  string
+		err error
+	}
+	results := make(chan result)
+	running := 0
+	var firstErr error
+	for len(done) < len(tasks) {
+		if firstErr == nil {
+			for _, task := range tasks {
+				if running >= maxConcurrency {
+					break
+				}
+				if started[task.id()] {
+					continue
+				}
+				ready := true
+				for _, dep := range task.deps {
+					if ids[dep] && !done[dep] {
+						ready = false
+						break
+					}
+				}
+				if !ready {
+					continue
+				}
+				task := task
+				started[task.id()] = true
+				running++
+				go func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logRecover(r)
+							results <- result{task.id(), fmt.Errorf("panic: %v", r)}
+						}
+					}()
+					results <- result{task.id(), task.fn()}
+				}()
+			}
 		}
-		err = InfraEnsureS3(ctx, infraSet, preview)
-		if err != nil {
+		if running == 0 {
+			if firstErr != nil {
+				return firstErr
+			}
+			var pending []string
+			for _, task := range tasks {
+				if !done[task.id()] {
+					pending = append(pending, task.id())
+				}
+			}
+			err := fmt.Errorf("dependency cycle between: %s", strings.Join(pending, " "))
 			Logger.Println("error:", err)
 			return err
 		}
-		err = InfraEnsureDynamoDB(ctx, infraSet, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
+		res := <-results
+		running--
+		done[res.id] = true
+		if res.err != nil && firstErr == nil {
+			Logger.Println("error:", res.id, res.err)
+			firstErr = res.err
+		}
+	}
+	return firstErr
+}
+
+// true if an allow like "dynamodb:* arn:aws:dynamodb:*:*:table/NAME" references the named resource
+func infraAllowReferences(allow, kind, name string) bool {
+	parts := SplitWhiteSpaceN(allow, 2)
+	if len(parts) != 2 {
+		return false
+	}
+	resource := parts[1]
+	switch kind {
+	case infraKeyS3:
+		return resource == "arn:aws:s3:::"+name || strings.HasPrefix(resource, "arn:aws:s3:::"+name+"/")
+	case infraKeyDynamoDB:
+		return strings.HasSuffix(resource, ":table/"+name) || strings.Contains(resource, ":table/"+name+"/")
+	case infraKeySqs, infraKeySNS:
+		return strings.HasPrefix(resource, "arn:aws:"+kind+":") && Last(strings.Split(resource, ":")) == name
+	}
+	return false
+}
+
+func infraEnsureTasks(ctx context.Context, infraSet *InfraSet, preview, showEnvVarValues bool) []*infraTask {
+	var tasks []*infraTask
+	if len(infraSet.Keypair) != 0 {
+		tasks = append(tasks, &infraTask{kind: infraKeyKeypair, fn: func() error { return InfraEnsureKeypair(ctx, infraSet, preview) }})
+	}
+	if len(infraSet.Vpc) != 0 {
+		tasks = append(tasks, &infraTask{kind: infraKeyVpc, fn: func() error { return InfraEnsureVpc(ctx, infraSet, preview) }})
+	}
+	for name, infraProfile := range infraSet.InstanceProfile {
+		subset := &InfraSet{Name: infraSet.Name, InstanceProfile: map[string]*InfraInstanceProfile{name: infraProfile}}
+		tasks = append(tasks, &infraTask{kind: infraKeyInstanceProfile, name: name, fn: func() error { return InfraEnsureInstanceProfile(ctx, subset, preview) }})
+	}
+	for vpcName, infraVpc := range infraSet.Vpc {
+		for name, infraEC2 := range infraVpc.EC2 {
+			vpcName := vpcName
+			name := name
+			infraEC2 := infraEC2
+			task := &infraTask{kind: infraKeyVpcEC2, name: vpcName + "/" + name, deps: []string{infraKeyVpc + "/"}}
+			task.fn = func() error {
+				input, err := EC2EnsureInput(infraSet.Name, vpcName, name, infraEC2.Count, infraEC2.Attr)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+				input.initDir = infraEC2.dir
+				return EC2Ensure(ctx, input, preview)
+			}
+			for _, attr := range infraEC2.Attr {
+				k, v, err := SplitOnce(attr, "=")
+				if err != nil {
+					continue
+				}
+				if k == ec2AttrKey && len(infraSet.Keypair) != 0 {
+					task.deps = append(task.deps, infraKeyKeypair+"/")
+				}
+				if k == ec2AttrProfile {
+					task.deps = append(task.deps, infraKeyInstanceProfile+"/"+v)
+				}
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	for name, infraUser := range infraSet.User {
+		subset := &InfraSet{Name: infraSet.Name, User: map[string]*InfraUser{name: infraUser}}
+		tasks = append(tasks, &infraTask{kind: infraKeyUser, name: name, fn: func() error { return InfraEnsureUser(ctx, subset, preview) }})
+	}
+	for name, infraRole := range infraSet.Role {
+		subset := &InfraSet{Name: infraSet.Name, Role: map[string]*InfraRole{name: infraRole}}
+		tasks = append(tasks, &infraTask{kind: infraKeyRole, name: name, fn: func() error { return InfraEnsureRole(ctx, subset, preview) }})
+	}
+	for name, infraS3 := range infraSet.S3 {
+		subset := &InfraSet{Name: infraSet.Name, S3: map[string]*InfraS3{name: infraS3}}
+		tasks = append(tasks, &infraTask{kind: infraKeyS3, name: name, fn: func() error { return InfraEnsureS3(ctx, subset, preview) }})
+	}
+	for name, infraDynamoDB := range infraSet.DynamoDB {
+		subset := &InfraSet{Name: infraSet.Name, DynamoDB: map[string]*InfraDynamoDB{name: infraDynamoDB}}
+		tasks = append(tasks, &infraTask{kind: infraKeyDynamoDB, name: name, fn: func() error { return InfraEnsureDynamoDB(ctx, subset, preview) }})
+	}
+	for name, infraSQS := range infraSet.SQS {
+		name := name
+		infraSQS := infraSQS
+		task := &infraTask{kind: infraKeySqs, name: name}
+		task.fn = func() error {
+			input, err := SQSEnsureInput(infraSet.Name, name, infraSQS.Attr)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			input.dlqEnsured = infraSet.SQS[input.dlq] != nil
+			return SQSEnsure(ctx, input, preview)
+		}
+		for _, attr := range infraSQS.Attr {
+			k, v, err := SplitOnce(attr, "=")
+			if err == nil && strings.ToLower(k) == "dlq" {
+				task.deps = append(task.deps, infraKeySqs+"/"+v)
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	for name, infraSNS := range infraSet.SNS {
+		subset := &InfraSet{Name: infraSet.Name, SNS: map[string]*InfraSNS{name: infraSNS}}
+		task := &infraTask{kind: infraKeySNS, name: name, fn: func() error { return InfraEnsureSNS(ctx, subset, preview) }}
+		for _, attr := range infraSNS.Attr {
+			k, v, err := SplitOnce(attr, "=")
+			if err == nil && k == "subscribe" && strings.HasPrefix(v, snsSubscribeSQS+":") {
+				task.deps = append(task.deps, infraKeySqs+"/"+strings.TrimPrefix(v, snsSubscribeSQS+":"))
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	declared := map[string][]string{}
+	for name := range infraSet.S3 {
+		declared[infraKeyS3] = append(declared[infraKeyS3], name)
+	}
+	for name := range infraSet.DynamoDB {
+		declared[infraKeyDynamoDB] = append(declared[infraKeyDynamoDB], name)
+	}
+	for name := range infraSet.SQS {
+		declared[infraKeySqs] = append(declared[infraKeySqs], name)
+	}
+	for name := range infraSet.SNS {
+		declared[infraKeySNS] = append(declared[infraKeySNS], name)
+	}
+	var lambdaIDs []string
+	for name, infraLambda := range infraSet.Lambda {
+		subset := &InfraSet{Name: infraSet.Name, Lambda: map[string]*InfraLambda{name: infraLambda}}
+		task := &infraTask{kind: infraKeyLambda, name: name, fn: func() error { return InfraEnsureLambda(ctx, subset, "", preview, showEnvVarValues) }}
+		for _, attr := range infraLambda.Attr {
+			if strings.HasPrefix(attr, lambdaAttrVpc+"=") && len(infraSet.Vpc) != 0 {
+				task.deps = append(task.deps, infraKeyVpc+"/")
+			}
+			if strings.HasPrefix(attr, lambdaAttrOnFailure+"=") || strings.HasPrefix(attr, lambdaAttrOnSuccess+"=") {
+				_, dest, _ := SplitOnce(attr, "=")
+				service, destName, err := lambdaParseDestination(dest)
+				if err == nil && !(service == infraKeyLambda && destName == name) {
+					task.deps = append(task.deps, service+"/"+destName)
+				}
+			}
+		}
+		for _, trigger := range infraLambda.Trigger {
+			if len(trigger.Attr) == 0 {
+				continue
+			}
+			switch trigger.Type {
+			case lambdaTrigerS3:
+				task.deps = append(task.deps, infraKeyS3+"/"+trigger.Attr[0])
+			case lambdaTriggerDynamoDB:
+				task.deps = append(task.deps, infraKeyDynamoDB+"/"+trigger.Attr[0])
+			case lambdaTriggerSQS:
+				task.deps = append(task.deps, infraKeySqs+"/"+trigger.Attr[0])
+			case lambdaTriggerSNS:
+				task.deps = append(task.deps, infraKeySNS+"/"+trigger.Attr[0])
+			}
+		}
+		for _, allow := range infraLambda.Allow {
+			for kind, names := range declared {
+				for _, resourceName := range names {
+					if infraAllowReferences(allow, kind, resourceName) {
+						task.deps = append(task.deps, kind+"/"+resourceName)
+					}
+				}
+			}
 		}
-		err = InfraEnsureSQS(ctx, infraSet, preview)
+		lambdaIDs = append(lambdaIDs, task.id())
+		tasks = append(tasks, task)
+	}
+	for name, infraAlarm := range infraSet.Alarm {
+		subset := &InfraSet{Name: infraSet.Name, Alarm: map[string]*InfraAlarm{name: infraAlarm}}
+		task := &infraTask{kind: infraKeyAlarm, name: name, fn: func() error { return InfraEnsureAlarm(ctx, subset, preview) }}
+		task.deps = append(task.deps, lambdaIDs...)
+		for _, topicName := range declared[infraKeySNS] {
+			task.deps = append(task.deps, infraKeySNS+"/"+topicName)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// ensure an infra set. resources run concurrently, bounded by
+// maxConcurrency, with each lambda waiting only on the resources its
+// triggers and allows reference. preview runs serially so output is ordered.
+func InfraEnsure(ctx context.Context, infraSet *InfraSet, quick string, preview, showEnvVarValues bool, maxConcurrency int) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraEnsure"}
+		defer d.Log()
+	}
+	if quick != "" {
+		err := InfraEnsureLambda(ctx, infraSet, quick, preview, showEnvVarValues)
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
+		return nil
 	}
-	err := InfraEnsureLambda(ctx, infraSet, quick, preview, showEnvVarValues)
+	if maxConcurrency == 0 {
+		maxConcurrency = infraEnsureMaxConcurrencyDefault
+	}
+	if preview {
+		maxConcurrency = 1
+	}
+	err := infraRunTasks(infraEnsureTasks(ctx, infraSet, preview, showEnvVarValues), maxConcurrency)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
@@ -2321,39 +3213,39 @@ func infraParseValidateSQS(val interface{}) error {
 	return nil
 }
 
-func infraParseValidateInstanceProfile(val interface{}) error {
+func infraParseValidateSNS(val interface{}) error {
 	_, ok := val.(map[string]interface{})
 	if !ok {
-		err := fmt.Errorf("infraInstanceProfile should be type: map[string]interface{}, got: %#v", val)
+		err := fmt.Errorf("infraSNS should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
 		return err
 	}
-	for name, instanceProfile := range val.(map[string]interface{}) {
-		_, ok := instanceProfile.(map[string]interface{})
+	for name, topic := range val.(map[string]interface{}) {
+		_, ok := topic.(map[string]interface{})
 		if !ok {
-			err := fmt.Errorf("infraInstanceProfile should be type: map[string]interface{}, got: %s %#v", name, instanceProfile)
+			err := fmt.Errorf("infraSNS should be type: map[string]interface{}, got: %s %#v", name, topic)
 			Logger.Println("error:", err)
 			return err
 		}
-		for k, v := range instanceProfile.(map[string]interface{}) {
+		for k, v := range topic.(map[string]interface{}) {
 			switch k {
-			case infraKeyInstanceProfileAllow, infraKeyInstanceProfilePolicy:
+			case infraKeySNSAttr:
 				xs, ok := v.([]interface{})
 				if !ok {
-					err := fmt.Errorf("infraInstanceProfile key %s should be type: []string, got: %#v", k, v)
+					err := fmt.Errorf("infraSNS key %s should be type: []string, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
 				for _, x := range xs {
 					_, ok := x.(string)
 					if !ok {
-						err := fmt.Errorf("infraInstanceProfile key %s should be type: []string, got: %#v", k, v)
+						err := fmt.Errorf("infraSNS key %s should be type: []string, got: %#v", k, v)
 						Logger.Println("error:", err)
 						return err
 					}
 				}
 			default:
-				err := fmt.Errorf("unknown infraInstanceProfile key: %s: %v", k, v)
+				err := fmt.Errorf("unknown infraSNS key: %s: %v", k, v)
 				Logger.Println("error:", err)
 				return err
 			}
@@ -2362,39 +3254,39 @@ func infraParseValidateInstanceProfile(val interface{}) error {
 	return nil
 }
 
-func infraParseValidateSecurityGroup(val interface{}) error {
+func infraParseValidateAlarm(val interface{}) error {
 	_, ok := val.(map[string]interface{})
 	if !ok {
-		err := fmt.Errorf("infraSecurityGroup should be type: map[string]interface{}, got: %#v", val)
+		err := fmt.Errorf("infraAlarm should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
 		return err
 	}
-	for sgName, infraSg := range val.(map[string]interface{}) {
-		_, ok := infraSg.(map[string]interface{})
+	for name, alarm := range val.(map[string]interface{}) {
+		_, ok := alarm.(map[string]interface{})
 		if !ok {
-			err := fmt.Errorf("infraSecurityGroup should be type: map[string]interface{}, got: %s %#v", sgName, infraSg)
+			err := fmt.Errorf("infraAlarm should be type: map[string]interface{}, got: %s %#v", name, alarm)
 			Logger.Println("error:", err)
 			return err
 		}
-		for k, v := range infraSg.(map[string]interface{}) {
+		for k, v := range alarm.(map[string]interface{}) {
 			switch k {
-			case infraKeySecurityGroupRule:
+			case infraKeyAlarmAttr:
 				xs, ok := v.([]interface{})
 				if !ok {
-					err := fmt.Errorf("infraSecurityGroup key %s should be type: []string, got: %#v", k, v)
+					err := fmt.Errorf("infraAlarm key %s should be type: []string, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
 				for _, x := range xs {
 					_, ok := x.(string)
 					if !ok {
-						err := fmt.Errorf("infraSecurityGroup key %s should be type: []string, got: %#v", k, v)
+						err := fmt.Errorf("infraAlarm key %s should be type: []string, got: %#v", k, v)
 						Logger.Println("error:", err)
 						return err
 					}
 				}
 			default:
-				err := fmt.Errorf("unknown infraVpc key: %s: %v", k, v)
+				err := fmt.Errorf("unknown infraAlarm key: %s: %v", k, v)
 				Logger.Println("error:", err)
 				return err
 			}
@@ -2403,34 +3295,39 @@ func infraParseValidateSecurityGroup(val interface{}) error {
 	return nil
 }
 
-func infraParseValidateVpc(val interface{}) error {
+func infraParseValidateInstanceProfile(val interface{}) error {
 	_, ok := val.(map[string]interface{})
 	if !ok {
-		err := fmt.Errorf("infraVpc should be type: map[string]interface{}, got: %#v", val)
+		err := fmt.Errorf("infraInstanceProfile should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
 		return err
 	}
-	for name, vpc := range val.(map[string]interface{}) {
-		_, ok := vpc.(map[string]interface{})
+	for name, instanceProfile := range val.(map[string]interface{}) {
+		_, ok := instanceProfile.(map[string]interface{})
 		if !ok {
-			err := fmt.Errorf("infraVpc should be type: map[string]interface{}, got: %s %#v", name, vpc)
+			err := fmt.Errorf("infraInstanceProfile should be type: map[string]interface{}, got: %s %#v", name, instanceProfile)
 			Logger.Println("error:", err)
 			return err
 		}
-		for k, v := range vpc.(map[string]interface{}) {
+		for k, v := range instanceProfile.(map[string]interface{}) {
 			switch k {
-			case infraKeyVpcEC2:
-				err := fmt.Errorf("infraVpc will list, but cannot declare ec2 instances. instead manage ec2 with a lambda in the infraset: %#v", v)
-				Logger.Println("error:", err)
-				return err
-			case infraKeyVpcSecurityGroup:
-				err := infraParseValidateSecurityGroup(v)
-				if err != nil {
+			case infraKeyInstanceProfileAllow, infraKeyInstanceProfilePolicy:
+				xs, ok := v.([]interface{})
+				if !ok {
+					err := fmt.Errorf("infraInstanceProfile key %s should be type: []string, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
+				for _, x := range xs {
+					_, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraInstanceProfile key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
+				}
 			default:
-				err := fmt.Errorf("unknown infraVpc key: %s: %v", k, v)
+				err := fmt.Errorf("unknown infraInstanceProfile key: %s: %v", k, v)
 				Logger.Println("error:", err)
 				return err
 			}
@@ -2439,68 +3336,93 @@ func infraParseValidateVpc(val interface{}) error {
 	return nil
 }
 
-func infraParseValidateLambda(val interface{}) error {
+func infraParseValidateUser(val interface{}) error {
 	_, ok := val.(map[string]interface{})
 	if !ok {
-		err := fmt.Errorf("infraLambda should be type: map[string]interface{}, got: %#v", val)
+		err := fmt.Errorf("infraUser should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
 		return err
 	}
-	for name, lambda := range val.(map[string]interface{}) {
-		_, ok := lambda.(map[string]interface{})
+	for name, user := range val.(map[string]interface{}) {
+		_, ok := user.(map[string]interface{})
 		if !ok {
-			err := fmt.Errorf("infraLambda should be type: map[string]interface{}, got: %s %#v", name, lambda)
+			err := fmt.Errorf("infraUser should be type: map[string]interface{}, got: %s %#v", name, user)
 			Logger.Println("error:", err)
 			return err
 		}
-		for k, v := range lambda.(map[string]interface{}) {
+		for k, v := range user.(map[string]interface{}) {
 			switch k {
-			case infraKeyLambdaName:
-				_, ok := v.(string)
-				if !ok {
-					err := fmt.Errorf("infraLambda key %s should be type: string, got: %#v", k, v)
-					Logger.Println("error:", err)
-					return err
-				}
-			case infraKeyLambdaEntrypoint:
-				x, ok := v.(string)
+			case infraKeyUserAllow, infraKeyUserPolicy:
+				xs, ok := v.([]interface{})
 				if !ok {
-					err := fmt.Errorf("infraLambda key %s should be type: string, got: %#v", k, v)
+					err := fmt.Errorf("infraUser key %s should be type: []string, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
-				switch {
-				case strings.HasSuffix(x, ".go"):
-				case strings.HasSuffix(x, ".py"):
-				case strings.Contains(x, ".dkr.ecr."):
-				default:
-					err := fmt.Errorf("infraLambda key %s should be *.py, *.go, or ecr container uri, got: %#v", k, v)
-					Logger.Println("error:", err)
-					return err
+				for _, x := range xs {
+					_, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraUser key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
 				}
-			case infraKeyLambdaTrigger:
-				err := infraParseValidateTrigger(v)
-				if err != nil {
+			default:
+				err := fmt.Errorf("unknown infraUser key: %s: %v", k, v)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func infraParseValidateRole(val interface{}) error {
+	_, ok := val.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("infraRole should be type: map[string]interface{}, got: %#v", val)
+		Logger.Println("error:", err)
+		return err
+	}
+	for name, role := range val.(map[string]interface{}) {
+		_, ok := role.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("infraRole should be type: map[string]interface{}, got: %s %#v", name, role)
+			Logger.Println("error:", err)
+			return err
+		}
+		_, ok = role.(map[string]interface{})[infraKeyRolePrincipal]
+		if !ok {
+			err := fmt.Errorf("infraRole missing key %s: %s", infraKeyRolePrincipal, name)
+			Logger.Println("error:", err)
+			return err
+		}
+		for k, v := range role.(map[string]interface{}) {
+			switch k {
+			case infraKeyRolePrincipal:
+				principal, ok := v.(string)
+				if !ok || principal == "" {
+					err := fmt.Errorf("infraRole key %s should be type: string, quote account ids, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
-			case infraKeyLambdaPolicy, infraKeyLambdaAllow, infraKeyLambdaInclude, infraKeyLambdaRequire, infraKeyLambdaEnv, infraKeyLambdaAttr:
+			case infraKeyRoleAllow, infraKeyRolePolicy:
 				xs, ok := v.([]interface{})
 				if !ok {
-					err := fmt.Errorf("infraLambda key %s should be type: []string, got: %#v", k, v)
+					err := fmt.Errorf("infraRole key %s should be type: []string, got: %#v", k, v)
 					Logger.Println("error:", err)
 					return err
 				}
 				for _, x := range xs {
 					_, ok := x.(string)
 					if !ok {
-						err := fmt.Errorf("infraLambda key %s should be type: []string, got: %#v", k, v)
+						err := fmt.Errorf("infraRole key %s should be type: []string, got: %#v", k, v)
 						Logger.Println("error:", err)
 						return err
 					}
 				}
 			default:
-				err := fmt.Errorf("unknown infraLambda key: %s: %v", k, v)
+				err := fmt.Errorf("unknown infraRole key: %s: %v", k, v)
 				Logger.Println("error:", err)
 				return err
 			}
@@ -2509,34 +3431,267 @@ func infraParseValidateLambda(val interface{}) error {
 	return nil
 }
 
-func InfraParse(yamlPath string) (*InfraSet, error) {
-	data, err := os.ReadFile(yamlPath)
-	if err != nil {
+func infraParseValidateSecurityGroup(val interface{}) error {
+	_, ok := val.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("infraSecurityGroup should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
-		return nil, err
+		return err
 	}
-	resolved, err := resolveEnvVars(string(data), []string{lambdaEnvVarApiID, lambdaEnvVarWebsocketID})
-	if err != nil {
-		Logger.Println("error:", err)
-		return nil, err
+	for sgName, infraSg := range val.(map[string]interface{}) {
+		_, ok := infraSg.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("infraSecurityGroup should be type: map[string]interface{}, got: %s %#v", sgName, infraSg)
+			Logger.Println("error:", err)
+			return err
+		}
+		for k, v := range infraSg.(map[string]interface{}) {
+			switch k {
+			case infraKeySecurityGroupRule:
+				xs, ok := v.([]interface{})
+				if !ok {
+					err := fmt.Errorf("infraSecurityGroup key %s should be type: []string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				for _, x := range xs {
+					_, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraSecurityGroup key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
+				}
+			default:
+				err := fmt.Errorf("unknown infraVpc key: %s: %v", k, v)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
 	}
-	data = []byte(resolved)
-	val := make(map[string]interface{})
-	err = yaml.Unmarshal(data, &val)
-	if err != nil {
+	return nil
+}
+
+func infraParseValidateEC2(vpcName string, val interface{}) error {
+	_, ok := val.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("infraEC2 should be type: map[string]interface{}, got: %#v", val)
 		Logger.Println("error:", err)
-		return nil, err
+		return err
 	}
-	for k, v := range val {
-		switch k {
-		case infraKeyName:
-			if v == "" {
-				err := fmt.Errorf("infraSet name cannot be empty")
+	for name, infraEC2 := range val.(map[string]interface{}) {
+		_, ok := infraEC2.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("infraEC2 should be type: map[string]interface{}, got: %s %#v", name, infraEC2)
+			Logger.Println("error:", err)
+			return err
+		}
+		count := 1
+		var attrs []string
+		for k, v := range infraEC2.(map[string]interface{}) {
+			switch k {
+			case infraKeyEC2Count:
+				n, ok := v.(int)
+				if !ok {
+					err := fmt.Errorf("infraEC2 key %s should be type: int, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				count = n
+			case infraKeyEC2Attr:
+				xs, ok := v.([]interface{})
+				if !ok {
+					err := fmt.Errorf("infraEC2 key %s should be type: []string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				for _, x := range xs {
+					attr, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraEC2 key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
+					attrs = append(attrs, attr)
+				}
+			default:
+				err := fmt.Errorf("unknown infraEC2 key: %s: %v", k, v)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		_, err := EC2EnsureInput("", vpcName, name, count, attrs)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		// count defaults to 1, which must be set before decoding since a decoded zero is ambiguous
+		infraEC2.(map[string]interface{})[infraKeyEC2Count] = count
+	}
+	return nil
+}
+
+func infraParseValidateVpc(val interface{}) error {
+	_, ok := val.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("infraVpc should be type: map[string]interface{}, got: %#v", val)
+		Logger.Println("error:", err)
+		return err
+	}
+	for name, vpc := range val.(map[string]interface{}) {
+		_, ok := vpc.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("infraVpc should be type: map[string]interface{}, got: %s %#v", name, vpc)
+			Logger.Println("error:", err)
+			return err
+		}
+		for k, v := range vpc.(map[string]interface{}) {
+			switch k {
+			case infraKeyVpcEC2:
+				err := infraParseValidateEC2(name, v)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			case infraKeyVpcAttr:
+				xs, ok := v.([]interface{})
+				if !ok {
+					err := fmt.Errorf("infraVpc key %s should be type: []string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				var attrs []string
+				for _, x := range xs {
+					attr, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraVpc key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
+					attrs = append(attrs, attr)
+				}
+				_, err := VpcEnsureInput("", name, attrs)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			case infraKeyVpcSecurityGroup:
+				err := infraParseValidateSecurityGroup(v)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			default:
+				err := fmt.Errorf("unknown infraVpc key: %s: %v", k, v)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func infraParseValidateLambda(dir string, val interface{}) error {
+	_, ok := val.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("infraLambda should be type: map[string]interface{}, got: %#v", val)
+		Logger.Println("error:", err)
+		return err
+	}
+	for name, lambda := range val.(map[string]interface{}) {
+		_, ok := lambda.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("infraLambda should be type: map[string]interface{}, got: %s %#v", name, lambda)
+			Logger.Println("error:", err)
+			return err
+		}
+		for k, v := range lambda.(map[string]interface{}) {
+			switch k {
+			case infraKeyLambdaName:
+				_, ok := v.(string)
+				if !ok {
+					err := fmt.Errorf("infraLambda key %s should be type: string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+			case infraKeyLambdaEntrypoint:
+				x, ok := v.(string)
+				if !ok {
+					err := fmt.Errorf("infraLambda key %s should be type: string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				switch {
+				case strings.HasSuffix(x, ".go"):
+				case strings.HasSuffix(x, ".py"):
+				case strings.HasSuffix(x, ".js"), strings.HasSuffix(x, ".mjs"), strings.HasSuffix(x, ".ts"):
+				case strings.Contains(x, ".dkr.ecr."):
+				case lambdaIsDockerfile(x):
+				case Exists(path.Join(dir, x, "Dockerfile")): // a directory containing a dockerfile
+				default:
+					err := fmt.Errorf("infraLambda key %s should be *.py, *.js, *.mjs, *.ts, *.go, Dockerfile, *.Dockerfile, a directory with a Dockerfile, or ecr container uri, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+			case infraKeyLambdaTrigger:
+				err := infraParseValidateTrigger(v)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			case infraKeyLambdaPolicy, infraKeyLambdaAllow, infraKeyLambdaInclude, infraKeyLambdaRequire, infraKeyLambdaEnv, infraKeyLambdaAttr:
+				xs, ok := v.([]interface{})
+				if !ok {
+					err := fmt.Errorf("infraLambda key %s should be type: []string, got: %#v", k, v)
+					Logger.Println("error:", err)
+					return err
+				}
+				for _, x := range xs {
+					_, ok := x.(string)
+					if !ok {
+						err := fmt.Errorf("infraLambda key %s should be type: []string, got: %#v", k, v)
+						Logger.Println("error:", err)
+						return err
+					}
+				}
+			default:
+				err := fmt.Errorf("unknown infraLambda key: %s: %v", k, v)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func InfraParse(yamlPath string) (*InfraSet, error) {
+	data, err := os.ReadFile(yamlPath)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	resolved, err := resolveEnvVars(string(data), []string{lambdaEnvVarApiID, lambdaEnvVarWebsocketID})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	data = []byte(resolved)
+	val := make(map[string]interface{})
+	err = yaml.Unmarshal(data, &val)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	for k, v := range val {
+		switch k {
+		case infraKeyName:
+			if v == "" {
+				err := fmt.Errorf("infraSet name cannot be empty")
 				Logger.Println("error:", err)
 				return nil, err
 			}
 		case infraKeyLambda:
-			err := infraParseValidateLambda(v)
+			err := infraParseValidateLambda(path.Dir(yamlPath), v)
 			if err != nil {
 				Logger.Println("error:", err)
 				return nil, err
@@ -2559,6 +3714,12 @@ func InfraParse(yamlPath string) (*InfraSet, error) {
 				Logger.Println("error:", err)
 				return nil, err
 			}
+		case infraKeySNS:
+			err := infraParseValidateSNS(v)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
 		case infraKeyVpc:
 			err := infraParseValidateVpc(v)
 			if err != nil {
@@ -2577,6 +3738,24 @@ func InfraParse(yamlPath string) (*InfraSet, error) {
 				Logger.Println("error:", err)
 				return nil, err
 			}
+		case infraKeyAlarm:
+			err := infraParseValidateAlarm(v)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		case infraKeyUser:
+			err := infraParseValidateUser(v)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		case infraKeyRole:
+			err := infraParseValidateRole(v)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
 		default:
 			err := fmt.Errorf("unknown infra key: %s: %v", k, v)
 			Logger.Println("error:", err)
@@ -2599,7 +3778,31 @@ func InfraParse(yamlPath string) (*InfraSet, error) {
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	for _, infraLambda := range infraSet.Lambda {
+	for vpcName, infraVpc := range infraSet.Vpc {
+		for name, infraEC2 := range infraVpc.EC2 {
+			infraEC2.dir = path.Dir(yamlPath)
+			for _, attr := range infraEC2.Attr {
+				k, v, err := SplitOnce(attr, "=")
+				if err != nil || k != ec2AttrInit {
+					continue
+				}
+				if !path.IsAbs(v) {
+					v = path.Join(path.Dir(yamlPath), v)
+				}
+				if !Exists(v) {
+					err := fmt.Errorf("ec2 %s in vpc %s has init file which does not exist: %s", name, vpcName, v)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+			}
+		}
+	}
+	type infraS3Trigger struct {
+		lambdaName string
+		trigger    *lambdaTriggerS3
+	}
+	var s3Triggers []infraS3Trigger
+	for lambdaName, infraLambda := range infraSet.Lambda {
 		infraLambda.infraSetName = infraSet.Name
 		infraLambda.dir = path.Dir(yamlPath)
 		if infraLambda.Entrypoint == "" {
@@ -2610,31 +3813,136 @@ func InfraParse(yamlPath string) (*InfraSet, error) {
 		if !strings.Contains(infraLambda.Entrypoint, ".dkr.ecr.") {
 			infraLambda.Entrypoint = path.Join(infraLambda.dir, infraLambda.Entrypoint)
 		}
+		vpcName := ""
+		var sgNames []string
+		alias := ""
+		canary := 0
 		for _, attr := range infraLambda.Attr {
 			k, v, err := SplitOnce(attr, "=")
 			if err != nil {
 				Logger.Println("error:", err)
 				return nil, err
 			}
-			validAttrs := []string{lambdaAttrConcurrency, lambdaAttrMemory, lambdaAttrTimeout, lambdaAttrLogsTTLDays}
+			validAttrs := []string{lambdaAttrConcurrency, lambdaAttrMemory, lambdaAttrTimeout, lambdaAttrLogsTTLDays, lambdaAttrArch, lambdaAttrVpc, lambdaAttrSg, lambdaAttrAlias, lambdaAttrCanary, lambdaAttrBake, lambdaAttrRetry, lambdaAttrMaxEventAge, lambdaAttrOnFailure, lambdaAttrOnSuccess}
 			if !Contains(validAttrs, k) {
 				err := fmt.Errorf("unknown attr: %s", k)
 				Logger.Println("error:", err)
 				return nil, err
 			}
+			if k == lambdaAttrArch {
+				if !Contains([]string{lambdaArchAmd64, lambdaArchArm64}, v) {
+					err := fmt.Errorf("arch should be %s or %s: %s", lambdaArchAmd64, lambdaArchArm64, v)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				continue
+			}
+			if k == lambdaAttrVpc {
+				vpcName = v
+				continue
+			}
+			if k == lambdaAttrSg {
+				sgNames = append(sgNames, v)
+				continue
+			}
+			if k == lambdaAttrAlias {
+				if IsDigit(v) || v == "$LATEST" {
+					err := fmt.Errorf("alias cannot be a version: %s", v)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				alias = v
+				continue
+			}
+			if k == lambdaAttrOnFailure || k == lambdaAttrOnSuccess {
+				_, _, err := lambdaParseDestination(v)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				continue
+			}
 			if !IsDigit(v) {
 				err := fmt.Errorf("conf value should be digits: %s %s", k, v)
 				Logger.Println("error:", err)
 				return nil, err
 			}
+			if k == lambdaAttrRetry && Atoi(v) > 2 {
+				err := fmt.Errorf("retry should be from 0 to 2: %s", v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			if k == lambdaAttrMaxEventAge && (Atoi(v) < 60 || Atoi(v) > 21600) {
+				err := fmt.Errorf("max-event-age should be seconds from 60 to 21600: %s", v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			if k == lambdaAttrCanary {
+				canary = Atoi(v)
+				if canary > 99 {
+					err := fmt.Errorf("canary should be a percent from 0 to 99: %s", v)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+			}
+		}
+		if canary > 0 && alias == "" {
+			err := fmt.Errorf("lambda %s has attr %s but no attr %s", lambdaName, lambdaAttrCanary, lambdaAttrAlias)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		if len(sgNames) > 0 && vpcName == "" {
+			err := fmt.Errorf("lambda %s has attr %s but no attr %s", lambdaName, lambdaAttrSg, lambdaAttrVpc)
+			Logger.Println("error:", err)
+			return nil, err
 		}
+		if infraVpc, ok := infraSet.Vpc[vpcName]; ok {
+			for _, sgName := range sgNames {
+				if _, ok := infraVpc.SecurityGroup[sgName]; !ok && sgName != "default" && !strings.HasPrefix(sgName, "sg-") {
+					err := fmt.Errorf("lambda %s has attr %s=%s but vpc %s has no such security-group", lambdaName, lambdaAttrSg, sgName, vpcName)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+			}
+		}
+		urlTriggers := 0
 		for _, trigger := range infraLambda.Trigger {
-			validTriggers := []string{lambdaTriggerSQS, lambdaTrigerS3, lambdaTriggerDynamoDB, lambdaTriggerApi, lambdaTriggerEcr, lambdaTriggerSchedule, lambdaTriggerWebsocket}
+			validTriggers := []string{lambdaTriggerSQS, lambdaTrigerS3, lambdaTriggerDynamoDB, lambdaTriggerApi, lambdaTriggerEcr, lambdaTriggerSchedule, lambdaTriggerWebsocket, lambdaTriggerSNS, lambdaTriggerUrl}
 			if !Contains(validTriggers, trigger.Type) {
 				err := fmt.Errorf("unknown trigger: %#v", trigger)
 				Logger.Println("error:", err)
 				return nil, err
 			}
+			if trigger.Type == lambdaTrigerS3 {
+				s3Trigger, err := lambdaParseTriggerS3(trigger.Attr)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				for _, other := range s3Triggers {
+					if s3Trigger.Overlaps(other.trigger) {
+						err := fmt.Errorf("s3 triggers overlap on bucket %s: %s (%s) and %s (%s)", s3Trigger.bucket, lambdaName, s3Trigger, other.lambdaName, other.trigger)
+						Logger.Println("error:", err)
+						return nil, err
+					}
+				}
+				trigger.Attr = s3Trigger.Attrs() // canonical order so infra-diff matches infra-ls
+				s3Triggers = append(s3Triggers, infraS3Trigger{lambdaName, s3Trigger})
+			}
+			if trigger.Type == lambdaTriggerUrl {
+				urlTriggers++
+				if urlTriggers > 1 {
+					err := fmt.Errorf("lambda %s has more than one url trigger", lambdaName)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				urlTrigger, err := lambdaParseTriggerUrlConf(trigger.Attr)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				trigger.Attr = urlTrigger.Attrs()
+			}
 		}
 	}
 	return infraSet, nil
@@ -2645,29 +3953,23 @@ func InfraDelete(ctx context.Context, infraSet *InfraSet, preview bool) error {
 		d := &Debug{start: time.Now(), name: "InfraDelete"}
 		defer d.Log()
 	}
-	for vpcName := range infraSet.Vpc {
-		err := VpcRm(ctx, vpcName, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
-	}
-	for profileName := range infraSet.InstanceProfile {
-		err := IamDeleteInstanceProfile(ctx, profileName, preview)
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
-	}
-	for keypairName := range infraSet.Keypair {
-		err := EC2DeleteKeypair(ctx, keypairName, preview)
+	for alarmName := range infraSet.Alarm {
+		err := CloudwatchDeleteAlarm(ctx, alarmName, preview)
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
 	}
+	// lambdas go before their vpc, whose subnets and security groups they use
+	var lambdaVpcNames []string
 	for lambdaName, infraLambda := range infraSet.Lambda {
 		infraLambda.Name = lambdaName
+		for _, attr := range infraLambda.Attr {
+			k, v, err := SplitOnce(attr, "=")
+			if err == nil && k == lambdaAttrVpc {
+				lambdaVpcNames = append(lambdaVpcNames, v)
+			}
+		}
 		infraLambda.Arn, _ = LambdaArn(ctx, lambdaName)
 		infraLambda.Trigger = nil
 		_, err := LambdaEnsureTriggerApi(ctx, infraLambda, preview)
@@ -2701,6 +4003,11 @@ func InfraDelete(ctx context.Context, infraSet *InfraSet, preview bool) error {
 				Logger.Println("error:", err)
 				return err
 			}
+			_, err = LambdaEnsureTriggerSNS(ctx, infraLambda, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
 		}
 		err = IamDeleteRole(ctx, lambdaName, preview)
 		if err != nil {
@@ -2718,6 +4025,62 @@ func InfraDelete(ctx context.Context, infraSet *InfraSet, preview bool) error {
 			return err
 		}
 	}
+	for vpcName, infraVpc := range infraSet.Vpc {
+		for name, infraEC2 := range infraVpc.EC2 {
+			input, err := EC2EnsureInput(infraSet.Name, vpcName, name, 0, infraEC2.Attr)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			err = EC2Ensure(ctx, input, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	for vpcName := range infraSet.Vpc {
+		if Contains(lambdaVpcNames, vpcName) {
+			err := vpcWaitLambdaEnis(ctx, vpcName, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		err := VpcRm(ctx, vpcName, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	for profileName := range infraSet.InstanceProfile {
+		err := IamDeleteInstanceProfile(ctx, profileName, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	for username := range infraSet.User {
+		err := IamDeleteUser(ctx, username, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	for roleName := range infraSet.Role {
+		err := IamDeleteRole(ctx, roleName, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	for keypairName := range infraSet.Keypair {
+		err := EC2DeleteKeypair(ctx, keypairName, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
 	for bucketName := range infraSet.S3 {
 		err := S3DeleteBucket(ctx, bucketName, preview)
 		if err != nil {
@@ -2739,5 +4102,393 @@ func InfraDelete(ctx context.Context, infraSet *InfraSet, preview bool) error {
 			return err
 		}
 	}
+	for topicName := range infraSet.SNS {
+		err := SNSDeleteTopic(ctx, topicName, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
 	return nil
 }
+
+const (
+	InfraDiffActionAdd    = "add"
+	InfraDiffActionChange = "change"
+	InfraDiffActionRemove = "remove"
+)
+
+type InfraDiffChange struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	Field  string   `json:"field,omitempty"`
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+func (c *InfraDiffChange) String() string {
+	if c.Action != InfraDiffActionChange {
+		return fmt.Sprintf("%s: %s %s", c.Action, c.Kind, c.Name)
+	}
+	var lines []string
+	for _, x := range c.Add {
+		lines = append(lines, fmt.Sprintf("%s: %s %s %s: +%s", c.Action, c.Kind, c.Name, c.Field, x))
+	}
+	for _, x := range c.Remove {
+		lines = append(lines, fmt.Sprintf("%s: %s %s %s: -%s", c.Action, c.Kind, c.Name, c.Field, x))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type InfraDiffOutput struct {
+	Account  string             `json:"account"`
+	Region   string             `json:"region"`
+	InfraSet string             `json:"infraset"`
+	Changes  []*InfraDiffChange `json:"changes"`
+}
+
+// fields that infra list cannot see, like lambda source code, are not compared
+var infraDiffIgnore = map[string][]string{
+	infraKeyLambda: {"arn", "entrypoint", "require", "include"},
+	infraKeyVpc:    {"ec2"},
+}
+
+// parse attrs with the ensure input of their kind and render them canonically, so
+// that aliases like timeout= and VisibilityTimeout=, and defaults whether spelled
+// out or omitted, compare equal. both the yaml and infra list attrs pass through
+// the same parse and render, so each kind renders its attrs the way infra list
+// reports them. attrs that fail to parse are compared as is.
+func infraDiffNormalizeAttrs(kind, name string, attrs []string) []string {
+	var normalized []string
+	var err error
+	switch kind {
+	case infraKeyLambda:
+		normalized, err = lambdaCanonicalAttrs(attrs)
+	case infraKeyDynamoDB:
+		var input *dynamodb.CreateTableInput
+		input, err = DynamoDBEnsureInput("", name, nil, attrs)
+		if err == nil {
+			normalized = dynamoDBCanonicalAttrs(input)
+		}
+	case infraKeyAlarm:
+		var input *cloudwatchEnsureAlarmInput
+		input, err = CloudwatchEnsureAlarmInput("", name, attrs)
+		if err == nil {
+			normalized = input.canonicalAttrs()
+		}
+	case infraKeyS3:
+		var input *s3EnsureInput
+		input, err = S3EnsureInput("", name, attrs)
+		if err == nil {
+			normalized = input.canonicalAttrs()
+		}
+	case infraKeySNS:
+		var input *snsEnsureInput
+		input, err = SNSEnsureInput("", name, attrs)
+		if err == nil {
+			normalized = input.canonicalAttrs()
+		}
+	case infraKeySqs:
+		var input *sqsEnsureInput
+		input, err = SQSEnsureInput("", name, attrs)
+		if err == nil {
+			normalized = input.canonicalAttrs()
+		}
+	case infraKeyVpc:
+		var input *vpcEnsureInput
+		input, err = VpcEnsureInput("", name, attrs)
+		if err == nil {
+			normalized = input.Attrs()
+		}
+	default:
+		return attrs
+	}
+	if err != nil {
+		return attrs
+	}
+	return normalized
+}
+
+// the attr field of each kind normalized by infraDiffNormalizeAttrs
+var infraDiffAttrKeys = map[string]string{
+	infraKeyLambda:   infraKeyLambdaAttr,
+	infraKeyDynamoDB: infraKeyDynamoDBAttr,
+	infraKeyAlarm:    infraKeyAlarmAttr,
+	infraKeyS3:       infraKeyS3Attr,
+	infraKeySNS:      infraKeySNSAttr,
+	infraKeySqs:      infraKeySQSAttr,
+	infraKeyVpc:      infraKeyVpcAttr,
+}
+
+// normalize the attrs of every resource in a map from infraDiffToMap
+func infraDiffNormalize(val map[string]interface{}) {
+	for kind, attrKey := range infraDiffAttrKeys {
+		resources, ok := val[kind].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, resource := range resources {
+			resource, ok := resource.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var attrs []string
+			if values, ok := resource[attrKey].([]interface{}); ok {
+				for _, value := range values {
+					attrs = append(attrs, fmt.Sprint(value))
+				}
+			}
+			var normalized []interface{}
+			for _, attr := range infraDiffNormalizeAttrs(kind, name, attrs) {
+				normalized = append(normalized, attr)
+			}
+			if len(normalized) == 0 {
+				delete(resource, attrKey)
+			} else {
+				resource[attrKey] = normalized
+			}
+		}
+	}
+}
+
+func infraDiffToMap(infraSet *InfraSet) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(infraSet)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	val := make(map[string]interface{})
+	err = yaml.Unmarshal(data, &val)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	delete(val, infraKeyName)
+	infraDiffNormalize(val)
+	return val, nil
+}
+
+// flatten a resource into field => values, where nested maps become dotted
+// fields and list items or scalars become single line values
+func infraDiffFlatten(prefix string, val interface{}, res map[string][]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, x := range v {
+			field := k
+			if prefix != "" {
+				field = prefix + "." + k
+			}
+			infraDiffFlatten(field, x, res)
+		}
+	case []interface{}:
+		for _, x := range v {
+			res[prefix] = append(res[prefix], infraDiffValue(x))
+		}
+	default:
+		res[prefix] = append(res[prefix], infraDiffValue(v))
+	}
+}
+
+func infraDiffValue(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(data)
+}
+
+func infraDiffResource(kind, name string, want, have interface{}) []*InfraDiffChange {
+	wantFields := map[string][]string{}
+	haveFields := map[string][]string{}
+	infraDiffFlatten("", want, wantFields)
+	infraDiffFlatten("", have, haveFields)
+	for _, field := range infraDiffIgnore[kind] {
+		delete(wantFields, field)
+		delete(haveFields, field)
+	}
+	var fields []string
+	for field := range wantFields {
+		fields = append(fields, field)
+	}
+	for field := range haveFields {
+		if _, ok := wantFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	var changes []*InfraDiffChange
+	for _, field := range fields {
+		change := &InfraDiffChange{
+			Action: InfraDiffActionChange,
+			Kind:   kind,
+			Name:   name,
+			Field:  field,
+		}
+		for _, x := range wantFields[field] {
+			if !Contains(haveFields[field], x) {
+				change.Add = append(change.Add, x)
+			}
+		}
+		for _, x := range haveFields[field] {
+			if !Contains(wantFields[field], x) {
+				change.Remove = append(change.Remove, x)
+			}
+		}
+		if len(change.Add) != 0 || len(change.Remove) != 0 {
+			sort.Strings(change.Add)
+			sort.Strings(change.Remove)
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// lambdas and instance profiles each own a role of the same name, which infra
+// list reports as a role of the infra set. those roles are ensured and removed
+// with their owner, so drop them from the live roles unless the yaml declares them.
+func infraDiffDropOwnedRoles(want, have map[string]interface{}) {
+	haveRoles, ok := have[infraKeyRole].(map[string]interface{})
+	if !ok {
+		return
+	}
+	wantRoles, _ := want[infraKeyRole].(map[string]interface{})
+	for _, val := range []map[string]interface{}{want, have} {
+		for _, kind := range []string{infraKeyLambda, infraKeyInstanceProfile} {
+			resources, _ := val[kind].(map[string]interface{})
+			for name := range resources {
+				if _, ok := wantRoles[name]; !ok {
+					delete(haveRoles, name)
+				}
+			}
+		}
+	}
+	if len(haveRoles) == 0 {
+		delete(have, infraKeyRole)
+	}
+}
+
+// compare a parsed infra set against the live account. changes describe
+// what infra-ensure would need to do: add or change a resource to match
+// the yaml, or remove a resource that is tagged with the infra set but not
+// declared. env var values are compared by hash.
+func InfraDiff(ctx context.Context, infraSet *InfraSet) (*InfraDiffOutput, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "InfraDiff"}
+		defer d.Log()
+	}
+	infra, err := InfraList(ctx, infraSet.Name, false)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	live := infra.InfraSet[infraSet.Name]
+	if live == nil {
+		live = &InfraSet{}
+	}
+	live.Name = infraSet.Name
+	// the function url is read only, infra-ls shows it but infra.yaml cannot set it
+	for _, infraLambda := range live.Lambda {
+		for _, trigger := range infraLambda.Trigger {
+			if trigger.Type != lambdaTriggerUrl {
+				continue
+			}
+			var attrs []string
+			for _, attr := range trigger.Attr {
+				if !strings.HasPrefix(attr, lambdaTriggerUrlAttrUrl+"=") {
+					attrs = append(attrs, attr)
+				}
+			}
+			trigger.Attr = attrs
+		}
+	}
+	// resolve vpc and sg ids to the names infra list reports, without touching infraSet
+	wantSet := *infraSet
+	wantSet.Lambda = map[string]*InfraLambda{}
+	for name, infraLambda := range infraSet.Lambda {
+		resolved := *infraLambda
+		resolved.Attr, err = lambdaResolveVpcAttrs(ctx, infraLambda.Attr)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		wantSet.Lambda[name] = &resolved
+	}
+	want, err := infraDiffToMap(&wantSet)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	have, err := infraDiffToMap(live)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	infraDiffDropOwnedRoles(want, have)
+	if lambdas, ok := want[infraKeyLambda].(map[string]interface{}); ok {
+		for _, infraLambda := range lambdas {
+			infraLambda, ok := infraLambda.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			envs, ok := infraLambda[infraKeyLambdaEnv].([]interface{})
+			if !ok {
+				continue
+			}
+			for i, env := range envs {
+				k, v, err := SplitOnce(fmt.Sprint(env), "=")
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				envs[i] = k + "=" + sha256Short([]byte(v))
+			}
+		}
+	}
+	output := &InfraDiffOutput{
+		Account:  infra.Account,
+		Region:   infra.Region,
+		InfraSet: infraSet.Name,
+		Changes:  []*InfraDiffChange{},
+	}
+	var kinds []string
+	for kind := range want {
+		kinds = append(kinds, kind)
+	}
+	for kind := range have {
+		if _, ok := want[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		wantResources, _ := want[kind].(map[string]interface{})
+		haveResources, _ := have[kind].(map[string]interface{})
+		var names []string
+		for name := range wantResources {
+			names = append(names, name)
+		}
+		for name := range haveResources {
+			if _, ok := wantResources[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			wantResource, wantOk := wantResources[name]
+			haveResource, haveOk := haveResources[name]
+			switch {
+			case wantOk && !haveOk:
+				output.Changes = append(output.Changes, &InfraDiffChange{Action: InfraDiffActionAdd, Kind: kind, Name: name})
+			case !wantOk && haveOk:
+				output.Changes = append(output.Changes, &InfraDiffChange{Action: InfraDiffActionRemove, Kind: kind, Name: name})
+			default:
+				output.Changes = append(output.Changes, infraDiffResource(kind, name, wantResource, haveResource)...)
+			}
+		}
+	}
+	return output, nil
+}
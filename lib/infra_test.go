@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestInfraDiffResource(t *testing.T) {
+	type test struct {
+		kind    string
+		want    interface{}
+		have    interface{}
+		changes []*InfraDiffChange
+	}
+	tests := []test{
+		{
+			infraKeyS3,
+			map[string]interface{}{"attr": []interface{}{"versioning=true"}},
+			map[string]interface{}{"attr": []interface{}{"versioning=true"}},
+			nil,
+		},
+		{
+			infraKeyS3,
+			map[string]interface{}{"attr": []interface{}{"versioning=true", "ttldays=7"}},
+			map[string]interface{}{"attr": []interface{}{"versioning=true", "acl=public"}},
+			[]*InfraDiffChange{{Action: InfraDiffActionChange, Kind: infraKeyS3, Name: "name", Field: "attr", Add: []string{"ttldays=7"}, Remove: []string{"acl=public"}}},
+		},
+		{
+			infraKeyLambda,
+			map[string]interface{}{"entrypoint": "main.go", "attr": []interface{}{"timeout=60"}},
+			map[string]interface{}{"arn": "arn:aws:lambda:us-west-2:123456789012:function:name"},
+			[]*InfraDiffChange{{Action: InfraDiffActionChange, Kind: infraKeyLambda, Name: "name", Field: "attr", Add: []string{"timeout=60"}}},
+		},
+		{
+			infraKeyVpc,
+			map[string]interface{}{"security-group": map[string]interface{}{"web": map[string]interface{}{"rule": []interface{}{"tcp:443:0.0.0.0/0"}}}},
+			map[string]interface{}{"security-group": map[string]interface{}{"web": map[string]interface{}{"rule": []interface{}{"tcp:80:0.0.0.0/0"}}}},
+			[]*InfraDiffChange{{Action: InfraDiffActionChange, Kind: infraKeyVpc, Name: "name", Field: "security-group.web.rule", Add: []string{"tcp:443:0.0.0.0/0"}, Remove: []string{"tcp:80:0.0.0.0/0"}}},
+		},
+	}
+	for _, test := range tests {
+		changes := infraDiffResource(test.kind, "name", test.want, test.have)
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", Pformat(changes), Pformat(test.changes))
+		}
+	}
+}
+
+func TestInfraDiffNormalize(t *testing.T) {
+	type test struct {
+		kind    string
+		want    []interface{}
+		have    []interface{}
+		changes []*InfraDiffChange
+	}
+	tests := []test{
+		{infraKeySqs, []interface{}{"timeout=30", "delay=0"}, nil, nil},
+		{infraKeySqs, []interface{}{"timeout=60"}, []interface{}{"VisibilityTimeout=60"}, nil},
+		{infraKeySNS, []interface{}{"kms=alias/aws/sns"}, nil, nil},
+		{infraKeyS3, []interface{}{"acl=private"}, []interface{}{"acl=private", "versioning=false", "encryption=true", "metrics=true"}, nil},
+		{infraKeyVpc, []interface{}{"cidr=10.0.0.0/16", "nat=none"}, nil, nil},
+		{infraKeyLambda, []interface{}{"memory=128", "timeout=300", "arch=amd64", "vpc=main", "sg=default"}, []interface{}{"vpc=main"}, nil},
+		{infraKeyLambda, []interface{}{"logs-ttl-days=0"}, []interface{}{"logs-ttl-days=0"}, nil},
+		{infraKeyDynamoDB, []interface{}{"stream=NEW_IMAGE", "ProvisionedThroughput.ReadCapacityUnits=5"}, []interface{}{"read=5", "stream=new_image"}, nil},
+		{infraKeyAlarm, []interface{}{"namespace=AWS/SQS", "metric=Age", "threshold=1", "statistic=AVERAGE", "comparison=gte", "period=60"}, []interface{}{"namespace=AWS/SQS", "metric=Age", "threshold=1"}, nil},
+		{
+			infraKeyLambda,
+			[]interface{}{"memory=256"},
+			[]interface{}{"memory=128"},
+			[]*InfraDiffChange{{Action: InfraDiffActionChange, Kind: infraKeyLambda, Name: "name", Field: "attr", Add: []string{"memory=256"}}},
+		},
+		{
+			infraKeySqs,
+			[]interface{}{"timeout=60", "delay=0"},
+			nil,
+			[]*InfraDiffChange{{Action: InfraDiffActionChange, Kind: infraKeySqs, Name: "name", Field: "attr", Add: []string{"VisibilityTimeout=60"}}},
+		},
+	}
+	for _, test := range tests {
+		resource := func(attrs []interface{}) map[string]interface{} {
+			resource := map[string]interface{}{}
+			if attrs != nil {
+				resource["attr"] = attrs
+			}
+			val := map[string]interface{}{test.kind: map[string]interface{}{"name": resource}}
+			infraDiffNormalize(val)
+			return resource
+		}
+		changes := infraDiffResource(test.kind, "name", resource(test.want), resource(test.have))
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", Pformat(changes), Pformat(test.changes))
+		}
+	}
+}
+
+func TestInfraRunTasks(t *testing.T) {
+	var order []string
+	task := func(kind, name string, deps ...string) *infraTask {
+		return &infraTask{kind: kind, name: name, deps: deps, fn: func() error {
+			order = append(order, kind+"/"+name)
+			return nil
+		}}
+	}
+	tasks := []*infraTask{
+		task(infraKeyLambda, "fn", "sqs/queue", "s3/missing"),
+		task(infraKeySqs, "queue", "sqs/dlq"),
+		task(infraKeySqs, "dlq"),
+		task(infraKeyS3, "bucket"),
+	}
+	err := infraRunTasks(tasks, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"s3/bucket", "sqs/dlq", "sqs/queue", "lambda/fn"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", order, want)
+	}
+	order = nil
+	tasks = []*infraTask{
+		task(infraKeySqs, "a", "sqs/b"),
+		task(infraKeySqs, "b", "sqs/a"),
+	}
+	err = infraRunTasks(tasks, 4)
+	if err == nil || len(order) != 0 {
+		t.Errorf("expected cycle error, got: %v %v", err, order)
+	}
+}
+
+func TestInfraAllowReferences(t *testing.T) {
+	type test struct {
+		allow string
+		kind  string
+		name  string
+		want  bool
+	}
+	tests := []test{
+		{"s3:* arn:aws:s3:::bucket", infraKeyS3, "bucket", true},
+		{"s3:* arn:aws:s3:::bucket/*", infraKeyS3, "bucket", true},
+		{"s3:* arn:aws:s3:::bucket2/*", infraKeyS3, "bucket", false},
+		{"dynamodb:* arn:aws:dynamodb:*:*:table/table", infraKeyDynamoDB, "table", true},
+		{"dynamodb:* arn:aws:dynamodb:*:*:table/table/index/*", infraKeyDynamoDB, "table", true},
+		{"dynamodb:* arn:aws:dynamodb:*:*:table/table2", infraKeyDynamoDB, "table", false},
+		{"sqs:* arn:aws:sqs:*:*:queue", infraKeySqs, "queue", true},
+		{"sns:* arn:aws:sqs:*:*:queue", infraKeySNS, "queue", false},
+		{"sns:* arn:aws:sns:*:*:topic", infraKeySNS, "topic", true},
+		{"sns:* *", infraKeySNS, "topic", false},
+	}
+	for _, test := range tests {
+		have := infraAllowReferences(test.allow, test.kind, test.name)
+		if have != test.want {
+			t.Errorf("\n%s %s %s\ngot:\n%v\nwant:\n%v\n", test.allow, test.kind, test.name, have, test.want)
+		}
+	}
+}
+
+func TestInfraUserFromIamUser(t *testing.T) {
+	type test struct {
+		tags         []*iam.Tag
+		infraSetName string
+	}
+	tests := []test{
+		{nil, ""},
+		{[]*iam.Tag{{Key: aws.String("owner"), Value: aws.String("ops")}}, ""},
+		{[]*iam.Tag{{Key: aws.String("owner"), Value: aws.String("ops")}, {Key: aws.String(infraSetTagName), Value: aws.String("test-infraset")}}, "test-infraset"},
+	}
+	for _, test := range tests {
+		user := &IamUser{
+			UserName: aws.String("test-user"),
+			Allows:   []string{"s3:GetObject arn:aws:s3:::bucket/*"},
+			tags:     test.tags,
+		}
+		infraUser := infraUserFromIamUser(user)
+		if infraUser.infraSetName != test.infraSetName {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", infraUser.infraSetName, test.infraSetName)
+		}
+		if !reflect.DeepEqual(infraUser.Allow, user.Allows) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", infraUser.Allow, user.Allows)
+		}
+	}
+}
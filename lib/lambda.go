@@ -6,12 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	goruntime "runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,9 +26,12 @@ import (
 	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/aws/aws-sdk-go/service/apigatewayv2"
 	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/gofrs/uuid"
 )
 
 const (
@@ -32,11 +39,31 @@ const (
 	lambdaAttrMemory      = "memory"
 	lambdaAttrTimeout     = "timeout"
 	lambdaAttrLogsTTLDays = "logs-ttl-days"
+	lambdaAttrArch        = "arch"
+	lambdaAttrVpc         = "vpc"
+	lambdaAttrSg          = "sg"
+	lambdaAttrAlias       = "alias"
+	lambdaAttrCanary      = "canary"
+	lambdaAttrBake        = "bake"
+	lambdaAttrRetry       = "retry"
+	lambdaAttrMaxEventAge = "max-event-age"
+	lambdaAttrOnFailure   = "on-failure"
+	lambdaAttrOnSuccess   = "on-success"
 
 	lambdaAttrConcurrencyDefault = 0
 	lambdaAttrMemoryDefault      = 128
 	lambdaAttrTimeoutDefault     = 300
 	lambdaAttrLogsTTLDaysDefault = 7
+	lambdaAttrArchDefault        = lambdaArchAmd64
+	lambdaAttrCanaryDefault      = 0
+	lambdaAttrBakeDefault        = 300
+	lambdaAttrRetryDefault       = 2
+	lambdaAttrMaxEventAgeDefault = 21600
+
+	lambdaVpcPolicy = "AWSLambdaVPCAccessExecutionRole" // eni permissions for lambdas in a vpc
+
+	lambdaArchAmd64 = "amd64"
+	lambdaArchArm64 = "arm64"
 
 	lambdaTriggerSQS       = "sqs"
 	lambdaTrigerS3         = "s3"
@@ -45,10 +72,21 @@ const (
 	lambdaTriggerEcr       = "ecr"
 	lambdaTriggerApi       = "api"
 	lambdaTriggerWebsocket = "websocket"
+	lambdaTriggerSNS       = "sns"
+	lambdaTriggerUrl       = "url"
 
 	lambdaTriggerApiAttrDns    = "dns"
 	lambdaTriggerApiAttrDomain = "domain"
 
+	lambdaTriggerUrlAttrAuth   = "auth"
+	lambdaTriggerUrlAttrCors   = "cors"
+	lambdaTriggerUrlAttrStream = "stream"
+	lambdaTriggerUrlAttrUrl    = "url" // read only, shown by infra-ls
+
+	lambdaUrlAuthNone      = "none"
+	lambdaUrlAuthIam       = "iam"
+	lambdaUrlPermissionSid = "function_url__ALL"
+
 	lambdaDollarDefault     = "$default"
 	lambdaDollarConnect     = "$connect"
 	lambdaDollarDisconnect  = "$disconnect"
@@ -64,8 +102,11 @@ const (
 	LambdaWebsocketSuffix        = lambdaEventRuleNameSeparator + "websocket"
 
 	lambdaRuntimePython    = "python3.11"
+	lambdaRuntimeNode      = "nodejs20.x"
 	lambdaRuntimeGo        = "provided.al2"
 	lambdaRuntimeContainer = "container"
+
+	lambdaEsbuildVersion = "0.21.5"
 )
 
 var lambdaClient *lambda.Lambda
@@ -125,6 +166,194 @@ func LambdaSetConcurrency(ctx context.Context, lambdaName string, concurrency in
 	return nil
 }
 
+// the service and name of an async invocation destination: sqs:NAME, sns:NAME or lambda:NAME
+func lambdaParseDestination(dest string) (string, string, error) {
+	service, name, err := SplitOnce(dest, ":")
+	if err != nil || name == "" || !Contains([]string{"sqs", "sns", "lambda"}, service) {
+		err := fmt.Errorf("destination should be sqs:NAME, sns:NAME or lambda:NAME, got: %s", dest)
+		Logger.Println("error:", err)
+		return "", "", err
+	}
+	return service, name, nil
+}
+
+// the arn of a destination and the role allow needed to send to it
+func lambdaDestinationArn(account, region, dest string) (string, string, error) {
+	service, name, err := lambdaParseDestination(dest)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", "", err
+	}
+	switch service {
+	case "sqs":
+		arn := fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, account, name)
+		return arn, "sqs:SendMessage " + arn, nil
+	case "sns":
+		arn := fmt.Sprintf("arn:aws:sns:%s:%s:%s", region, account, name)
+		return arn, "sns:Publish " + arn, nil
+	default:
+		arn := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", region, account, name)
+		return arn, "lambda:InvokeFunction " + arn, nil
+	}
+}
+
+// the inverse of lambdaDestinationArn, or the arn itself for destinations libaws does not manage
+func lambdaDestinationFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	switch {
+	case len(parts) == 6 && (parts[2] == "sqs" || parts[2] == "sns"):
+		return parts[2] + ":" + parts[5]
+	case len(parts) == 7 && parts[2] == "lambda" && parts[5] == "function":
+		return "lambda:" + parts[6]
+	}
+	return arn
+}
+
+// the non default async invocation attrs of an existing config
+func lambdaEventInvokeConfigAttrs(config *lambda.GetFunctionEventInvokeConfigOutput) []string {
+	var attrs []string
+	if config == nil {
+		return attrs
+	}
+	if config.MaximumRetryAttempts != nil && *config.MaximumRetryAttempts != lambdaAttrRetryDefault {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", lambdaAttrRetry, *config.MaximumRetryAttempts))
+	}
+	if config.MaximumEventAgeInSeconds != nil && *config.MaximumEventAgeInSeconds != lambdaAttrMaxEventAgeDefault {
+		attrs = append(attrs, fmt.Sprintf("%s=%d", lambdaAttrMaxEventAge, *config.MaximumEventAgeInSeconds))
+	}
+	if config.DestinationConfig != nil {
+		if config.DestinationConfig.OnFailure != nil && config.DestinationConfig.OnFailure.Destination != nil {
+			attrs = append(attrs, lambdaAttrOnFailure+"="+lambdaDestinationFromArn(*config.DestinationConfig.OnFailure.Destination))
+		}
+		if config.DestinationConfig.OnSuccess != nil && config.DestinationConfig.OnSuccess.Destination != nil {
+			attrs = append(attrs, lambdaAttrOnSuccess+"="+lambdaDestinationFromArn(*config.DestinationConfig.OnSuccess.Destination))
+		}
+	}
+	return attrs
+}
+
+func LambdaGetEventInvokeConfig(ctx context.Context, name, qualifier string) (*lambda.GetFunctionEventInvokeConfigOutput, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaGetEventInvokeConfig"}
+		defer d.Log()
+	}
+	input := &lambda.GetFunctionEventInvokeConfigInput{
+		FunctionName: aws.String(name),
+	}
+	if qualifier != "" {
+		input.Qualifier = aws.String(qualifier)
+	}
+	out, err := LambdaClient().GetFunctionEventInvokeConfigWithContext(ctx, input)
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// configure retries, max event age and destinations for async invocations of
+// the function, or of its alias when triggers invoke an alias
+func LambdaEnsureEventInvokeConfig(ctx context.Context, infraLambda *InfraLambda, retry, maxEventAge int, onFailure, onSuccess string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaEnsureEventInvokeConfig"}
+		defer d.Log()
+	}
+	account, err := StsAccount(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	input := &lambda.PutFunctionEventInvokeConfigInput{
+		FunctionName:             aws.String(infraLambda.Name),
+		MaximumRetryAttempts:     aws.Int64(int64(retry)),
+		MaximumEventAgeInSeconds: aws.Int64(int64(maxEventAge)),
+		DestinationConfig:        &lambda.DestinationConfig{},
+	}
+	if infraLambda.alias != "" {
+		input.Qualifier = aws.String(infraLambda.alias)
+	}
+	if onFailure != "" {
+		arn, _, err := lambdaDestinationArn(account, Region(), onFailure)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		input.DestinationConfig.OnFailure = &lambda.OnFailure{Destination: aws.String(arn)}
+	}
+	if onSuccess != "" {
+		arn, _, err := lambdaDestinationArn(account, Region(), onSuccess)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		input.DestinationConfig.OnSuccess = &lambda.OnSuccess{Destination: aws.String(arn)}
+	}
+	if infraLambda.alias != "" {
+		// async invokes go through the alias, so a config left on the unqualified
+		// function only applies stale settings to direct invokes
+		unqualified, err := LambdaGetEventInvokeConfig(ctx, infraLambda.Name, "")
+		if err != nil && !preview {
+			Logger.Println("error:", err)
+			return err
+		}
+		if unqualified != nil {
+			if !preview {
+				_, err := LambdaClient().DeleteFunctionEventInvokeConfigWithContext(ctx, &lambda.DeleteFunctionEventInvokeConfigInput{
+					FunctionName: aws.String(infraLambda.Name),
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"deleted unqualified event invoke config:", infraLambda.Name)
+		}
+	}
+	out, err := LambdaGetEventInvokeConfig(ctx, infraLambda.Name, infraLambda.alias)
+	if err != nil && !preview {
+		Logger.Println("error:", err)
+		return err
+	}
+	existingAttrs := lambdaEventInvokeConfigAttrs(out)
+	attrs := lambdaEventInvokeConfigAttrs(&lambda.GetFunctionEventInvokeConfigOutput{
+		MaximumRetryAttempts:     input.MaximumRetryAttempts,
+		MaximumEventAgeInSeconds: input.MaximumEventAgeInSeconds,
+		DestinationConfig:        input.DestinationConfig,
+	})
+	if reflect.DeepEqual(existingAttrs, attrs) {
+		return nil
+	}
+	if !preview {
+		if len(attrs) == 0 {
+			deleteInput := &lambda.DeleteFunctionEventInvokeConfigInput{
+				FunctionName: input.FunctionName,
+				Qualifier:    input.Qualifier,
+			}
+			_, err := LambdaClient().DeleteFunctionEventInvokeConfigWithContext(ctx, deleteInput)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		} else {
+			// retry while the destination allow added to the role propagates
+			err := Retry(ctx, func() error {
+				_, err := LambdaClient().PutFunctionEventInvokeConfigWithContext(ctx, input)
+				return err
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	Logger.Printf(PreviewString(preview)+"updated event invoke config for %s: %s => %s\n", infraLambda.qualifiedName(), strings.Join(existingAttrs, " "), strings.Join(attrs, " "))
+	return nil
+}
+
 func LambdaArn(ctx context.Context, name string) (string, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "LambdaArn"}
@@ -213,7 +442,7 @@ func LambdaEnsureTriggerEcr(ctx context.Context, infraLambda *InfraLambda, previ
 			}
 			ruleArn = *out.Arn
 		}
-		sid, err := lambdaEnsurePermission(ctx, infraLambda.Name, "events.amazonaws.com", ruleArn, preview)
+		sid, err := lambdaEnsurePermission(ctx, infraLambda.qualifiedName(), "events.amazonaws.com", ruleArn, preview)
 		if err != nil {
 			Logger.Println("error:", err)
 			return nil, err
@@ -251,9 +480,16 @@ func LambdaEnsureTriggerEcr(ctx context.Context, infraLambda *InfraLambda, previ
 			Logger.Println(PreviewString(preview)+"created ecr rule target:", ruleName, infraLambda.Arn)
 		case 1:
 			if *targets[0].Arn != infraLambda.Arn {
-				err := fmt.Errorf("ecr rule is misconfigured with unknown target: %s %s", infraLambda.Arn, *targets[0].Arn)
-				Logger.Println("error:", err)
-				return nil, err
+				if !lambdaSameFunction(*targets[0].Arn, infraLambda.Arn) {
+					err := fmt.Errorf("ecr rule is misconfigured with unknown target: %s %s", infraLambda.Arn, *targets[0].Arn)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				err := lambdaUpdateRuleTarget(ctx, ruleName, targets[0], infraLambda.Arn, preview)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
 			}
 		default:
 			var targetArns []string
@@ -308,155 +544,653 @@ func LambdaEnsureTriggerEcr(ctx context.Context, infraLambda *InfraLambda, previ
 	return permissionSids, nil
 }
 
+const (
+	lambdaTriggerS3AttrEvents = "events"
+	lambdaTriggerS3AttrPrefix = "prefix"
+	lambdaTriggerS3AttrSuffix = "suffix"
+)
+
+var lambdaTriggerS3Events = map[string]string{
+	"created": "s3:ObjectCreated:*",
+	"removed": "s3:ObjectRemoved:*",
+	"restore": "s3:ObjectRestore:*",
+}
+
+var lambdaTriggerS3EventsDefault = []string{"created", "removed"}
+
+type lambdaTriggerS3 struct {
+	bucket string
+	events []string
+	prefix string
+	suffix string
+}
+
+// parse s3 trigger attrs: BUCKET [events=created,removed,restore] [prefix=PREFIX] [suffix=SUFFIX]
+func lambdaParseTriggerS3(attrs []string) (*lambdaTriggerS3, error) {
+	if len(attrs) == 0 {
+		err := fmt.Errorf("s3 trigger requires a bucket name")
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	trigger := &lambdaTriggerS3{
+		bucket: attrs[0],
+		events: lambdaTriggerS3EventsDefault,
+	}
+	for _, line := range attrs[1:] {
+		k, v, err := SplitOnce(line, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case lambdaTriggerS3AttrEvents:
+			trigger.events = nil
+			for _, event := range strings.Split(v, ",") {
+				if lambdaTriggerS3Events[event] == "" {
+					err := fmt.Errorf("unknown s3 trigger event, should be one of created, removed, restore: %s", event)
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				if !Contains(trigger.events, event) {
+					trigger.events = append(trigger.events, event)
+				}
+			}
+			sort.Strings(trigger.events)
+		case lambdaTriggerS3AttrPrefix:
+			trigger.prefix = v
+		case lambdaTriggerS3AttrSuffix:
+			trigger.suffix = v
+		default:
+			err := fmt.Errorf("unknown s3 trigger attribute: %s", line)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	return trigger, nil
+}
+
+// the inverse of lambdaParseTriggerS3, omitting defaults
+func (t *lambdaTriggerS3) Attrs() []string {
+	attrs := []string{t.bucket}
+	if !reflect.DeepEqual(t.events, lambdaTriggerS3EventsDefault) {
+		attrs = append(attrs, lambdaTriggerS3AttrEvents+"="+strings.Join(t.events, ","))
+	}
+	if t.prefix != "" {
+		attrs = append(attrs, lambdaTriggerS3AttrPrefix+"="+t.prefix)
+	}
+	if t.suffix != "" {
+		attrs = append(attrs, lambdaTriggerS3AttrSuffix+"="+t.suffix)
+	}
+	return attrs
+}
+
+func (t *lambdaTriggerS3) String() string {
+	return strings.Join(t.Attrs(), " ")
+}
+
+func (t *lambdaTriggerS3) Conf(lambdaArn string) *s3.LambdaFunctionConfiguration {
+	conf := &s3.LambdaFunctionConfiguration{
+		LambdaFunctionArn: aws.String(lambdaArn),
+	}
+	for _, event := range t.events {
+		conf.Events = append(conf.Events, aws.String(lambdaTriggerS3Events[event]))
+	}
+	var rules []*s3.FilterRule
+	if t.prefix != "" {
+		rules = append(rules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNamePrefix), Value: aws.String(t.prefix)})
+	}
+	if t.suffix != "" {
+		rules = append(rules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNameSuffix), Value: aws.String(t.suffix)})
+	}
+	if len(rules) > 0 {
+		conf.Filter = &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{FilterRules: rules}}
+	}
+	return conf
+}
+
+// s3 rejects notification configurations whose events and key filters overlap
+func (t *lambdaTriggerS3) Overlaps(other *lambdaTriggerS3) bool {
+	if t.bucket != other.bucket {
+		return false
+	}
+	sharedEvent := false
+	for _, event := range t.events {
+		if Contains(other.events, event) {
+			sharedEvent = true
+			break
+		}
+	}
+	if !sharedEvent {
+		return false
+	}
+	prefixOverlap := strings.HasPrefix(t.prefix, other.prefix) || strings.HasPrefix(other.prefix, t.prefix)
+	suffixOverlap := strings.HasSuffix(t.suffix, other.suffix) || strings.HasSuffix(other.suffix, t.suffix)
+	return prefixOverlap && suffixOverlap
+}
+
+func lambdaTriggerS3FromConf(bucket string, conf *s3.LambdaFunctionConfiguration) *lambdaTriggerS3 {
+	trigger := &lambdaTriggerS3{bucket: bucket}
+	for _, event := range conf.Events {
+		name := *event
+		for k, v := range lambdaTriggerS3Events {
+			if v == *event {
+				name = k
+			}
+		}
+		if !Contains(trigger.events, name) {
+			trigger.events = append(trigger.events, name)
+		}
+	}
+	sort.Strings(trigger.events)
+	if conf.Filter != nil && conf.Filter.Key != nil {
+		for _, rule := range conf.Filter.Key.FilterRules {
+			switch strings.ToLower(*rule.Name) {
+			case "prefix":
+				trigger.prefix = *rule.Value
+			case "suffix":
+				trigger.suffix = *rule.Value
+			}
+		}
+	}
+	return trigger
+}
+
+// bucket notification configuration is a single document shared by every
+// lambda on the bucket, so read-modify-write must not interleave
+var lambdaS3NotificationLocks = map[string]*sync.Mutex{}
+var lambdaS3NotificationLocksLock sync.Mutex
+
+func lambdaS3NotificationLock(bucket string) *sync.Mutex {
+	lambdaS3NotificationLocksLock.Lock()
+	defer lambdaS3NotificationLocksLock.Unlock()
+	lock, ok := lambdaS3NotificationLocks[bucket]
+	if !ok {
+		lock = &sync.Mutex{}
+		lambdaS3NotificationLocks[bucket] = lock
+	}
+	return lock
+}
+
 func LambdaEnsureTriggerS3(ctx context.Context, infraLambda *InfraLambda, preview bool) ([]string, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "LambdaEnsureTriggerS3"}
 		defer d.Log()
 	}
 	var permissionSids []string
-	events := []*string{
-		aws.String("s3:ObjectCreated:*"),
-		aws.String("s3:ObjectRemoved:*"),
-	}
-	var triggers []string
+	var buckets []string
+	triggers := map[string][]*lambdaTriggerS3{}
 	for _, trigger := range infraLambda.Trigger {
 		if trigger.Type == lambdaTrigerS3 {
-			triggers = append(triggers, trigger.Attr[0])
-		}
-	}
-	if len(triggers) > 0 {
-		for _, bucket := range triggers {
-			sid, err := lambdaEnsurePermission(ctx, infraLambda.Name, "s3.amazonaws.com", "arn:aws:s3:::"+bucket, preview)
+			s3Trigger, err := lambdaParseTriggerS3(trigger.Attr)
 			if err != nil {
 				Logger.Println("error:", err)
 				return nil, err
 			}
-			permissionSids = append(permissionSids, sid)
-			s3Client, err := S3ClientBucketRegion(bucket)
-			if err != nil {
-				aerr, ok := err.(awserr.Error)
-				if !ok || aerr.Code() != s3.ErrCodeNoSuchBucket {
-					Logger.Println("error:", err)
-					return nil, err
-				}
-				s3Client = nil
-			}
-			var out *s3.NotificationConfiguration
-			if s3Client != nil {
-				out, err = s3Client.GetBucketNotificationConfigurationWithContext(ctx, &s3.GetBucketNotificationConfigurationRequest{
-					Bucket: aws.String(bucket),
-				})
-				if err != nil {
-					aerr, ok := err.(awserr.Error)
-					if !ok || aerr.Code() != s3.ErrCodeNoSuchBucket {
-						Logger.Println("error:", err)
-						return nil, err
-					}
-				}
-			}
-			var existingEvents []*string
-			if out == nil {
-				out = &s3.NotificationConfiguration{
-					LambdaFunctionConfigurations: []*s3.LambdaFunctionConfiguration{},
-				}
-			}
-			for _, conf := range out.LambdaFunctionConfigurations {
-				if *conf.LambdaFunctionArn == infraLambda.Arn {
-					existingEvents = conf.Events
-				}
-			}
-			if !reflect.DeepEqual(existingEvents, events) {
-				var confs []*s3.LambdaFunctionConfiguration
-				for _, conf := range out.LambdaFunctionConfigurations {
-					if *conf.LambdaFunctionArn != infraLambda.Arn {
-						confs = append(confs, conf)
-					}
-				}
-				confs = append(confs, &s3.LambdaFunctionConfiguration{
-					LambdaFunctionArn: aws.String(infraLambda.Arn),
-					Events:            events,
-				})
-				out.LambdaFunctionConfigurations = confs
-				if !preview {
-					err := Retry(ctx, func() error {
-						_, err := s3Client.PutBucketNotificationConfigurationWithContext(ctx, &s3.PutBucketNotificationConfigurationInput{
-							Bucket:                    aws.String(bucket),
-							NotificationConfiguration: out,
-						})
-						return err
-					})
-					if err != nil {
-						Logger.Println("error:", err)
-						return nil, err
-					}
-				}
-				Logger.Printf(PreviewString(preview)+"updated bucket notifications for %s %s: %s => %s\n", bucket, infraLambda.Name, StringSlice(existingEvents), StringSlice(events))
+			if !Contains(buckets, s3Trigger.bucket) {
+				buckets = append(buckets, s3Trigger.bucket)
 			}
+			triggers[s3Trigger.bucket] = append(triggers[s3Trigger.bucket], s3Trigger)
+		}
+	}
+	for _, bucket := range buckets {
+		sid, err := lambdaEnsurePermission(ctx, infraLambda.qualifiedName(), "s3.amazonaws.com", "arn:aws:s3:::"+bucket, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		permissionSids = append(permissionSids, sid)
+		err = lambdaEnsureBucketNotifications(ctx, infraLambda, bucket, triggers[bucket], preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
 		}
 	}
-	buckets, err := S3Client().ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+	out, err := S3Client().ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
 	if err != nil {
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	for _, bucket := range buckets.Buckets {
-		out, err := S3ClientBucketRegionMust(*bucket.Name).GetBucketNotificationConfigurationWithContext(ctx, &s3.GetBucketNotificationConfigurationRequest{
-			Bucket: bucket.Name,
-		})
+	for _, bucket := range out.Buckets {
+		if Contains(buckets, *bucket.Name) {
+			continue
+		}
+		err := lambdaEnsureBucketNotifications(ctx, infraLambda, *bucket.Name, nil, preview)
 		if err != nil {
-			aerr, ok := err.(awserr.Error)
-			if ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
-				continue // recently delete buckets can still show up in listbuckets but fail with 404
-			}
 			Logger.Println("error:", err)
 			return nil, err
 		}
-		var confs []*s3.LambdaFunctionConfiguration
-		for _, conf := range out.LambdaFunctionConfigurations {
-			if *conf.LambdaFunctionArn != infraLambda.Arn || Contains(triggers, *bucket.Name) {
-				confs = append(confs, conf)
-			} else {
-				Logger.Println(PreviewString(preview)+"deleted bucket notification:", infraLambda.Name, *bucket.Name)
-			}
-		}
-		if len(confs) != len(out.LambdaFunctionConfigurations) && !preview {
-			out.LambdaFunctionConfigurations = confs
-			_, err := S3ClientBucketRegionMust(*bucket.Name).PutBucketNotificationConfigurationWithContext(ctx, &s3.PutBucketNotificationConfigurationInput{
-				Bucket:                    bucket.Name,
-				NotificationConfiguration: out,
-			})
-			if err != nil {
-				Logger.Println("error:", err)
-				return nil, err
-			}
-		}
 	}
 	return permissionSids, nil
 }
 
-func lambdaRemoveUnusedPermissions(ctx context.Context, name string, permissionSids []string, preview bool) error {
+// replace this lambda's notifications on a bucket with triggers, leaving
+// notifications for other lambdas, topics and queues untouched. notifications
+// for other qualifiers of this lambda, like a stale alias or the unqualified
+// function once alias= is set, are removed.
+func lambdaEnsureBucketNotifications(ctx context.Context, infraLambda *InfraLambda, bucket string, triggers []*lambdaTriggerS3, preview bool) error {
 	if doDebug {
-		d := &Debug{start: time.Now(), name: "lambdaRemoveUnusedPermissions"}
+		d := &Debug{start: time.Now(), name: "lambdaEnsureBucketNotifications"}
 		defer d.Log()
 	}
-	out, err := LambdaClient().GetPolicyWithContext(ctx, &lambda.GetPolicyInput{
-		FunctionName: aws.String(name),
-	})
+	lock := lambdaS3NotificationLock(bucket)
+	lock.Lock()
+	defer lock.Unlock()
+	s3Client, err := S3ClientBucketRegion(bucket)
 	if err != nil {
 		aerr, ok := err.(awserr.Error)
-		if !ok || aerr.Code() != lambda.ErrCodeResourceNotFoundException {
+		if !ok || aerr.Code() != s3.ErrCodeNoSuchBucket {
 			Logger.Println("error:", err)
 			return err
 		}
-		return nil
+		if len(triggers) == 0 {
+			return nil
+		}
+		if !preview {
+			Logger.Println("error:", err)
+			return err
+		}
+		s3Client = nil // bucket will be created before this lambda outside of preview
 	}
-	policy := IamPolicyDocument{}
-	err = json.Unmarshal([]byte(*out.Policy), &policy)
-	if err != nil {
-		Logger.Println("error:", err)
-		return err
+	var out *s3.NotificationConfiguration
+	if s3Client != nil {
+		out, err = s3Client.GetBucketNotificationConfigurationWithContext(ctx, &s3.GetBucketNotificationConfigurationRequest{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			aerr, ok := err.(awserr.Error)
+			if !ok || aerr.Code() != s3.ErrCodeNoSuchBucket {
+				Logger.Println("error:", err)
+				return err
+			}
+			out = nil // recently delete buckets can still show up in listbuckets but fail with 404
+		}
 	}
-	for _, statement := range policy.Statement {
+	if out == nil {
+		if len(triggers) == 0 {
+			return nil
+		}
+		out = &s3.NotificationConfiguration{}
+	}
+	var confs []*s3.LambdaFunctionConfiguration
+	var existing []string
+	var stale []string
+	for _, conf := range out.LambdaFunctionConfigurations {
+		switch {
+		case *conf.LambdaFunctionArn == infraLambda.Arn:
+			existing = append(existing, lambdaTriggerS3FromConf(bucket, conf).String())
+		case lambdaSameFunction(*conf.LambdaFunctionArn, infraLambda.Arn):
+			stale = append(stale, *conf.LambdaFunctionArn+" "+lambdaTriggerS3FromConf(bucket, conf).String())
+		default:
+			confs = append(confs, conf)
+		}
+	}
+	var desired []string
+	for _, trigger := range triggers {
+		desired = append(desired, trigger.String())
+		confs = append(confs, trigger.Conf(infraLambda.Arn))
+	}
+	sort.Strings(existing)
+	sort.Strings(desired)
+	if len(stale) == 0 && reflect.DeepEqual(existing, desired) {
+		return nil
+	}
+	if !preview {
+		out.LambdaFunctionConfigurations = confs
+		err := Retry(ctx, func() error {
+			_, err := s3Client.PutBucketNotificationConfigurationWithContext(ctx, &s3.PutBucketNotificationConfigurationInput{
+				Bucket:                    aws.String(bucket),
+				NotificationConfiguration: out,
+			})
+			return err
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	for _, trigger := range existing {
+		if !Contains(desired, trigger) {
+			Logger.Println(PreviewString(preview)+"deleted bucket notification:", infraLambda.Name, trigger)
+		}
+	}
+	for _, trigger := range stale {
+		Logger.Println(PreviewString(preview)+"deleted bucket notification of other qualifier:", trigger)
+	}
+	for _, trigger := range desired {
+		if !Contains(existing, trigger) {
+			Logger.Println(PreviewString(preview)+"created bucket notification:", infraLambda.Name, trigger)
+		}
+	}
+	return nil
+}
+
+type lambdaTriggerUrlConf struct {
+	auth   string
+	cors   []string
+	stream bool
+}
+
+// parse url trigger attrs: auth=none|iam, cors=ORIGIN (repeatable) and stream=true|false
+func lambdaParseTriggerUrlConf(attrs []string) (*lambdaTriggerUrlConf, error) {
+	trigger := &lambdaTriggerUrlConf{auth: lambdaUrlAuthNone}
+	for _, attr := range attrs {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case lambdaTriggerUrlAttrAuth:
+			if !Contains([]string{lambdaUrlAuthNone, lambdaUrlAuthIam}, v) {
+				err := fmt.Errorf("url trigger auth should be %s or %s, got: %s", lambdaUrlAuthNone, lambdaUrlAuthIam, v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			trigger.auth = v
+		case lambdaTriggerUrlAttrCors:
+			if !Contains(trigger.cors, v) {
+				trigger.cors = append(trigger.cors, v)
+			}
+		case lambdaTriggerUrlAttrStream:
+			if !Contains([]string{"true", "false"}, v) {
+				err := fmt.Errorf("url trigger stream should be true or false, got: %s", v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			trigger.stream = v == "true"
+		case lambdaTriggerUrlAttrUrl:
+		default:
+			err := fmt.Errorf("unknown url trigger attr: %s", attr)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	sort.Strings(trigger.cors)
+	return trigger, nil
+}
+
+// canonical attrs, omitting defaults
+func (t *lambdaTriggerUrlConf) Attrs() []string {
+	var attrs []string
+	if t.auth != lambdaUrlAuthNone {
+		attrs = append(attrs, lambdaTriggerUrlAttrAuth+"="+t.auth)
+	}
+	for _, origin := range t.cors {
+		attrs = append(attrs, lambdaTriggerUrlAttrCors+"="+origin)
+	}
+	if t.stream {
+		attrs = append(attrs, lambdaTriggerUrlAttrStream+"=true")
+	}
+	return attrs
+}
+
+func (t *lambdaTriggerUrlConf) String() string {
+	return strings.Join(t.Attrs(), " ")
+}
+
+func (t *lambdaTriggerUrlConf) authType() string {
+	if t.auth == lambdaUrlAuthIam {
+		return lambda.FunctionUrlAuthTypeAwsIam
+	}
+	return lambda.FunctionUrlAuthTypeNone
+}
+
+func (t *lambdaTriggerUrlConf) invokeMode() string {
+	if t.stream {
+		return lambda.InvokeModeResponseStream
+	}
+	return lambda.InvokeModeBuffered
+}
+
+func (t *lambdaTriggerUrlConf) corsConfig() *lambda.Cors {
+	if len(t.cors) == 0 {
+		return &lambda.Cors{}
+	}
+	return &lambda.Cors{
+		AllowOrigins: aws.StringSlice(t.cors),
+		AllowMethods: []*string{aws.String("*")},
+		AllowHeaders: []*string{aws.String("*")},
+	}
+}
+
+func lambdaTriggerUrlConfFromConfig(authType, invokeMode *string, cors *lambda.Cors) *lambdaTriggerUrlConf {
+	trigger := &lambdaTriggerUrlConf{auth: lambdaUrlAuthNone}
+	if aws.StringValue(authType) == lambda.FunctionUrlAuthTypeAwsIam {
+		trigger.auth = lambdaUrlAuthIam
+	}
+	trigger.stream = aws.StringValue(invokeMode) == lambda.InvokeModeResponseStream
+	if cors != nil {
+		trigger.cors = aws.StringValueSlice(cors.AllowOrigins)
+		sort.Strings(trigger.cors)
+	}
+	return trigger
+}
+
+// the function url config for a function or alias, or nil if there is none
+func lambdaGetUrlConfig(ctx context.Context, name, qualifier string) (*lambda.GetFunctionUrlConfigOutput, error) {
+	input := &lambda.GetFunctionUrlConfigInput{
+		FunctionName: aws.String(name),
+	}
+	if qualifier != "" {
+		input.Qualifier = aws.String(qualifier)
+	}
+	out, err := LambdaClient().GetFunctionUrlConfigWithContext(ctx, input)
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return out, nil
+}
+
+func lambdaDeleteUrlConfig(ctx context.Context, name, qualifier string, preview bool) error {
+	out, err := lambdaGetUrlConfig(ctx, name, qualifier)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if !preview {
+		input := &lambda.DeleteFunctionUrlConfigInput{
+			FunctionName: aws.String(name),
+		}
+		if qualifier != "" {
+			input.Qualifier = aws.String(qualifier)
+		}
+		_, err := LambdaClient().DeleteFunctionUrlConfigWithContext(ctx, input)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"deleted function url:", name, *out.FunctionUrl)
+	return nil
+}
+
+// the function url of a lambda, qualified by alias when triggers invoke an alias
+func LambdaUrl(ctx context.Context, name, qualifier string) (string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaUrl"}
+		defer d.Log()
+	}
+	out, err := lambdaGetUrlConfig(ctx, name, qualifier)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if out == nil {
+		err := fmt.Errorf("no function url for: %s", name)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	return *out.FunctionUrl, nil
+}
+
+func LambdaEnsureTriggerUrl(ctx context.Context, infraLambda *InfraLambda, preview bool) ([]string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaEnsureTriggerUrl"}
+		defer d.Log()
+	}
+	var trigger *lambdaTriggerUrlConf
+	for _, t := range infraLambda.Trigger {
+		if t.Type == lambdaTriggerUrl {
+			if trigger != nil {
+				err := fmt.Errorf("only one url trigger is allowed per lambda: %s", infraLambda.Name)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			var err error
+			trigger, err = lambdaParseTriggerUrlConf(t.Attr)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		}
+	}
+	if trigger == nil {
+		err := lambdaDeleteUrlConfig(ctx, infraLambda.Name, infraLambda.alias, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+	out, err := lambdaGetUrlConfig(ctx, infraLambda.Name, infraLambda.alias)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	var qualifier *string
+	if infraLambda.alias != "" {
+		qualifier = aws.String(infraLambda.alias)
+	}
+	if out == nil {
+		url := ""
+		if !preview {
+			err := Retry(ctx, func() error {
+				out, err := LambdaClient().CreateFunctionUrlConfigWithContext(ctx, &lambda.CreateFunctionUrlConfigInput{
+					FunctionName: aws.String(infraLambda.Name),
+					Qualifier:    qualifier,
+					AuthType:     aws.String(trigger.authType()),
+					InvokeMode:   aws.String(trigger.invokeMode()),
+					Cors:         trigger.corsConfig(),
+				})
+				if err != nil {
+					return err
+				}
+				url = *out.FunctionUrl
+				return nil
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"created function url:", infraLambda.qualifiedName(), url, trigger)
+	} else {
+		existing := lambdaTriggerUrlConfFromConfig(out.AuthType, out.InvokeMode, out.Cors)
+		if existing.String() != trigger.String() {
+			if !preview {
+				_, err := LambdaClient().UpdateFunctionUrlConfigWithContext(ctx, &lambda.UpdateFunctionUrlConfigInput{
+					FunctionName: aws.String(infraLambda.Name),
+					Qualifier:    qualifier,
+					AuthType:     aws.String(trigger.authType()),
+					InvokeMode:   aws.String(trigger.invokeMode()),
+					Cors:         trigger.corsConfig(),
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+			}
+			Logger.Printf(PreviewString(preview)+"updated function url for %s: %s => %s\n", infraLambda.qualifiedName(), existing, trigger)
+		}
+	}
+	if trigger.auth != lambdaUrlAuthNone {
+		return nil, nil
+	}
+	// public urls need a resource policy allowing anyone to invoke the url
+	sids, err := lambdaPermissionSids(ctx, infraLambda.qualifiedName())
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if !Contains(sids, lambdaUrlPermissionSid) {
+		if !preview {
+			err := Retry(ctx, func() error {
+				_, err := LambdaClient().AddPermissionWithContext(ctx, &lambda.AddPermissionInput{
+					FunctionName:        aws.String(infraLambda.qualifiedName()),
+					StatementId:         aws.String(lambdaUrlPermissionSid),
+					Action:              aws.String("lambda:InvokeFunctionUrl"),
+					Principal:           aws.String("*"),
+					FunctionUrlAuthType: aws.String(lambda.FunctionUrlAuthTypeNone),
+				})
+				return err
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"created lambda permission:", infraLambda.qualifiedName(), "function url")
+	}
+	return []string{lambdaUrlPermissionSid}, nil
+}
+
+// the statement ids of a function's resource policy
+func lambdaPermissionSids(ctx context.Context, name string) ([]string, error) {
+	out, err := LambdaClient().GetPolicyWithContext(ctx, &lambda.GetPolicyInput{
+		FunctionName: aws.String(name),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	policy := IamPolicyDocument{}
+	err = json.Unmarshal([]byte(*out.Policy), &policy)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	var sids []string
+	for _, statement := range policy.Statement {
+		sids = append(sids, statement.Sid)
+	}
+	return sids, nil
+}
+
+func lambdaRemoveUnusedPermissions(ctx context.Context, name string, permissionSids []string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaRemoveUnusedPermissions"}
+		defer d.Log()
+	}
+	out, err := LambdaClient().GetPolicyWithContext(ctx, &lambda.GetPolicyInput{
+		FunctionName: aws.String(name),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != lambda.ErrCodeResourceNotFoundException {
+			Logger.Println("error:", err)
+			return err
+		}
+		return nil
+	}
+	policy := IamPolicyDocument{}
+	err = json.Unmarshal([]byte(*out.Policy), &policy)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	for _, statement := range policy.Statement {
 		if !Contains(permissionSids, statement.Sid) {
 			if !preview {
 				_, err := LambdaClient().RemovePermissionWithContext(ctx, &lambda.RemovePermissionInput{
@@ -828,7 +1562,7 @@ func lambdaEnsureTriggerApiIntegrationStageRoute(ctx context.Context, name, arnL
 		}
 	}
 	arn := fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/*/*", Region(), account, *api.ApiId)
-	lambdaName := Last(strings.Split(arnLambda, ":"))
+	lambdaName := strings.SplitN(arnLambda, ":function:", 2)[1] // name or name:alias
 	sid, err := lambdaEnsurePermission(ctx, lambdaName, "apigateway.amazonaws.com", arn, preview)
 	if err != nil {
 		Logger.Println("error:", err)
@@ -1129,7 +1863,7 @@ func LambdaEnsureTriggerApi(ctx context.Context, infraLambda *InfraLambda, previ
 		Logger.Println("error:", err)
 		return nil, err
 	}
-	arnLambda := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", Region(), account, infraLambda.Name)
+	arnLambda := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", Region(), account, infraLambda.qualifiedName())
 	count := 0
 	for _, trigger := range infraLambda.Trigger {
 		var protocolType string
@@ -1413,7 +2147,7 @@ func LambdaEnsureTriggerSchedule(ctx context.Context, infraLambda *InfraLambda,
 				}
 				scheduleArn = *out.Arn
 			}
-			sid, err := lambdaEnsurePermission(ctx, infraLambda.Name, "events.amazonaws.com", scheduleArn, preview)
+			sid, err := lambdaEnsurePermission(ctx, infraLambda.qualifiedName(), "events.amazonaws.com", scheduleArn, preview)
 			if err != nil {
 				Logger.Println("error:", err)
 				return nil, err
@@ -1451,9 +2185,16 @@ func LambdaEnsureTriggerSchedule(ctx context.Context, infraLambda *InfraLambda,
 				Logger.Println(PreviewString(preview)+"created cloudwatch rule target:", scheduleName, infraLambda.Arn)
 			case 1:
 				if *targets[0].Arn != infraLambda.Arn {
-					err := fmt.Errorf("cloudwatch rule is misconfigured with unknown target: %s != %s", infraLambda.Arn, *targets[0].Arn)
-					Logger.Println("error:", err)
-					return nil, err
+					if !lambdaSameFunction(*targets[0].Arn, infraLambda.Arn) {
+						err := fmt.Errorf("cloudwatch rule is misconfigured with unknown target: %s != %s", infraLambda.Arn, *targets[0].Arn)
+						Logger.Println("error:", err)
+						return nil, err
+					}
+					err := lambdaUpdateRuleTarget(ctx, scheduleName, targets[0], infraLambda.Arn, preview)
+					if err != nil {
+						Logger.Println("error:", err)
+						return nil, err
+					}
 				}
 			default:
 				var targetArns []string
@@ -1540,7 +2281,7 @@ func LambdaEnsureTriggerDynamoDB(ctx context.Context, infraLambda *InfraLambda,
 			tableName := triggerAttrs[0]
 			triggerAttrs := triggerAttrs[1:]
 			createMappingInput := &lambda.CreateEventSourceMappingInput{
-				FunctionName:                   aws.String(infraLambda.Name),
+				FunctionName:                   aws.String(infraLambda.qualifiedName()),
 				Enabled:                        aws.Bool(true),
 				BatchSize:                      aws.Int64(100),
 				MaximumBatchingWindowInSeconds: aws.Int64(0),
@@ -1602,7 +2343,7 @@ func LambdaEnsureTriggerDynamoDB(ctx context.Context, infraLambda *InfraLambda,
 				}
 			} else {
 				createMappingInput.EventSourceArn = aws.String(streamArn)
-				eventSourceMappings, err := lambdaListEventSourceMappings(ctx, infraLambda.Name)
+				eventSourceMappings, err := lambdaListEventSourceMappings(ctx, infraLambda.qualifiedName())
 				if err != nil {
 					Logger.Println("error:", err)
 					return err
@@ -1768,7 +2509,7 @@ func LambdaEnsureTriggerSQS(ctx context.Context, infraLambda *InfraLambda, previ
 				return err
 			}
 			input := &lambda.CreateEventSourceMappingInput{
-				FunctionName:                   aws.String(infraLambda.Name),
+				FunctionName:                   aws.String(infraLambda.qualifiedName()),
 				EventSourceArn:                 aws.String(sqsArn),
 				Enabled:                        aws.Bool(true),
 				BatchSize:                      aws.Int64(10),
@@ -1802,7 +2543,7 @@ func LambdaEnsureTriggerSQS(ctx context.Context, infraLambda *InfraLambda, previ
 					return err
 				}
 			}
-			eventSourceMappings, err := lambdaListEventSourceMappings(ctx, infraLambda.Name)
+			eventSourceMappings, err := lambdaListEventSourceMappings(ctx, infraLambda.qualifiedName())
 			if err != nil {
 				Logger.Println("error:", err)
 				return err
@@ -1899,50 +2640,362 @@ func LambdaEnsureTriggerSQS(ctx context.Context, infraLambda *InfraLambda, previ
 	return nil
 }
 
-func LambdaZipFile(name string) string {
-	return fmt.Sprintf("/tmp/%s/lambda.zip", name)
-}
-
-func lambdaUpdateZipGo(infraLambda *InfraLambda) error {
-	return lambdaCreateZipGo(infraLambda)
-}
-
-func lambdaCreateZipGo(infraLambda *InfraLambda) error {
+func LambdaEnsureTriggerSNS(ctx context.Context, infraLambda *InfraLambda, preview bool) ([]string, error) {
 	if doDebug {
-		d := &Debug{start: time.Now(), name: "lambdaCreateZipGo"}
+		d := &Debug{start: time.Now(), name: "LambdaEnsureTriggerSNS"}
 		defer d.Log()
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
-	dir := path.Dir(zipFile)
-	err := os.RemoveAll(dir)
-	if err != nil {
-		Logger.Println("error:", err)
-		return err
+	var permissionSids []string
+	var topicArns []string
+	for _, trigger := range infraLambda.Trigger {
+		if trigger.Type == lambdaTriggerSNS {
+			if len(trigger.Attr) != 1 {
+				err := fmt.Errorf("sns trigger should have exactly one attr, the topic name: %s %v", infraLambda.Name, trigger.Attr)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			topicArn, err := SNSArn(ctx, trigger.Attr[0])
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			topicArns = append(topicArns, topicArn)
+		}
 	}
-	_ = os.MkdirAll(dir, os.ModePerm)
-	err = shellAt(path.Dir(infraLambda.Entrypoint), "CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags='-s -w %s' -tags 'netgo osusergo' -o %s %s", os.Getenv("LDFLAGS"), path.Join(dir, "main"), path.Base(infraLambda.Entrypoint))
-	if err != nil {
-		Logger.Println("error:", err)
-		return err
+	for _, topicArn := range topicArns {
+		sid, err := lambdaEnsurePermission(ctx, infraLambda.qualifiedName(), "sns.amazonaws.com", topicArn, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		permissionSids = append(permissionSids, sid)
+		subscriptions, err := SNSListSubscriptions(ctx, topicArn)
+		if err != nil {
+			aerr, ok := err.(awserr.Error)
+			if !ok || aerr.Code() != sns.ErrCodeNotFoundException || !preview {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		}
+		found := false
+		for _, subscription := range subscriptions {
+			if *subscription.Protocol == "lambda" && *subscription.Endpoint == infraLambda.Arn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if !preview {
+				_, err := SNSClient().SubscribeWithContext(ctx, &sns.SubscribeInput{
+					TopicArn: aws.String(topicArn),
+					Protocol: aws.String("lambda"),
+					Endpoint: aws.String(infraLambda.Arn),
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"created sns subscription:", infraLambda.Name, SNSArnToName(topicArn))
+		}
+	}
+	var nextToken *string
+	for {
+		out, err := SNSClient().ListSubscriptionsWithContext(ctx, &sns.ListSubscriptionsInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, subscription := range out.Subscriptions {
+			if *subscription.Protocol != "lambda" || !lambdaSameFunction(*subscription.Endpoint, infraLambda.Arn) {
+				continue
+			}
+			// keep declared topics, but drop subscriptions of other qualifiers of this lambda
+			if *subscription.Endpoint == infraLambda.Arn && Contains(topicArns, *subscription.TopicArn) {
+				continue
+			}
+			err := snsUnsubscribe(ctx, subscription, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+	return permissionSids, nil
+}
+
+func LambdaZipFile(name string) string {
+	return fmt.Sprintf("/tmp/%s/lambda.zip", name)
+}
+
+func lambdaZipFile(infraLambda *InfraLambda) string {
+	if infraLambda.zipFile != "" {
+		return infraLambda.zipFile
+	}
+	return LambdaZipFile(infraLambda.Name)
+}
+
+// lambda architecture name for a goarch
+func lambdaArchitecture(arch string) string {
+	if arch == lambdaArchArm64 {
+		return lambda.ArchitectureArm64
+	}
+	return lambda.ArchitectureX8664
+}
+
+// goarch for a lambda architecture name, the inverse of lambdaArchitecture
+func lambdaArch(architectures []*string) string {
+	for _, architecture := range architectures {
+		if *architecture == lambda.ArchitectureArm64 {
+			return lambdaArchArm64
+		}
+	}
+	return lambdaArchAmd64
+}
+
+// lambda attrs with defaults omitted and numbers and vpc attrs rendered the way
+// InfraListLambda reports them
+func lambdaCanonicalAttrs(attrs []string) ([]string, error) {
+	defaults := map[string]int{
+		lambdaAttrConcurrency: lambdaAttrConcurrencyDefault,
+		lambdaAttrMemory:      lambdaAttrMemoryDefault,
+		lambdaAttrTimeout:     lambdaAttrTimeoutDefault,
+		lambdaAttrLogsTTLDays: lambdaAttrLogsTTLDaysDefault,
+		lambdaAttrCanary:      lambdaAttrCanaryDefault,
+		lambdaAttrBake:        lambdaAttrBakeDefault,
+		lambdaAttrRetry:       lambdaAttrRetryDefault,
+		lambdaAttrMaxEventAge: lambdaAttrMaxEventAgeDefault,
+	}
+	var res []string
+	vpcName := ""
+	var sgNames []string
+	for _, attr := range attrs {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case lambdaAttrVpc:
+			vpcName = v
+		case lambdaAttrSg:
+			sgNames = append(sgNames, v)
+		case lambdaAttrArch:
+			if v != lambdaAttrArchDefault {
+				res = append(res, attr)
+			}
+		case lambdaAttrAlias, lambdaAttrOnFailure, lambdaAttrOnSuccess:
+			res = append(res, attr)
+		default:
+			defaultValue, ok := defaults[k]
+			if !ok {
+				err := fmt.Errorf("unknown attr: %s", k)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			value, err := strconv.Atoi(v)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			if value != defaultValue {
+				res = append(res, fmt.Sprintf("%s=%d", k, value))
+			}
+		}
+	}
+	return append(res, lambdaVpcAttrsFromNames(vpcName, sgNames)...), nil
+}
+
+// vpc and sg attrs as they appear in infra.yaml, sorted for comparison. the
+// default security group is implied when no sg is named.
+func lambdaVpcAttrsFromNames(vpcName string, sgNames []string) []string {
+	if vpcName == "" {
+		return nil
+	}
+	var sgs []string
+	for _, sgName := range sgNames {
+		if sgName != "default" {
+			sgs = append(sgs, lambdaAttrSg+"="+sgName)
+		}
 	}
-	compression := "-9"
-	if os.Getenv("ZIP_COMPRESSION") != "" {
-		compression = "-" + os.Getenv("ZIP_COMPRESSION")
+	sort.Strings(sgs)
+	return append([]string{lambdaAttrVpc + "=" + vpcName}, sgs...)
+}
+
+// the inverse of lambdaVpcConfig, resolving ids back to names
+func lambdaVpcAttrs(ctx context.Context, vpcConfig *lambda.VpcConfigResponse) ([]string, error) {
+	if vpcConfig == nil || vpcConfig.VpcId == nil || *vpcConfig.VpcId == "" {
+		return nil, nil
 	}
-	err = shellAt(dir, "zip %s %s ./main", compression, zipFile)
+	vpcName, err := lambdaVpcName(ctx, *vpcConfig.VpcId)
 	if err != nil {
 		Logger.Println("error:", err)
-		return err
+		return nil, err
 	}
-	return nil
+	var sgNames []string
+	if len(vpcConfig.SecurityGroupIds) > 0 {
+		sgs, err := EC2Client().DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+			GroupIds: vpcConfig.SecurityGroupIds,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, sg := range sgs.SecurityGroups {
+			sgNames = append(sgNames, *sg.GroupName)
+		}
+	}
+	return lambdaVpcAttrsFromNames(vpcName, sgNames), nil
 }
 
-func lambdaCreateZipPy(infraLambda *InfraLambda) error {
+// resolve a vpc id to its name, so that vpc=vpc-xxxx compares equal to the name
+// lambdaVpcAttrs reports. untagged vpcs keep their id.
+func lambdaVpcName(ctx context.Context, vpcName string) (string, error) {
+	if !strings.HasPrefix(vpcName, "vpc-") {
+		return vpcName, nil
+	}
+	out, err := EC2Client().DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{
+		VpcIds: []*string{aws.String(vpcName)},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	for _, vpc := range out.Vpcs {
+		if name := EC2Name(vpc.Tags); name != "" {
+			return name, nil
+		}
+	}
+	return vpcName, nil
+}
+
+// vpc= and sg= attrs with ids resolved to names, the way lambdaVpcAttrs reports them
+func lambdaResolveVpcAttrs(ctx context.Context, attrs []string) ([]string, error) {
+	var res []string
+	for _, attr := range attrs {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case lambdaAttrVpc:
+			v, err = lambdaVpcName(ctx, v)
+		case lambdaAttrSg:
+			var names []string
+			names, err = lambdaSgNames(ctx, []string{v})
+			if err == nil {
+				v = names[0]
+			}
+		}
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		res = append(res, k+"="+v)
+	}
+	return res, nil
+}
+
+func lambdaSortedIDs(ids []*string) []string {
+	res := aws.StringValueSlice(ids)
+	sort.Strings(res)
+	return res
+}
+
+// resolve sg ids to names, so that sg=sg-xxxx compares equal to the names
+// lambdaVpcAttrs reports
+func lambdaSgNames(ctx context.Context, sgNames []string) ([]string, error) {
+	var ids []*string
+	for _, sgName := range sgNames {
+		if strings.HasPrefix(sgName, "sg-") {
+			ids = append(ids, aws.String(sgName))
+		}
+	}
+	if len(ids) == 0 {
+		return sgNames, nil
+	}
+	out, err := EC2Client().DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: ids,
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, sg := range out.SecurityGroups {
+		names[*sg.GroupId] = *sg.GroupName
+	}
+	var result []string
+	for _, sgName := range sgNames {
+		if name, ok := names[sgName]; ok {
+			sgName = name
+		}
+		result = append(result, sgName)
+	}
+	return result, nil
+}
+
+// resolve vpc and security group names to subnet and security group ids,
+// using the vpc's default security group when none are named
+func lambdaVpcConfig(ctx context.Context, vpcName string, sgNames []string) (*lambda.VpcConfig, error) {
+	vpcID, err := VpcID(ctx, vpcName)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	subnets, err := VpcSubnets(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if len(subnets) == 0 {
+		err := fmt.Errorf("no subnets for vpc: %s", vpcName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	// lambdas prefer private subnets, since lambda network interfaces never get public ips
+	vpcConfig := &lambda.VpcConfig{}
+	for _, subnet := range subnets {
+		if vpcSubnetTier(subnet.Tags) == vpcSubnetPrivate {
+			vpcConfig.SubnetIds = append(vpcConfig.SubnetIds, subnet.SubnetId)
+		}
+	}
+	if len(vpcConfig.SubnetIds) == 0 {
+		for _, subnet := range subnets {
+			vpcConfig.SubnetIds = append(vpcConfig.SubnetIds, subnet.SubnetId)
+		}
+	}
+	if len(sgNames) == 0 {
+		sgNames = []string{"default"}
+	}
+	for _, sgName := range sgNames {
+		sgID, err := EC2SgID(ctx, vpcName, sgName)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		vpcConfig.SecurityGroupIds = append(vpcConfig.SecurityGroupIds, aws.String(sgID))
+	}
+	return vpcConfig, nil
+}
+
+func lambdaUpdateZipGo(infraLambda *InfraLambda) error {
+	return lambdaCreateZipGo(infraLambda)
+}
+
+func lambdaCreateZipGo(infraLambda *InfraLambda) error {
 	if doDebug {
-		d := &Debug{start: time.Now(), name: "lambdaCreateZipPy"}
+		d := &Debug{start: time.Now(), name: "lambdaCreateZipGo"}
 		defer d.Log()
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
+	zipFile := lambdaZipFile(infraLambda)
 	dir := path.Dir(zipFile)
 	err := os.RemoveAll(dir)
 	if err != nil {
@@ -1950,54 +3003,174 @@ func lambdaCreateZipPy(infraLambda *InfraLambda) error {
 		return err
 	}
 	_ = os.MkdirAll(dir, os.ModePerm)
-	err = shell("virtualenv --python python3 %s/env", dir)
+	arch := infraLambda.arch
+	if arch == "" {
+		arch = lambdaAttrArchDefault
+	}
+	// trimpath and no vcs stamping so the binary depends only on source
+	err = shellAt(path.Dir(infraLambda.Entrypoint), "CGO_ENABLED=0 GOOS=linux GOARCH="+arch+" go build -trimpath -buildvcs=false -ldflags='-s -w %s' -tags 'netgo osusergo' -o %s %s", os.Getenv("LDFLAGS"), path.Join(dir, "main"), path.Base(infraLambda.Entrypoint))
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	zipBuilder := NewZipBuilder()
+	err = zipBuilder.AddFile("main", path.Join(dir, "main"))
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = zipBuilder.WriteFile(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+// packaging tools and metadata that are not needed at runtime
+var lambdaZipPySkip = []string{"wheel", "pip", "setuptools", "pkg_resources", "easy_install.py", "_distutils_hack", "distutils-precedence.pth", "bin"}
+
+func lambdaZipPySkipFn(rel string, info os.FileInfo) bool {
+	base := path.Base(filepath.ToSlash(rel))
+	if base == "__pycache__" || strings.HasSuffix(base, ".pyc") {
+		return true
+	}
+	if !strings.Contains(filepath.ToSlash(rel), "/") {
+		return Contains(lambdaZipPySkip, base) || (strings.HasSuffix(base, "info") && !strings.Contains(base, " "))
+	}
+	return false
+}
+
+func lambdaCreateZipPy(infraLambda *InfraLambda) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaCreateZipPy"}
+		defer d.Log()
+	}
+	zipFile := lambdaZipFile(infraLambda)
+	dir := path.Dir(zipFile)
+	err := os.RemoveAll(dir)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
+	sitePackages := path.Join(dir, "site-packages")
+	_ = os.MkdirAll(sitePackages, os.ModePerm)
 	if len(infraLambda.Require) > 0 {
 		var args []string
 		for _, require := range infraLambda.Require {
 			args = append(args, fmt.Sprintf(`"%s"`, require))
 		}
 		arg := strings.Join(args, " ")
-		err := shell("%s/env/bin/pip install %s", dir, arg)
+		if infraLambda.arch == lambdaArchArm64 {
+			// cross install binary wheels built for graviton
+			pythonVersion := strings.TrimPrefix(lambdaRuntimePython, "python")
+			err = shell("python3 -m pip install --no-compile --platform manylinux2014_aarch64 --implementation cp --python-version %s --only-binary=:all: --target %s %s", pythonVersion, sitePackages, arg)
+		} else {
+			err = shell("python3 -m pip install --no-compile --target %s %s", sitePackages, arg)
+		}
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
 	}
-	site_packages, err := filepath.Glob(fmt.Sprintf("%s/env/lib/python3*/site-packages", dir))
+	zipBuilder := NewZipBuilder()
+	err = zipBuilder.AddDir("", sitePackages, lambdaZipPySkipFn)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = zipBuilder.AddFile(path.Base(infraLambda.Entrypoint), infraLambda.Entrypoint)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = zipBuilder.WriteFile(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+// entrypoint.mjs bundles to an es module, everything else to commonjs
+func lambdaNodeBundleName(entrypoint string) string {
+	ext := path.Ext(entrypoint)
+	name := strings.TrimSuffix(path.Base(entrypoint), ext)
+	if ext == ".mjs" {
+		return name + ".mjs"
+	}
+	return name + ".js"
+}
+
+func lambdaNodeBundle(infraLambda *InfraLambda) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaNodeBundle"}
+		defer d.Log()
+	}
+	dir := path.Dir(lambdaZipFile(infraLambda))
+	bundle := path.Join(dir, "bundle", lambdaNodeBundleName(infraLambda.Entrypoint))
+	format := "cjs"
+	banner := ""
+	if path.Ext(bundle) == ".mjs" {
+		// bundled commonjs dependencies still expect require to exist
+		format = "esm"
+		banner = `--banner:js='import { createRequire } from "module"; const require = createRequire(import.meta.url);'`
+	}
+	err := shellAt(path.Dir(infraLambda.Entrypoint), "NODE_PATH=%s %s %s --bundle --platform=node --target=node20 --format=%s --legal-comments=none --outfile=%s %s",
+		path.Join(dir, "node_modules"),
+		path.Join(dir, "node_modules/.bin/esbuild"),
+		path.Base(infraLambda.Entrypoint),
+		format,
+		bundle,
+		banner,
+	)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	if len(site_packages) != 1 {
-		err := fmt.Errorf("expected 1 site-package dir: %v", site_packages)
+	return nil
+}
+
+func lambdaCreateZipNode(infraLambda *InfraLambda) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaCreateZipNode"}
+		defer d.Log()
+	}
+	zipFile := lambdaZipFile(infraLambda)
+	dir := path.Dir(zipFile)
+	err := os.RemoveAll(dir)
+	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	site_package := site_packages[0]
-	err = shellAt(site_package, "cp %s .", infraLambda.Entrypoint)
+	_ = os.MkdirAll(path.Join(dir, "bundle"), os.ModePerm)
+	err = os.WriteFile(path.Join(dir, "package.json"), []byte(`{"private": true}`+"\n"), 0644)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	err = shellAt(site_package, "rm -rf wheel pip setuptools pkg_resources easy_install.py")
+	args := []string{"esbuild@" + lambdaEsbuildVersion}
+	for _, require := range infraLambda.Require {
+		args = append(args, fmt.Sprintf(`"%s"`, require))
+	}
+	err = shellAt(dir, "npm install --no-audit --no-fund --omit=dev %s", strings.Join(args, " "))
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	err = shellAt(site_package, "ls | grep -E 'info$' | grep -v ' ' | xargs rm -rf")
+	err = lambdaNodeBundle(infraLambda)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	compression := "-9"
-	if os.Getenv("ZIP_COMPRESSION") != "" {
-		compression = "-" + os.Getenv("ZIP_COMPRESSION")
+	bundleName := lambdaNodeBundleName(infraLambda.Entrypoint)
+	zipBuilder := NewZipBuilder()
+	err = zipBuilder.AddFile(bundleName, path.Join(dir, "bundle", bundleName))
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
 	}
-	err = shellAt(site_package, "zip %s -r %s .", compression, zipFile)
+	err = zipBuilder.WriteFile(zipFile)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
@@ -2010,7 +3183,7 @@ func LambdaZipBytes(infraLambda *InfraLambda) ([]byte, error) {
 		d := &Debug{start: time.Now(), name: "LambdaZipBytes"}
 		defer d.Log()
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
+	zipFile := lambdaZipFile(infraLambda)
 	data, err := os.ReadFile(zipFile)
 	if err != nil {
 		Logger.Println("error:", err)
@@ -2024,7 +3197,10 @@ func LambdaIncludeInZip(infraLambda *InfraLambda) error {
 		d := &Debug{start: time.Now(), name: "LambdaIncludeInZip"}
 		defer d.Log()
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
+	if len(infraLambda.Include) == 0 {
+		return nil
+	}
+	zipFile := lambdaZipFile(infraLambda)
 	dir := infraLambda.dir
 	var includes []string
 	for _, include := range infraLambda.Include {
@@ -2045,27 +3221,52 @@ func LambdaIncludeInZip(infraLambda *InfraLambda) error {
 			}
 		}
 	}
+	zipBuilder := NewZipBuilder()
+	err := zipBuilder.AddZip(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
 	for _, include := range includes {
-		_, errReadlink := os.Readlink(include)
-		if !Exists(include) && errReadlink != nil {
+		pth := include
+		if !strings.HasPrefix(include, "/") {
+			pth = path.Join(dir, include)
+		}
+		_, errReadlink := os.Readlink(pth)
+		if !Exists(pth) && errReadlink != nil {
 			err := fmt.Errorf("no such path for include: %s", include)
 			Logger.Println("error:", err)
 			return err
 		}
-		args := ""
 		if strings.HasPrefix(include, "/") {
-			args = "--junk-paths"
-		}
-		compression := "-9"
-		if os.Getenv("ZIP_COMPRESSION") != "" {
-			compression = "-" + os.Getenv("ZIP_COMPRESSION")
+			// like zip --junk-paths, every file under an absolute path is added at the root
+			err := filepath.Walk(pth, func(pth string, info os.FileInfo, err error) error {
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+				if info.IsDir() {
+					return nil
+				}
+				return zipBuilder.AddFile(path.Base(pth), pth)
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			continue
 		}
-		err := shellAt(dir, "zip %s %s --symlinks -r %s '%s'", compression, args, zipFile, include)
+		err := zipBuilder.AddFile(path.Clean(include), pth)
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
 	}
+	err = zipBuilder.WriteFile(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
 	return nil
 }
 
@@ -2074,29 +3275,56 @@ func lambdaUpdateZipPy(infraLambda *InfraLambda) error {
 		d := &Debug{start: time.Now(), name: "lambdaUpdateZipPy"}
 		defer d.Log()
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
-	dir := path.Dir(zipFile)
-	site_packages, err := filepath.Glob(fmt.Sprintf("%s/env/lib/python3*/site-packages", dir))
+	zipFile := lambdaZipFile(infraLambda)
+	zipBuilder := NewZipBuilder()
+	err := zipBuilder.AddZip(zipFile)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	if len(site_packages) != 1 {
-		err := fmt.Errorf("expected 1 site-package dir: %v", site_packages)
+	err = zipBuilder.AddFile(path.Base(infraLambda.Entrypoint), infraLambda.Entrypoint)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = zipBuilder.WriteFile(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	Logger.Println("updated zip:", zipFile, infraLambda.Entrypoint)
+	return nil
+}
+
+// rebundle only the handler, reusing node_modules from the last full build
+func lambdaUpdateZipNode(infraLambda *InfraLambda) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaUpdateZipNode"}
+		defer d.Log()
+	}
+	zipFile := lambdaZipFile(infraLambda)
+	dir := path.Dir(zipFile)
+	if !Exists(path.Join(dir, "node_modules/.bin/esbuild")) {
+		return lambdaCreateZipNode(infraLambda)
+	}
+	err := lambdaNodeBundle(infraLambda)
+	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	site_package := site_packages[0]
-	err = shellAt(site_package, "cp %s .", infraLambda.Entrypoint)
+	bundleName := lambdaNodeBundleName(infraLambda.Entrypoint)
+	zipBuilder := NewZipBuilder()
+	err = zipBuilder.AddZip(zipFile)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	compression := "-9"
-	if os.Getenv("ZIP_COMPRESSION") != "" {
-		compression = "-" + os.Getenv("ZIP_COMPRESSION")
+	err = zipBuilder.AddFile(bundleName, path.Join(dir, "bundle", bundleName))
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
 	}
-	err = shellAt(site_package, "zip %s %s %s", compression, zipFile, path.Base(infraLambda.Entrypoint))
+	err = zipBuilder.WriteFile(zipFile)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
@@ -2129,6 +3357,126 @@ func LambdaListFunctions(ctx context.Context) ([]*lambda.FunctionConfiguration,
 	return functions, nil
 }
 
+// Dockerfile or NAME.Dockerfile
+func lambdaIsDockerfile(entrypoint string) bool {
+	base := path.Base(entrypoint)
+	return base == "Dockerfile" || strings.HasSuffix(base, ".Dockerfile")
+}
+
+// the dockerfile and build context for an entrypoint that is a Dockerfile or a directory containing one
+func lambdaDockerfile(entrypoint string) (string, string, bool) {
+	if strings.Contains(entrypoint, ".dkr.ecr.") {
+		return "", "", false
+	}
+	if lambdaIsDockerfile(entrypoint) {
+		return entrypoint, path.Dir(entrypoint), true
+	}
+	info, err := os.Stat(entrypoint)
+	if err == nil && info.IsDir() {
+		return path.Join(entrypoint, "Dockerfile"), entrypoint, true
+	}
+	return "", "", false
+}
+
+// build the dockerfile entrypoint, push it to an ecr repo named after the
+// lambda, and pin the lambda to the pushed digest
+func lambdaEnsureImage(ctx context.Context, infraLambda *InfraLambda, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaEnsureImage"}
+		defer d.Log()
+	}
+	dockerfile, contextDir, ok := lambdaDockerfile(infraLambda.Entrypoint)
+	if !ok {
+		err := fmt.Errorf("not a dockerfile entrypoint: %s", infraLambda.Entrypoint)
+		Logger.Println("error:", err)
+		return err
+	}
+	if !Exists(dockerfile) {
+		err := fmt.Errorf("no such dockerfile: %s", dockerfile)
+		Logger.Println("error:", err)
+		return err
+	}
+	arch := infraLambda.arch
+	if arch == "" {
+		arch = lambdaAttrArchDefault
+	}
+	err := EcrEnsure(ctx, infraLambda.Name, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	registry, err := EcrUrl(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	repo := registry + "/" + infraLambda.Name
+	if preview {
+		// building can take minutes and pushing changes the registry, so only say what would happen
+		Logger.Println(PreviewString(preview)+"built image:", dockerfile)
+		Logger.Println(PreviewString(preview)+"pushed image:", repo)
+		infraLambda.image = repo + ":preview"
+		return nil
+	}
+	dir := path.Dir(lambdaZipFile(infraLambda))
+	_ = os.MkdirAll(dir, os.ModePerm)
+	iidFile := path.Join(dir, "image.id")
+	// lambda rejects image indexes, so skip the attestations buildx adds by default
+	err = shellAt(contextDir, "BUILDX_NO_DEFAULT_ATTESTATIONS=1 %s build --platform linux/%s --iidfile %s -f %s .", EcrBuilder(), arch, iidFile, dockerfile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	data, err := os.ReadFile(iidFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	imageID := strings.TrimPrefix(strings.TrimSpace(string(data)), "sha256:")
+	if len(imageID) < 16 {
+		err := fmt.Errorf("unexpected image id: %s", data)
+		Logger.Println("error:", err)
+		return err
+	}
+	tag := imageID[:16]
+	digest, err := EcrImageDigest(ctx, infraLambda.Name, tag)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if digest == "" {
+		if !preview {
+			err := EcrLogin(ctx)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			err = shell("%s tag sha256:%s %s:%s && %s push %s:%s", EcrBuilder(), imageID, repo, tag, EcrBuilder(), repo, tag)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			digest, err = EcrImageDigest(ctx, infraLambda.Name, tag)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			if digest == "" {
+				err := fmt.Errorf("pushed image not found: %s:%s", repo, tag)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"pushed image:", repo+":"+tag)
+	}
+	if digest == "" {
+		infraLambda.image = repo + ":" + tag // preview of an image not yet pushed
+	} else {
+		infraLambda.image = repo + "@" + digest
+	}
+	return nil
+}
+
 type LambdaUpdateZipFn func(infraLambda *InfraLambda) error
 
 type LambdaCreateZipFn func(infraLambda *InfraLambda) error
@@ -2143,6 +3491,16 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 	memory := lambdaAttrMemoryDefault
 	timeout := lambdaAttrTimeoutDefault
 	logsTTLDays := lambdaAttrLogsTTLDaysDefault
+	arch := lambdaAttrArchDefault
+	vpcName := ""
+	var sgNames []string
+	alias := ""
+	canary := lambdaAttrCanaryDefault
+	bake := lambdaAttrBakeDefault
+	retry := lambdaAttrRetryDefault
+	maxEventAge := lambdaAttrMaxEventAgeDefault
+	onFailure := ""
+	onSuccess := ""
 	for _, attr := range infraLambda.Attr {
 		k, v, err := SplitOnce(attr, "=")
 		if err != nil {
@@ -2158,14 +3516,43 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 			timeout = Atoi(v)
 		case lambdaAttrLogsTTLDays:
 			logsTTLDays = Atoi(v)
+		case lambdaAttrArch:
+			arch = v
+		case lambdaAttrVpc:
+			vpcName = v
+		case lambdaAttrSg:
+			sgNames = append(sgNames, v)
+		case lambdaAttrAlias:
+			alias = v
+		case lambdaAttrCanary:
+			canary = Atoi(v)
+		case lambdaAttrBake:
+			bake = Atoi(v)
+		case lambdaAttrRetry:
+			retry = Atoi(v)
+		case lambdaAttrMaxEventAge:
+			maxEventAge = Atoi(v)
+		case lambdaAttrOnFailure:
+			onFailure = v
+		case lambdaAttrOnSuccess:
+			onSuccess = v
 		default:
 			err := fmt.Errorf("unknown attr: %s", k)
 			Logger.Println("error:", err)
 			return err
 		}
 	}
-	zipFile := LambdaZipFile(infraLambda.Name)
-	if quick && !(infraLambda.runtime == lambdaRuntimePython && !Exists(zipFile)) { // python requires existing zip for quick, since it only adds source instead of rebuilding the virtualenv, which is way faster
+	infraLambda.arch = arch
+	infraLambda.alias = alias
+	if _, _, ok := lambdaDockerfile(infraLambda.Entrypoint); ok {
+		err := lambdaEnsureImage(ctx, infraLambda, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	zipFile := lambdaZipFile(infraLambda)
+	if quick && !((infraLambda.runtime == lambdaRuntimePython || infraLambda.runtime == lambdaRuntimeNode) && !Exists(zipFile)) { // python and node require existing zip for quick, since they only add source instead of reinstalling requires, which is way faster
 		err := updateZipFn(infraLambda)
 		if err != nil {
 			Logger.Println("error:", err)
@@ -2181,6 +3568,13 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 			Logger.Println("error:", err)
 			return err
 		}
+		if alias != "" {
+			_, err := lambdaEnsureAlias(ctx, infraLambda, canary, bake, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
 		return nil
 	}
 	err = LogsEnsureGroup(ctx, infraLambda.infraSetName, "/aws/lambda/"+infraLambda.Name, logsTTLDays, preview)
@@ -2193,7 +3587,30 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		Logger.Println("error:", err)
 		return err
 	}
-	err = IamEnsureRolePolicies(ctx, infraLambda.Name, infraLambda.Policy, preview)
+	policies := append([]string{}, infraLambda.Policy...)
+	if vpcName != "" && !Contains(policies, lambdaVpcPolicy) {
+		policies = append(policies, lambdaVpcPolicy)
+	}
+	// lambda needs the vpc policy to remove its network interfaces when the
+	// function leaves the vpc, so it is detached only after the update below
+	detachVpcPolicy := false
+	if !Contains(policies, lambdaVpcPolicy) {
+		attached, err := IamListRolePolicies(ctx, infraLambda.Name)
+		if err != nil && !preview {
+			Logger.Println("error:", err)
+			return err
+		}
+		for _, policy := range attached {
+			if *policy.PolicyName == lambdaVpcPolicy {
+				detachVpcPolicy = true
+			}
+		}
+	}
+	if detachVpcPolicy {
+		err = IamEnsureRolePolicies(ctx, infraLambda.Name, append(append([]string{}, policies...), lambdaVpcPolicy), preview)
+	} else {
+		err = IamEnsureRolePolicies(ctx, infraLambda.Name, policies, preview)
+	}
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
@@ -2244,13 +3661,14 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		return err
 	}
 	createInput := &lambda.CreateFunctionInput{
-		FunctionName: aws.String(infraLambda.Name),
-		Timeout:      aws.Int64(int64(timeout)),
-		MemorySize:   aws.Int64(int64(memory)),
-		Role:         aws.String(arnRole),
-		Code:         &lambda.FunctionCode{},
-		Environment:  &lambda.Environment{Variables: make(map[string]*string)},
-		Tags:         map[string]*string{infraSetTagName: aws.String(infraLambda.infraSetName)},
+		FunctionName:  aws.String(infraLambda.Name),
+		Timeout:       aws.Int64(int64(timeout)),
+		MemorySize:    aws.Int64(int64(memory)),
+		Role:          aws.String(arnRole),
+		Code:          &lambda.FunctionCode{},
+		Architectures: []*string{aws.String(lambdaArchitecture(arch))},
+		Environment:   &lambda.Environment{Variables: make(map[string]*string)},
+		Tags:          map[string]*string{infraSetTagName: aws.String(infraLambda.infraSetName)},
 	}
 	for _, val := range infraLambda.Env {
 		k, v, err := SplitOnce(val, "=")
@@ -2266,7 +3684,7 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		createInput.Environment.Variables[k] = aws.String(v)
 	}
 	if infraLambda.runtime == lambdaRuntimeContainer {
-		createInput.Code.ImageUri = aws.String(infraLambda.Entrypoint)
+		createInput.Code.ImageUri = aws.String(infraLambda.imageUri())
 		createInput.PackageType = aws.String(lambda.PackageTypeImage)
 	} else {
 		createInput.Code.ZipFile = zipBytes
@@ -2274,6 +3692,27 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		createInput.Runtime = aws.String(infraLambda.runtime)
 		createInput.Handler = aws.String(infraLambda.handler)
 	}
+	sgAttrNames, err := lambdaSgNames(ctx, sgNames)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	vpcAttrName, err := lambdaVpcName(ctx, vpcName)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	vpcAttrs := lambdaVpcAttrsFromNames(vpcAttrName, sgAttrNames)
+	if vpcName != "" {
+		createInput.VpcConfig, err = lambdaVpcConfig(ctx, vpcName, sgNames)
+		if err != nil {
+			if !preview {
+				Logger.Println("error:", err)
+				return err
+			}
+			createInput.VpcConfig = nil // the vpc or its security groups may not exist until ensure
+		}
+	}
 	if expectedErr != nil { // create lambda
 		if infraLambda.runtime == lambdaRuntimeContainer {
 			existing := map[string]*string{}
@@ -2325,6 +3764,10 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		}
 		Logger.Printf(PreviewString(preview)+"update timeout: %d => %d\n", 0, timeout)
 		Logger.Printf(PreviewString(preview)+"update memory: %d => %d\n", 0, memory)
+		Logger.Printf(PreviewString(preview)+"update arch: %s => %s\n", "", arch)
+		if vpcName != "" {
+			Logger.Printf(PreviewString(preview)+"update vpc: %s => %s\n", "", strings.Join(vpcAttrs, " "))
+		}
 		Logger.Println(PreviewString(preview) + "created function: " + infraLambda.Name)
 	} else { // update lambda
 		var diff bool
@@ -2364,6 +3807,12 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 				return err
 			}
 		}
+		// architecture can only be changed alongside the code
+		existingArch := lambdaArch(getFunctionOut.Configuration.Architectures)
+		if existingArch != arch {
+			diff = true
+			Logger.Printf(PreviewString(preview)+"update arch: %s => %s\n", existingArch, arch)
+		}
 		if diff {
 			err := LambdaUpdateFunctionCode(ctx, infraLambda, preview)
 			if err != nil {
@@ -2407,15 +3856,50 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 			needsUpdate = true
 			Logger.Printf(PreviewString(preview)+"update memory: %d => %d\n", *out.MemorySize, memory)
 		}
+		runtimeDiff := infraLambda.runtime != lambdaRuntimeContainer && (aws.StringValue(out.Runtime) != infraLambda.runtime || aws.StringValue(out.Handler) != infraLambda.handler)
+		if runtimeDiff {
+			needsUpdate = true
+			Logger.Printf(PreviewString(preview)+"update runtime: %s %s => %s %s\n", aws.StringValue(out.Runtime), aws.StringValue(out.Handler), infraLambda.runtime, infraLambda.handler)
+		}
+		existingVpcAttrs, err := lambdaVpcAttrs(ctx, out.VpcConfig)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		vpcDiff := !reflect.DeepEqual(existingVpcAttrs, vpcAttrs)
+		if vpcDiff {
+			needsUpdate = true
+			Logger.Printf(PreviewString(preview)+"update vpc: %s => %s\n", strings.Join(existingVpcAttrs, " "), strings.Join(vpcAttrs, " "))
+		} else if createInput.VpcConfig != nil && out.VpcConfig != nil {
+			// subnets follow the vpc, like private subnets added after the lambda
+			existingSubnets := lambdaSortedIDs(out.VpcConfig.SubnetIds)
+			subnets := lambdaSortedIDs(createInput.VpcConfig.SubnetIds)
+			if !reflect.DeepEqual(existingSubnets, subnets) {
+				vpcDiff = true
+				needsUpdate = true
+				Logger.Printf(PreviewString(preview)+"update vpc subnets: %s => %s\n", strings.Join(existingSubnets, " "), strings.Join(subnets, " "))
+			}
+		}
 		if needsUpdate {
 			if !preview {
+				updateInput := &lambda.UpdateFunctionConfigurationInput{
+					FunctionName: aws.String(infraLambda.Name),
+					Timeout:      aws.Int64(int64(timeout)),
+					MemorySize:   aws.Int64(int64(memory)),
+					Environment:  createInput.Environment,
+				}
+				if runtimeDiff {
+					updateInput.Runtime = aws.String(infraLambda.runtime)
+					updateInput.Handler = aws.String(infraLambda.handler)
+				}
+				if vpcDiff {
+					updateInput.VpcConfig = createInput.VpcConfig
+					if updateInput.VpcConfig == nil { // detach from vpc
+						updateInput.VpcConfig = &lambda.VpcConfig{SubnetIds: []*string{}, SecurityGroupIds: []*string{}}
+					}
+				}
 				err = Retry(ctx, func() error {
-					_, err = LambdaClient().UpdateFunctionConfigurationWithContext(ctx, &lambda.UpdateFunctionConfigurationInput{
-						FunctionName: aws.String(infraLambda.Name),
-						Timeout:      aws.Int64(int64(timeout)),
-						MemorySize:   aws.Int64(int64(memory)),
-						Environment:  createInput.Environment,
-					})
+					_, err = LambdaClient().UpdateFunctionConfigurationWithContext(ctx, updateInput)
 					return err
 				})
 				if err != nil {
@@ -2426,9 +3910,56 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 			Logger.Println(PreviewString(preview)+"updated function configuration:", infraLambda.Name)
 		}
 	}
+	if detachVpcPolicy {
+		if !preview {
+			// the vpc config change must finish before the policy goes
+			err := LambdaClient().WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+				FunctionName: aws.String(infraLambda.Name),
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		err = IamEnsureRolePolicies(ctx, infraLambda.Name, policies, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
 	if getFunctionOut.Configuration != nil {
 		infraLambda.Arn = *getFunctionOut.Configuration.FunctionArn
 	}
+	if alias != "" {
+		unqualifiedArn := infraLambda.Arn
+		aliasArn, err := lambdaEnsureAlias(ctx, infraLambda, canary, bake, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if aliasArn != "" {
+			infraLambda.Arn = aliasArn // triggers invoke the alias instead of $LATEST
+		}
+		err = lambdaPruneVersions(ctx, infraLambda.Name, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if unqualifiedArn != "" {
+			err = lambdaRemoveUnqualifiedTriggers(ctx, infraLambda.Name, unqualifiedArn, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	if getFunctionOut.Configuration != nil {
+		err = lambdaRemoveStaleAliasTriggers(ctx, infraLambda, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
 	err = LambdaEnsureTriggerDynamoDB(ctx, infraLambda, preview)
 	if err != nil {
 		Logger.Println("error:", err)
@@ -2452,79 +3983,654 @@ func lambdaEnsure(ctx context.Context, infraLambda *InfraLambda, quick, preview,
 		Logger.Println("error:", err)
 		return err
 	}
-	// ensure role allows after api trigger because it defines $API_ID and WEBSOCKET_ID
-	err = IamEnsureRoleAllows(ctx, infraLambda.Name, infraLambda.Allow, preview)
-	if err != nil {
-		Logger.Println("error:", err)
-		return err
+	allows := append([]string{}, infraLambda.Allow...)
+	for _, dest := range []string{onFailure, onSuccess} {
+		if dest == "" {
+			continue
+		}
+		account, err := StsAccount(ctx)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		_, allow, err := lambdaDestinationArn(account, Region(), dest)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if !Contains(allows, allow) {
+			allows = append(allows, allow)
+		}
+	}
+	// ensure role allows after api trigger because it defines $API_ID and WEBSOCKET_ID
+	err = IamEnsureRoleAllows(ctx, infraLambda.Name, allows, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	permissionSids = append(permissionSids, sids...)
+	sids, err = LambdaEnsureTriggerS3(ctx, infraLambda, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	permissionSids = append(permissionSids, sids...)
+	err = LambdaEnsureTriggerSQS(ctx, infraLambda, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	sids, err = LambdaEnsureTriggerSNS(ctx, infraLambda, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	permissionSids = append(permissionSids, sids...)
+	sids, err = LambdaEnsureTriggerUrl(ctx, infraLambda, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	permissionSids = append(permissionSids, sids...)
+	err = LambdaSetConcurrency(ctx, infraLambda.Name, concurrency, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = LambdaEnsureEventInvokeConfig(ctx, infraLambda, retry, maxEventAge, onFailure, onSuccess, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = lambdaRemoveUnusedPermissions(ctx, infraLambda.qualifiedName(), permissionSids, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+func LambdaUpdateFunctionCode(ctx context.Context, infraLambda *InfraLambda, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaUpdateFunctionCode"}
+		defer d.Log()
+	}
+	if !preview {
+		var expectedErr error
+		err := Retry(ctx, func() error {
+			updateInput := &lambda.UpdateFunctionCodeInput{
+				FunctionName: aws.String(infraLambda.Name),
+			}
+			if infraLambda.arch != "" {
+				updateInput.Architectures = []*string{aws.String(lambdaArchitecture(infraLambda.arch))}
+			}
+			if infraLambda.runtime == lambdaRuntimeContainer {
+				updateInput.ImageUri = aws.String(infraLambda.imageUri())
+			} else {
+				zipBytes, err := LambdaZipBytes(infraLambda)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+				updateInput.ZipFile = zipBytes
+			}
+			_, err := LambdaClient().UpdateFunctionCodeWithContext(ctx, updateInput)
+			if err != nil {
+				aerr, ok := err.(awserr.Error)
+				if !ok || !(aerr.Code() == lambda.ErrCodeResourceNotFoundException || aerr.Code() == "RequestEntityTooLargeException") {
+					return err
+				}
+				expectedErr = err
+				return nil
+			}
+			return nil
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if expectedErr != nil {
+			Logger.Println("error:", expectedErr)
+			return expectedErr
+		}
+	}
+	Logger.Println(PreviewString(preview) + "lambda updated code for: " + infraLambda.Name)
+	return nil
+}
+
+// the image to deploy, which is the pushed digest for dockerfile entrypoints
+func (l *InfraLambda) imageUri() string {
+	if l.image != "" {
+		return l.image
+	}
+	return l.Entrypoint
+}
+
+// the function name, qualified with the alias when triggers should invoke an alias
+func (l *InfraLambda) qualifiedName() string {
+	if l.alias == "" {
+		return l.Name
+	}
+	return l.Name + ":" + l.alias
+}
+
+// true if two lambda arns name the same function, ignoring any version or alias qualifier
+func lambdaSameFunction(a, b string) bool {
+	partsA := strings.Split(a, ":")
+	partsB := strings.Split(b, ":")
+	if len(partsA) < 7 || len(partsB) < 7 {
+		return false
+	}
+	return strings.Join(partsA[:7], ":") == strings.Join(partsB[:7], ":")
+}
+
+// repoint a rule target at a different qualifier of the same function
+func lambdaUpdateRuleTarget(ctx context.Context, ruleName string, target *cloudwatchevents.Target, arn string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaUpdateRuleTarget"}
+		defer d.Log()
+	}
+	if !preview {
+		_, err := EventsClient().PutTargetsWithContext(ctx, &cloudwatchevents.PutTargetsInput{
+			Rule: aws.String(ruleName),
+			Targets: []*cloudwatchevents.Target{{
+				Id:  target.Id,
+				Arn: aws.String(arn),
+			}},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"updated rule target:", ruleName, *target.Arn, "=>", arn)
+	return nil
+}
+
+const lambdaAliasDescriptionPrefix = "libaws:"
+
+// aliases managed by infra-ensure record the version they replaced and their
+// canary settings in their description, since aliases cannot be tagged
+func lambdaAliasDescription(previous string, canary, bake int) string {
+	return fmt.Sprintf("%s previous=%s canary=%d bake=%d", lambdaAliasDescriptionPrefix, previous, canary, bake)
+}
+
+func lambdaParseAliasDescription(description *string) (previous string, canary, bake int, ok bool) {
+	canary = lambdaAttrCanaryDefault
+	bake = lambdaAttrBakeDefault
+	if description == nil || !strings.HasPrefix(*description, lambdaAliasDescriptionPrefix) {
+		return "", canary, bake, false
+	}
+	for _, part := range strings.Fields(strings.TrimPrefix(*description, lambdaAliasDescriptionPrefix)) {
+		k, v, err := SplitOnce(part, "=")
+		if err != nil {
+			continue
+		}
+		switch k {
+		case "previous":
+			previous = v
+		case lambdaAttrCanary:
+			canary = Atoi(v)
+		case lambdaAttrBake:
+			bake = Atoi(v)
+		}
+	}
+	return previous, canary, bake, true
+}
+
+func lambdaUpdateAlias(ctx context.Context, name, aliasName, version, canaryVersion string, canary int, description string) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaUpdateAlias"}
+		defer d.Log()
+	}
+	routing := &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]*float64{}}
+	if canaryVersion != "" {
+		routing.AdditionalVersionWeights[canaryVersion] = aws.Float64(float64(canary) / 100)
+	}
+	err := Retry(ctx, func() error {
+		_, err := LambdaClient().UpdateAliasWithContext(ctx, &lambda.UpdateAliasInput{
+			FunctionName:    aws.String(name),
+			Name:            aws.String(aliasName),
+			FunctionVersion: aws.String(version),
+			RoutingConfig:   routing,
+			Description:     aws.String(description),
+		})
+		return err
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+func lambdaGetAlias(ctx context.Context, name, aliasName string) (*lambda.AliasConfiguration, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaGetAlias"}
+		defer d.Log()
+	}
+	var alias *lambda.AliasConfiguration
+	err := Retry(ctx, func() error {
+		out, err := LambdaClient().GetAliasWithContext(ctx, &lambda.GetAliasInput{
+			FunctionName: aws.String(name),
+			Name:         aws.String(aliasName),
+		})
+		if err != nil {
+			aerr, ok := err.(awserr.Error)
+			if ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+				return nil
+			}
+			return err
+		}
+		alias = out
+		return nil
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return alias, nil
+}
+
+// publish a version and move the alias to it. with canary > 0, first shift
+// that percent of traffic to the new version, then promote it if it reports
+// no errors for the bake period, otherwise roll back and return an error.
+func lambdaEnsureAlias(ctx context.Context, infraLambda *InfraLambda, canary, bake int, preview bool) (string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaEnsureAlias"}
+		defer d.Log()
+	}
+	name := infraLambda.Name
+	aliasName := infraLambda.alias
+	alias, err := lambdaGetAlias(ctx, name, aliasName)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if preview {
+		if alias == nil {
+			Logger.Println(PreviewString(preview)+"created alias:", name, aliasName)
+			return "", nil
+		}
+		return *alias.AliasArn, nil
+	}
+	var version string
+	err = RetryAttempts(ctx, 12, func() error { // publish fails while a code or configuration update is in progress
+		out, err := LambdaClient().PublishVersionWithContext(ctx, &lambda.PublishVersionInput{
+			FunctionName: aws.String(name),
+		})
+		if err != nil {
+			return err
+		}
+		version = *out.Version
+		return nil
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if alias == nil {
+		var aliasArn string
+		err := Retry(ctx, func() error {
+			out, err := LambdaClient().CreateAliasWithContext(ctx, &lambda.CreateAliasInput{
+				FunctionName:    aws.String(name),
+				Name:            aws.String(aliasName),
+				FunctionVersion: aws.String(version),
+				Description:     aws.String(lambdaAliasDescription("", canary, bake)),
+			})
+			if err != nil {
+				return err
+			}
+			aliasArn = *out.AliasArn
+			return nil
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		Logger.Println("created alias:", name, aliasName, version)
+		return aliasArn, nil
+	}
+	previous, _, _, _ := lambdaParseAliasDescription(alias.Description)
+	current := *alias.FunctionVersion
+	if current == version {
+		canaryInFlight := alias.RoutingConfig != nil && len(alias.RoutingConfig.AdditionalVersionWeights) > 0
+		description := lambdaAliasDescription(previous, canary, bake)
+		if canaryInFlight || alias.Description == nil || *alias.Description != description {
+			err := lambdaUpdateAlias(ctx, name, aliasName, version, "", 0, description)
+			if err != nil {
+				Logger.Println("error:", err)
+				return "", err
+			}
+			Logger.Println("updated alias:", name, aliasName, version)
+		}
+		return *alias.AliasArn, nil
+	}
+	if canary > 0 {
+		err := lambdaUpdateAlias(ctx, name, aliasName, current, version, canary, aws.StringValue(alias.Description))
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		Logger.Printf("shifted %d%% of alias %s:%s to version %s\n", canary, name, aliasName, version)
+		err = lambdaBakeVersion(ctx, name, aliasName, version, time.Duration(bake)*time.Second)
+		if err != nil {
+			rollbackErr := lambdaUpdateAlias(ctx, name, aliasName, current, "", 0, aws.StringValue(alias.Description))
+			if rollbackErr != nil {
+				Logger.Println("error:", rollbackErr)
+				return "", rollbackErr
+			}
+			Logger.Println("rolled back alias:", name, aliasName, version, "=>", current)
+			Logger.Println("error:", err)
+			return "", err
+		}
+	}
+	err = lambdaUpdateAlias(ctx, name, aliasName, version, "", 0, lambdaAliasDescription(current, canary, bake))
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	Logger.Println("updated alias:", name, aliasName, current, "=>", version)
+	return *alias.AliasArn, nil
+}
+
+// every alias deploy publishes a version, so delete versions that no alias
+// points at, routes canary traffic to, or records as its rollback target
+func lambdaPruneVersions(ctx context.Context, name string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaPruneVersions"}
+		defer d.Log()
+	}
+	keep := []string{"$LATEST"}
+	var marker *string
+	for {
+		out, err := LambdaClient().ListAliasesWithContext(ctx, &lambda.ListAliasesInput{
+			FunctionName: aws.String(name),
+			Marker:       marker,
+		})
+		if err != nil {
+			aerr, ok := err.(awserr.Error)
+			if preview && ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+				return nil
+			}
+			Logger.Println("error:", err)
+			return err
+		}
+		for _, alias := range out.Aliases {
+			keep = append(keep, *alias.FunctionVersion)
+			if alias.RoutingConfig != nil {
+				for version := range alias.RoutingConfig.AdditionalVersionWeights {
+					keep = append(keep, version)
+				}
+			}
+			previous, _, _, _ := lambdaParseAliasDescription(alias.Description)
+			if previous != "" {
+				keep = append(keep, previous)
+			}
+		}
+		if out.NextMarker == nil {
+			break
+		}
+		marker = out.NextMarker
+	}
+	marker = nil
+	for {
+		out, err := LambdaClient().ListVersionsByFunctionWithContext(ctx, &lambda.ListVersionsByFunctionInput{
+			FunctionName: aws.String(name),
+			Marker:       marker,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		for _, version := range out.Versions {
+			if Contains(keep, *version.Version) {
+				continue
+			}
+			if !preview {
+				err := Retry(ctx, func() error {
+					_, err := LambdaClient().DeleteFunctionWithContext(ctx, &lambda.DeleteFunctionInput{
+						FunctionName: aws.String(name),
+						Qualifier:    version.Version,
+					})
+					return err
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"deleted version:", name, *version.Version)
+		}
+		if out.NextMarker == nil {
+			break
+		}
+		marker = out.NextMarker
+	}
+	return nil
+}
+
+// wait for the bake period, failing as soon as the version executed through
+// the alias reports any errors
+func lambdaBakeVersion(ctx context.Context, name, aliasName, version string, bake time.Duration) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaBakeVersion"}
+		defer d.Log()
+	}
+	start := time.Now()
+	from := start.Add(-time.Minute) // datapoints are per minute
+	dimension := fmt.Sprintf("FunctionName=%s,Resource=%s:%s,ExecutedVersion=%s", name, name, aliasName, version)
+	for {
+		wait := time.Minute
+		if remaining := time.Until(start.Add(bake)); remaining < wait {
+			wait = remaining
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		now := time.Now()
+		results, err := CloudwatchGetMetricData(ctx, 60, "Sum", &from, &now, "AWS/Lambda", []string{"Errors"}, dimension)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		errorCount := 0.0
+		for _, result := range results {
+			for _, value := range result.Values {
+				errorCount += *value
+			}
+		}
+		if errorCount > 0 {
+			err := fmt.Errorf("canary version %s of %s reported %d errors", version, name, int(errorCount))
+			Logger.Println("error:", err)
+			return err
+		}
+		if !now.Before(start.Add(bake)) {
+			return nil
+		}
+		Logger.Printf("baking version %s of %s:%s, %s remaining\n", version, name, aliasName, time.Until(start.Add(bake)).Round(time.Second))
+	}
+}
+
+// once triggers invoke an alias, remove permissions and event source mappings
+// left on the unqualified function. s3 notifications and sns subscriptions of
+// the unqualified function are removed by LambdaEnsureTriggerS3 and
+// LambdaEnsureTriggerSNS, which drop those of every other qualifier.
+func lambdaRemoveUnqualifiedTriggers(ctx context.Context, name, unqualifiedArn string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaRemoveUnqualifiedTriggers"}
+		defer d.Log()
+	}
+	err := lambdaRemoveUnusedPermissions(ctx, name, nil, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = lambdaDeleteUrlConfig(ctx, name, "", preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	mappings, err := lambdaListEventSourceMappings(ctx, name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	for _, mapping := range mappings {
+		if *mapping.FunctionArn != unqualifiedArn {
+			continue
+		}
+		if !preview {
+			err := Retry(ctx, func() error {
+				_, err := LambdaClient().DeleteEventSourceMappingWithContext(ctx, &lambda.DeleteEventSourceMappingInput{
+					UUID: mapping.UUID,
+				})
+				return err
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"deleted unqualified event source mapping:", name, *mapping.EventSourceArn)
+	}
+	return nil
+}
+
+// when alias= is removed or renamed, triggers ensured on the old alias would keep
+// invoking it alongside the new ones, so remove them from every managed alias but
+// the current one. s3 notifications and sns subscriptions of old aliases are
+// removed by LambdaEnsureTriggerS3 and LambdaEnsureTriggerSNS.
+func lambdaRemoveStaleAliasTriggers(ctx context.Context, infraLambda *InfraLambda, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "lambdaRemoveStaleAliasTriggers"}
+		defer d.Log()
+	}
+	aliases, err := LambdaListManagedAliases(ctx, infraLambda.Name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	for _, alias := range aliases {
+		if *alias.Name == infraLambda.alias {
+			continue
+		}
+		qualifiedName := infraLambda.Name + ":" + *alias.Name
+		err := lambdaRemoveUnusedPermissions(ctx, qualifiedName, nil, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = lambdaDeleteUrlConfig(ctx, infraLambda.Name, *alias.Name, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		mappings, err := lambdaListEventSourceMappings(ctx, qualifiedName)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		for _, mapping := range mappings {
+			if *mapping.FunctionArn != *alias.AliasArn {
+				continue
+			}
+			if !preview {
+				err := Retry(ctx, func() error {
+					_, err := LambdaClient().DeleteEventSourceMappingWithContext(ctx, &lambda.DeleteEventSourceMappingInput{
+						UUID: mapping.UUID,
+					})
+					return err
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"deleted event source mapping of stale alias:", qualifiedName, *mapping.EventSourceArn)
+		}
+	}
+	return nil
+}
+
+// aliases managed by infra-ensure, see lambdaAliasDescription
+func LambdaListManagedAliases(ctx context.Context, name string) ([]*lambda.AliasConfiguration, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaListManagedAliases"}
+		defer d.Log()
+	}
+	var aliases []*lambda.AliasConfiguration
+	var marker *string
+	for {
+		out, err := LambdaClient().ListAliasesWithContext(ctx, &lambda.ListAliasesInput{
+			FunctionName: aws.String(name),
+			Marker:       marker,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, alias := range out.Aliases {
+			if _, _, _, ok := lambdaParseAliasDescription(alias.Description); ok {
+				aliases = append(aliases, alias)
+			}
+		}
+		if out.NextMarker == nil {
+			break
+		}
+		marker = out.NextMarker
+	}
+	return aliases, nil
+}
+
+// move an alias back to the version it pointed at before the last deploy
+func LambdaRollback(ctx context.Context, name, aliasName string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaRollback"}
+		defer d.Log()
 	}
-	permissionSids = append(permissionSids, sids...)
-	sids, err = LambdaEnsureTriggerS3(ctx, infraLambda, preview)
-	if err != nil {
-		Logger.Println("error:", err)
-		return err
+	if aliasName == "" {
+		aliases, err := LambdaListManagedAliases(ctx, name)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if len(aliases) != 1 {
+			err := fmt.Errorf("%s managed alias for lambda %s, specify one: %d", ErrPrefixDidntFindExactlyOne, name, len(aliases))
+			Logger.Println("error:", err)
+			return err
+		}
+		aliasName = *aliases[0].Name
 	}
-	permissionSids = append(permissionSids, sids...)
-	err = LambdaEnsureTriggerSQS(ctx, infraLambda, preview)
+	alias, err := lambdaGetAlias(ctx, name, aliasName)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	err = LambdaSetConcurrency(ctx, infraLambda.Name, concurrency, preview)
-	if err != nil {
+	if alias == nil {
+		err := fmt.Errorf("no such alias: %s:%s", name, aliasName)
 		Logger.Println("error:", err)
 		return err
 	}
-	err = lambdaRemoveUnusedPermissions(ctx, infraLambda.Name, permissionSids, preview)
-	if err != nil {
+	previous, canary, bake, ok := lambdaParseAliasDescription(alias.Description)
+	if !ok || previous == "" {
+		err := fmt.Errorf("no previous version recorded for alias: %s:%s", name, aliasName)
 		Logger.Println("error:", err)
 		return err
 	}
-	return nil
-}
-
-func LambdaUpdateFunctionCode(ctx context.Context, infraLambda *InfraLambda, preview bool) error {
-	if doDebug {
-		d := &Debug{start: time.Now(), name: "LambdaUpdateFunctionCode"}
-		defer d.Log()
-	}
+	current := *alias.FunctionVersion
 	if !preview {
-		var expectedErr error
-		err := Retry(ctx, func() error {
-			updateInput := &lambda.UpdateFunctionCodeInput{
-				FunctionName: aws.String(infraLambda.Name),
-			}
-			if infraLambda.runtime == lambdaRuntimeContainer {
-				updateInput.ImageUri = aws.String(infraLambda.Entrypoint)
-			} else {
-				zipBytes, err := LambdaZipBytes(infraLambda)
-				if err != nil {
-					Logger.Println("error:", err)
-					return err
-				}
-				updateInput.ZipFile = zipBytes
-			}
-			_, err := LambdaClient().UpdateFunctionCodeWithContext(ctx, updateInput)
-			if err != nil {
-				aerr, ok := err.(awserr.Error)
-				if !ok || !(aerr.Code() == lambda.ErrCodeResourceNotFoundException || aerr.Code() == "RequestEntityTooLargeException") {
-					return err
-				}
-				expectedErr = err
-				return nil
-			}
-			return nil
-		})
+		err := lambdaUpdateAlias(ctx, name, aliasName, previous, "", 0, lambdaAliasDescription(current, canary, bake))
 		if err != nil {
 			Logger.Println("error:", err)
 			return err
 		}
-		if expectedErr != nil {
-			Logger.Println("error:", expectedErr)
-			return expectedErr
-		}
 	}
-	Logger.Println(PreviewString(preview) + "lambda updated code for: " + infraLambda.Name)
+	Logger.Println(PreviewString(preview)+"rolled back alias:", name, aliasName, current, "=>", previous)
 	return nil
 }
 
@@ -2615,6 +4721,11 @@ func LambdaDelete(ctx context.Context, name string, preview bool) error {
 				Logger.Println("error:", err)
 				return err
 			}
+			_, err = LambdaEnsureTriggerSNS(ctx, infraLambda, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
 		}
 		err = IamDeleteRole(ctx, lambdaName, preview)
 		if err != nil {
@@ -2634,3 +4745,640 @@ func LambdaDelete(ctx context.Context, name string, preview bool) error {
 	}
 	return nil
 }
+
+const (
+	lambdaLocalAccount   = "123456789012" // placeholder account in synthetic events
+	lambdaLocalApiPrefix = "/2018-06-01/runtime"
+)
+
+// minimal python runtime interface client, the equivalent of the bootstrap
+// that the python runtime provides
+const lambdaLocalPythonBootstrap = `
+import importlib, json, os, sys, time, traceback, urllib.request
+sys.path.insert(0, os.environ['LAMBDA_TASK_ROOT'])
+api = 'http://' + os.environ['AWS_LAMBDA_RUNTIME_API'] + '/2018-06-01/runtime'
+module_name, function_name = os.environ['_HANDLER'].rsplit('.', 1)
+
+class Context:
+    def __init__(self, request_id, deadline_ms, arn):
+        self.aws_request_id = request_id
+        self.invoked_function_arn = arn
+        self.function_name = os.environ['AWS_LAMBDA_FUNCTION_NAME']
+        self.function_version = os.environ['AWS_LAMBDA_FUNCTION_VERSION']
+        self.memory_limit_in_mb = os.environ['AWS_LAMBDA_FUNCTION_MEMORY_SIZE']
+        self.log_group_name = os.environ['AWS_LAMBDA_LOG_GROUP_NAME']
+        self.log_stream_name = os.environ['AWS_LAMBDA_LOG_STREAM_NAME']
+        self._deadline_ms = deadline_ms
+    def get_remaining_time_in_millis(self):
+        return max(0, self._deadline_ms - int(time.time() * 1000))
+
+def post(path, data):
+    urllib.request.urlopen(urllib.request.Request(api + path, data=json.dumps(data).encode(), method='POST'))
+
+def error(e):
+    return {'errorMessage': str(e), 'errorType': type(e).__name__, 'stackTrace': traceback.format_exc().splitlines()}
+
+try:
+    handler = getattr(importlib.import_module(module_name), function_name)
+except Exception as e:
+    post('/init/error', error(e))
+    sys.exit(1)
+
+while True:
+    with urllib.request.urlopen(api + '/invocation/next') as resp:
+        request_id = resp.headers['Lambda-Runtime-Aws-Request-Id']
+        context = Context(request_id, int(resp.headers['Lambda-Runtime-Deadline-Ms']), resp.headers['Lambda-Runtime-Invoked-Function-Arn'])
+        event = json.loads(resp.read())
+    try:
+        post('/invocation/' + request_id + '/response', handler(event, context))
+    except Exception as e:
+        post('/invocation/' + request_id + '/error', error(e))
+`
+
+// minimal node runtime interface client, supporting async and callback handlers
+const lambdaLocalNodeBootstrap = `
+import fs from 'fs'
+import path from 'path'
+import { pathToFileURL } from 'url'
+
+const api = 'http://' + process.env.AWS_LAMBDA_RUNTIME_API + '/2018-06-01/runtime'
+const handlerName = process.env._HANDLER
+const moduleName = handlerName.slice(0, handlerName.lastIndexOf('.'))
+const functionName = handlerName.slice(handlerName.lastIndexOf('.') + 1)
+
+const post = (p, data) => fetch(api + p, { method: 'POST', body: JSON.stringify(data === undefined ? null : data) })
+
+const error = (e) => ({
+  errorMessage: String((e && e.message) || e),
+  errorType: (e && e.name) || 'Error',
+  stackTrace: String((e && e.stack) || '').split('\n'),
+})
+
+let handler
+try {
+  const root = process.env.LAMBDA_TASK_ROOT
+  const file = ['.mjs', '.js', '.cjs'].map((ext) => path.join(root, moduleName + ext)).find((f) => fs.existsSync(f))
+  const mod = await import(pathToFileURL(file).href)
+  handler = mod[functionName] || (mod.default && mod.default[functionName])
+  if (typeof handler !== 'function') {
+    throw new Error('no such handler: ' + handlerName)
+  }
+} catch (e) {
+  await post('/init/error', error(e))
+  process.exit(1)
+}
+
+const invoke = (event, context) => {
+  if (handler.length < 3) {
+    return handler(event, context)
+  }
+  return new Promise((resolve, reject) => {
+    handler(event, context, (err, result) => (err ? reject(err) : resolve(result)))
+  })
+}
+
+while (true) {
+  const resp = await fetch(api + '/invocation/next')
+  const requestId = resp.headers.get('lambda-runtime-aws-request-id')
+  const deadline = Number(resp.headers.get('lambda-runtime-deadline-ms'))
+  const context = {
+    awsRequestId: requestId,
+    invokedFunctionArn: resp.headers.get('lambda-runtime-invoked-function-arn'),
+    functionName: process.env.AWS_LAMBDA_FUNCTION_NAME,
+    functionVersion: process.env.AWS_LAMBDA_FUNCTION_VERSION,
+    memoryLimitInMB: process.env.AWS_LAMBDA_FUNCTION_MEMORY_SIZE,
+    logGroupName: process.env.AWS_LAMBDA_LOG_GROUP_NAME,
+    logStreamName: process.env.AWS_LAMBDA_LOG_STREAM_NAME,
+    getRemainingTimeInMillis: () => Math.max(0, deadline - Date.now()),
+  }
+  const event = await resp.json()
+  try {
+    await post('/invocation/' + requestId + '/response', await invoke(event, context))
+  } catch (e) {
+    await post('/invocation/' + requestId + '/error', error(e))
+  }
+}
+`
+
+type LambdaLocalEvent struct {
+	Trigger string
+	Payload []byte
+}
+
+type lambdaLocalResult struct {
+	body []byte
+	err  bool
+}
+
+type lambdaLocalInvocation struct {
+	requestID string
+	event     []byte
+	result    chan *lambdaLocalResult
+}
+
+// an emulation of the lambda runtime api, serving one invocation at a time
+type lambdaLocalRuntime struct {
+	arn      string
+	timeout  time.Duration
+	next     chan *lambdaLocalInvocation
+	initErr  chan []byte
+	lock     sync.Mutex
+	inflight map[string]*lambdaLocalInvocation
+}
+
+func (r *lambdaLocalRuntime) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == lambdaLocalApiPrefix+"/invocation/next":
+		var invocation *lambdaLocalInvocation
+		select {
+		case invocation = <-r.next:
+		case <-req.Context().Done():
+			return
+		}
+		r.lock.Lock()
+		r.inflight[invocation.requestID] = invocation
+		r.lock.Unlock()
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", invocation.requestID)
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", fmt.Sprint(time.Now().Add(r.timeout).UnixMilli()))
+		w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", r.arn)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(invocation.event)
+	case req.Method == http.MethodPost && req.URL.Path == lambdaLocalApiPrefix+"/init/error":
+		select {
+		case r.initErr <- body:
+		default:
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, lambdaLocalApiPrefix+"/invocation/"):
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, lambdaLocalApiPrefix+"/invocation/"), "/")
+		if len(parts) != 2 || !Contains([]string{"response", "error"}, parts[1]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.lock.Lock()
+		invocation, ok := r.inflight[parts[0]]
+		delete(r.inflight, parts[0])
+		r.lock.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		invocation.result <- &lambdaLocalResult{body: body, err: parts[1] == "error"}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// synthetic events shaped like those each trigger type delivers
+func lambdaLocalEvents(infraLambda *InfraLambda, region string, triggerTypes []string) ([]*LambdaLocalEvent, error) {
+	now := time.Now().UTC()
+	requestID := func() string { return uuid.Must(uuid.NewV4()).String() }
+	var events []*LambdaLocalEvent
+	for _, trigger := range infraLambda.Trigger {
+		if len(triggerTypes) > 0 && !Contains(triggerTypes, trigger.Type) {
+			continue
+		}
+		var payload interface{}
+		switch trigger.Type {
+		case lambdaTriggerSQS:
+			queueName := trigger.Attr[0]
+			payload = map[string]interface{}{"Records": []interface{}{map[string]interface{}{
+				"messageId":     requestID(),
+				"receiptHandle": "local",
+				"body":          "{}",
+				"attributes": map[string]string{
+					"ApproximateReceiveCount":          "1",
+					"SentTimestamp":                    fmt.Sprint(now.UnixMilli()),
+					"SenderId":                         lambdaLocalAccount,
+					"ApproximateFirstReceiveTimestamp": fmt.Sprint(now.UnixMilli()),
+				},
+				"messageAttributes": map[string]interface{}{},
+				"md5OfBody":         "99914b932bd37a50b983c5e7c90ae93b",
+				"eventSource":       "aws:sqs",
+				"eventSourceARN":    fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, lambdaLocalAccount, queueName),
+				"awsRegion":         region,
+			}}}
+		case lambdaTrigerS3:
+			s3Trigger, err := lambdaParseTriggerS3(trigger.Attr)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			eventName := "ObjectCreated:Put"
+			if !Contains(s3Trigger.events, "created") && len(s3Trigger.events) > 0 {
+				eventName = map[string]string{"removed": "ObjectRemoved:Delete", "restore": "ObjectRestore:Completed"}[s3Trigger.events[0]]
+			}
+			payload = map[string]interface{}{"Records": []interface{}{map[string]interface{}{
+				"eventVersion": "2.1",
+				"eventSource":  "aws:s3",
+				"awsRegion":    region,
+				"eventTime":    now.Format(time.RFC3339),
+				"eventName":    eventName,
+				"s3": map[string]interface{}{
+					"s3SchemaVersion": "1.0",
+					"bucket": map[string]interface{}{
+						"name": s3Trigger.bucket,
+						"arn":  "arn:aws:s3:::" + s3Trigger.bucket,
+					},
+					"object": map[string]interface{}{
+						"key":       s3Trigger.prefix + "key" + s3Trigger.suffix,
+						"size":      0,
+						"eTag":      "d41d8cd98f00b204e9800998ecf8427e",
+						"sequencer": "0",
+					},
+				},
+			}}}
+		case lambdaTriggerDynamoDB:
+			tableName := trigger.Attr[0]
+			payload = map[string]interface{}{"Records": []interface{}{map[string]interface{}{
+				"eventID":      requestID(),
+				"eventName":    "INSERT",
+				"eventVersion": "1.1",
+				"eventSource":  "aws:dynamodb",
+				"awsRegion":    region,
+				"dynamodb": map[string]interface{}{
+					"ApproximateCreationDateTime": now.Unix(),
+					"Keys":                        map[string]interface{}{"id": map[string]string{"S": "local"}},
+					"NewImage":                    map[string]interface{}{"id": map[string]string{"S": "local"}},
+					"SequenceNumber":              "1",
+					"SizeBytes":                   0,
+					"StreamViewType":              "NEW_AND_OLD_IMAGES",
+				},
+				"eventSourceARN": fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s/stream/%s", region, lambdaLocalAccount, tableName, now.Format("2006-01-02T15:04:05.000")),
+			}}}
+		case lambdaTriggerSchedule:
+			payload = map[string]interface{}{
+				"version":     "0",
+				"id":          requestID(),
+				"detail-type": "Scheduled Event",
+				"source":      "aws.events",
+				"account":     lambdaLocalAccount,
+				"time":        now.Format(time.RFC3339),
+				"region":      region,
+				"resources":   []string{fmt.Sprintf("arn:aws:events:%s:%s:rule/%s", region, lambdaLocalAccount, infraLambda.Name)},
+				"detail":      map[string]interface{}{},
+			}
+		case lambdaTriggerSNS:
+			topicName := trigger.Attr[0]
+			topicArn := fmt.Sprintf("arn:aws:sns:%s:%s:%s", region, lambdaLocalAccount, topicName)
+			payload = map[string]interface{}{"Records": []interface{}{map[string]interface{}{
+				"EventVersion":         "1.0",
+				"EventSource":          "aws:sns",
+				"EventSubscriptionArn": topicArn + ":" + requestID(),
+				"Sns": map[string]interface{}{
+					"Type":              "Notification",
+					"MessageId":         requestID(),
+					"TopicArn":          topicArn,
+					"Subject":           nil,
+					"Message":           "{}",
+					"Timestamp":         now.Format(time.RFC3339),
+					"MessageAttributes": map[string]interface{}{},
+				},
+			}}}
+		case lambdaTriggerApi: // payload format version 1.0, see lambdaPayloadVersion
+			payload = map[string]interface{}{
+				"version":                         lambdaPayloadVersion,
+				"resource":                        "/{proxy+}",
+				"path":                            "/",
+				"httpMethod":                      "GET",
+				"headers":                         map[string]string{"host": "localhost", "user-agent": "libaws"},
+				"multiValueHeaders":               map[string][]string{"host": {"localhost"}, "user-agent": {"libaws"}},
+				"queryStringParameters":           nil,
+				"multiValueQueryStringParameters": nil,
+				"pathParameters":                  map[string]string{"proxy": ""},
+				"stageVariables":                  nil,
+				"body":                            nil,
+				"isBase64Encoded":                 false,
+				"requestContext": map[string]interface{}{
+					"accountId":        lambdaLocalAccount,
+					"apiId":            "local",
+					"domainName":       "localhost",
+					"httpMethod":       "GET",
+					"path":             "/",
+					"protocol":         "HTTP/1.1",
+					"requestId":        requestID(),
+					"requestTime":      now.Format("02/Jan/2006:15:04:05 -0700"),
+					"requestTimeEpoch": now.UnixMilli(),
+					"resourcePath":     "/{proxy+}",
+					"stage":            "$default",
+					"identity":         map[string]interface{}{"sourceIp": "127.0.0.1", "userAgent": "libaws"},
+				},
+			}
+		case lambdaTriggerUrl: // payload format version 2.0, which function urls always use
+			payload = map[string]interface{}{
+				"version":         "2.0",
+				"routeKey":        "$default",
+				"rawPath":         "/",
+				"rawQueryString":  "",
+				"headers":         map[string]string{"host": "localhost", "user-agent": "libaws"},
+				"isBase64Encoded": false,
+				"requestContext": map[string]interface{}{
+					"accountId":  lambdaLocalAccount,
+					"apiId":      "local",
+					"domainName": "localhost",
+					"http": map[string]interface{}{
+						"method":    "GET",
+						"path":      "/",
+						"protocol":  "HTTP/1.1",
+						"sourceIp":  "127.0.0.1",
+						"userAgent": "libaws",
+					},
+					"requestId": requestID(),
+					"routeKey":  "$default",
+					"stage":     "$default",
+					"time":      now.Format("02/Jan/2006:15:04:05 -0700"),
+					"timeEpoch": now.UnixMilli(),
+				},
+			}
+		case lambdaTriggerWebsocket:
+			connectionID := requestID()
+			for _, route := range []struct{ key, eventType, body string }{
+				{"$connect", "CONNECT", ""},
+				{"$default", "MESSAGE", "{}"},
+				{"$disconnect", "DISCONNECT", ""},
+			} {
+				event := map[string]interface{}{
+					"isBase64Encoded": false,
+					"requestContext": map[string]interface{}{
+						"routeKey":         route.key,
+						"eventType":        route.eventType,
+						"connectionId":     connectionID,
+						"requestId":        requestID(),
+						"apiId":            "local",
+						"domainName":       "localhost",
+						"stage":            "$default",
+						"requestTimeEpoch": now.UnixMilli(),
+						"identity":         map[string]interface{}{"sourceIp": "127.0.0.1", "userAgent": "libaws"},
+					},
+				}
+				if route.body != "" {
+					event["body"] = route.body
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				events = append(events, &LambdaLocalEvent{Trigger: trigger.Type + " " + route.key, Payload: data})
+			}
+			continue
+		default:
+			Logger.Println("skipping trigger without a local event:", trigger.Type)
+			continue
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		events = append(events, &LambdaLocalEvent{Trigger: trigger.Type, Payload: data})
+	}
+	return events, nil
+}
+
+// build a lambda the same way infra-ensure does, then invoke it locally once
+// per event under an emulation of the lambda runtime api. responses are
+// written to stdout, one per line, and function output goes to stderr.
+func LambdaRunLocal(ctx context.Context, infraSet *InfraSet, name string, events []*LambdaLocalEvent, triggerTypes []string) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "LambdaRunLocal"}
+		defer d.Log()
+	}
+	infraLambda, ok := infraSet.Lambda[name]
+	if !ok {
+		err := fmt.Errorf("no such lambda in infra set %s: %s", infraSet.Name, name)
+		Logger.Println("error:", err)
+		return err
+	}
+	infraLambda.Name = name
+	_, createZipFn, err := lambdaSetRuntime(infraLambda)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if infraLambda.runtime == lambdaRuntimeContainer {
+		err := fmt.Errorf("container lambdas cannot be run locally: %s", name)
+		Logger.Println("error:", err)
+		return err
+	}
+	if infraLambda.runtime == lambdaRuntimeGo && goruntime.GOOS != "linux" {
+		err := fmt.Errorf("go lambdas build for linux and cannot run locally on: %s", goruntime.GOOS)
+		Logger.Println("error:", err)
+		return err
+	}
+	memory := lambdaAttrMemoryDefault
+	timeout := lambdaAttrTimeoutDefault
+	for _, attr := range infraLambda.Attr {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		switch k {
+		case lambdaAttrMemory:
+			memory = Atoi(v)
+		case lambdaAttrTimeout:
+			timeout = Atoi(v)
+		}
+	}
+	// build for this machine, not the arch attr, and away from the deploy zip so
+	// that a later infra-ensure --quick never ships this build
+	buildDir, err := os.MkdirTemp("", "libaws-run-local-")
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	defer func() { _ = os.RemoveAll(buildDir) }()
+	infraLambda.arch = goruntime.GOARCH
+	infraLambda.zipFile = path.Join(buildDir, "lambda.zip")
+	err = createZipFn(infraLambda)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = LambdaIncludeInZip(infraLambda)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	zipFile := lambdaZipFile(infraLambda)
+	dir := path.Dir(zipFile)
+	taskDir := path.Join(dir, "task")
+	_ = os.RemoveAll(taskDir)
+	_ = os.MkdirAll(taskDir, os.ModePerm)
+	err = ZipExtract(zipFile, taskDir)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	region := aws.StringValue(Session().Config.Region)
+	if len(events) == 0 {
+		events, err = lambdaLocalEvents(infraLambda, region, triggerTypes)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	if len(events) == 0 {
+		err := fmt.Errorf("no triggers with local events for lambda %s, provide an event", name)
+		Logger.Println("error:", err)
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	runtimeApi := &lambdaLocalRuntime{
+		arn:      fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", region, lambdaLocalAccount, name),
+		timeout:  time.Duration(timeout) * time.Second,
+		next:     make(chan *lambdaLocalInvocation),
+		initErr:  make(chan []byte, 1),
+		inflight: map[string]*lambdaLocalInvocation{},
+	}
+	server := &http.Server{Handler: runtimeApi}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logRecover(r)
+			}
+		}()
+		_ = server.Serve(listener)
+	}()
+	defer func() { _ = server.Close() }()
+	env := append(os.Environ(), infraLambda.Env...)
+	env = append(env,
+		"AWS_LAMBDA_RUNTIME_API="+listener.Addr().String(),
+		"AWS_LAMBDA_FUNCTION_NAME="+name,
+		"AWS_LAMBDA_FUNCTION_VERSION=$LATEST",
+		fmt.Sprintf("AWS_LAMBDA_FUNCTION_MEMORY_SIZE=%d", memory),
+		"AWS_LAMBDA_LOG_GROUP_NAME=/aws/lambda/"+name,
+		"AWS_LAMBDA_LOG_STREAM_NAME=local",
+		"AWS_REGION="+region,
+		"AWS_DEFAULT_REGION="+region,
+		"LAMBDA_TASK_ROOT="+taskDir,
+		"_HANDLER="+infraLambda.handler,
+	)
+	command := []string{path.Join(taskDir, "main")}
+	if infraLambda.runtime == lambdaRuntimePython {
+		bootstrap := path.Join(dir, "bootstrap.py")
+		err := os.WriteFile(bootstrap, []byte(lambdaLocalPythonBootstrap), 0644)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		command = []string{"python3", bootstrap}
+	} else if infraLambda.runtime == lambdaRuntimeNode {
+		bootstrap := path.Join(dir, "bootstrap.mjs")
+		err := os.WriteFile(bootstrap, []byte(lambdaLocalNodeBootstrap), 0644)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		command = []string{"node", bootstrap}
+	}
+	var cmd *exec.Cmd
+	var exited chan error
+	start := func() error {
+		cmd = exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Dir = taskDir
+		cmd.Env = env
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Start()
+		if err != nil {
+			cmd = nil
+			Logger.Println("error:", err)
+			return err
+		}
+		exited = make(chan error, 1)
+		go func(cmd *exec.Cmd, exited chan error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logRecover(r)
+				}
+			}()
+			exited <- cmd.Wait()
+		}(cmd, exited)
+		return nil
+	}
+	defer func() {
+		if cmd != nil {
+			_ = cmd.Process.Kill()
+		}
+	}()
+	// like lambda, a timed out runtime is killed and the next event gets a fresh one
+	timedOut := func() *lambdaLocalResult {
+		_ = cmd.Process.Kill()
+		<-exited
+		cmd = nil
+		return &lambdaLocalResult{body: []byte(fmt.Sprintf(`{"errorMessage": "timed out after %d seconds"}`, timeout)), err: true}
+	}
+	failures := 0
+	for i, event := range events {
+		if cmd == nil {
+			err := start()
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		invocation := &lambdaLocalInvocation{
+			requestID: uuid.Must(uuid.NewV4()).String(),
+			event:     event.Payload,
+			result:    make(chan *lambdaLocalResult, 1),
+		}
+		timer := time.NewTimer(runtimeApi.timeout)
+		var result *lambdaLocalResult
+		dead := false
+		select {
+		case runtimeApi.next <- invocation:
+			select {
+			case result = <-invocation.result:
+			case <-timer.C:
+				result = timedOut()
+			case body := <-runtimeApi.initErr:
+				result = &lambdaLocalResult{body: body, err: true}
+				dead = true
+			case err := <-exited:
+				result = &lambdaLocalResult{body: []byte(fmt.Sprintf(`{"errorMessage": "runtime exited: %v"}`, err)), err: true}
+				dead = true
+			}
+		case body := <-runtimeApi.initErr:
+			result = &lambdaLocalResult{body: body, err: true}
+			dead = true
+		case err := <-exited:
+			result = &lambdaLocalResult{body: []byte(fmt.Sprintf(`{"errorMessage": "runtime exited: %v"}`, err)), err: true}
+			dead = true
+		case <-timer.C:
+			result = timedOut()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		timer.Stop()
+		if result.err {
+			failures++
+			Logger.Println("invocation failed:", name, event.Trigger)
+		} else {
+			Logger.Println("invoked:", name, event.Trigger)
+		}
+		fmt.Println(strings.TrimSpace(string(result.body)))
+		if dead {
+			failures += len(events) - i - 1
+			break
+		}
+	}
+	if failures > 0 {
+		err := fmt.Errorf("%d of %d invocations failed for: %s", failures, len(events), name)
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+func TestLambdaParseTriggerS3(t *testing.T) {
+	type test struct {
+		attrs   []string
+		trigger *lambdaTriggerS3
+		err     bool
+	}
+	tests := []test{
+		{
+			[]string{"bucket"},
+			&lambdaTriggerS3{bucket: "bucket", events: []string{"created", "removed"}},
+			false,
+		},
+		{
+			[]string{"bucket", "events=restore,created", "prefix=uploads/", "suffix=.csv"},
+			&lambdaTriggerS3{bucket: "bucket", events: []string{"created", "restore"}, prefix: "uploads/", suffix: ".csv"},
+			false,
+		},
+		{
+			[]string{"bucket", "events=updated"},
+			nil,
+			true,
+		},
+		{
+			[]string{"bucket", "glob=*.csv"},
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		trigger, err := lambdaParseTriggerS3(test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error for: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %v: %s", test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(trigger, test.trigger) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", trigger, test.trigger)
+		}
+		roundTrip := lambdaTriggerS3FromConf(trigger.bucket, trigger.Conf("arn:aws:lambda:us-west-2:123:function:fn"))
+		if !reflect.DeepEqual(roundTrip, test.trigger) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", roundTrip, test.trigger)
+		}
+	}
+}
+
+func TestLambdaTriggerS3Overlaps(t *testing.T) {
+	type test struct {
+		a        []string
+		b        []string
+		overlaps bool
+	}
+	tests := []test{
+		{[]string{"bucket"}, []string{"bucket"}, true},
+		{[]string{"bucket"}, []string{"other"}, false},
+		{[]string{"bucket", "prefix=a/"}, []string{"bucket", "prefix=b/"}, false},
+		{[]string{"bucket", "prefix=a/"}, []string{"bucket", "prefix=a/b/"}, true},
+		{[]string{"bucket", "suffix=.csv"}, []string{"bucket", "suffix=.json"}, false},
+		{[]string{"bucket", "prefix=a/"}, []string{"bucket", "suffix=.csv"}, true},
+		{[]string{"bucket", "events=created"}, []string{"bucket", "events=removed"}, false},
+	}
+	for _, test := range tests {
+		a, err := lambdaParseTriggerS3(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := lambdaParseTriggerS3(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Overlaps(b) != test.overlaps || b.Overlaps(a) != test.overlaps {
+			t.Errorf("\n%v %v\ngot:\n%v\nwant:\n%v\n", test.a, test.b, a.Overlaps(b), test.overlaps)
+		}
+	}
+}
+
+func TestLambdaAliasDescription(t *testing.T) {
+	description := lambdaAliasDescription("3", 10, 600)
+	previous, canary, bake, ok := lambdaParseAliasDescription(&description)
+	if !ok || previous != "3" || canary != 10 || bake != 600 {
+		t.Errorf("\ngot:\n%v %v %v %v\n", previous, canary, bake, ok)
+	}
+	unmanaged := "created by hand"
+	_, canary, bake, ok = lambdaParseAliasDescription(&unmanaged)
+	if ok || canary != lambdaAttrCanaryDefault || bake != lambdaAttrBakeDefault {
+		t.Errorf("\ngot:\n%v %v %v\n", canary, bake, ok)
+	}
+	_, _, _, ok = lambdaParseAliasDescription(nil)
+	if ok {
+		t.Errorf("expected nil description to be unmanaged")
+	}
+}
+
+func TestLambdaSameFunction(t *testing.T) {
+	type test struct {
+		a    string
+		b    string
+		want bool
+	}
+	tests := []test{
+		{"arn:aws:lambda:us-west-2:123:function:fn", "arn:aws:lambda:us-west-2:123:function:fn:live", true},
+		{"arn:aws:lambda:us-west-2:123:function:fn:3", "arn:aws:lambda:us-west-2:123:function:fn:live", true},
+		{"arn:aws:lambda:us-west-2:123:function:fn", "arn:aws:lambda:us-west-2:123:function:fn2", false},
+		{"fn", "fn", false},
+	}
+	for _, test := range tests {
+		have := lambdaSameFunction(test.a, test.b)
+		if have != test.want {
+			t.Errorf("\n%s %s\ngot:\n%v\nwant:\n%v\n", test.a, test.b, have, test.want)
+		}
+	}
+}
+
+func TestLambdaArch(t *testing.T) {
+	type test struct {
+		arch         string
+		architecture string
+		roundTrip    string
+	}
+	tests := []test{
+		{lambdaArchArm64, lambda.ArchitectureArm64, lambdaArchArm64},
+		{lambdaArchAmd64, lambda.ArchitectureX8664, lambdaArchAmd64},
+		{"", lambda.ArchitectureX8664, lambdaArchAmd64},
+		{"x86", lambda.ArchitectureX8664, lambdaArchAmd64},
+	}
+	for _, test := range tests {
+		architecture := lambdaArchitecture(test.arch)
+		if architecture != test.architecture {
+			t.Errorf("\n%q\ngot:\n%v\nwant:\n%v\n", test.arch, architecture, test.architecture)
+		}
+		arch := lambdaArch([]*string{aws.String(architecture)})
+		if arch != test.roundTrip {
+			t.Errorf("\n%q\ngot:\n%v\nwant:\n%v\n", test.arch, arch, test.roundTrip)
+		}
+	}
+	if arch := lambdaArch(nil); arch != lambdaArchAmd64 {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", arch, lambdaArchAmd64)
+	}
+	if arch := lambdaArch([]*string{aws.String("bogus")}); arch != lambdaArchAmd64 {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", arch, lambdaArchAmd64)
+	}
+	dir := t.TempDir()
+	for _, arch := range []string{lambdaArchArm64, lambdaArchAmd64, "x86"} {
+		yamlPath := path.Join(dir, "infra.yaml")
+		data := "name: test\nlambda:\n  fn:\n    entrypoint: main.py\n    attr:\n      - arch=" + arch + "\n"
+		err := os.WriteFile(yamlPath, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = InfraParse(yamlPath)
+		if (err == nil) != (arch != "x86") {
+			t.Errorf("\n%s\ngot:\n%v\n", arch, err)
+		}
+	}
+}
+
+func TestLambdaLocalEvents(t *testing.T) {
+	infraLambda := &InfraLambda{
+		Name: "fn",
+		Trigger: []*InfraTrigger{
+			{Type: lambdaTriggerSQS, Attr: []string{"queue"}},
+			{Type: lambdaTrigerS3, Attr: []string{"bucket", "prefix=uploads/", "suffix=.csv"}},
+			{Type: lambdaTriggerDynamoDB, Attr: []string{"table", "start=trim_horizon"}},
+			{Type: lambdaTriggerSchedule, Attr: []string{"rate(5 minutes)"}},
+			{Type: lambdaTriggerApi},
+			{Type: lambdaTriggerWebsocket},
+		},
+	}
+	events, err := lambdaLocalEvents(infraLambda, "us-west-2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var triggers []string
+	for _, event := range events {
+		triggers = append(triggers, event.Trigger)
+		val := map[string]interface{}{}
+		err := json.Unmarshal(event.Payload, &val)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"sqs", "s3", "dynamodb", "schedule", "api", "websocket $connect", "websocket $default", "websocket $disconnect"}
+	if !reflect.DeepEqual(triggers, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", triggers, want)
+	}
+	if !bytes.Contains(events[1].Payload, []byte(`"key":"uploads/key.csv"`)) {
+		t.Errorf("s3 event key should match trigger filters: %s", events[1].Payload)
+	}
+	events, err = lambdaLocalEvents(infraLambda, "us-west-2", []string{lambdaTriggerSQS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Trigger != lambdaTriggerSQS {
+		t.Errorf("expected only sqs event, got: %v", events)
+	}
+}
+
+func TestLambdaLocalRuntime(t *testing.T) {
+	runtimeApi := &lambdaLocalRuntime{
+		arn:      "arn:aws:lambda:us-west-2:123456789012:function:fn",
+		timeout:  time.Minute,
+		next:     make(chan *lambdaLocalInvocation),
+		initErr:  make(chan []byte, 1),
+		inflight: map[string]*lambdaLocalInvocation{},
+	}
+	server := httptest.NewServer(runtimeApi)
+	defer server.Close()
+	invocation := &lambdaLocalInvocation{
+		requestID: "request-1",
+		event:     []byte(`{"hello":"world"}`),
+		result:    make(chan *lambdaLocalResult, 1),
+	}
+	go func() {
+		runtimeApi.next <- invocation
+	}()
+	resp, err := http.Get(server.URL + lambdaLocalApiPrefix + "/invocation/next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"hello":"world"}` || resp.Header.Get("Lambda-Runtime-Aws-Request-Id") != "request-1" {
+		t.Fatalf("unexpected next invocation: %s %v", body, resp.Header)
+	}
+	resp, err = http.Post(server.URL+lambdaLocalApiPrefix+"/invocation/request-1/response", "application/json", bytes.NewBufferString(`"ok"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	result := <-invocation.result
+	if string(result.body) != `"ok"` || result.err {
+		t.Errorf("unexpected result: %s %v", result.body, result.err)
+	}
+	resp, err = http.Post(server.URL+lambdaLocalApiPrefix+"/invocation/request-1/response", "application/json", bytes.NewBufferString(`"ok"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected second response for same request to fail, got: %d", resp.StatusCode)
+	}
+}
+
+func TestLambdaSetRuntime(t *testing.T) {
+	type test struct {
+		entrypoint string
+		runtime    string
+		handler    string
+		bundle     string
+	}
+	tests := []test{
+		{"api/handler.py", lambdaRuntimePython, "handler.main", ""},
+		{"api/handler.js", lambdaRuntimeNode, "handler.main", "handler.js"},
+		{"api/handler.ts", lambdaRuntimeNode, "handler.main", "handler.js"},
+		{"api/handler.mjs", lambdaRuntimeNode, "handler.main", "handler.mjs"},
+		{"api/main.go", lambdaRuntimeGo, "main", ""},
+	}
+	for _, test := range tests {
+		infraLambda := &InfraLambda{Entrypoint: test.entrypoint}
+		_, _, err := lambdaSetRuntime(infraLambda)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if infraLambda.runtime != test.runtime || infraLambda.handler != test.handler {
+			t.Errorf("\ngot:\n%s %s\nwant:\n%s %s\n", infraLambda.runtime, infraLambda.handler, test.runtime, test.handler)
+		}
+		if test.bundle != "" && lambdaNodeBundleName(test.entrypoint) != test.bundle {
+			t.Errorf("\ngot:\n%s\nwant:\n%s\n", lambdaNodeBundleName(test.entrypoint), test.bundle)
+		}
+	}
+	_, _, err := lambdaSetRuntime(&InfraLambda{Entrypoint: "api/handler.rb"})
+	if err == nil {
+		t.Errorf("expected error for unknown entrypoint type")
+	}
+}
+
+func TestLambdaDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type test struct {
+		entrypoint string
+		dockerfile string
+		contextDir string
+		ok         bool
+	}
+	tests := []test{
+		{path.Join(dir, "Dockerfile"), path.Join(dir, "Dockerfile"), dir, true},
+		{path.Join(dir, "api.Dockerfile"), path.Join(dir, "api.Dockerfile"), dir, true},
+		{dir, path.Join(dir, "Dockerfile"), dir, true},
+		{path.Join(dir, "main.go"), "", "", false},
+		{"123.dkr.ecr.us-west-2.amazonaws.com/fn:latest", "", "", false},
+	}
+	for _, test := range tests {
+		dockerfile, contextDir, ok := lambdaDockerfile(test.entrypoint)
+		if dockerfile != test.dockerfile || contextDir != test.contextDir || ok != test.ok {
+			t.Errorf("\ngot:\n%s %s %v\nwant:\n%s %s %v\n", dockerfile, contextDir, ok, test.dockerfile, test.contextDir, test.ok)
+		}
+	}
+	infraLambda := &InfraLambda{Entrypoint: dir}
+	_, _, err = lambdaSetRuntime(infraLambda)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infraLambda.runtime != lambdaRuntimeContainer {
+		t.Errorf("\ngot:\n%s\nwant:\n%s\n", infraLambda.runtime, lambdaRuntimeContainer)
+	}
+}
+
+func TestLambdaDestinationArn(t *testing.T) {
+	type test struct {
+		dest  string
+		arn   string
+		allow string
+	}
+	tests := []test{
+		{"sqs:failed", "arn:aws:sqs:us-west-2:123:failed", "sqs:SendMessage arn:aws:sqs:us-west-2:123:failed"},
+		{"sns:alerts", "arn:aws:sns:us-west-2:123:alerts", "sns:Publish arn:aws:sns:us-west-2:123:alerts"},
+		{"lambda:handler", "arn:aws:lambda:us-west-2:123:function:handler", "lambda:InvokeFunction arn:aws:lambda:us-west-2:123:function:handler"},
+	}
+	for _, test := range tests {
+		arn, allow, err := lambdaDestinationArn("123", "us-west-2", test.dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if arn != test.arn || allow != test.allow {
+			t.Errorf("\ngot:\n%s %s\nwant:\n%s %s\n", arn, allow, test.arn, test.allow)
+		}
+		if lambdaDestinationFromArn(arn) != test.dest {
+			t.Errorf("\ngot:\n%s\nwant:\n%s\n", lambdaDestinationFromArn(arn), test.dest)
+		}
+	}
+	for _, dest := range []string{"sqs", "sqs:", "s3:bucket"} {
+		_, _, err := lambdaDestinationArn("123", "us-west-2", dest)
+		if err == nil {
+			t.Errorf("expected error for: %s", dest)
+		}
+	}
+}
+
+func TestLambdaEventInvokeConfigAttrs(t *testing.T) {
+	attrs := lambdaEventInvokeConfigAttrs(&lambda.GetFunctionEventInvokeConfigOutput{
+		MaximumRetryAttempts:     aws.Int64(0),
+		MaximumEventAgeInSeconds: aws.Int64(lambdaAttrMaxEventAgeDefault),
+		DestinationConfig: &lambda.DestinationConfig{
+			OnFailure: &lambda.OnFailure{Destination: aws.String("arn:aws:sqs:us-west-2:123:failed")},
+		},
+	})
+	want := []string{"retry=0", "on-failure=sqs:failed"}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", attrs, want)
+	}
+	if len(lambdaEventInvokeConfigAttrs(nil)) != 0 {
+		t.Errorf("expected no attrs for missing config")
+	}
+}
+
+func TestLambdaParseTriggerUrl(t *testing.T) {
+	type test struct {
+		attrs []string
+		want  []string
+		err   bool
+	}
+	tests := []test{
+		{nil, nil, false},
+		{[]string{"auth=none", "stream=false"}, nil, false},
+		{[]string{"stream=true", "cors=https://b.com", "auth=iam", "cors=https://a.com"}, []string{"auth=iam", "cors=https://a.com", "cors=https://b.com", "stream=true"}, false},
+		{[]string{"url=https://abc.lambda-url.us-west-2.on.aws/"}, nil, false},
+		{[]string{"auth=cognito"}, nil, true},
+		{[]string{"dns=example.com"}, nil, true},
+	}
+	for _, test := range tests {
+		trigger, err := lambdaParseTriggerUrlConf(test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error for: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %v: %s", test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(trigger.Attrs(), test.want) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", trigger.Attrs(), test.want)
+		}
+		roundTrip := lambdaTriggerUrlConfFromConfig(aws.String(trigger.authType()), aws.String(trigger.invokeMode()), trigger.corsConfig())
+		if roundTrip.String() != trigger.String() {
+			t.Errorf("\ngot:\n%s\nwant:\n%s\n", roundTrip, trigger)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package lib
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -17,8 +18,11 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -315,6 +319,238 @@ func zipSha256Hex(data []byte) (map[string]*string, error) {
 	return results, nil
 }
 
+// zip entries get this fixed mtime so identical content gives identical bytes
+var zipModified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type zipEntry struct {
+	path string // read from disk when set
+	data []byte // otherwise the content, or link target for symlinks
+	mode os.FileMode
+}
+
+// a reproducible zip archive: entries are sorted by name, mtimes are fixed,
+// and permissions are normalized to 0644, 0755 or a symlink.
+type ZipBuilder struct {
+	entries map[string]*zipEntry
+}
+
+func NewZipBuilder() *ZipBuilder {
+	return &ZipBuilder{entries: map[string]*zipEntry{}}
+}
+
+func zipNormalizeMode(mode os.FileMode) os.FileMode {
+	if mode&os.ModeSymlink != 0 {
+		return os.ModeSymlink | 0777
+	}
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+func (z *ZipBuilder) AddBytes(name string, data []byte, mode os.FileMode) {
+	z.entries[filepath.ToSlash(name)] = &zipEntry{data: data, mode: zipNormalizeMode(mode)}
+}
+
+// add a file or symlink from disk, symlinks are stored as links rather than followed
+func (z *ZipBuilder) AddFile(name, pth string) error {
+	info, err := os.Lstat(pth)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(pth)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		z.AddBytes(name, []byte(target), info.Mode())
+		return nil
+	}
+	if info.IsDir() {
+		return z.AddDir(name, pth, nil)
+	}
+	z.entries[filepath.ToSlash(name)] = &zipEntry{path: pth, mode: zipNormalizeMode(info.Mode())}
+	return nil
+}
+
+// add every file under dir with names relative to prefix. skip is called with
+// paths relative to dir, and skipping a directory skips its contents.
+func (z *ZipBuilder) AddDir(prefix, dir string, skip func(rel string, info os.FileInfo) bool) error {
+	return filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		rel, err := filepath.Rel(dir, pth)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if skip != nil && skip(rel, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return z.AddFile(path.Join(prefix, filepath.ToSlash(rel)), pth)
+	})
+}
+
+// add the entries of an existing zip file
+func (z *ZipBuilder) AddZip(zipFile string) error {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		z.AddBytes(f.Name, data, f.Mode())
+	}
+	return nil
+}
+
+func (z *ZipBuilder) Names() []string {
+	var names []string
+	for name := range z.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// write the archive, compressed at ZIP_COMPRESSION level or 9 by default
+func (z *ZipBuilder) Write(w io.Writer) error {
+	level := flate.BestCompression
+	if os.Getenv("ZIP_COMPRESSION") != "" {
+		level = Atoi(os.Getenv("ZIP_COMPRESSION"))
+	}
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	for _, name := range z.Names() {
+		entry := z.entries[name]
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: zipModified,
+		}
+		header.SetMode(entry.mode)
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if entry.path != "" {
+			f, err := os.Open(entry.path)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			_, err = io.Copy(fw, f)
+			_ = f.Close()
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		} else {
+			_, err = fw.Write(entry.data)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+func (z *ZipBuilder) WriteFile(zipFile string) error {
+	var buf bytes.Buffer
+	err := z.Write(&buf)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = os.WriteFile(zipFile, buf.Bytes(), 0644)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+// extract a zip file into dir, restoring modes and symlinks
+func ZipExtract(zipFile, dir string) error {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	for _, f := range r.File {
+		pth := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(pth, filepath.Clean(dir)+string(os.PathSeparator)) {
+			err := fmt.Errorf("zip entry outside of dir: %s", f.Name)
+			Logger.Println("error:", err)
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			_ = os.MkdirAll(pth, os.ModePerm)
+			continue
+		}
+		_ = os.MkdirAll(filepath.Dir(pth), os.ModePerm)
+		rc, err := f.Open()
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			err = os.Symlink(string(data), pth)
+		} else {
+			err = os.WriteFile(pth, data, f.Mode().Perm())
+		}
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
 func sha256Hex(data []byte) string {
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
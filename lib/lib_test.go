@@ -1,8 +1,12 @@
 package lib
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDropLinesWithAny(t *testing.T) {
@@ -39,3 +43,70 @@ func TestChunk(t *testing.T) {
 		}
 	}
 }
+
+func TestZipBuilder(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "pkg", "__pycache__"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "pkg", "b.py"), []byte("b"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "pkg", "__pycache__", "b.pyc"), []byte("b"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "main"), []byte("main"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := func() []byte {
+		z := NewZipBuilder()
+		err := z.AddDir("", dir, func(rel string, info os.FileInfo) bool {
+			return info.Name() == "__pycache__"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		z.AddBytes("a.txt", []byte("a"), 0600)
+		want := []string{"a.txt", "main", "pkg/b.py"}
+		if !reflect.DeepEqual(z.Names(), want) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", z.Names(), want)
+		}
+		var buf bytes.Buffer
+		err = z.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := build()
+	now := time.Now().Add(time.Hour)
+	err = os.Chtimes(filepath.Join(dir, "main"), now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := build()
+	if !bytes.Equal(first, second) {
+		t.Errorf("expected identical zip bytes for identical content")
+	}
+	zipFile := filepath.Join(dir, "out.zip")
+	err = os.WriteFile(zipFile, first, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	err = ZipExtract(zipFile, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(out, "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("\ngot:\n%v\nwant:\n%v\n", info.Mode().Perm(), os.FileMode(0755))
+	}
+}
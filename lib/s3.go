@@ -1,12 +1,25 @@
 package lib
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -67,6 +80,11 @@ func S3BucketRegion(bucket string) (string, error) {
 	s3BucketRegionLock.Lock()
 	defer s3BucketRegionLock.Unlock()
 	region, ok := s3BucketRegion[bucket]
+	if !ok && EndpointUrl("s3") != "" {
+		region = Region() // local emulators have no x-amz-bucket-region lookup, everything lives in the session region
+		s3BucketRegion[bucket] = region
+		ok = true
+	}
 	if !ok {
 		cacheFile := "/tmp/aws.s3.bucket.region=" + bucket
 		data, err := os.ReadFile(cacheFile)
@@ -162,7 +180,7 @@ type s3EnsureInput struct {
 	metrics      bool
 	cors         *bool
 	corsOrigins  []string
-	ttlDays      int
+	lifecycle    *s3Lifecycle
 
 	// NOTE: you almost never want to use this, danger close.
 	// currently used in cmd/vpc/ensure_flowlogs.go
@@ -177,8 +195,175 @@ func s3EnsureInputDefault() *s3EnsureInput {
 		encryption: true,
 		metrics:    true,
 		cors:       nil,
-		ttlDays:    0,
+		lifecycle:  newS3Lifecycle(),
+	}
+}
+
+var s3StorageClasses = map[string]string{
+	"standard_ia":  s3.TransitionStorageClassStandardIa,
+	"glacier_ir":   s3.TransitionStorageClassGlacierIr,
+	"deep_archive": s3.TransitionStorageClassDeepArchive,
+}
+
+// lifecycle rules managed by s3-ensure, one rule per prefix. the empty
+// prefix is the whole bucket and also holds the bucket wide settings.
+type s3Lifecycle struct {
+	expire               map[string]int64            // prefix => days
+	transition           map[string]map[string]int64 // prefix => storage class => days
+	noncurrentExpireDays int64
+	abortMultipartDays   int64
+}
+
+func newS3Lifecycle() *s3Lifecycle {
+	return &s3Lifecycle{
+		expire:     map[string]int64{},
+		transition: map[string]map[string]int64{},
+	}
+}
+
+func (l *s3Lifecycle) prefixes() []string {
+	var prefixes []string
+	for prefix := range l.expire {
+		prefixes = append(prefixes, prefix)
+	}
+	for prefix := range l.transition {
+		if _, ok := l.expire[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if (l.noncurrentExpireDays != 0 || l.abortMultipartDays != 0) && l.expire[""] == 0 && l.transition[""] == nil {
+		prefixes = append(prefixes, "")
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func (l *s3Lifecycle) Attrs() []string {
+	var attrs []string
+	for prefix, days := range l.expire {
+		if prefix == "" {
+			attrs = append(attrs, fmt.Sprintf("ttldays=%d", days))
+		} else {
+			attrs = append(attrs, fmt.Sprintf("expire=%d:%s", days, prefix))
+		}
+	}
+	for prefix, classes := range l.transition {
+		for class, days := range classes {
+			attr := fmt.Sprintf("transition=%s:%d", strings.ToLower(class), days)
+			if prefix != "" {
+				attr += ":" + prefix
+			}
+			attrs = append(attrs, attr)
+		}
+	}
+	if l.noncurrentExpireDays != 0 {
+		attrs = append(attrs, fmt.Sprintf("noncurrent-expire=%d", l.noncurrentExpireDays))
 	}
+	if l.abortMultipartDays != 0 {
+		attrs = append(attrs, fmt.Sprintf("abort-multipart=%d", l.abortMultipartDays))
+	}
+	sort.Strings(attrs)
+	return attrs
+}
+
+func (l *s3Lifecycle) Rules() []*s3.LifecycleRule {
+	var rules []*s3.LifecycleRule
+	for _, prefix := range l.prefixes() {
+		rule := &s3.LifecycleRule{
+			ID:     aws.String("prefix=" + prefix),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(prefix)},
+			Status: aws.String(s3.ExpirationStatusEnabled),
+		}
+		if days, ok := l.expire[prefix]; ok {
+			rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(days)}
+		}
+		var classes []string
+		for class := range l.transition[prefix] {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool { return l.transition[prefix][classes[i]] < l.transition[prefix][classes[j]] })
+		for _, class := range classes {
+			rule.Transitions = append(rule.Transitions, &s3.Transition{
+				Days:         aws.Int64(l.transition[prefix][class]),
+				StorageClass: aws.String(class),
+			})
+		}
+		if prefix == "" && l.noncurrentExpireDays != 0 {
+			rule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(l.noncurrentExpireDays)}
+		}
+		if prefix == "" && l.abortMultipartDays != 0 {
+			rule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(l.abortMultipartDays)}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// parse lifecycle rules into the subset s3-ensure manages. returns false if
+// any rule uses features that cannot be expressed as attrs, like tag filters.
+func s3LifecycleFromRules(rules []*s3.LifecycleRule) (*s3Lifecycle, bool) {
+	l := newS3Lifecycle()
+	for _, rule := range rules {
+		if rule.Status == nil || *rule.Status != s3.ExpirationStatusEnabled {
+			return l, false
+		}
+		prefix := ""
+		if rule.Prefix != nil {
+			prefix = *rule.Prefix
+		}
+		if rule.Filter != nil {
+			if rule.Filter.And != nil || rule.Filter.Tag != nil || rule.Filter.ObjectSizeGreaterThan != nil || rule.Filter.ObjectSizeLessThan != nil {
+				return l, false
+			}
+			if rule.Filter.Prefix != nil {
+				prefix = *rule.Filter.Prefix
+			}
+		}
+		if rule.Expiration != nil {
+			if rule.Expiration.Days == nil || rule.Expiration.Date != nil {
+				return l, false
+			}
+			if _, ok := l.expire[prefix]; ok {
+				return l, false
+			}
+			l.expire[prefix] = *rule.Expiration.Days
+		}
+		for _, transition := range rule.Transitions {
+			if transition.Days == nil || transition.StorageClass == nil {
+				return l, false
+			}
+			if l.transition[prefix] == nil {
+				l.transition[prefix] = map[string]int64{}
+			}
+			l.transition[prefix][*transition.StorageClass] = *transition.Days
+		}
+		if len(rule.NoncurrentVersionTransitions) != 0 {
+			return l, false
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			if prefix != "" || rule.NoncurrentVersionExpiration.NoncurrentDays == nil {
+				return l, false
+			}
+			l.noncurrentExpireDays = *rule.NoncurrentVersionExpiration.NoncurrentDays
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			if prefix != "" || rule.AbortIncompleteMultipartUpload.DaysAfterInitiation == nil {
+				return l, false
+			}
+			l.abortMultipartDays = *rule.AbortIncompleteMultipartUpload.DaysAfterInitiation
+		}
+	}
+	return l, true
+}
+
+func s3ParseLifecycleDays(line, value string) (int64, error) {
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		err := fmt.Errorf("lifecycle days must be a positive integer: %s", line)
+		Logger.Println("error:", err)
+		return 0, err
+	}
+	return int64(days), nil
 }
 
 func S3EnsureInput(infraSetName, bucketName string, attrs []string) (*s3EnsureInput, error) {
@@ -186,15 +371,72 @@ func S3EnsureInput(infraSetName, bucketName string, attrs []string) (*s3EnsureIn
 	input.infraSetName = infraSetName
 	input.name = bucketName
 	for _, line := range attrs {
-		line = strings.ToLower(line)
-		attr, value, err := SplitOnce(line, "=")
+		attr, rawValue, err := SplitOnce(line, "=")
 		if err != nil {
 			Logger.Println("error:", err)
 			return nil, err
 		}
+		attr = strings.ToLower(attr)
+		value := strings.ToLower(rawValue)
+		line = attr + "=" + rawValue
 		switch attr {
 		case "ttldays":
-			input.ttlDays = Atoi(value)
+			if value != "0" {
+				days, err := s3ParseLifecycleDays(line, value)
+				if err != nil {
+					Logger.Println("error:", err)
+					return nil, err
+				}
+				input.lifecycle.expire[""] = days
+			}
+		case "expire":
+			daysStr, prefix, err := SplitOnce(rawValue, ":")
+			if err != nil || prefix == "" {
+				err := fmt.Errorf("expire should be DAYS:PREFIX, use ttldays=DAYS for the whole bucket: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			days, err := s3ParseLifecycleDays(line, daysStr)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.lifecycle.expire[prefix] = days
+		case "transition":
+			parts := strings.SplitN(rawValue, ":", 3)
+			class, ok := s3StorageClasses[strings.ToLower(parts[0])]
+			if len(parts) < 2 || !ok {
+				err := fmt.Errorf("transition should be CLASS:DAYS[:PREFIX] with class in standard_ia, glacier_ir, deep_archive: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			days, err := s3ParseLifecycleDays(line, parts[1])
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			prefix := ""
+			if len(parts) == 3 {
+				prefix = parts[2]
+			}
+			if input.lifecycle.transition[prefix] == nil {
+				input.lifecycle.transition[prefix] = map[string]int64{}
+			}
+			input.lifecycle.transition[prefix][class] = days
+		case "noncurrent-expire":
+			days, err := s3ParseLifecycleDays(line, value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.lifecycle.noncurrentExpireDays = days
+		case "abort-multipart":
+			days, err := s3ParseLifecycleDays(line, value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.lifecycle.abortMultipartDays = days
 		case "corsorigin":
 			if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
 				err := fmt.Errorf("corsorigin must begin with http or https: %s", line)
@@ -252,9 +494,33 @@ func S3EnsureInput(infraSetName, bucketName string, attrs []string) (*s3EnsureIn
 	if len(input.corsOrigins) > 0 {
 		input.cors = aws.Bool(true)
 	}
+	if input.lifecycle.noncurrentExpireDays != 0 && !input.versioning {
+		err := fmt.Errorf("noncurrent-expire requires versioning=true: %s", bucketName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
 	return input, nil
 }
 
+// bucket settings are always reported, then sorted cors origins and lifecycle rules
+func (input *s3EnsureInput) canonicalAttrs() []string {
+	attrs := []string{
+		"acl=" + input.acl,
+		fmt.Sprintf("versioning=%t", input.versioning),
+		fmt.Sprintf("encryption=%t", input.encryption),
+		fmt.Sprintf("metrics=%t", input.metrics),
+	}
+	if input.cors != nil {
+		attrs = append(attrs, fmt.Sprintf("cors=%t", *input.cors))
+	}
+	origins := append([]string{}, input.corsOrigins...)
+	sort.Strings(origins)
+	for _, origin := range origins {
+		attrs = append(attrs, "corsorigin="+origin)
+	}
+	return append(attrs, input.lifecycle.Attrs()...)
+}
+
 func s3PublicPolicy(bucket string) IamPolicyDocument {
 	return IamPolicyDocument{
 		Version: "2012-10-17",
@@ -709,87 +975,50 @@ func S3Ensure(ctx context.Context, input *s3EnsureInput, preview bool) error {
 			Logger.Println(PreviewString(preview)+"delete bucket metrics for:", input.name)
 		}
 	}
-	ttlOut, err := S3Client().GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+	lifecycleOut, err := S3Client().GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
 		Bucket:              aws.String(input.name),
 		ExpectedBucketOwner: aws.String(account),
 	})
+	var rules []*s3.LifecycleRule
 	if err != nil {
 		aerr, ok := err.(awserr.Error)
 		if !ok || !Contains([]string{"NoSuchLifecycleConfiguration", "NoSuchBucket"}, aerr.Code()) {
 			Logger.Println("error:", err)
 			return err
 		}
-		if input.ttlDays != 0 {
-			if !preview {
-				_, err := S3Client().PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
-					Bucket:              aws.String(input.name),
-					ExpectedBucketOwner: aws.String(account),
-					LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
-						Rules: []*s3.LifecycleRule{{
-							Expiration: &s3.LifecycleExpiration{
-								Days: aws.Int64(int64(input.ttlDays)),
-							},
-							ID:     aws.String(fmt.Sprintf("ttlDays=%d", input.ttlDays)),
-							Prefix: aws.String(""),
-							Status: aws.String("Enabled"),
-						}},
-					},
-				})
-				if err != nil {
-					Logger.Println("error:", err)
-					return err
-				}
-			}
-			Logger.Println(PreviewString(preview)+"put bucket ttl days for:", input.name, input.ttlDays)
-		}
 	} else {
-		if input.ttlDays == 0 {
-			if !preview {
-				_, err := S3Client().DeleteBucketLifecycleWithContext(ctx, &s3.DeleteBucketLifecycleInput{
-					Bucket:              aws.String(input.name),
-					ExpectedBucketOwner: aws.String(account),
-				})
-				if err != nil {
-					Logger.Println("error:", err)
-					return err
-				}
-			}
-			Logger.Println(PreviewString(preview)+"deleted bucket ttl for:", input.name)
-		} else {
-			if len(ttlOut.Rules) != 1 {
-				err := fmt.Errorf("expected exactly 1 ttl rule: %s %s", input.name, Pformat(ttlOut.Rules))
+		rules = lifecycleOut.Rules
+	}
+	current, supported := s3LifecycleFromRules(rules)
+	currentAttrs := current.Attrs()
+	wantAttrs := input.lifecycle.Attrs()
+	if len(rules) != 0 && len(wantAttrs) == 0 {
+		if !preview {
+			_, err := S3Client().DeleteBucketLifecycleWithContext(ctx, &s3.DeleteBucketLifecycleInput{
+				Bucket:              aws.String(input.name),
+				ExpectedBucketOwner: aws.String(account),
+			})
+			if err != nil {
 				Logger.Println("error:", err)
 				return err
 			}
-			ttlDays := ttlOut.Rules[0].Expiration.Days
-			if ttlDays == nil || *ttlDays != int64(input.ttlDays) {
-				if !preview {
-					_, err := S3Client().PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
-						Bucket:              aws.String(input.name),
-						ExpectedBucketOwner: aws.String(account),
-						LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
-							Rules: []*s3.LifecycleRule{
-								{
-									Prefix: aws.String(""),
-									Expiration: &s3.LifecycleExpiration{
-										Days: aws.Int64(int64(input.ttlDays)),
-									},
-									Status: aws.String("Enabled"),
-								},
-							},
-						},
-					})
-					if err != nil {
-						Logger.Println("error:", err)
-						return err
-					}
-				}
-				if ttlDays == nil {
-					*ttlDays = 0
-				}
-				Logger.Printf(PreviewString(preview)+"updated bucket ttl for %s: %d => %d\n", input.name, *ttlDays, input.ttlDays)
+		}
+		Logger.Println(PreviewString(preview)+"deleted bucket lifecycle for:", input.name)
+	} else if len(wantAttrs) != 0 && (!supported || !reflect.DeepEqual(currentAttrs, wantAttrs)) {
+		if !preview {
+			_, err := S3Client().PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+				Bucket:              aws.String(input.name),
+				ExpectedBucketOwner: aws.String(account),
+				LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+					Rules: input.lifecycle.Rules(),
+				},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
 			}
 		}
+		Logger.Printf(PreviewString(preview)+"updated bucket lifecycle for %s: %v => %v\n", input.name, currentAttrs, wantAttrs)
 	}
 	return nil
 }
@@ -799,11 +1028,13 @@ func S3DeleteBucket(ctx context.Context, bucket string, preview bool) error {
 		d := &Debug{start: time.Now(), name: "S3DeleteBucket"}
 		defer d.Log()
 	}
-	resp, err := http.Head(fmt.Sprintf("https://%s.s3.amazonaws.com", bucket))
-	if err == nil {
-		_ = resp.Body.Close()
-		if resp.StatusCode == 404 { // already deleted
-			return nil
+	if EndpointUrl("s3") == "" {
+		resp, err := http.Head(fmt.Sprintf("https://%s.s3.amazonaws.com", bucket))
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == 404 { // already deleted
+				return nil
+			}
 		}
 	}
 	s3Client, err := S3ClientBucketRegion(bucket)
@@ -1113,3 +1344,808 @@ func S3PresignGet(bucket, key, byterange string, expire time.Duration) string {
 	}
 	return url
 }
+
+const (
+	S3PartSizeDefault    = 64 * 1024 * 1024
+	S3ParallelismDefault = 8
+	s3PartSizeMin        = 5 * 1024 * 1024
+	s3PartsMax           = 10000
+)
+
+type S3StreamInput struct {
+	Bucket      string
+	Key         string
+	PartSize    int64
+	Parallelism int
+	Sha256      bool   // put only, add per-part sha256 checksums
+	ContentType string // put only
+}
+
+func s3StreamInputDefaults(input *S3StreamInput) error {
+	if input.PartSize == 0 {
+		input.PartSize = S3PartSizeDefault
+	}
+	if input.Parallelism == 0 {
+		input.Parallelism = S3ParallelismDefault
+	}
+	if input.PartSize < s3PartSizeMin {
+		err := fmt.Errorf("s3 part size must be at least %d bytes, got: %d", s3PartSizeMin, input.PartSize)
+		Logger.Println("error:", err)
+		return err
+	}
+	if input.Parallelism < 1 {
+		err := fmt.Errorf("s3 parallelism must be at least 1, got: %d", input.Parallelism)
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+// mirrors S3PutStream, which only knows a reader is done after a short read
+func s3StreamIsMultipart(size, partSize int64) bool {
+	return size >= partSize
+}
+
+func s3Sha256(data []byte) *string {
+	hash := sha256.Sum256(data)
+	return aws.String(base64.StdEncoding.EncodeToString(hash[:]))
+}
+
+// stream a reader to s3. objects smaller than one part use a single put,
+// otherwise parts are uploaded concurrently and the multipart upload is
+// aborted on any failure. memory use is bounded by parallelism*partSize.
+func S3PutStream(ctx context.Context, s3Client *s3.S3, input *S3StreamInput, reader io.Reader) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "S3PutStream"}
+		defer d.Log()
+	}
+	err := s3StreamInputDefaults(input)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	buffers := make(chan []byte, input.Parallelism)
+	for i := 0; i < input.Parallelism; i++ {
+		buffers <- nil
+	}
+	readPart := func() ([]byte, []byte, bool, error) {
+		buf := <-buffers
+		if buf == nil {
+			buf = make([]byte, input.PartSize)
+		}
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return buf, buf[:n], true, nil
+		}
+		if err != nil {
+			return buf, nil, false, err
+		}
+		return buf, buf[:n], false, nil
+	}
+	buf, data, eof, err := readPart()
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if eof {
+		putInput := &s3.PutObjectInput{
+			Bucket: aws.String(input.Bucket),
+			Key:    aws.String(input.Key),
+			Body:   bytes.NewReader(data),
+		}
+		if input.ContentType != "" {
+			putInput.ContentType = aws.String(input.ContentType)
+		}
+		if input.Sha256 {
+			putInput.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+			putInput.ChecksumSHA256 = s3Sha256(data)
+		}
+		_, err := s3Client.PutObjectWithContext(ctx, putInput)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		return nil
+	}
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+	}
+	if input.ContentType != "" {
+		createInput.ContentType = aws.String(input.ContentType)
+	}
+	if input.Sha256 {
+		createInput.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	createOut, err := s3Client.CreateMultipartUploadWithContext(ctx, createInput)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	uploadID := createOut.UploadId
+	abort := func() {
+		_, err := s3Client.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(input.Bucket),
+			Key:      aws.String(input.Key),
+			UploadId: uploadID,
+		})
+		if err != nil {
+			Logger.Println("error: failed to abort multipart upload:", input.Bucket, input.Key, *uploadID, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lock sync.Mutex
+	var uploadErr error
+	var parts []*s3.CompletedPart
+	var wg sync.WaitGroup
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+			cancel()
+		}
+	}
+	for partNumber := int64(1); ; partNumber++ {
+		if partNumber > s3PartsMax {
+			fail(fmt.Errorf("s3 upload exceeds %d parts, increase part size: %s/%s", s3PartsMax, input.Bucket, input.Key))
+			break
+		}
+		partNumber := partNumber
+		partBuf := buf
+		partData := data
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logRecover(r)
+				}
+			}()
+			defer wg.Done()
+			defer func() { buffers <- partBuf }()
+			partInput := &s3.UploadPartInput{
+				Bucket:     aws.String(input.Bucket),
+				Key:        aws.String(input.Key),
+				UploadId:   uploadID,
+				PartNumber: aws.Int64(partNumber),
+				Body:       bytes.NewReader(partData),
+			}
+			if input.Sha256 {
+				partInput.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+				partInput.ChecksumSHA256 = s3Sha256(partData)
+			}
+			out, err := s3Client.UploadPartWithContext(ctx, partInput)
+			if err != nil {
+				fail(err)
+				return
+			}
+			lock.Lock()
+			parts = append(parts, &s3.CompletedPart{
+				PartNumber:     aws.Int64(partNumber),
+				ETag:           out.ETag,
+				ChecksumSHA256: out.ChecksumSHA256,
+			})
+			lock.Unlock()
+		}()
+		if eof {
+			break
+		}
+		buf, data, eof, err = readPart()
+		if err != nil {
+			fail(err)
+			break
+		}
+		if len(data) == 0 {
+			buffers <- buf
+			break
+		}
+		lock.Lock()
+		failed := uploadErr != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+	}
+	wg.Wait()
+	if uploadErr != nil {
+		Logger.Println("error:", uploadErr)
+		abort()
+		return uploadErr
+	}
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	_, err = s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(input.Bucket),
+		Key:             aws.String(input.Key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		abort()
+		return err
+	}
+	return nil
+}
+
+type s3GetPart struct {
+	buf  []byte
+	data []byte
+	err  error
+}
+
+// stream an object from s3 to a writer using concurrent ranged gets, written
+// in order. the etag is pinned so a concurrent overwrite fails the download.
+func S3GetStream(ctx context.Context, s3Client *s3.S3, input *S3StreamInput, writer io.Writer) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "S3GetStream"}
+		defer d.Log()
+	}
+	err := s3StreamInputDefaults(input)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	head, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	size := *head.ContentLength
+	if size <= input.PartSize {
+		out, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(input.Bucket),
+			Key:     aws.String(input.Key),
+			IfMatch: head.ETag,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		defer func() { _ = out.Body.Close() }()
+		_, err = io.Copy(writer, out.Body)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	numParts := (size + input.PartSize - 1) / input.PartSize
+	results := make([]chan *s3GetPart, numParts)
+	for i := range results {
+		results[i] = make(chan *s3GetPart, 1)
+	}
+	buffers := make(chan []byte, input.Parallelism)
+	for i := 0; i < input.Parallelism; i++ {
+		buffers <- nil
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logRecover(r)
+			}
+		}()
+		for i := int64(0); i < numParts; i++ {
+			var buf []byte
+			select {
+			case <-ctx.Done():
+				return
+			case buf = <-buffers:
+			}
+			if buf == nil {
+				buf = make([]byte, input.PartSize)
+			}
+			i := i
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logRecover(r)
+					}
+				}()
+				start := i * input.PartSize
+				end := start + input.PartSize - 1
+				if end > size-1 {
+					end = size - 1
+				}
+				out, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+					Bucket:  aws.String(input.Bucket),
+					Key:     aws.String(input.Key),
+					IfMatch: head.ETag,
+					Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				})
+				if err != nil {
+					results[i] <- &s3GetPart{buf: buf, err: err}
+					return
+				}
+				defer func() { _ = out.Body.Close() }()
+				n, err := io.ReadFull(out.Body, buf[:end-start+1])
+				results[i] <- &s3GetPart{buf: buf, data: buf[:n], err: err}
+			}()
+		}
+	}()
+	for i := range results {
+		part := <-results[i]
+		if part.err != nil {
+			Logger.Println("error:", part.err)
+			return part.err
+		}
+		_, err := writer.Write(part.data)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		buffers <- part.buf
+	}
+	return nil
+}
+
+type S3SyncInput struct {
+	Src            string // local directory or s3://bucket/prefix
+	Dst            string // local directory or s3://bucket/prefix
+	Delete         bool
+	Include        []string
+	Exclude        []string
+	MaxConcurrency int
+	PartSize       int64
+}
+
+type s3SyncEntry struct {
+	size              int64
+	etag              string
+	checksumAlgorithm []string
+}
+
+// returns the etag and sha256 checksum s3 would report for a file uploaded
+// with S3PutStream, including the -N suffix of multipart uploads
+func s3LocalChecksums(filePath string, partSize int64) (string, string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", "", err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", "", err
+	}
+	if !s3StreamIsMultipart(info.Size(), partSize) {
+		md5Hash := md5.New()
+		sha256Hash := sha256.New()
+		_, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", "", err
+		}
+		return hex.EncodeToString(md5Hash.Sum(nil)), base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)), nil
+	}
+	var md5s []byte
+	var sha256s []byte
+	parts := 0
+	for {
+		md5Hash := md5.New()
+		sha256Hash := sha256.New()
+		n, err := io.CopyN(io.MultiWriter(md5Hash, sha256Hash), f, partSize)
+		if err != nil && err != io.EOF {
+			Logger.Println("error:", err)
+			return "", "", err
+		}
+		if n == 0 {
+			break
+		}
+		md5s = append(md5s, md5Hash.Sum(nil)...)
+		sha256s = append(sha256s, sha256Hash.Sum(nil)...)
+		parts++
+		if n < partSize {
+			break
+		}
+	}
+	md5Sum := md5.Sum(md5s)
+	sha256Sum := sha256.Sum256(sha256s)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(md5Sum[:]), parts), fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(sha256Sum[:]), parts), nil
+}
+
+func s3SyncMatch(rel string, include, exclude []string) (bool, error) {
+	match := func(patterns []string) (bool, error) {
+		for _, pattern := range patterns {
+			for _, name := range []string{rel, path.Base(rel)} {
+				ok, err := path.Match(pattern, name)
+				if err != nil {
+					Logger.Println("error:", err)
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	if len(include) != 0 {
+		ok, err := match(include)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	ok, err := match(exclude)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+func s3SyncListLocal(root string, include, exclude []string) (map[string]*s3SyncEntry, error) {
+	res := make(map[string]*s3SyncEntry)
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		ok, err := s3SyncMatch(rel, include, exclude)
+		if err != nil || !ok {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		res[rel] = &s3SyncEntry{size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return res, nil
+}
+
+func s3SyncListRemote(ctx context.Context, s3Client *s3.S3, bucket, prefix string, include, exclude []string) (map[string]*s3SyncEntry, error) {
+	res := make(map[string]*s3SyncEntry)
+	var token *string
+	for {
+		out, err := s3Client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			Prefix:            aws.String(prefix),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			if strings.HasSuffix(*obj.Key, "/") {
+				continue
+			}
+			rel := strings.TrimPrefix(*obj.Key, prefix)
+			ok, err := s3SyncMatch(rel, include, exclude)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			res[rel] = &s3SyncEntry{
+				size:              *obj.Size,
+				etag:              strings.Trim(*obj.ETag, `"`),
+				checksumAlgorithm: aws.StringValueSlice(obj.ChecksumAlgorithm),
+			}
+		}
+		if out.NextContinuationToken == nil {
+			break
+		}
+		token = out.NextContinuationToken
+	}
+	return res, nil
+}
+
+// compare by size, then by sha256 checksum when the object has one, else by etag
+func s3SyncEqual(ctx context.Context, s3Client *s3.S3, bucket, key, filePath string, local, remote *s3SyncEntry, partSize int64) (bool, error) {
+	if local.size != remote.size {
+		return false, nil
+	}
+	etag, checksum, err := s3LocalChecksums(filePath, partSize)
+	if err != nil {
+		Logger.Println("error:", err)
+		return false, err
+	}
+	if Contains(remote.checksumAlgorithm, s3.ChecksumAlgorithmSha256) {
+		out, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return false, err
+		}
+		if out.ChecksumSHA256 != nil {
+			return *out.ChecksumSHA256 == checksum, nil
+		}
+	}
+	return remote.etag == etag, nil
+}
+
+func s3SyncParse(p string) (bool, string, string, error) {
+	if !strings.HasPrefix(p, "s3://") {
+		return false, "", "", nil
+	}
+	bucket, prefix, err := SplitOnce(strings.TrimPrefix(p, "s3://")+"/", "/")
+	if err != nil {
+		Logger.Println("error:", err)
+		return false, "", "", err
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return true, bucket, prefix, nil
+}
+
+// mirror a local directory to an s3 prefix or an s3 prefix to a local
+// directory. one side must be s3://bucket/prefix and the other a local path.
+func S3Sync(ctx context.Context, input *S3SyncInput, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "S3Sync"}
+		defer d.Log()
+	}
+	if input.MaxConcurrency == 0 {
+		input.MaxConcurrency = 16
+	}
+	if input.PartSize == 0 {
+		input.PartSize = S3PartSizeDefault
+	}
+	srcIsS3, srcBucket, srcPrefix, err := s3SyncParse(input.Src)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	dstIsS3, dstBucket, dstPrefix, err := s3SyncParse(input.Dst)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if srcIsS3 == dstIsS3 {
+		err := fmt.Errorf("s3 sync needs exactly one s3:// path, got: %s %s", input.Src, input.Dst)
+		Logger.Println("error:", err)
+		return err
+	}
+	upload := dstIsS3
+	localRoot, bucket, prefix := input.Src, dstBucket, dstPrefix
+	if !upload {
+		localRoot, bucket, prefix = input.Dst, srcBucket, srcPrefix
+	}
+	if upload {
+		info, err := os.Stat(localRoot)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if !info.IsDir() {
+			err := fmt.Errorf("s3 sync source is not a directory: %s", localRoot)
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	s3Client, err := S3ClientBucketRegion(bucket)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	local, err := s3SyncListLocal(localRoot, input.Include, input.Exclude)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	remote, err := s3SyncListRemote(ctx, s3Client, bucket, prefix, input.Include, input.Exclude)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	src, dst := local, remote
+	if !upload {
+		src, dst = remote, local
+	}
+	var rels []string
+	for rel := range src {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lock sync.Mutex
+	var syncErr error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, input.MaxConcurrency)
+	for _, rel := range rels {
+		rel := rel
+		sem <- struct{}{}
+		lock.Lock()
+		failed := syncErr != nil
+		lock.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logRecover(r)
+				}
+			}()
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := s3SyncOne(ctx, s3Client, input, bucket, prefix+rel, filepath.Join(localRoot, filepath.FromSlash(rel)), local[rel], remote[rel], upload, preview)
+			if err != nil {
+				lock.Lock()
+				if syncErr == nil {
+					syncErr = err
+					cancel()
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if syncErr != nil {
+		Logger.Println("error:", syncErr)
+		return syncErr
+	}
+	if !input.Delete {
+		return nil
+	}
+	var deletes []string
+	for rel := range dst {
+		if src[rel] == nil {
+			deletes = append(deletes, rel)
+		}
+	}
+	sort.Strings(deletes)
+	if !upload {
+		for _, rel := range deletes {
+			filePath := filepath.Join(localRoot, filepath.FromSlash(rel))
+			if !preview {
+				err := os.Remove(filePath)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"deleted:", filePath)
+		}
+		return nil
+	}
+	for len(deletes) > 0 {
+		batch := deletes
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		deletes = deletes[len(batch):]
+		var objects []*s3.ObjectIdentifier
+		for _, rel := range batch {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(prefix + rel)})
+		}
+		if !preview {
+			out, err := s3Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &s3.Delete{Objects: objects},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			for _, e := range out.Errors {
+				err := fmt.Errorf("failed to delete s3://%s/%s: %s", bucket, *e.Key, *e.Message)
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		for _, rel := range batch {
+			Logger.Println(PreviewString(preview)+"deleted:", "s3://"+bucket+"/"+prefix+rel)
+		}
+	}
+	return nil
+}
+
+// files are synced MaxConcurrency at a time with one part in flight each, so
+// that memory stays at MaxConcurrency parts instead of MaxConcurrency times
+// S3ParallelismDefault parts
+const s3SyncParallelism = 1
+
+func s3SyncOne(ctx context.Context, s3Client *s3.S3, input *S3SyncInput, bucket, key, filePath string, local, remote *s3SyncEntry, upload, preview bool) error {
+	if local != nil && remote != nil {
+		equal, err := s3SyncEqual(ctx, s3Client, bucket, key, filePath, local, remote, input.PartSize)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if equal {
+			return nil
+		}
+	}
+	s3Path := "s3://" + bucket + "/" + key
+	if upload {
+		if !preview {
+			f, err := os.Open(filePath)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			partSize := input.PartSize
+			if !s3StreamIsMultipart(local.size, partSize) {
+				partSize = int64(Max(s3PartSizeMin, int(local.size)+1)) // avoid allocating a full part for small files
+			}
+			err = S3PutStream(ctx, s3Client, &S3StreamInput{
+				Bucket:      bucket,
+				Key:         key,
+				PartSize:    partSize,
+				Parallelism: s3SyncParallelism,
+				Sha256:      true,
+				ContentType: mime.TypeByExtension(path.Ext(key)),
+			}, f)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"upload:", filePath, "=>", s3Path)
+		return nil
+	}
+	if !preview {
+		err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		tmpPath := filePath + ".libaws-sync.tmp"
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		defer func() { _ = os.Remove(tmpPath) }()
+		w := bufio.NewWriter(f)
+		err = S3GetStream(ctx, s3Client, &S3StreamInput{
+			Bucket:      bucket,
+			Key:         key,
+			PartSize:    input.PartSize,
+			Parallelism: s3SyncParallelism,
+		}, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = f.Close()
+		} else {
+			_ = f.Close()
+		}
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		err = os.Rename(tmpPath, filePath)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"download:", s3Path, "=>", filePath)
+	return nil
+}
@@ -1,7 +1,13 @@
 package lib
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -503,3 +509,170 @@ func TestS3EnsurePublicToPrivateNotAllowed(t *testing.T) {
 		return
 	}
 }
+
+func TestS3PutGetStream(t *testing.T) {
+	checkAccountS3()
+	bucket := "libaws-s3-test-" + uuid.Must(uuid.NewV4()).String()
+	input, err := S3EnsureInput("", bucket, []string{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ctx := context.Background()
+	err = S3Ensure(ctx, input, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		err := S3DeleteBucket(ctx, bucket, false)
+		if err != nil {
+			panic(err)
+		}
+	}()
+	for _, size := range []int{0, 1024, s3PartSizeMin, 2*s3PartSizeMin + 1024} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		key := fmt.Sprintf("stream-%d", size)
+		err = S3PutStream(ctx, S3Client(), &S3StreamInput{
+			Bucket:      bucket,
+			Key:         key,
+			PartSize:    s3PartSizeMin,
+			Parallelism: 2,
+			Sha256:      true,
+		}, bytes.NewReader(data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		err = S3GetStream(ctx, S3Client(), &S3StreamInput{
+			Bucket:      bucket,
+			Key:         key,
+			PartSize:    s3PartSizeMin,
+			Parallelism: 2,
+		}, &buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("roundtrip mismatch for size: %d", size)
+			return
+		}
+	}
+}
+
+func TestS3SyncMatch(t *testing.T) {
+	type test struct {
+		rel     string
+		include []string
+		exclude []string
+		match   bool
+	}
+	tests := []test{
+		{"index.html", nil, nil, true},
+		{"css/site.css", []string{"*.css"}, nil, true},
+		{"css/site.css", []string{"*.html"}, nil, false},
+		{"js/app.js.map", nil, []string{"*.map"}, false},
+		{"js/app.js", nil, []string{"*.map"}, true},
+		{"drafts/post.html", []string{"*.html"}, []string{"drafts/*"}, false},
+	}
+	for _, test := range tests {
+		match, err := s3SyncMatch(test.rel, test.include, test.exclude)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if match != test.match {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", match, test.match)
+		}
+	}
+}
+
+func TestS3LocalChecksums(t *testing.T) {
+	data := []byte("0123456789")
+	filePath := t.TempDir() + "/data"
+	err := os.WriteFile(filePath, data, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	etag, checksum, err := s3LocalChecksums(filePath, 11)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if etag != hex.EncodeToString(md5Sum[:]) || checksum != base64.StdEncoding.EncodeToString(sha256Sum[:]) {
+		t.Errorf("\ngot:\n%s %s\n", etag, checksum)
+	}
+	var md5s []byte
+	var sha256s []byte
+	for _, part := range [][]byte{data[:4], data[4:8], data[8:]} {
+		md5Part := md5.Sum(part)
+		sha256Part := sha256.Sum256(part)
+		md5s = append(md5s, md5Part[:]...)
+		sha256s = append(sha256s, sha256Part[:]...)
+	}
+	md5Sum = md5.Sum(md5s)
+	sha256Sum = sha256.Sum256(sha256s)
+	etag, checksum, err = s3LocalChecksums(filePath, 4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	wantEtag := hex.EncodeToString(md5Sum[:]) + "-3"
+	wantChecksum := base64.StdEncoding.EncodeToString(sha256Sum[:]) + "-3"
+	if etag != wantEtag || checksum != wantChecksum {
+		t.Errorf("\ngot:\n%s %s\nwant:\n%s %s\n", etag, checksum, wantEtag, wantChecksum)
+	}
+}
+
+func TestS3EnsureInputLifecycle(t *testing.T) {
+	type test struct {
+		attrs []string
+		want  []string
+		err   bool
+	}
+	tests := []test{
+		{[]string{"ttldays=7"}, []string{"ttldays=7"}, false},
+		{[]string{"ttldays=0"}, nil, false},
+		{
+			[]string{"expire=30:Logs/", "transition=STANDARD_IA:30", "transition=deep_archive:180:Logs/", "abort-multipart=3"},
+			[]string{"abort-multipart=3", "expire=30:Logs/", "transition=deep_archive:180:Logs/", "transition=standard_ia:30"},
+			false,
+		},
+		{[]string{"versioning=true", "noncurrent-expire=14"}, []string{"noncurrent-expire=14"}, false},
+		{[]string{"noncurrent-expire=14"}, nil, true},
+		{[]string{"expire=30"}, nil, true},
+		{[]string{"transition=glacier:30"}, nil, true},
+		{[]string{"abort-multipart=0"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := S3EnsureInput("", "bucket", test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", test.attrs, err)
+			continue
+		}
+		attrs := input.lifecycle.Attrs()
+		if !reflect.DeepEqual(attrs, test.want) {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", attrs, test.want)
+		}
+		lifecycle, supported := s3LifecycleFromRules(input.lifecycle.Rules())
+		if !supported || !reflect.DeepEqual(lifecycle.Attrs(), test.want) {
+			t.Errorf("\nroundtrip got:\n%v\nwant:\n%v\n", lifecycle.Attrs(), test.want)
+		}
+	}
+}
@@ -2,13 +2,17 @@ package lib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
 var snsClient *sns.SNS
@@ -60,17 +64,130 @@ func SNSListSubscriptions(ctx context.Context, topicArn string) ([]*sns.Subscrip
 	return subscriptions, nil
 }
 
-func SNSEnsure(ctx context.Context, name string, preview bool) error {
+func SNSListTopics(ctx context.Context) ([]string, error) {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "SNSListTopics"}
+		defer d.Log()
+	}
+	var nextToken *string
+	var topicArns []string
+	for {
+		out, err := SNSClient().ListTopicsWithContext(ctx, &sns.ListTopicsInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		for _, topic := range out.Topics {
+			topicArns = append(topicArns, *topic.TopicArn)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+	return topicArns, nil
+}
+
+func SNSArnToName(arn string) string {
+	return Last(strings.Split(arn, ":"))
+}
+
+const (
+	snsKmsDefault = "alias/aws/sns"
+
+	snsSubscribeSQS   = "sqs"
+	snsSubscribeEmail = "email"
+)
+
+type snsEnsureInput struct {
+	infraSetName string
+	name         string
+	fifo         bool
+	kms          string
+	subscribe    []string // sqs:QUEUE_NAME or email:ADDRESS
+}
+
+func SNSEnsureInput(infraSetName, topicName string, attrs []string) (*snsEnsureInput, error) {
+	input := &snsEnsureInput{
+		infraSetName: infraSetName,
+		name:         topicName,
+		kms:          snsKmsDefault,
+	}
+	for _, line := range attrs {
+		attr, value, err := SplitOnce(line, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch strings.ToLower(attr) {
+		case "fifo":
+			switch value {
+			case "true", "false":
+				input.fifo = value == "true"
+			default:
+				err := fmt.Errorf("unknown sns attr: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+		case "kms":
+			if value == "false" {
+				value = ""
+			}
+			input.kms = value
+		case "subscribe":
+			kind, endpoint, err := SplitOnce(value, ":")
+			if err != nil || !Contains([]string{snsSubscribeSQS, snsSubscribeEmail}, kind) || endpoint == "" {
+				err := fmt.Errorf("sns subscribe should be sqs:QUEUE_NAME or email:ADDRESS, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.subscribe = append(input.subscribe, value)
+		default:
+			err := fmt.Errorf("unknown sns attr: %s", line)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	if input.fifo != strings.HasSuffix(topicName, ".fifo") {
+		err := fmt.Errorf("sns fifo topics, and only fifo topics, must have names ending in .fifo: %s", topicName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return input, nil
+}
+
+// topic attrs with the default kms key omitted and subscriptions sorted
+func (input *snsEnsureInput) canonicalAttrs() []string {
+	var attrs []string
+	if input.fifo {
+		attrs = append(attrs, "fifo=true")
+	}
+	if input.kms == "" {
+		attrs = append(attrs, "kms=false")
+	} else if input.kms != snsKmsDefault {
+		attrs = append(attrs, "kms="+input.kms)
+	}
+	subscribe := append([]string{}, input.subscribe...)
+	sort.Strings(subscribe)
+	for _, value := range subscribe {
+		attrs = append(attrs, "subscribe="+value)
+	}
+	return attrs
+}
+
+func SNSEnsure(ctx context.Context, input *snsEnsureInput, preview bool) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "SNSEnsure"}
 		defer d.Log()
 	}
-	snsArn, err := SNSArn(ctx, name)
+	snsArn, err := SNSArn(ctx, input.name)
 	if err != nil {
 		Logger.Println("error:", err)
 		return err
 	}
-	_, err = SNSClient().GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
+	out, err := SNSClient().GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
 		TopicArn: aws.String(snsArn),
 	})
 	if err != nil {
@@ -79,18 +196,275 @@ func SNSEnsure(ctx context.Context, name string, preview bool) error {
 			return err
 		}
 		if !preview {
+			attrs := map[string]*string{}
+			if input.kms != "" {
+				attrs["KmsMasterKeyId"] = aws.String(input.kms)
+			}
+			if input.fifo {
+				attrs["FifoTopic"] = aws.String("true")
+				attrs["ContentBasedDeduplication"] = aws.String("true")
+			}
 			_, err := SNSClient().CreateTopicWithContext(ctx, &sns.CreateTopicInput{
-				Name: aws.String(name),
-				Attributes: map[string]*string{
-					"KmsMasterKeyId": aws.String("alias/aws/sns"),
-				},
+				Name:       aws.String(input.name),
+				Attributes: attrs,
+				Tags: []*sns.Tag{{
+					Key:   aws.String(infraSetTagName),
+					Value: aws.String(input.infraSetName),
+				}},
 			})
 			if err != nil {
 				Logger.Println("error:", err)
 				return err
 			}
 		}
-		Logger.Println(PreviewString(preview)+"create sns topic:", name)
+		Logger.Println(PreviewString(preview)+"create sns topic:", input.name)
+	} else {
+		fifo := out.Attributes["FifoTopic"] != nil && *out.Attributes["FifoTopic"] == "true"
+		if fifo != input.fifo {
+			err := fmt.Errorf("cannot change sns topic fifo for %s: %t => %t", input.name, fifo, input.fifo)
+			Logger.Println("error:", err)
+			return err
+		}
+		kms := ""
+		if out.Attributes["KmsMasterKeyId"] != nil {
+			kms = *out.Attributes["KmsMasterKeyId"]
+		}
+		if kms != input.kms {
+			if !preview {
+				_, err := SNSClient().SetTopicAttributesWithContext(ctx, &sns.SetTopicAttributesInput{
+					TopicArn:       aws.String(snsArn),
+					AttributeName:  aws.String("KmsMasterKeyId"),
+					AttributeValue: aws.String(input.kms),
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Printf(PreviewString(preview)+"updated sns topic kms for %s: %s => %s\n", input.name, kms, input.kms)
+		}
+	}
+	err = snsEnsureSubscriptions(ctx, input, snsArn, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
+func snsEnsureSubscriptions(ctx context.Context, input *snsEnsureInput, snsArn string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "snsEnsureSubscriptions"}
+		defer d.Log()
+	}
+	subscriptions, err := SNSListSubscriptions(ctx, snsArn)
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != sns.ErrCodeNotFoundException || !preview {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	var endpoints []string
+	for _, subscribe := range input.subscribe {
+		kind, endpoint, err := SplitOnce(subscribe, ":")
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if kind == snsSubscribeSQS {
+			endpoint, err = SQSArn(ctx, endpoint)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			err = snsEnsureSQSPolicy(ctx, endpoint, snsArn, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+		found := false
+		for _, subscription := range subscriptions {
+			if *subscription.Protocol == kind && *subscription.Endpoint == endpoint {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if !preview {
+				_, err := SNSClient().SubscribeWithContext(ctx, &sns.SubscribeInput{
+					TopicArn: aws.String(snsArn),
+					Protocol: aws.String(kind),
+					Endpoint: aws.String(endpoint),
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"created sns subscription:", input.name, kind, endpoint)
+		}
+	}
+	for _, subscription := range subscriptions {
+		if !Contains([]string{snsSubscribeSQS, snsSubscribeEmail}, *subscription.Protocol) {
+			continue // lambda subscriptions are managed by the lambda's sns trigger
+		}
+		if Contains(endpoints, *subscription.Endpoint) {
+			continue
+		}
+		err := snsUnsubscribe(ctx, subscription, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func snsUnsubscribe(ctx context.Context, subscription *sns.Subscription, preview bool) error {
+	if *subscription.SubscriptionArn == "PendingConfirmation" {
+		Logger.Println("skipping unsubscribe of unconfirmed sns subscription:", *subscription.TopicArn, *subscription.Protocol, *subscription.Endpoint)
+		return nil
+	}
+	if !preview {
+		_, err := SNSClient().UnsubscribeWithContext(ctx, &sns.UnsubscribeInput{
+			SubscriptionArn: subscription.SubscriptionArn,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"deleted sns subscription:", SNSArnToName(*subscription.TopicArn), *subscription.Protocol, *subscription.Endpoint)
+	return nil
+}
+
+// the queue policy is a single document shared by every topic subscribed to
+// the queue, so read-modify-write must not interleave
+var snsSQSPolicyLocks = map[string]*sync.Mutex{}
+var snsSQSPolicyLocksLock sync.Mutex
+
+func snsSQSPolicyLock(sqsArn string) *sync.Mutex {
+	snsSQSPolicyLocksLock.Lock()
+	defer snsSQSPolicyLocksLock.Unlock()
+	lock, ok := snsSQSPolicyLocks[sqsArn]
+	if !ok {
+		lock = &sync.Mutex{}
+		snsSQSPolicyLocks[sqsArn] = lock
+	}
+	return lock
+}
+
+// allow the topic to send messages to the queue
+func snsEnsureSQSPolicy(ctx context.Context, sqsArn, snsArn string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "snsEnsureSQSPolicy"}
+		defer d.Log()
+	}
+	lock := snsSQSPolicyLock(sqsArn)
+	lock.Lock()
+	defer lock.Unlock()
+	queueName := SQSArnToName(sqsArn)
+	sqsUrl, err := SQSQueueUrl(ctx, queueName)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	out, err := SQSClient().GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(sqsUrl),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNamePolicy)},
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist || !preview {
+			Logger.Println("error:", err)
+			return err
+		}
+		out = &sqs.GetQueueAttributesOutput{}
+	}
+	policy := IamPolicyDocumentCondition{
+		Version: "2012-10-17",
+	}
+	if out.Attributes[sqs.QueueAttributeNamePolicy] != nil {
+		err := json.Unmarshal([]byte(*out.Attributes[sqs.QueueAttributeNamePolicy]), &policy)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	sid := "sns__" + SNSArnToName(snsArn)
+	sid = strings.ReplaceAll(sid, ".", "_")
+	statement := IamStatementEntryCondition{
+		Sid:       sid,
+		Effect:    "Allow",
+		Principal: map[string]string{"Service": "sns.amazonaws.com"},
+		Action:    "sqs:SendMessage",
+		Resource:  sqsArn,
+		Condition: map[string]map[string]string{"ArnEquals": {"aws:SourceArn": snsArn}},
+	}
+	found := false
+	for i, existing := range policy.Statement {
+		if existing.Sid != sid {
+			continue
+		}
+		// compare as json, since unmarshaled fields are generic maps
+		if Json(existing) == Json(statement) {
+			return nil
+		}
+		policy.Statement[i] = statement
+		found = true
+	}
+	if !found {
+		policy.Statement = append(policy.Statement, statement)
+	}
+	if !preview {
+		_, err := SQSClient().SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+			QueueUrl: aws.String(sqsUrl),
+			Attributes: map[string]*string{
+				sqs.QueueAttributeNamePolicy: aws.String(Json(policy)),
+			},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"updated sqs policy to allow sns:", queueName, SNSArnToName(snsArn))
+	return nil
+}
+
+func SNSDeleteTopic(ctx context.Context, name string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "SNSDeleteTopic"}
+		defer d.Log()
+	}
+	snsArn, err := SNSArn(ctx, name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	_, err = SNSClient().GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
+		TopicArn: aws.String(snsArn),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok && aerr.Code() == sns.ErrCodeNotFoundException {
+			return nil
+		}
+		Logger.Println("error:", err)
+		return err
+	}
+	if !preview {
+		_, err := SNSClient().DeleteTopicWithContext(ctx, &sns.DeleteTopicInput{
+			TopicArn: aws.String(snsArn),
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
 	}
+	Logger.Println(PreviewString(preview)+"deleted sns topic:", name)
 	return nil
 }
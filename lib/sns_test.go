@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSNSEnsureInput(t *testing.T) {
+	type test struct {
+		name  string
+		attrs []string
+		input *snsEnsureInput
+		err   bool
+	}
+	tests := []test{
+		{
+			"topic",
+			[]string{},
+			&snsEnsureInput{name: "topic", kms: snsKmsDefault},
+			false,
+		},
+		{
+			"topic.fifo",
+			[]string{"fifo=true", "kms=false"},
+			&snsEnsureInput{name: "topic.fifo", fifo: true},
+			false,
+		},
+		{
+			"topic",
+			[]string{"subscribe=sqs:queue", "subscribe=email:ops@example.com"},
+			&snsEnsureInput{name: "topic", kms: snsKmsDefault, subscribe: []string{"sqs:queue", "email:ops@example.com"}},
+			false,
+		},
+		{"topic", []string{"fifo=true"}, nil, true},
+		{"topic.fifo", []string{}, nil, true},
+		{"topic", []string{"subscribe=http:example.com"}, nil, true},
+		{"topic", []string{"subscribe=sqs:"}, nil, true},
+		{"topic", []string{"unknown=value"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := SNSEnsureInput("", test.name, test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(input, test.input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", input, test.input)
+		}
+	}
+}
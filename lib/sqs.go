@@ -2,7 +2,9 @@ package lib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -91,6 +93,10 @@ func SQSQueueUrl(ctx context.Context, name string) (string, error) {
 		Logger.Println("error:", err)
 		return "", err
 	}
+	endpoint := EndpointUrl("sqs")
+	if endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", endpoint, account, name), nil
+	}
 	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", Region(), account, name), nil
 }
 
@@ -136,6 +142,23 @@ func SQSNumMessages(ctx context.Context, queueUrl string) (*SQSNumMessageOutput,
 	}, nil
 }
 
+const (
+	sqsRedriveAllowAll   = "allowAll"
+	sqsRedriveDenyAll    = "denyAll"
+	sqsRedriveByQueue    = "byQueue"
+	sqsMaxReceiveDefault = 5
+)
+
+type sqsRedrivePolicy struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     interface{} `json:"maxReceiveCount"` // aws accepts a string and returns a number
+}
+
+type sqsRedriveAllowPolicy struct {
+	RedrivePermission string   `json:"redrivePermission"`
+	SourceQueueArns   []string `json:"sourceQueueArns,omitempty"`
+}
+
 type sqsEnsureInput struct {
 	infraSetName                  string
 	name                          string
@@ -145,6 +168,14 @@ type sqsEnsureInput struct {
 	receiveMessageWaitTimeSeconds int
 	visibilityTimeout             int
 	kmsDataKeyReusePeriodSeconds  int
+	fifo                          bool
+	contentBasedDeduplication     bool
+	dlq                           string
+	maxReceiveCount               int
+	redriveAllow                  []string // allowAll, denyAll, or source queue names
+	redrivePolicy                 string   // json, resolved by SQSEnsure
+	redriveAllowPolicy            string   // json, resolved by SQSEnsure
+	dlqEnsured                    bool     // set when the dlq is ensured separately, as in an infra set
 }
 
 func (input *sqsEnsureInput) Attrs() map[string]*string {
@@ -168,12 +199,51 @@ func (input *sqsEnsureInput) Attrs() map[string]*string {
 	if input.kmsDataKeyReusePeriodSeconds != -1 {
 		m["KmsDataKeyReusePeriodSeconds"] = aws.String(fmt.Sprint(input.kmsDataKeyReusePeriodSeconds))
 	}
+	if input.fifo {
+		m["ContentBasedDeduplication"] = aws.String(fmt.Sprint(input.contentBasedDeduplication))
+	}
+	if input.redrivePolicy != "" {
+		m["RedrivePolicy"] = aws.String(input.redrivePolicy)
+	}
+	if input.redriveAllowPolicy != "" {
+		m["RedriveAllowPolicy"] = aws.String(input.redriveAllowPolicy)
+	}
 	if len(m) != 0 {
 		return m
 	}
 	return nil
 }
 
+// queue attributes under their aws names, then fifo and redrive attrs, defaults omitted
+func (input *sqsEnsureInput) canonicalAttrs() []string {
+	var attrs []string
+	add := func(name string, value, defaultValue int) {
+		if value != -1 && value != defaultValue {
+			attrs = append(attrs, fmt.Sprintf("%s=%d", name, value))
+		}
+	}
+	add("DelaySeconds", input.delaySeconds, 0)
+	add("MaximumMessageSize", input.maximumMessageSize, 262144)
+	add("MessageRetentionPeriod", input.messageRetentionPeriod, 345600)
+	add("ReceiveMessageWaitTimeSeconds", input.receiveMessageWaitTimeSeconds, 0)
+	add("VisibilityTimeout", input.visibilityTimeout, 30)
+	add("KmsDataKeyReusePeriodSeconds", input.kmsDataKeyReusePeriodSeconds, 300)
+	if input.fifo {
+		attrs = append(attrs, "fifo=true")
+		if !input.contentBasedDeduplication {
+			attrs = append(attrs, "dedup=false")
+		}
+	}
+	if input.dlq != "" {
+		attrs = append(attrs, "dlq="+input.dlq)
+		add("maxreceive", input.maxReceiveCount, sqsMaxReceiveDefault)
+	}
+	if len(input.redriveAllow) != 0 && strings.Join(input.redriveAllow, ",") != sqsRedriveAllowAll {
+		attrs = append(attrs, "redrive-allow="+strings.Join(input.redriveAllow, ","))
+	}
+	return attrs
+}
+
 func SQSEnsureInput(infraSetName, queueName string, attrs []string) (*sqsEnsureInput, error) {
 	input := &sqsEnsureInput{
 		infraSetName:                  infraSetName,
@@ -184,15 +254,16 @@ func SQSEnsureInput(infraSetName, queueName string, attrs []string) (*sqsEnsureI
 		receiveMessageWaitTimeSeconds: -1,
 		visibilityTimeout:             -1,
 		kmsDataKeyReusePeriodSeconds:  -1,
+		maxReceiveCount:               -1,
 	}
+	dedup := ""
 	for _, line := range attrs {
-		line = strings.ToLower(line)
 		attr, value, err := SplitOnce(line, "=")
 		if err != nil {
 			Logger.Println("error:", err)
 			return nil, err
 		}
-		switch attr {
+		switch strings.ToLower(attr) {
 		case "delayseconds", "delay":
 			num, err := strconv.Atoi(value)
 			if err != nil {
@@ -235,20 +306,210 @@ func SQSEnsureInput(infraSetName, queueName string, attrs []string) (*sqsEnsureI
 				return nil, err
 			}
 			input.kmsDataKeyReusePeriodSeconds = num
+		case "fifo":
+			if value != "true" && value != "false" {
+				err := fmt.Errorf("sqs attr fifo should be true or false, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.fifo = value == "true"
+		case "contentbaseddeduplication", "dedup":
+			if value != "true" && value != "false" {
+				err := fmt.Errorf("sqs attr dedup should be true or false, got: %s", line)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			dedup = value
+		case "dlq":
+			input.dlq = value
+		case "maxreceivecount", "maxreceive":
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.maxReceiveCount = num
+		case "redrive-allow":
+			input.redriveAllow = strings.Split(value, ",")
 		default:
 			err := fmt.Errorf("unknown sqs attr: %s", line)
 			Logger.Println("error:", err)
 			return nil, err
 		}
 	}
+	if input.fifo != strings.HasSuffix(queueName, ".fifo") {
+		err := fmt.Errorf("sqs fifo queues, and only fifo queues, must have names ending in .fifo: %s", queueName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if dedup != "" && !input.fifo {
+		err := fmt.Errorf("sqs attr dedup is only valid for fifo queues: %s", queueName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	input.contentBasedDeduplication = input.fifo && dedup != "false"
+	if input.dlq == "" && input.maxReceiveCount != -1 {
+		err := fmt.Errorf("sqs attr maxreceive requires dlq: %s", queueName)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if input.dlq != "" {
+		if input.dlq == queueName {
+			err := fmt.Errorf("sqs queue cannot be its own dlq: %s", queueName)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		if input.fifo != strings.HasSuffix(input.dlq, ".fifo") {
+			err := fmt.Errorf("sqs dlq must be fifo if and only if the queue is fifo: %s dlq=%s", queueName, input.dlq)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		if input.maxReceiveCount == -1 {
+			input.maxReceiveCount = sqsMaxReceiveDefault
+		}
+	}
+	for _, queue := range input.redriveAllow {
+		if (queue == sqsRedriveAllowAll || queue == sqsRedriveDenyAll) && len(input.redriveAllow) != 1 {
+			err := fmt.Errorf("sqs attr redrive-allow should be %s, %s, or a list of queue names: %s", sqsRedriveAllowAll, sqsRedriveDenyAll, queueName)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		if queue == "" {
+			err := fmt.Errorf("sqs attr redrive-allow has an empty queue name: %s", queueName)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	sort.Strings(input.redriveAllow)
 	return input, nil
 }
 
+func sqsResolveRedrive(ctx context.Context, input *sqsEnsureInput) error {
+	if input.dlq != "" {
+		dlqArn, err := SQSArn(ctx, input.dlq)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		bytes, err := json.Marshal(sqsRedrivePolicy{
+			DeadLetterTargetArn: dlqArn,
+			MaxReceiveCount:     fmt.Sprint(input.maxReceiveCount),
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		input.redrivePolicy = string(bytes)
+	}
+	if len(input.redriveAllow) != 0 {
+		policy := sqsRedriveAllowPolicy{}
+		switch input.redriveAllow[0] {
+		case sqsRedriveAllowAll, sqsRedriveDenyAll:
+			policy.RedrivePermission = input.redriveAllow[0]
+		default:
+			policy.RedrivePermission = sqsRedriveByQueue
+			for _, queue := range input.redriveAllow {
+				queueArn, err := SQSArn(ctx, queue)
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+				policy.SourceQueueArns = append(policy.SourceQueueArns, queueArn)
+			}
+		}
+		bytes, err := json.Marshal(policy)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		input.redriveAllowPolicy = string(bytes)
+	}
+	return nil
+}
+
+// returns dlq name and max receive count from a RedrivePolicy attribute
+func sqsParseRedrivePolicy(policy string) (string, int, error) {
+	redrive := sqsRedrivePolicy{}
+	err := json.Unmarshal([]byte(policy), &redrive)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", 0, err
+	}
+	maxReceiveCount, err := strconv.Atoi(fmt.Sprint(redrive.MaxReceiveCount))
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", 0, err
+	}
+	return SQSArnToName(redrive.DeadLetterTargetArn), maxReceiveCount, nil
+}
+
+// returns the redrive-allow attr value from a RedriveAllowPolicy attribute
+func sqsParseRedriveAllowPolicy(policy string) ([]string, error) {
+	allow := sqsRedriveAllowPolicy{}
+	err := json.Unmarshal([]byte(policy), &allow)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if allow.RedrivePermission != sqsRedriveByQueue {
+		return []string{allow.RedrivePermission}, nil
+	}
+	var queues []string
+	for _, queueArn := range allow.SourceQueueArns {
+		queues = append(queues, SQSArnToName(queueArn))
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+// creates the dlq with default attrs if it does not exist, so it can be referenced by a RedrivePolicy
+func sqsEnsureDLQ(ctx context.Context, input *sqsEnsureInput, preview bool) error {
+	if input.dlq == "" || input.dlqEnsured {
+		return nil
+	}
+	_, err := SQSClient().GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(input.dlq),
+	})
+	if err == nil {
+		return nil
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		Logger.Println("error:", err)
+		return err
+	}
+	var attrs []string
+	if input.fifo {
+		attrs = append(attrs, "fifo=true")
+	}
+	dlqInput, err := SQSEnsureInput(input.infraSetName, input.dlq, attrs)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = SQSEnsure(ctx, dlqInput, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	return nil
+}
+
 func SQSEnsure(ctx context.Context, input *sqsEnsureInput, preview bool) error {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "SQSEnsure"}
 		defer d.Log()
 	}
+	err := sqsEnsureDLQ(ctx, input, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	err = sqsResolveRedrive(ctx, input)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
 	sqsUrl, err := SQSQueueUrl(ctx, input.name)
 	if err != nil {
 		Logger.Println("error:", err)
@@ -263,6 +524,10 @@ func SQSEnsure(ctx context.Context, input *sqsEnsureInput, preview bool) error {
 			aws.String("ReceiveMessageWaitTimeSeconds"),
 			aws.String("VisibilityTimeout"),
 			aws.String("KmsDataKeyReusePeriodSeconds"),
+			aws.String("FifoQueue"),
+			aws.String("ContentBasedDeduplication"),
+			aws.String("RedrivePolicy"),
+			aws.String("RedriveAllowPolicy"),
 		},
 	})
 	if err != nil {
@@ -272,9 +537,13 @@ func SQSEnsure(ctx context.Context, input *sqsEnsureInput, preview bool) error {
 			return err
 		}
 		if !preview {
+			attrs := input.Attrs()
+			if input.fifo {
+				attrs["FifoQueue"] = aws.String("true")
+			}
 			_, err := SQSClient().CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
 				QueueName:  aws.String(input.name),
-				Attributes: input.Attrs(),
+				Attributes: attrs,
 				Tags: map[string]*string{
 					infraSetTagName: aws.String(input.infraSetName),
 				},
@@ -329,11 +598,58 @@ func SQSEnsure(ctx context.Context, input *sqsEnsureInput, preview bool) error {
 			Logger.Printf(PreviewString(preview)+"will update attr %s for %s: %d => %d\n", "KmsDataKeyReusePeriodSeconds", input.name, Atoi(*attrs["KmsDataKeyReusePeriodSeconds"]), input.kmsDataKeyReusePeriodSeconds)
 			needsUpdate = true
 		}
+		fifo := attrs["FifoQueue"] != nil && *attrs["FifoQueue"] == "true"
+		if input.fifo != fifo {
+			err := fmt.Errorf("sqs fifo cannot be changed after queue creation: %s", input.name)
+			Logger.Println("error:", err)
+			return err
+		}
+		if input.fifo && attrs["ContentBasedDeduplication"] != nil && fmt.Sprint(input.contentBasedDeduplication) != *attrs["ContentBasedDeduplication"] {
+			Logger.Printf(PreviewString(preview)+"will update attr %s for %s: %s => %t\n", "ContentBasedDeduplication", input.name, *attrs["ContentBasedDeduplication"], input.contentBasedDeduplication)
+			needsUpdate = true
+		}
+		dlq := ""
+		maxReceiveCount := 0
+		if attrs["RedrivePolicy"] != nil && *attrs["RedrivePolicy"] != "" {
+			dlq, maxReceiveCount, err = sqsParseRedrivePolicy(*attrs["RedrivePolicy"])
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		if dlq != input.dlq || (input.dlq != "" && maxReceiveCount != input.maxReceiveCount) {
+			Logger.Printf(PreviewString(preview)+"will update attr %s for %s: dlq=%s maxreceive=%d => dlq=%s maxreceive=%d\n", "RedrivePolicy", input.name, dlq, maxReceiveCount, input.dlq, input.maxReceiveCount)
+			needsUpdate = true
+		}
+		redriveAllow := []string{sqsRedriveAllowAll} // default
+		if attrs["RedriveAllowPolicy"] != nil && *attrs["RedriveAllowPolicy"] != "" {
+			redriveAllow, err = sqsParseRedriveAllowPolicy(*attrs["RedriveAllowPolicy"])
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+		}
+		inputRedriveAllow := input.redriveAllow
+		if len(inputRedriveAllow) == 0 {
+			inputRedriveAllow = []string{sqsRedriveAllowAll}
+		}
+		if strings.Join(redriveAllow, ",") != strings.Join(inputRedriveAllow, ",") {
+			Logger.Printf(PreviewString(preview)+"will update attr %s for %s: %s => %s\n", "RedriveAllowPolicy", input.name, strings.Join(redriveAllow, ","), strings.Join(inputRedriveAllow, ","))
+			needsUpdate = true
+		}
 		if needsUpdate {
 			if !preview {
+				setAttrs := input.Attrs()
+				// an empty value clears a redrive policy removed from the attrs
+				if input.redrivePolicy == "" {
+					setAttrs["RedrivePolicy"] = aws.String("")
+				}
+				if input.redriveAllowPolicy == "" {
+					setAttrs["RedriveAllowPolicy"] = aws.String("")
+				}
 				_, err := SQSClient().SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
 					QueueUrl:   aws.String(sqsUrl),
-					Attributes: input.Attrs(),
+					Attributes: setAttrs,
 				})
 				if err != nil {
 					Logger.Println("error:", err)
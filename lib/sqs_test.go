@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -21,6 +22,69 @@ func checkAccountSQS() {
 	}
 }
 
+func TestSQSEnsureInput(t *testing.T) {
+	type test struct {
+		name  string
+		attrs []string
+		input *sqsEnsureInput
+		err   bool
+	}
+	tests := []test{
+		{
+			"q",
+			nil,
+			&sqsEnsureInput{name: "q", delaySeconds: -1, maximumMessageSize: -1, messageRetentionPeriod: -1, receiveMessageWaitTimeSeconds: -1, visibilityTimeout: -1, kmsDataKeyReusePeriodSeconds: -1, maxReceiveCount: -1},
+			false,
+		},
+		{
+			"q",
+			[]string{"timeout=60", "dlq=q-dlq", "redrive-allow=b,a"},
+			&sqsEnsureInput{name: "q", delaySeconds: -1, maximumMessageSize: -1, messageRetentionPeriod: -1, receiveMessageWaitTimeSeconds: -1, visibilityTimeout: 60, kmsDataKeyReusePeriodSeconds: -1, dlq: "q-dlq", maxReceiveCount: sqsMaxReceiveDefault, redriveAllow: []string{"a", "b"}},
+			false,
+		},
+		{
+			"q.fifo",
+			[]string{"fifo=true"},
+			&sqsEnsureInput{name: "q.fifo", delaySeconds: -1, maximumMessageSize: -1, messageRetentionPeriod: -1, receiveMessageWaitTimeSeconds: -1, visibilityTimeout: -1, kmsDataKeyReusePeriodSeconds: -1, fifo: true, contentBasedDeduplication: true, maxReceiveCount: -1},
+			false,
+		},
+		{
+			"q.fifo",
+			[]string{"fifo=true", "dedup=false", "dlq=q-dlq.fifo", "maxreceive=3", "redrive-allow=denyAll"},
+			&sqsEnsureInput{name: "q.fifo", delaySeconds: -1, maximumMessageSize: -1, messageRetentionPeriod: -1, receiveMessageWaitTimeSeconds: -1, visibilityTimeout: -1, kmsDataKeyReusePeriodSeconds: -1, fifo: true, dlq: "q-dlq.fifo", maxReceiveCount: 3, redriveAllow: []string{sqsRedriveDenyAll}},
+			false,
+		},
+		{"q", []string{"fifo=true"}, nil, true},
+		{"q.fifo", nil, nil, true},
+		{"q.fifo", []string{"fifo=yes"}, nil, true},
+		{"q", []string{"dedup=true"}, nil, true},
+		{"q", []string{"maxreceive=3"}, nil, true},
+		{"q", []string{"dlq=q"}, nil, true},
+		{"q", []string{"dlq=q-dlq.fifo"}, nil, true},
+		{"q.fifo", []string{"fifo=true", "dlq=q-dlq"}, nil, true},
+		{"q", []string{"redrive-allow=allowAll,a"}, nil, true},
+		{"q", []string{"redrive-allow=a,,b"}, nil, true},
+		{"q", []string{"timeout=soon"}, nil, true},
+		{"q", []string{"unknown=value"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := SQSEnsureInput("", test.name, test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error: %s %v", test.name, test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s %v %s", test.name, test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(input, test.input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", input, test.input)
+		}
+	}
+}
+
 func TestSQSEnsure(t *testing.T) {
 	checkAccountSQS()
 	queue := "libaws-sqs-test-" + uuid.Must(uuid.NewV4()).String()
@@ -352,3 +416,50 @@ func TestSQSEnsureVisibilityTimeout(t *testing.T) {
 		return
 	}
 }
+
+func TestSQSEnsureDLQ(t *testing.T) {
+	checkAccountSQS()
+	queue := "libaws-sqs-test-" + uuid.Must(uuid.NewV4()).String()
+	dlq := queue + "-dlq"
+	ctx := context.Background()
+	input, err := SQSEnsureInput("", queue, []string{"dlq=" + dlq, "maxreceive=3"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = SQSEnsure(ctx, input, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		for _, name := range []string{queue, dlq} {
+			err := SQSDeleteQueue(ctx, name, false)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}()
+	url, err := SQSQueueUrl(ctx, queue)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	attrs, err := SQSClient().GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(url),
+		AttributeNames: []*string{aws.String("RedrivePolicy")},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	name, maxReceiveCount, err := sqsParseRedrivePolicy(*attrs.Attributes["RedrivePolicy"])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if name != dlq || maxReceiveCount != 3 {
+		t.Errorf("expected %s 3, got %s %d", dlq, name, maxReceiveCount)
+		return
+	}
+}
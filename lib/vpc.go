@@ -2,7 +2,10 @@ package lib
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -91,199 +94,1023 @@ func VpcSubnets(ctx context.Context, vpcID string) ([]*ec2.Subnet, error) {
 	return out.Subnets, nil
 }
 
-func VpcEnsure(ctx context.Context, infraSetName, vpcName string, preview bool) (string, error) {
-	// TODO make this idempotent and previewable. currently if aborted must rm then ensure.
+const (
+	vpcAttrCidr           = "cidr"
+	vpcAttrPrivateSubnets = "private-subnets"
+	vpcAttrNat            = "nat"
+
+	vpcAttrCidrDefault = "10.0.0.0/16"
+
+	vpcNatNone    = "none"
+	vpcNatSingle  = "single"
+	vpcNatPerZone = "per-zone"
+
+	// the nat mode is recorded on the vpc, since a single zone vpc looks the
+	// same with nat=single and nat=per-zone
+	vpcNatTagName = "libaws.vpc.nat"
+
+	// subnets are tagged public or private, untagged subnets predate private subnets and are public
+	vpcSubnetTierTagName = "libaws.subnet"
+	vpcSubnetPublic      = "public"
+	vpcSubnetPrivate     = "private"
+
+	// the vpc cidr is split into 16 subnet blocks, public subnets use the first
+	// half and private subnets the second, so at most 8 zones get subnets
+	vpcSubnetBlocks   = 16
+	vpcSubnetMaxZones = vpcSubnetBlocks / 2
+)
+
+type vpcEnsureInput struct {
+	infraSetName   string
+	name           string
+	cidr           string
+	privateSubnets bool
+	nat            string
+}
+
+func VpcEnsureInput(infraSetName, vpcName string, attrs []string) (*vpcEnsureInput, error) {
+	input := &vpcEnsureInput{
+		infraSetName: infraSetName,
+		name:         vpcName,
+		cidr:         vpcAttrCidrDefault,
+		nat:          vpcNatNone,
+	}
+	for _, attr := range attrs {
+		k, v, err := SplitOnce(attr, "=")
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		switch k {
+		case vpcAttrCidr:
+			_, err := vpcSubnetCidr(v, 0)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.cidr = v
+		case vpcAttrPrivateSubnets:
+			if !Contains([]string{"true", "false"}, v) {
+				err := fmt.Errorf("vpc attr %s should be true or false, got: %s", k, v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.privateSubnets = v == "true"
+		case vpcAttrNat:
+			if !Contains([]string{vpcNatNone, vpcNatSingle, vpcNatPerZone}, v) {
+				err := fmt.Errorf("vpc attr %s should be %s, %s or %s, got: %s", k, vpcNatNone, vpcNatSingle, vpcNatPerZone, v)
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			input.nat = v
+		default:
+			err := fmt.Errorf("unknown vpc attr: %s", attr)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+	}
+	if input.nat != vpcNatNone && !input.privateSubnets {
+		err := fmt.Errorf("vpc %s has attr %s=%s but not %s=true", vpcName, vpcAttrNat, input.nat, vpcAttrPrivateSubnets)
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	return input, nil
+}
+
+// attrs not at their default, also what vpcListAttrs reports for a live vpc
+func (input *vpcEnsureInput) Attrs() []string {
+	var attrs []string
+	if input.cidr != vpcAttrCidrDefault {
+		attrs = append(attrs, vpcAttrCidr+"="+input.cidr)
+	}
+	if input.privateSubnets {
+		attrs = append(attrs, vpcAttrPrivateSubnets+"=true")
+	}
+	if input.nat != vpcNatNone {
+		attrs = append(attrs, vpcAttrNat+"="+input.nat)
+	}
+	return attrs
+}
+
+func (input *vpcEnsureInput) tags(name string) []*ec2.Tag {
+	return []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String(infraSetTagName), Value: aws.String(input.infraSetName)},
+	}
+}
+
+// the cidr of subnet block index within a vpc cidr from /16 to /24
+func vpcSubnetCidr(vpcCidr string, index int) (string, error) {
+	ip, network, err := net.ParseCIDR(vpcCidr)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	ones, bits := network.Mask.Size()
+	if bits != 32 || ones < 16 || ones > 24 || !ip.Equal(network.IP) {
+		err := fmt.Errorf("vpc cidr should be an ipv4 network from /16 to /24, got: %s", vpcCidr)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if index < 0 || index >= vpcSubnetBlocks {
+		err := fmt.Errorf("subnet index out of range: %d", index)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	size := uint32(1) << (32 - ones - 4)
+	start := binary.BigEndian.Uint32(network.IP.To4()) + uint32(index)*size
+	subnetIP := make(net.IP, 4)
+	binary.BigEndian.PutUint32(subnetIP, start)
+	return fmt.Sprintf("%s/%d", subnetIP, ones+4), nil
+}
+
+func vpcSubnetTier(tags []*ec2.Tag) string {
+	for _, tag := range tags {
+		if *tag.Key == vpcSubnetTierTagName {
+			return *tag.Value
+		}
+	}
+	return vpcSubnetPublic
+}
+
+// private subnets are created by VpcEnsure with private-subnets=true and have no public ips
+func VpcSubnetIsPrivate(subnet *ec2.Subnet) bool {
+	return vpcSubnetTier(subnet.Tags) == vpcSubnetPrivate
+}
+
+// the zones that get subnets, in a stable order
+func vpcZones(ctx context.Context) ([]string, error) {
+	zones, err := Zones(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	var names []string
+	for _, zone := range zones {
+		names = append(names, *zone.ZoneName)
+	}
+	sort.Strings(names)
+	if len(names) > vpcSubnetMaxZones {
+		names = names[:vpcSubnetMaxZones]
+	}
+	return names, nil
+}
+
+// the zones that get a nat gateway
+func (input *vpcEnsureInput) natZones(zones []string) []string {
+	switch input.nat {
+	case vpcNatSingle:
+		return zones[:1]
+	case vpcNatPerZone:
+		return zones
+	}
+	return nil
+}
+
+func vpcGet(ctx context.Context, name string) (*ec2.Vpc, error) {
+	out, err := EC2Client().DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("tag:Name"), Values: []*string{aws.String(name)}}},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	switch len(out.Vpcs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out.Vpcs[0], nil
+	}
+	err = fmt.Errorf("%s vpc for name %s: %d", ErrPrefixDidntFindExactlyOne, name, len(out.Vpcs))
+	Logger.Println("error:", err)
+	return nil, err
+}
+
+// reconcile a vpc with an internet gateway and a public subnet per zone, and
+// optionally a private subnet per zone routed through nat gateways. every piece
+// is checked on every run, so an aborted ensure is finished by the next one.
+func VpcEnsure(ctx context.Context, input *vpcEnsureInput, preview bool) (string, error) {
 	if doDebug {
 		d := &Debug{start: time.Now(), name: "VpcEnsure"}
 		defer d.Log()
 	}
-	xx := 0
-	id, err := VpcID(ctx, vpcName)
-	if err == nil {
-		return id, nil
+	zones, err := vpcZones(ctx)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if strings.HasPrefix(input.name, "vpc-") {
+		err := fmt.Errorf("vpc name cannot be a vpc id: %s", input.name)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	vpc, err := vpcGet(ctx, input.name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	created := false
+	if vpc == nil {
+		if preview {
+			Logger.Println(PreviewString(preview)+"created vpc:", input.name, input.cidr)
+			Logger.Println(PreviewString(preview)+"created internet gateway:", input.name)
+			for _, zone := range zones {
+				Logger.Println(PreviewString(preview)+"created subnet:", vpcSubnetPublic, zone)
+				if input.privateSubnets {
+					Logger.Println(PreviewString(preview)+"created subnet:", vpcSubnetPrivate, zone)
+				}
+			}
+			for _, zone := range input.natZones(zones) {
+				Logger.Println(PreviewString(preview)+"created nat gateway:", zone)
+			}
+			return "", nil
+		}
+		out, err := EC2Client().CreateVpcWithContext(ctx, &ec2.CreateVpcInput{
+			CidrBlock: aws.String(input.cidr),
+			TagSpecifications: []*ec2.TagSpecification{{
+				ResourceType: aws.String(ec2.ResourceTypeVpc),
+				Tags:         append(input.tags(input.name), &ec2.Tag{Key: aws.String(vpcNatTagName), Value: aws.String(input.nat)}),
+			}},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		vpc = out.Vpc
+		created = true
+		Logger.Println("created vpc:", input.name, *vpc.VpcId, input.cidr)
+		err = EC2Client().WaitUntilVpcAvailableWithContext(ctx, &ec2.DescribeVpcsInput{
+			VpcIds: []*string{vpc.VpcId},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+	} else if *vpc.CidrBlock != input.cidr {
+		err := fmt.Errorf("vpc %s has cidr %s, which cannot be changed to %s", input.name, *vpc.CidrBlock, input.cidr)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	vpcID := *vpc.VpcId
+	err = vpcEnsureDnsHostnames(ctx, vpcID, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	// only on creation, so rules later added to the default security group are kept
+	if created {
+		err = vpcEnsureDefaultSgEmpty(ctx, vpcID, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+	}
+	gatewayID, err := vpcEnsureInternetGateway(ctx, input, vpcID, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	tables, err := vpcRouteTables(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	var publicTable *ec2.RouteTable
+	for _, table := range tables {
+		for _, association := range table.Associations {
+			if *association.Main {
+				publicTable = table
+			}
+		}
+	}
+	if publicTable == nil {
+		err := fmt.Errorf("no main route table for vpc: %s", input.name)
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if gatewayID != "" {
+		err = vpcEnsureDefaultRoute(ctx, publicTable, gatewayID, "", preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+	}
+	subnets, err := VpcSubnets(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	publicSubnets := map[string]*ec2.Subnet{}
+	privateSubnets := map[string]*ec2.Subnet{}
+	used := map[string]bool{}
+	for _, subnet := range subnets {
+		used[*subnet.CidrBlock] = true
+		if vpcSubnetTier(subnet.Tags) == vpcSubnetPrivate {
+			privateSubnets[*subnet.AvailabilityZone] = subnet
+		} else if publicSubnets[*subnet.AvailabilityZone] == nil {
+			publicSubnets[*subnet.AvailabilityZone] = subnet
+		}
+	}
+	for i, zone := range zones {
+		subnet, err := vpcEnsureSubnet(ctx, input, vpcID, zone, vpcSubnetPublic, i, used, publicSubnets[zone], preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		publicSubnets[zone] = subnet
+		err = vpcEnsureRouteTableAssociation(ctx, tables, publicTable, subnet, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+	}
+	err = vpcEnsureNatGateways(ctx, input, vpcID, zones, publicSubnets, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	err = vpcEnsureNatTag(ctx, input, vpc, preview)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	natGateways, err := vpcNatGateways(ctx, input.name, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	natZones := input.natZones(zones)
+	for i, zone := range zones {
+		tableName := input.name + "-" + vpcSubnetPrivate + "-" + zone
+		var table *ec2.RouteTable
+		for _, t := range tables {
+			if EC2Name(t.Tags) == tableName {
+				table = t
+			}
+		}
+		if !input.privateSubnets {
+			err := vpcDeletePrivateZone(ctx, table, privateSubnets[zone], preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return "", err
+			}
+			continue
+		}
+		subnet, err := vpcEnsureSubnet(ctx, input, vpcID, zone, vpcSubnetPrivate, vpcSubnetMaxZones+i, used, privateSubnets[zone], preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+		if table == nil {
+			if !preview {
+				out, err := EC2Client().CreateRouteTableWithContext(ctx, &ec2.CreateRouteTableInput{
+					VpcId: aws.String(vpcID),
+					TagSpecifications: []*ec2.TagSpecification{{
+						ResourceType: aws.String(ec2.ResourceTypeRouteTable),
+						Tags:         input.tags(tableName),
+					}},
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return "", err
+				}
+				table = out.RouteTable
+			}
+			Logger.Println(PreviewString(preview)+"created route table:", tableName)
+		}
+		if table == nil {
+			continue // preview
+		}
+		natGatewayID := ""
+		if len(natZones) > 0 {
+			natZone := natZones[0]
+			if input.nat == vpcNatPerZone {
+				natZone = zone
+			}
+			if natGateway, ok := natGateways[natZone]; ok {
+				natGatewayID = *natGateway.NatGatewayId
+			}
+		}
+		if natGatewayID != "" || len(natZones) == 0 {
+			err = vpcEnsureDefaultRoute(ctx, table, "", natGatewayID, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return "", err
+			}
+		}
+		err = vpcEnsureRouteTableAssociation(ctx, tables, table, subnet, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return "", err
+		}
+	}
+	return vpcID, nil
+}
+
+func vpcEnsureDnsHostnames(ctx context.Context, vpcID string, preview bool) error {
+	out, err := EC2Client().DescribeVpcAttributeWithContext(ctx, &ec2.DescribeVpcAttributeInput{
+		VpcId:     aws.String(vpcID),
+		Attribute: aws.String(ec2.VpcAttributeNameEnableDnsHostnames),
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if out.EnableDnsHostnames != nil && aws.BoolValue(out.EnableDnsHostnames.Value) {
+		return nil
+	}
+	if !preview {
+		err = Retry(ctx, func() error {
+			_, err := EC2Client().ModifyVpcAttributeWithContext(ctx, &ec2.ModifyVpcAttributeInput{
+				VpcId:              aws.String(vpcID),
+				EnableDnsHostnames: &ec2.AttributeBooleanValue{Value: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"enabled dns hostnames:", vpcID)
+	return nil
+}
+
+// the default security group has no ingress rules, use named security groups instead
+func vpcEnsureDefaultSgEmpty(ctx context.Context, vpcID string, preview bool) error {
+	out, err := EC2Client().DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+			{Name: aws.String("group-name"), Values: []*string{aws.String("default")}},
+		},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if len(out.SecurityGroups) != 1 {
+		err := fmt.Errorf("could not find default security group")
+		Logger.Println("error:", err)
+		return err
+	}
+	securityGroup := out.SecurityGroups[0]
+	if len(securityGroup.IpPermissions) == 0 {
+		return nil
+	}
+	if !preview {
+		_, err = EC2Client().RevokeSecurityGroupIngressWithContext(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       securityGroup.GroupId,
+			IpPermissions: securityGroup.IpPermissions,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"removed all rules from default security group:", *securityGroup.GroupId)
+	return nil
+}
+
+// find or create the internet gateway, including one left unattached by an aborted ensure
+func vpcEnsureInternetGateway(ctx context.Context, input *vpcEnsureInput, vpcID string, preview bool) (string, error) {
+	out, err := EC2Client().DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.vpc-id"), Values: []*string{aws.String(vpcID)}}},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	if len(out.InternetGateways) > 0 {
+		return *out.InternetGateways[0].InternetGatewayId, nil
+	}
+	out, err = EC2Client().DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{{Name: aws.String("tag:Name"), Values: []*string{aws.String(input.name)}}},
+	})
+	if err != nil {
+		Logger.Println("error:", err)
+		return "", err
+	}
+	var gatewayID string
+	for _, gateway := range out.InternetGateways {
+		if len(gateway.Attachments) == 0 {
+			gatewayID = *gateway.InternetGatewayId
+			break
+		}
+	}
+	if gatewayID == "" {
+		if !preview {
+			out, err := EC2Client().CreateInternetGatewayWithContext(ctx, &ec2.CreateInternetGatewayInput{
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeInternetGateway),
+					Tags:         input.tags(input.name),
+				}},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return "", err
+			}
+			gatewayID = *out.InternetGateway.InternetGatewayId
+		}
+		Logger.Println(PreviewString(preview)+"created internet gateway:", input.name, gatewayID)
 	}
 	if !preview {
-		if !strings.HasPrefix(err.Error(), ErrPrefixDidntFindExactlyOne) {
+		err = Retry(ctx, func() error {
+			_, err := EC2Client().AttachInternetGatewayWithContext(ctx, &ec2.AttachInternetGatewayInput{
+				VpcId:             aws.String(vpcID),
+				InternetGatewayId: aws.String(gatewayID),
+			})
+			return err
+		})
+		if err != nil {
 			Logger.Println("error:", err)
 			return "", err
 		}
-		tags := []*ec2.Tag{
-			{
-				Key:   aws.String("Name"),
-				Value: aws.String(vpcName),
-			},
-			{
-				Key:   aws.String(infraSetTagName),
-				Value: aws.String(infraSetName),
-			},
+	}
+	Logger.Println(PreviewString(preview)+"attached internet gateway:", gatewayID)
+	return gatewayID, nil
+}
+
+func vpcRouteTables(ctx context.Context, vpcID string) ([]*ec2.RouteTable, error) {
+	var tables []*ec2.RouteTable
+	var token *string
+	for {
+		out, err := EC2Client().DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+			Filters:   []*ec2.Filter{{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}}},
+			NextToken: token,
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		tables = append(tables, out.RouteTables...)
+		if out.NextToken == nil {
+			break
+		}
+		token = out.NextToken
+	}
+	return tables, nil
+}
+
+// point 0.0.0.0/0 at an internet gateway or a nat gateway, or remove it when both are empty
+func vpcEnsureDefaultRoute(ctx context.Context, table *ec2.RouteTable, gatewayID, natGatewayID string, preview bool) error {
+	var existing *ec2.Route
+	for _, route := range table.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" {
+			existing = route
+		}
+	}
+	target := gatewayID + natGatewayID
+	if existing != nil && aws.StringValue(existing.GatewayId)+aws.StringValue(existing.NatGatewayId) == target {
+		return nil
+	}
+	if existing == nil && target == "" {
+		return nil
+	}
+	if !preview {
+		var err error
+		switch {
+		case target == "":
+			_, err = EC2Client().DeleteRouteWithContext(ctx, &ec2.DeleteRouteInput{
+				RouteTableId:         table.RouteTableId,
+				DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			})
+		case existing == nil:
+			input := &ec2.CreateRouteInput{
+				RouteTableId:         table.RouteTableId,
+				DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			}
+			if gatewayID != "" {
+				input.GatewayId = aws.String(gatewayID)
+			} else {
+				input.NatGatewayId = aws.String(natGatewayID)
+			}
+			err = Retry(ctx, func() error {
+				_, err := EC2Client().CreateRouteWithContext(ctx, input)
+				return err
+			})
+		default:
+			input := &ec2.ReplaceRouteInput{
+				RouteTableId:         table.RouteTableId,
+				DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			}
+			if gatewayID != "" {
+				input.GatewayId = aws.String(gatewayID)
+			} else {
+				input.NatGatewayId = aws.String(natGatewayID)
+			}
+			_, err = EC2Client().ReplaceRouteWithContext(ctx, input)
+		}
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
+	Logger.Println(PreviewString(preview)+"updated default route:", *table.RouteTableId, "=>", target)
+	return nil
+}
+
+// create a subnet in the first free block of its tier, starting from index. blocks
+// are checked against used since subnets made before tiers may not follow the layout.
+func vpcEnsureSubnet(ctx context.Context, input *vpcEnsureInput, vpcID, zone, tier string, index int, used map[string]bool, subnet *ec2.Subnet, preview bool) (*ec2.Subnet, error) {
+	if subnet == nil {
+		start := 0
+		if tier == vpcSubnetPrivate {
+			start = vpcSubnetMaxZones
+		}
+		var block string
+		for i := 0; i < vpcSubnetMaxZones; i++ {
+			candidate, err := vpcSubnetCidr(input.cidr, start+(index-start+i)%vpcSubnetMaxZones)
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			if !used[candidate] {
+				block = candidate
+				break
+			}
+		}
+		if block == "" {
+			err := fmt.Errorf("no free %s subnet block in vpc %s for zone %s", tier, input.name, zone)
+			Logger.Println("error:", err)
+			return nil, err
+		}
+		used[block] = true
+		if !preview {
+			tags := append(input.tags(input.name), &ec2.Tag{Key: aws.String(vpcSubnetTierTagName), Value: aws.String(tier)})
+			out, err := EC2Client().CreateSubnetWithContext(ctx, &ec2.CreateSubnetInput{
+				VpcId:            aws.String(vpcID),
+				AvailabilityZone: aws.String(zone),
+				CidrBlock:        aws.String(block),
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeSubnet),
+					Tags:         tags,
+				}},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
+			subnet = out.Subnet
+		}
+		Logger.Println(PreviewString(preview)+"created subnet:", tier, zone, block)
+		if subnet == nil {
+			return nil, nil // preview
 		}
-		// create vpc
-		cidr := strings.ReplaceAll("10.xx.0.0/16", "xx", fmt.Sprint(xx))
-		vpc, err := EC2Client().CreateVpcWithContext(ctx, &ec2.CreateVpcInput{
-			CidrBlock: aws.String(cidr),
-			TagSpecifications: []*ec2.TagSpecification{{
-				ResourceType: aws.String(ec2.ResourceTypeVpc),
-				Tags:         tags,
-			}},
-		})
-		if err != nil {
-			Logger.Println("error:", err)
-			return "", err
+	}
+	mapPublicIp := tier == vpcSubnetPublic
+	if aws.BoolValue(subnet.MapPublicIpOnLaunch) != mapPublicIp {
+		if !preview {
+			err := Retry(ctx, func() error {
+				_, err := EC2Client().ModifySubnetAttributeWithContext(ctx, &ec2.ModifySubnetAttributeInput{
+					SubnetId:            subnet.SubnetId,
+					MapPublicIpOnLaunch: &ec2.AttributeBooleanValue{Value: aws.Bool(mapPublicIp)},
+				})
+				return err
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return nil, err
+			}
 		}
-		Logger.Println("created:", vpcName, *vpc.Vpc.VpcId, cidr)
-		err = EC2Client().WaitUntilVpcAvailableWithContext(ctx, &ec2.DescribeVpcsInput{
-			VpcIds: []*string{vpc.Vpc.VpcId},
-		})
-		if err != nil {
-			Logger.Println("error:", err)
-			return "", err
+		Logger.Printf(PreviewString(preview)+"updated map public ip on launch: %s %t\n", *subnet.SubnetId, mapPublicIp)
+	}
+	return subnet, nil
+}
+
+func vpcEnsureRouteTableAssociation(ctx context.Context, tables []*ec2.RouteTable, table *ec2.RouteTable, subnet *ec2.Subnet, preview bool) error {
+	if subnet == nil {
+		return nil // preview
+	}
+	for _, t := range tables {
+		for _, association := range t.Associations {
+			if aws.StringValue(association.SubnetId) != *subnet.SubnetId {
+				continue
+			}
+			if *t.RouteTableId == *table.RouteTableId {
+				return nil
+			}
+			if !preview {
+				_, err := EC2Client().ReplaceRouteTableAssociationWithContext(ctx, &ec2.ReplaceRouteTableAssociationInput{
+					AssociationId: association.RouteTableAssociationId,
+					RouteTableId:  table.RouteTableId,
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			Logger.Println(PreviewString(preview)+"updated route table association:", *subnet.SubnetId, *table.RouteTableId)
+			return nil
 		}
-		// enable dns hostnames
-		err = Retry(ctx, func() error {
-			_, err := EC2Client().ModifyVpcAttributeWithContext(ctx, &ec2.ModifyVpcAttributeInput{
-				VpcId: vpc.Vpc.VpcId,
-				EnableDnsHostnames: &ec2.AttributeBooleanValue{
-					Value: aws.Bool(true),
-				},
+	}
+	if !preview {
+		err := Retry(ctx, func() error {
+			_, err := EC2Client().AssociateRouteTableWithContext(ctx, &ec2.AssociateRouteTableInput{
+				RouteTableId: table.RouteTableId,
+				SubnetId:     subnet.SubnetId,
 			})
 			return err
 		})
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return err
 		}
-		Logger.Println("enabled dns hostnames:", *vpc.Vpc.VpcId)
-		// remove all rules from default security group
-		out, err := EC2Client().DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
-			Filters: []*ec2.Filter{
-				{Name: aws.String("vpc-id"), Values: []*string{vpc.Vpc.VpcId}},
+	}
+	Logger.Println(PreviewString(preview)+"created route table association:", *subnet.SubnetId, *table.RouteTableId)
+	return nil
+}
+
+// pending or available nat gateways managed by libaws by zone name, from their Name tag
+func vpcNatGateways(ctx context.Context, vpcName, vpcID string) (map[string]*ec2.NatGateway, error) {
+	natGateways, err := vpcListNatGateways(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	res := map[string]*ec2.NatGateway{}
+	for _, natGateway := range natGateways {
+		name := EC2Name(natGateway.Tags)
+		if !strings.HasPrefix(name, vpcName+"-nat-") {
+			continue // not managed by libaws
+		}
+		res[strings.TrimPrefix(name, vpcName+"-nat-")] = natGateway
+	}
+	return res, nil
+}
+
+// pending or available nat gateways, including those not managed by libaws
+func vpcListNatGateways(ctx context.Context, vpcID string) ([]*ec2.NatGateway, error) {
+	var res []*ec2.NatGateway
+	var token *string
+	for {
+		out, err := EC2Client().DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+			Filter: []*ec2.Filter{
+				{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+				{Name: aws.String("state"), Values: []*string{aws.String(ec2.NatGatewayStatePending), aws.String(ec2.NatGatewayStateAvailable)}},
 			},
+			NextToken: token,
 		})
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return nil, err
 		}
-		if len(out.SecurityGroups) != 1 {
-			err := fmt.Errorf("could not find default security group")
-			Logger.Println("error:", err)
-			return "", err
+		res = append(res, out.NatGateways...)
+		if out.NextToken == nil {
+			break
 		}
-		securityGroup := out.SecurityGroups[0]
-		_, err = EC2Client().RevokeSecurityGroupIngressWithContext(ctx, &ec2.RevokeSecurityGroupIngressInput{
-			GroupId:       securityGroup.GroupId,
-			IpPermissions: securityGroup.IpPermissions,
-		})
-		if err != nil {
-			Logger.Println("error:", err)
-			return "", err
+		token = out.NextToken
+	}
+	return res, nil
+}
+
+func vpcNatTag(tags []*ec2.Tag) string {
+	for _, tag := range tags {
+		if *tag.Key == vpcNatTagName {
+			return *tag.Value
 		}
-		Logger.Println("removed all rules from default security group:", *securityGroup.GroupId)
-		// create and attach internet gateway
-		gateway, err := EC2Client().CreateInternetGatewayWithContext(ctx, &ec2.CreateInternetGatewayInput{
-			TagSpecifications: []*ec2.TagSpecification{{
-				ResourceType: aws.String(ec2.ResourceTypeInternetGateway),
-				Tags:         tags,
-			}},
+	}
+	return ""
+}
+
+func vpcEnsureNatTag(ctx context.Context, input *vpcEnsureInput, vpc *ec2.Vpc, preview bool) error {
+	if vpcNatTag(vpc.Tags) == input.nat {
+		return nil
+	}
+	if !preview {
+		_, err := EC2Client().CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{vpc.VpcId},
+			Tags:      []*ec2.Tag{{Key: aws.String(vpcNatTagName), Value: aws.String(input.nat)}},
 		})
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
-		}
-		Logger.Println("created internet gateway:", *gateway.InternetGateway.InternetGatewayId)
-		err = Retry(ctx, func() error {
-			_, err := EC2Client().AttachInternetGatewayWithContext(ctx, &ec2.AttachInternetGatewayInput{
-				VpcId:             vpc.Vpc.VpcId,
-				InternetGatewayId: gateway.InternetGateway.InternetGatewayId,
-			})
 			return err
-		})
-		if err != nil {
-			Logger.Println("error:", err)
-			return "", err
 		}
-		Logger.Println("attached internet gateway:", *gateway.InternetGateway.InternetGatewayId)
-		// create route to internet
-		routes, err := EC2Client().DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
-			Filters: []*ec2.Filter{
-				{
-					Name:   aws.String("vpc-id"),
-					Values: []*string{vpc.Vpc.VpcId},
-				},
-			},
-		})
-		if err != nil {
-			return "", err
+	}
+	Logger.Println(PreviewString(preview)+"tagged vpc:", input.name, vpcNatTagName+"="+input.nat)
+	return nil
+}
+
+func vpcEnsureNatGateways(ctx context.Context, input *vpcEnsureInput, vpcID string, zones []string, publicSubnets map[string]*ec2.Subnet, preview bool) error {
+	natGateways, err := vpcNatGateways(ctx, input.name, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	natZones := input.natZones(zones)
+	for zone, natGateway := range natGateways {
+		if !Contains(natZones, zone) {
+			err := vpcDeleteNatGateway(ctx, natGateway, preview)
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
 		}
-		if len(routes.RouteTables) != 1 {
-			err := fmt.Errorf("needed exactly 1 route table %s", Pformat(routes.RouteTables))
-			Logger.Println("error:", err)
-			return "", err
+	}
+	for _, zone := range natZones {
+		natGateway, ok := natGateways[zone]
+		if ok {
+			if *natGateway.State == ec2.NatGatewayStatePending && !preview {
+				err := EC2Client().WaitUntilNatGatewayAvailableWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+					NatGatewayIds: []*string{natGateway.NatGatewayId},
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+			continue
+		}
+		natName := input.name + "-nat-" + zone
+		if !preview {
+			subnet := publicSubnets[zone]
+			if subnet == nil {
+				err := fmt.Errorf("no public subnet for nat gateway: %s", natName)
+				Logger.Println("error:", err)
+				return err
+			}
+			address, err := EC2Client().AllocateAddressWithContext(ctx, &ec2.AllocateAddressInput{
+				Domain: aws.String(ec2.DomainTypeVpc),
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeElasticIp),
+					Tags:         input.tags(natName),
+				}},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			out, err := EC2Client().CreateNatGatewayWithContext(ctx, &ec2.CreateNatGatewayInput{
+				SubnetId:     subnet.SubnetId,
+				AllocationId: address.AllocationId,
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeNatgateway),
+					Tags:         input.tags(natName),
+				}},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
+			err = EC2Client().WaitUntilNatGatewayAvailableWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+				NatGatewayIds: []*string{out.NatGateway.NatGatewayId},
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
+			}
 		}
-		table := routes.RouteTables[0]
-		_, err = EC2Client().CreateRouteWithContext(ctx, &ec2.CreateRouteInput{
-			DestinationCidrBlock: aws.String("0.0.0.0/0"),
-			GatewayId:            gateway.InternetGateway.InternetGatewayId,
-			RouteTableId:         table.RouteTableId,
+		Logger.Println(PreviewString(preview)+"created nat gateway:", natName)
+	}
+	return nil
+}
+
+// delete a nat gateway and release its elastic ip
+func vpcDeleteNatGateway(ctx context.Context, natGateway *ec2.NatGateway, preview bool) error {
+	if !preview {
+		_, err := EC2Client().DeleteNatGatewayWithContext(ctx, &ec2.DeleteNatGatewayInput{
+			NatGatewayId: natGateway.NatGatewayId,
 		})
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return err
 		}
-		Logger.Println("created route to internet gateway:", *gateway.InternetGateway.InternetGatewayId, *table.RouteTableId)
-		// create subnets
-		zones, err := Zones(ctx)
+		err = EC2Client().WaitUntilNatGatewayDeletedWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+			NatGatewayIds: []*string{natGateway.NatGatewayId},
+		})
 		if err != nil {
 			Logger.Println("error:", err)
-			return "", err
+			return err
 		}
-		for i, zone := range zones {
-			str := strings.Split(cidr, "/")[0]
-			slice := strings.Split(str, ".")[:2]
-			slice = append(slice, fmt.Sprint(16*i+1))
-			slice = append(slice, "0/20")
-			block := strings.Join(slice, ".")
-			subnet, err := EC2Client().CreateSubnetWithContext(ctx, &ec2.CreateSubnetInput{
-				VpcId:            vpc.Vpc.VpcId,
-				AvailabilityZone: zone.ZoneName,
-				CidrBlock:        aws.String(block),
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String(ec2.ResourceTypeSubnet),
-					Tags:         tags,
-				}},
+		for _, address := range natGateway.NatGatewayAddresses {
+			if address.AllocationId == nil {
+				continue
+			}
+			_, err := EC2Client().ReleaseAddressWithContext(ctx, &ec2.ReleaseAddressInput{
+				AllocationId: address.AllocationId,
 			})
 			if err != nil {
 				Logger.Println("error:", err)
-				return "", err
+				return err
 			}
-			Logger.Println("created subnet:", *subnet.Subnet.SubnetId, *zone.ZoneName, block)
-			err = Retry(ctx, func() error {
-				_, err := EC2Client().ModifySubnetAttributeWithContext(ctx, &ec2.ModifySubnetAttributeInput{
-					SubnetId:            subnet.Subnet.SubnetId,
-					MapPublicIpOnLaunch: &ec2.AttributeBooleanValue{Value: aws.Bool(true)},
+		}
+	}
+	Logger.Println(PreviewString(preview)+"deleted nat gateway:", EC2Name(natGateway.Tags), *natGateway.NatGatewayId)
+	return nil
+}
+
+// remove the private route table and subnet of a zone after private-subnets is turned off
+func vpcDeletePrivateZone(ctx context.Context, table *ec2.RouteTable, subnet *ec2.Subnet, preview bool) error {
+	if table != nil {
+		for _, association := range table.Associations {
+			if !preview {
+				_, err := EC2Client().DisassociateRouteTableWithContext(ctx, &ec2.DisassociateRouteTableInput{
+					AssociationId: association.RouteTableAssociationId,
 				})
-				return err
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
+				}
+			}
+		}
+		if !preview {
+			_, err := EC2Client().DeleteRouteTableWithContext(ctx, &ec2.DeleteRouteTableInput{
+				RouteTableId: table.RouteTableId,
 			})
 			if err != nil {
 				Logger.Println("error:", err)
-				return "", err
-			}
-			Logger.Println("enable map public ip on launch:", *subnet.Subnet.SubnetId)
-			err = Retry(ctx, func() error {
-				_, err := EC2Client().AssociateRouteTableWithContext(ctx, &ec2.AssociateRouteTableInput{
-					RouteTableId: table.RouteTableId,
-					SubnetId:     subnet.Subnet.SubnetId,
-				})
 				return err
+			}
+		}
+		Logger.Println(PreviewString(preview)+"deleted route table:", EC2Name(table.Tags), *table.RouteTableId)
+	}
+	if subnet != nil {
+		if !preview {
+			_, err := EC2Client().DeleteSubnetWithContext(ctx, &ec2.DeleteSubnetInput{
+				SubnetId: subnet.SubnetId,
 			})
 			if err != nil {
 				Logger.Println("error:", err)
-				return "", err
+				return err
 			}
 		}
-		return *vpc.Vpc.VpcId, nil
+		Logger.Println(PreviewString(preview)+"deleted subnet:", vpcSubnetPrivate, *subnet.AvailabilityZone, *subnet.SubnetId)
+	}
+	return nil
+}
+
+// the attrs of an existing vpc, the inverse of VpcEnsureInput
+func vpcListAttrs(ctx context.Context, vpc *ec2.Vpc) ([]string, error) {
+	input := &vpcEnsureInput{cidr: *vpc.CidrBlock, nat: vpcNatNone}
+	subnets, err := VpcSubnets(ctx, *vpc.VpcId)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	privateCount := 0
+	for _, subnet := range subnets {
+		if vpcSubnetTier(subnet.Tags) == vpcSubnetPrivate {
+			privateCount++
+		}
+	}
+	input.privateSubnets = privateCount > 0
+	nat := vpcNatTag(vpc.Tags)
+	if nat != "" {
+		input.nat = nat
+		return input.Attrs(), nil
+	}
+	// vpcs created before the nat tag, infer the mode from the nat gateways
+	natGateways, err := vpcNatGateways(ctx, EC2Name(vpc.Tags), *vpc.VpcId)
+	if err != nil {
+		Logger.Println("error:", err)
+		return nil, err
+	}
+	if len(natGateways) == 1 && privateCount != 1 {
+		input.nat = vpcNatSingle
+	} else if len(natGateways) > 0 {
+		input.nat = vpcNatPerZone
+	}
+	return input.Attrs(), nil
+}
+
+// lambda network interfaces outlive their functions by up to 20 minutes, and
+// block deleting the subnets and security groups they use
+func vpcWaitLambdaEnis(ctx context.Context, name string, preview bool) error {
+	if doDebug {
+		d := &Debug{start: time.Now(), name: "vpcWaitLambdaEnis"}
+		defer d.Log()
+	}
+	if preview {
+		return nil
+	}
+	vpc, err := vpcGet(ctx, name)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	if vpc == nil {
+		return nil
+	}
+	for {
+		out, err := EC2Client().DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("vpc-id"), Values: []*string{vpc.VpcId}},
+				{Name: aws.String("interface-type"), Values: []*string{aws.String(ec2.NetworkInterfaceTypeLambda)}},
+			},
+		})
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+		if len(out.NetworkInterfaces) == 0 {
+			return nil
+		}
+		Logger.Println("waiting for lambda network interfaces to be deleted:", name, len(out.NetworkInterfaces))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(15 * time.Second):
+		}
 	}
-	Logger.Println(PreviewString(preview)+"created vpc:", vpcName)
-	return "", nil
 }
 
 func VpcRm(ctx context.Context, name string, preview bool) error {
@@ -318,6 +1145,19 @@ func VpcRm(ctx context.Context, name string, preview bool) error {
 			return err
 		}
 	}
+	// delete nat gateways before internet gateways, their elastic ips block detach
+	natGateways, err := vpcListNatGateways(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
+	}
+	for _, natGateway := range natGateways {
+		err := vpcDeleteNatGateway(ctx, natGateway, preview)
+		if err != nil {
+			Logger.Println("error:", err)
+			return err
+		}
+	}
 	// delete internet gateways
 	var gateways []*ec2.InternetGateway
 	var token *string
@@ -359,40 +1199,42 @@ func VpcRm(ctx context.Context, name string, preview bool) error {
 		Logger.Println(PreviewString(preview)+"deleted:", *gateway.InternetGatewayId)
 	}
 	// delete route tables
-	var routeTables []*ec2.RouteTable
-	token = nil
-	for {
-		out, err := EC2Client().DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
-			Filters: []*ec2.Filter{{
-				Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)},
-			}},
-			NextToken: token,
-		})
-		if err != nil {
-			Logger.Println("error:", err)
-			return err
-		}
-		routeTables = append(routeTables, out.RouteTables...)
-		if out.NextToken == nil {
-			break
-		}
-		out.NextToken = token
+	routeTables, err := vpcRouteTables(ctx, vpcID)
+	if err != nil {
+		Logger.Println("error:", err)
+		return err
 	}
 	for _, routeTable := range routeTables {
+		main := false
 		for _, association := range routeTable.Associations {
-			if !*association.Main {
-				if !preview {
-					_, err := EC2Client().DisassociateRouteTableWithContext(ctx, &ec2.DisassociateRouteTableInput{
-						AssociationId: association.RouteTableAssociationId,
-					})
-					if err != nil {
-						Logger.Println("error:", err)
-						return err
-					}
+			if *association.Main {
+				main = true
+				continue
+			}
+			if !preview {
+				_, err := EC2Client().DisassociateRouteTableWithContext(ctx, &ec2.DisassociateRouteTableInput{
+					AssociationId: association.RouteTableAssociationId,
+				})
+				if err != nil {
+					Logger.Println("error:", err)
+					return err
 				}
-				Logger.Println(PreviewString(preview)+"deleted:", *association.RouteTableAssociationId)
+			}
+			Logger.Println(PreviewString(preview)+"deleted:", *association.RouteTableAssociationId)
+		}
+		if main {
+			continue // main route table is deleted with the vpc
+		}
+		if !preview {
+			_, err := EC2Client().DeleteRouteTableWithContext(ctx, &ec2.DeleteRouteTableInput{
+				RouteTableId: routeTable.RouteTableId,
+			})
+			if err != nil {
+				Logger.Println("error:", err)
+				return err
 			}
 		}
+		Logger.Println(PreviewString(preview)+"deleted:", *routeTable.RouteTableId)
 	}
 	// delete vpc endpoints
 	token = nil
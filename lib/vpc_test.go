@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVpcEnsureInput(t *testing.T) {
+	type test struct {
+		attrs []string
+		input *vpcEnsureInput
+		err   bool
+	}
+	tests := []test{
+		{
+			[]string{},
+			&vpcEnsureInput{name: "vpc", cidr: vpcAttrCidrDefault, nat: vpcNatNone},
+			false,
+		},
+		{
+			[]string{"cidr=10.1.0.0/16", "private-subnets=true", "nat=per-zone"},
+			&vpcEnsureInput{name: "vpc", cidr: "10.1.0.0/16", privateSubnets: true, nat: vpcNatPerZone},
+			false,
+		},
+		{
+			[]string{"private-subnets=true"},
+			&vpcEnsureInput{name: "vpc", cidr: vpcAttrCidrDefault, privateSubnets: true, nat: vpcNatNone},
+			false,
+		},
+		{[]string{"nat=single"}, nil, true},
+		{[]string{"private-subnets=yes"}, nil, true},
+		{[]string{"private-subnets=true", "nat=double"}, nil, true},
+		{[]string{"cidr=10.1.0.0/8"}, nil, true},
+		{[]string{"cidr=10.1.0.0/25"}, nil, true},
+		{[]string{"cidr=10.1.1.0/16"}, nil, true},
+		{[]string{"cidr=10.1.0.0"}, nil, true},
+		{[]string{"unknown=value"}, nil, true},
+	}
+	for _, test := range tests {
+		input, err := VpcEnsureInput("", "vpc", test.attrs)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error: %v", test.attrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v %s", test.attrs, err)
+			continue
+		}
+		if !reflect.DeepEqual(input, test.input) {
+			t.Errorf("\ngot:\n%#v\nwant:\n%#v\n", input, test.input)
+		}
+		if !reflect.DeepEqual(input.Attrs(), test.attrs) && len(test.attrs) != 0 {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", input.Attrs(), test.attrs)
+		}
+	}
+}
+
+func TestVpcSubnetCidr(t *testing.T) {
+	type test struct {
+		cidr   string
+		index  int
+		subnet string
+	}
+	tests := []test{
+		{"10.0.0.0/16", 0, "10.0.0.0/20"},
+		{"10.0.0.0/16", 1, "10.0.16.0/20"},
+		{"10.0.0.0/16", 8, "10.0.128.0/20"},
+		{"10.0.0.0/16", 15, "10.0.240.0/20"},
+		{"10.1.0.0/20", 3, "10.1.3.0/24"},
+		{"192.168.4.0/24", 9, "192.168.4.144/28"},
+	}
+	for _, test := range tests {
+		subnet, err := vpcSubnetCidr(test.cidr, test.index)
+		if err != nil {
+			t.Errorf("unexpected error: %s %d %s", test.cidr, test.index, err)
+			continue
+		}
+		if subnet != test.subnet {
+			t.Errorf("\ngot:\n%v\nwant:\n%v\n", subnet, test.subnet)
+		}
+	}
+	_, err := vpcSubnetCidr("10.0.0.0/16", vpcSubnetBlocks)
+	if err == nil {
+		t.Errorf("expected error for index out of range")
+	}
+}
@@ -25,6 +25,7 @@ import (
 	_ "github.com/Azathothas/libaws/cmd/organizations"
 	_ "github.com/Azathothas/libaws/cmd/route53"
 	_ "github.com/Azathothas/libaws/cmd/s3"
+	_ "github.com/Azathothas/libaws/cmd/sns"
 	_ "github.com/Azathothas/libaws/cmd/sqs"
 	_ "github.com/Azathothas/libaws/cmd/ssh"
 	_ "github.com/Azathothas/libaws/cmd/vpc"